@@ -11,7 +11,10 @@ require (
 	github.com/google/go-github/v35 v35.3.0
 	github.com/minio/minio-go/v7 v7.0.91
 	github.com/spf13/cobra v1.8.1
+	github.com/spf13/pflag v1.0.5
+	go.uber.org/zap v1.27.0
 	golang.org/x/mod v0.22.0
+	golang.org/x/net v0.39.0
 	golang.org/x/oauth2 v0.27.0
 	helm.sh/helm/v3 v3.17.3
 	istio.io/istio v0.0.0-20241216163125-4f5270fdad7a
@@ -62,7 +65,6 @@ require (
 	github.com/sergi/go-diff v1.3.2-0.20230802210424-5b0b94c5c0d3 // indirect
 	github.com/sirupsen/logrus v1.9.3 // indirect
 	github.com/skeema/knownhosts v1.3.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/vbatts/tar-split v0.11.6 // indirect
 	github.com/xanzy/ssh-agent v0.3.3 // indirect
 	go.opentelemetry.io/auto/sdk v1.1.0 // indirect
@@ -72,9 +74,7 @@ require (
 	go.opentelemetry.io/otel/sdk v1.33.0 // indirect
 	go.opentelemetry.io/otel/trace v1.33.0 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
-	go.uber.org/zap v1.27.0 // indirect
 	golang.org/x/crypto v0.37.0 // indirect
-	golang.org/x/net v0.39.0 // indirect
 	golang.org/x/sync v0.13.0 // indirect
 	golang.org/x/sys v0.32.0 // indirect
 	golang.org/x/text v0.24.0 // indirect
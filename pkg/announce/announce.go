@@ -0,0 +1,205 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package announce
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"istio.io/istio/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/build"
+	"github.com/alauda-mesh/release-builder/pkg/publish"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// defaultTemplates are the announcements rendered when no templates are passed.
+//
+//go:embed templates
+var defaultTemplates embed.FS
+
+// Data is what the announcement templates are executed with.
+type Data struct {
+	Version    string
+	Prerelease bool
+	// Date is the build date of the release
+	Date string
+	// Highlights are the bullets of the Highlights section of the release notes
+	Highlights []string
+	// Notes is the full release notes markdown
+	Notes         string
+	Downloads     []Download
+	Images        []publish.PublishedImage
+	Charts        []publish.PublishedChart
+	GithubRelease string
+}
+
+// Download is a downloadable artifact of the release.
+type Download struct {
+	Name string
+	// URL is empty if the download URL of the file is not known
+	URL    string
+	SHA256 string
+}
+
+// LoadData reads the announcement data from the release metadata and, if the release was published, published.yaml
+// of the release in dir. notes and downloadURL are optional.
+func LoadData(dir string, notes string, downloadURL string) (Data, error) {
+	metadata := build.ReleaseMetadata{}
+	by, err := os.ReadFile(filepath.Join(dir, "release-metadata.json"))
+	if err != nil {
+		return Data{}, fmt.Errorf("failed to read release metadata: %v", err)
+	}
+	if err := json.Unmarshal(by, &metadata); err != nil {
+		return Data{}, fmt.Errorf("failed to unmarshal release metadata: %v", err)
+	}
+	published := publish.Published{}
+	if by, err := os.ReadFile(filepath.Join(dir, publish.PublishedFile)); err == nil {
+		if err := yaml.Unmarshal(by, &published); err != nil {
+			return Data{}, fmt.Errorf("failed to unmarshal %v: %v", publish.PublishedFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return Data{}, err
+	} else {
+		log.Warnf("%v not found, announcing the release as unpublished", publish.PublishedFile)
+	}
+
+	data := Data{
+		Version:       metadata.Version,
+		Prerelease:    util.IsPrerelease(metadata.Version),
+		Date:          metadata.BuildDate,
+		Downloads:     downloads(metadata.Files, published.Objects, downloadURL),
+		Images:        published.Images,
+		Charts:        published.Charts,
+		GithubRelease: published.GithubRelease,
+	}
+	if len(data.Images) == 0 {
+		// Unpublished images are only known by their archive and config digest
+		for archive, digest := range metadata.Images {
+			data.Images = append(data.Images, publish.PublishedImage{Reference: archive, Digest: digest})
+		}
+		sort.Slice(data.Images, func(i, j int) bool { return data.Images[i].Reference < data.Images[j].Reference })
+	}
+	if notes != "" {
+		by, err := os.ReadFile(notes)
+		if err != nil {
+			return Data{}, fmt.Errorf("failed to read release notes: %v", err)
+		}
+		data.Notes = string(by)
+		data.Highlights = Highlights(data.Notes)
+	}
+	return data, nil
+}
+
+// downloads lists the archives and packages at the root of the release, linking each to downloadURL if set, or else
+// the published object of the same name.
+func downloads(files map[string]string, objects []string, downloadURL string) []Download {
+	res := []Download{}
+	for file, sha := range files {
+		if strings.Contains(file, "/") || strings.HasSuffix(file, ".yaml") || strings.HasSuffix(file, ".json") {
+			continue
+		}
+		d := Download{Name: file, SHA256: sha}
+		if downloadURL != "" {
+			d.URL = strings.TrimSuffix(downloadURL, "/") + "/" + file
+		} else {
+			for _, o := range objects {
+				if strings.HasSuffix(o, "/"+file) {
+					d.URL = o
+					break
+				}
+			}
+		}
+		res = append(res, d)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// Highlights returns the top level bullets under the first heading of the release notes containing "highlights".
+func Highlights(notes string) []string {
+	res := []string{}
+	inSection := false
+	for _, line := range strings.Split(notes, "\n") {
+		if strings.HasPrefix(line, "#") {
+			if inSection {
+				break
+			}
+			inSection = strings.Contains(strings.ToLower(line), "highlights")
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		line = strings.TrimRight(line, " \r")
+		if strings.HasPrefix(line, "- ") || strings.HasPrefix(line, "* ") {
+			res = append(res, strings.TrimSpace(line[2:]))
+		}
+	}
+	return res
+}
+
+// Render executes each template in templateDir, or the default templates if unset, writing it to a file of the same
+// name in output.
+func Render(data Data, templateDir string, output string) error {
+	var templates fs.FS
+	if templateDir != "" {
+		templates = os.DirFS(templateDir)
+	} else {
+		sub, err := fs.Sub(defaultTemplates, "templates")
+		if err != nil {
+			return err
+		}
+		templates = sub
+	}
+	entries, err := fs.ReadDir(templates, ".")
+	if err != nil {
+		return fmt.Errorf("failed to read templates: %v", err)
+	}
+	if err := os.MkdirAll(output, 0o755); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		by, err := fs.ReadFile(templates, e.Name())
+		if err != nil {
+			return err
+		}
+		t, err := template.New(e.Name()).Option("missingkey=error").Parse(string(by))
+		if err != nil {
+			return fmt.Errorf("invalid template %v: %v", e.Name(), err)
+		}
+		buf := &bytes.Buffer{}
+		if err := t.Execute(buf, data); err != nil {
+			return fmt.Errorf("failed to render %v: %v", e.Name(), err)
+		}
+		if err := os.WriteFile(filepath.Join(output, e.Name()), buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+		log.Infof("Wrote announcement %v", filepath.Join(output, e.Name()))
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package announce
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHighlights(t *testing.T) {
+	notes := `# Istio 1.25.0
+
+## Highlights
+
+- Ambient mode is GA
+  - nested details are dropped
+* Faster istioctl
+
+## Upgrade notes
+
+- not a highlight
+`
+	want := []string{"Ambient mode is GA", "Faster istioctl"}
+	if got := Highlights(notes); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := Highlights("- no sections"); len(got) != 0 {
+		t.Errorf("got %v, want no highlights", got)
+	}
+}
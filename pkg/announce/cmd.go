@@ -0,0 +1,69 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package announce
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	flags = struct {
+		release     string
+		notes       string
+		templates   string
+		downloadURL string
+		output      string
+	}{}
+	announceCmd = &cobra.Command{
+		Use:          "announce",
+		Short:        "Renders the release announcements of a built and published release of Istio",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(0),
+		RunE: func(c *cobra.Command, _ []string) error {
+			if flags.release == "" {
+				return fmt.Errorf("--release must be passed")
+			}
+			data, err := LoadData(flags.release, flags.notes, flags.downloadURL)
+			if err != nil {
+				return err
+			}
+			output := flags.output
+			if output == "" {
+				output = filepath.Join(flags.release, "announcements")
+			}
+			return Render(data, flags.templates, output)
+		},
+	}
+)
+
+func init() {
+	announceCmd.PersistentFlags().StringVar(&flags.release, "release", flags.release,
+		"The directory of the release to announce.")
+	announceCmd.PersistentFlags().StringVar(&flags.notes, "notes", flags.notes,
+		"The release notes markdown. Bullets under a Highlights heading are announced as the highlights.")
+	announceCmd.PersistentFlags().StringVar(&flags.templates, "templates", flags.templates,
+		"A directory of templates replacing the default announcements, each rendered to a file of the same name.")
+	announceCmd.PersistentFlags().StringVar(&flags.downloadURL, "download-url", flags.downloadURL,
+		"The base URL the release is downloaded from. Defaults to the published objects of the release.")
+	announceCmd.PersistentFlags().StringVar(&flags.output, "output", flags.output,
+		"The directory to write the announcements to. Defaults to the announcements directory of the release.")
+}
+
+func GetAnnounceCommand() *cobra.Command {
+	return announceCmd
+}
@@ -30,6 +30,8 @@ var (
 		dryrun          bool
 		step            int
 		githubTokenFile string
+		profile         string
+		set             []string
 	}{
 		manifest: "example/manifest_branch.yaml",
 		dryrun:   true, // Default to dry-run for now
@@ -44,7 +46,7 @@ var (
 				return fmt.Errorf("invalid flags: %v", err)
 			}
 
-			inManifest, err := pkg.ReadInManifest(flags.manifest)
+			inManifest, err := pkg.ReadInManifest(flags.manifest, flags.profile, flags.set)
 			if err != nil {
 				return fmt.Errorf("failed to unmarshal manifest: %v", err)
 			}
@@ -58,7 +60,7 @@ var (
 				return fmt.Errorf("failed to setup work dir: %v", err)
 			}
 
-			if err := pkg.Sources(manifest); err != nil {
+			if err := pkg.Sources(manifest, util.CloneOptions{}); err != nil {
 				return fmt.Errorf("failed to fetch sources: %v", err)
 			}
 			log.Infof("Fetched all sources and setup working directory at %v", manifest.WorkDir())
@@ -81,6 +83,10 @@ var (
 func init() {
 	branchCmd.PersistentFlags().StringVar(&flags.manifest, "manifest", flags.manifest,
 		"The manifest used to get the repos for the branch cut.")
+	branchCmd.PersistentFlags().StringVar(&flags.profile, "profile", flags.profile,
+		"The manifest profile to apply. Example: daily")
+	branchCmd.PersistentFlags().StringArrayVar(&flags.set, "set", flags.set,
+		"Override a manifest field, in the form key=value. Keys are dot separated, such as dependencies.istio.branch. May be repeated.")
 	branchCmd.PersistentFlags().BoolVar(&flags.dryrun, "dryrun", flags.dryrun,
 		"Do not run any github commands.")
 	branchCmd.PersistentFlags().IntVar(&flags.step, "step", flags.step,
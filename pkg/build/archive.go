@@ -18,25 +18,81 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"slices"
 	"strings"
 
-	"istio.io/istio/pkg/log"
-
 	"github.com/alauda-mesh/release-builder/pkg/model"
 	"github.com/alauda-mesh/release-builder/pkg/util"
 )
 
-// Archive creates the release archive that users will download. This includes the installation templates,
-// istioctl, and various tools.
-func Archive(manifest model.Manifest) error {
-	// First, build all variants of istioctl (linux, osx, windows).
-	if err := util.RunMake(manifest, "istio", nil, "istioctl-all", "istioctl.completion"); err != nil {
+// archiveArchs are the platforms release archives are always built for
+var archiveArchs = []string{"linux-amd64", "linux-armv7", "linux-arm64", "osx-amd64", "osx-arm64", "win-amd64"}
+
+// archiveArchitectures returns the platforms to build release archives for: the standard platforms, plus any
+// additional linux architectures, such as s390x and ppc64le, the manifest builds for.
+func archiveArchitectures(manifest model.Manifest) []string {
+	archs := slices.Clone(archiveArchs)
+	for _, arch := range extraLinuxArchitectures(manifest) {
+		archs = append(archs, "linux-"+arch)
+	}
+	return archs
+}
+
+// extraLinuxArchitectures returns the architectures of the manifest not built by `make istioctl-all`.
+func extraLinuxArchitectures(manifest model.Manifest) []string {
+	archs := []string{}
+	for _, plat := range manifest.Architectures {
+		_, arch, _ := strings.Cut(plat, "/")
+		if !slices.Contains(archiveArchs, "linux-"+arch) {
+			archs = append(archs, arch)
+		}
+	}
+	return archs
+}
+
+// makeIstioctl builds istioctl for every archive platform, along with the completion files.
+func makeIstioctl(manifest model.Manifest) error {
+	if err := buildIstioctl(manifest); err != nil {
+		return err
+	}
+	if manifest.DebugSymbols {
+		return splitIstioctlDebugInfo(manifest)
+	}
+	return nil
+}
+
+func buildIstioctl(manifest model.Manifest) error {
+	if manifest.FastIstioctl {
+		return goBuildIstioctl(manifest)
+	}
+	ldflags := []string{}
+	if manifest.DebugSymbols {
+		// The ldflags are passed as arguments, as they override those set by the Makefile
+		ldflags = []string{"RELEASE_LDFLAGS=" + istioctlLdflags(manifest), "LDFLAGS=" + istioctlLdflags(manifest)}
+	}
+	// First, build all standard variants of istioctl (linux, osx, windows).
+	if err := util.RunMake(manifest, "istio", nil, append([]string{"istioctl-all", "istioctl.completion"}, ldflags...)...); err != nil {
 		return fmt.Errorf("failed to make istioctl: %v", err)
 	}
+	// Additional architectures are built individually, and named as istioctl-all would
+	for _, arch := range extraLinuxArchitectures(manifest) {
+		if err := util.RunMake(manifest, "istio", []string{"TARGET_OS=linux", "TARGET_ARCH=" + arch}, append([]string{"istioctl"}, ldflags...)...); err != nil {
+			return fmt.Errorf("failed to make istioctl for %v: %v", arch, err)
+		}
+		binary := path.Join(path.Dir(manifest.RepoArchOutDir("istio", arch)), "istioctl")
+		if err := util.CopyFile(binary, path.Join(manifest.RepoOutDir("istio"), "istioctl-linux-"+arch)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// Archive creates the release archive that users will download. This includes the installation templates,
+// istioctl, as built by makeIstioctl, and various tools.
+func Archive(manifest model.Manifest) error {
 	// We build archives for each arch. These contain the same thing except arch specific istioctl
-	for _, arch := range []string{"linux-amd64", "linux-armv7", "linux-arm64", "osx-amd64", "osx-arm64", "win-amd64"} {
-		out := path.Join(manifest.Directory, "work", "archive", arch, fmt.Sprintf("istio-%s", manifest.Version))
+	for _, arch := range archiveArchitectures(manifest) {
+		out := archiveDir(manifest, arch)
 		if err := os.MkdirAll(out, 0o750); err != nil {
 			return err
 		}
@@ -52,6 +108,38 @@ func Archive(manifest model.Manifest) error {
 			}
 		}
 
+		// Include third party notices, if generated
+		if _, err := os.Stat(noticesDir(manifest)); err == nil {
+			if err := util.CopyFile(path.Join(noticesDir(manifest), "NOTICES"), path.Join(out, "NOTICES")); err != nil {
+				return err
+			}
+			if err := util.CopyDir(path.Join(noticesDir(manifest), "LICENSES"), path.Join(out, "LICENSES")); err != nil {
+				return err
+			}
+		}
+
+		// Include the image list, if the images were built
+		if images := path.Join(manifest.OutDir(), ImagesFile); util.FileExists(images) {
+			if err := util.CopyFile(images, path.Join(out, ImagesFile)); err != nil {
+				return err
+			}
+		}
+
+		// Include the Wasm extension modules
+		for _, w := range manifest.WasmExtensions {
+			module := wasmModuleName(manifest, w)
+			if err := util.CopyFile(path.Join(manifest.OutDir(), manifest.ArtifactDir("wasm", ""), module), path.Join(out, "extensions", module)); err != nil {
+				return err
+			}
+		}
+		if manifest.Proxy != nil {
+			for _, filter := range manifest.Proxy.WasmFilters {
+				if err := util.CopyFile(proxyWasmFilter(manifest, filter), path.Join(out, "extensions", filter+".wasm")); err != nil {
+					return err
+				}
+			}
+		}
+
 		// Set up tools/certs. We filter down to only some file patterns
 		includePatterns := []string{"README.md", "Makefile*", "common.mk"}
 		if err := util.CopyDirFiltered(path.Join(manifest.RepoDir("istio"), "tools", "certs"), path.Join(out, "tools", "certs"), includePatterns); err != nil {
@@ -76,8 +164,18 @@ func Archive(manifest model.Manifest) error {
 			return err
 		}
 
-		if err := updateValues(manifest, path.Join(out, "manifests/profiles/default.yaml")); err != nil {
-			return fmt.Errorf("failed to sanitize istioctl profiles: %v", err)
+		// Rendered manifests are only built for semver releases, along with the charts
+		if manifest.RenderedManifests != nil && util.IsValidSemver(manifest.Version) {
+			rendered := path.Join(manifest.OutDir(), manifest.ArtifactDir("manifests", ""))
+			for _, profile := range manifest.RenderedManifests.Profiles {
+				if err := util.CopyFile(path.Join(rendered, profile+".yaml"), path.Join(manifestsDir, "rendered", profile+".yaml")); err != nil {
+					return fmt.Errorf("failed to copy rendered manifests: %v", err)
+				}
+			}
+		}
+
+		if err := addArchiveExtraFiles(manifest, out); err != nil {
+			return err
 		}
 
 		// Write manifest
@@ -86,21 +184,7 @@ func Archive(manifest model.Manifest) error {
 		}
 
 		// Copy the istioctl binary over
-		istioctlBinary := fmt.Sprintf("istioctl-%s", arch)
-		istioctlDest := "istioctl"
-		// The istioctl binaries for MacOS and Windows do not have the `-amd64` so remove from name.
-		// Windows also needs the `.exe` added.
-		if arch == "osx-amd64" {
-			istioctlBinary = istioctlBinary[:strings.LastIndexByte(istioctlBinary, '-')]
-		}
-		if arch == "win-amd64" {
-			istioctlBinary = istioctlBinary[:strings.LastIndexByte(istioctlBinary, '-')] + ".exe"
-			istioctlDest += ".exe"
-		}
-		if err := util.CopyFile(path.Join(manifest.RepoOutDir("istio"), istioctlBinary), path.Join(out, "bin", istioctlDest)); err != nil {
-			return err
-		}
-		if err := os.Chmod(path.Join(out, "bin", istioctlDest), 0o755); err != nil {
+		if _, err := copyIstioctl(manifest, arch, path.Join(out, "bin")); err != nil {
 			return err
 		}
 
@@ -112,62 +196,63 @@ func Archive(manifest model.Manifest) error {
 			}
 		}
 
-		if err := createArchive(arch, manifest, out); err != nil {
+		if err := createArchives(arch, manifest, out); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		if err := createStandaloneIstioctl(arch, manifest, out); err != nil {
-			return err
-		}
+// archiveDir is the directory the release archive for arch is assembled in.
+func archiveDir(manifest model.Manifest, arch string) string {
+	return path.Join(manifest.Directory, "work", "archive", arch, fmt.Sprintf("istio-%s", manifest.Version))
+}
 
-		// Handle creating additional archives of the older deprecated names.
-		// This is slower than simply copying the files, but keeps the change in one location.
-		// TODO - When we no longer need the older archives we can remove this creation.
-		if arch == "osx-amd64" || arch == "win-amd64" {
-			additionalArch := arch[:strings.IndexByte(arch, '-')]
-			if err := createArchive(additionalArch, manifest, out); err != nil {
-				return err
-			}
+// createArchives creates the release archive for arch, and that of its deprecated name, if it has one.
+func createArchives(arch string, manifest model.Manifest, out string) error {
+	if err := createArchive(arch, manifest, out); err != nil {
+		return err
+	}
 
-			if err := createStandaloneIstioctl(additionalArch, manifest, out); err != nil {
-				return err
-			}
+	// Handle creating additional archives of the older deprecated names.
+	// This is slower than simply copying the files, but keeps the change in one location.
+	// TODO - When we no longer need the older archives we can remove this creation.
+	if arch == "osx-amd64" || arch == "win-amd64" {
+		additionalArch := arch[:strings.IndexByte(arch, '-')]
+		if err := createArchive(additionalArch, manifest, out); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func createStandaloneIstioctl(arch string, manifest model.Manifest, out string) error {
-	var istioctlArchive string
-	// Create a stand alone archive for istioctl
-	// Windows should use zip, linux and osx tar
-	if strings.HasPrefix(arch, "win") {
-		istioctlArchive = fmt.Sprintf("istioctl-%s-%s.zip", manifest.Version, arch)
-		if err := util.ZipFolder(path.Join(out, "bin", "istioctl.exe"), path.Join(out, "bin", istioctlArchive)); err != nil {
-			return fmt.Errorf("failed to zip istioctl: %v", err)
-		}
-	} else {
-		istioctlArchive = fmt.Sprintf("istioctl-%s-%s.tar.gz", manifest.Version, arch)
-		icmd := util.VerboseCommand("tar", "-czf", istioctlArchive, "istioctl")
-		icmd.Dir = path.Join(out, "bin")
-		if err := icmd.Run(); err != nil {
-			return fmt.Errorf("failed to tar istioctl: %v", err)
-		}
-	}
-	// Move file over to the output directory. We move the file because we may reuse the directory for
-	// another archive (in the case of created a non-arch named archive). Also add a log message.
-	archivePath := path.Join(out, "bin", istioctlArchive)
-	dest := path.Join(manifest.OutDir(), istioctlArchive)
-	log.Infof("Moving %v -> %v", archivePath, dest)
-	if err := os.Rename(archivePath, dest); err != nil {
-		return fmt.Errorf("failed to package %v release archive: %v", arch, err)
+// istioctlBinary returns the name `make istioctl-all` gives the istioctl binary for the arch.
+func istioctlBinary(arch string) string {
+	binary := fmt.Sprintf("istioctl-%s", arch)
+	// The istioctl binaries for MacOS and Windows do not have the `-amd64` so remove from name.
+	// Windows also needs the `.exe` added.
+	if arch == "osx-amd64" {
+		binary = binary[:strings.LastIndexByte(binary, '-')]
+	}
+	if arch == "win-amd64" {
+		binary = binary[:strings.LastIndexByte(binary, '-')] + ".exe"
 	}
+	return binary
+}
 
-	// Create a SHA of the archive
-	if err := util.CreateSha(dest); err != nil {
-		return fmt.Errorf("failed to package %v: %v", dest, err)
+// copyIstioctl copies the istioctl binary for the arch into dir, returning the name of the copied binary.
+func copyIstioctl(manifest model.Manifest, arch string, dir string) (string, error) {
+	istioctlDest := "istioctl"
+	if arch == "win-amd64" {
+		istioctlDest += ".exe"
 	}
-	return nil
+	if err := util.CopyFile(path.Join(manifest.RepoOutDir("istio"), istioctlBinary(arch)), path.Join(dir, istioctlDest)); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(path.Join(dir, istioctlDest), 0o755); err != nil {
+		return "", err
+	}
+	return istioctlDest, nil
 }
 
 func createArchive(arch string, manifest model.Manifest, out string) error {
@@ -181,9 +266,7 @@ func createArchive(arch string, manifest model.Manifest, out string) error {
 		}
 	} else {
 		archive = fmt.Sprintf("istio-%s-%s.tar.gz", manifest.Version, arch)
-		cmd := util.VerboseCommand("tar", "-czf", archive, fmt.Sprintf("istio-%s", manifest.Version))
-		cmd.Dir = path.Join(out, "..")
-		if err := cmd.Run(); err != nil {
+		if err := util.TarGz(path.Join(out, ".."), archive, fmt.Sprintf("istio-%s", manifest.Version)); err != nil {
 			return err
 		}
 	}
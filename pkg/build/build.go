@@ -19,91 +19,144 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"istio.io/istio/pkg/log"
 	"sigs.k8s.io/yaml"
 
+	"github.com/alauda-mesh/release-builder/pkg"
 	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/notify"
 	"github.com/alauda-mesh/release-builder/pkg/util"
 )
 
 // Build will create all artifacts required by the manifest
 // This assumes the working directory has been setup and sources resolved.
 func Build(manifest model.Manifest) error {
-	if _, f := manifest.BuildOutputs[model.Docker]; f {
-		if err := Docker(manifest); err != nil {
-			return fmt.Errorf("failed to build Docker: %v", err)
+	if manifest.Reproducible {
+		if err := setupReproducible(manifest); err != nil {
+			return err
 		}
 	}
-
-	if err := SanitizeAllCharts(manifest); err != nil {
-		return fmt.Errorf("failed to sanitize charts: %v", err)
-	}
-	if util.IsValidSemver(manifest.Version) {
-		if _, f := manifest.BuildOutputs[model.Helm]; f {
-			if err := HelmCharts(manifest); err != nil {
-				return fmt.Errorf("failed to build HelmCharts: %v", err)
-			}
+	if len(manifest.BaseImages) > 0 {
+		images, err := VerifyBaseImages(manifest.BaseImages)
+		if err != nil {
+			return err
 		}
-	} else {
-		log.Warnf("Invalid Semantic Version. Skipping Charts build")
+		// The verified digests are recorded in the output manifest
+		manifest.BaseImages = images
+		manifest.BuildContainer = pinBuildContainer(manifest.BuildContainer, images)
 	}
-
-	if _, f := manifest.BuildOutputs[model.Debian]; f {
-		if err := Debian(manifest); err != nil {
-			return fmt.Errorf("failed to build Debian: %v", err)
+	if flags.containerized {
+		if err := verifyToolchain(manifest.BuildContainer); err != nil {
+			return err
 		}
 	}
-
-	if _, f := manifest.BuildOutputs[model.Rpm]; f {
-		if err := Rpm(manifest); err != nil {
-			return fmt.Errorf("failed to build Rpm: %v", err)
+	metrics := util.NewMetrics(manifest.Version)
+	// Metrics are written even if the build fails, so slow or failing steps can be investigated
+	buildErr := build(manifest, metrics)
+	if err := metrics.Write(metricsFile(manifest)); err != nil {
+		log.Warnf("failed to write build metrics: %v", err)
+	}
+	if flags.pushgateway != "" {
+		if err := metrics.Push(flags.pushgateway, "release-builder-build"); err != nil {
+			log.Warnf("failed to push build metrics: %v", err)
 		}
 	}
+	// Only a complete build is notified, rather than each step of a plan
+	if len(flags.steps) == 0 {
+		notify.Notify(manifest, "build", manifest.OutDir(), metrics, buildErr)
+	}
+	return buildErr
+}
 
-	if _, f := manifest.BuildOutputs[model.Archive]; f {
-		if err := Archive(manifest); err != nil {
-			return fmt.Errorf("failed to build Archive: %v", err)
-		}
+// metricsFile returns where the build metrics are written. They are kept out of the release, and each run of a plan
+// writes its own file, so steps do not overwrite each other.
+func metricsFile(manifest model.Manifest) string {
+	if flags.metrics != "" {
+		return flags.metrics
+	}
+	if len(flags.steps) > 0 {
+		return path.Join(manifest.WorkDir(), "metrics-"+strings.Join(flags.steps, "-")+".json")
 	}
+	return path.Join(manifest.WorkDir(), "metrics.json")
+}
 
-	if _, f := manifest.BuildOutputs[model.Grafana]; f {
-		if err := Grafana(manifest); err != nil {
-			return fmt.Errorf("failed to build Grafana: %v", err)
+func build(manifest model.Manifest, metrics *util.Metrics) error {
+	steps := Steps(manifest)
+	// Steps that do not run are recorded in the output manifest
+	skipped, err := skippedSteps(manifest, steps)
+	if err != nil {
+		return err
+	}
+	manifest.SkippedSteps = skipped
+	for _, step := range steps {
+		if !stepSelected(step.Name) {
+			log.Infof("Skipping step %v", step.Name)
+			continue
+		}
+		done, err := stepCompleted(manifest, step.Name)
+		if err != nil {
+			return err
+		}
+		if done {
+			log.Infof("Skipping step %v, completed in a previous run", step.Name)
+			continue
+		}
+		if err := setStepCompleted(manifest, step.Name, false); err != nil {
+			return err
+		}
+		run := func() error { return step.Run(manifest) }
+		if flags.containerized {
+			run = func() error { return runContainerized(manifest, step.Name) }
+		}
+		if err := metrics.Time(step.Name, manifest.OutDir(), run); err != nil {
+			return fmt.Errorf("failed to build %v: %v", step.Name, err)
+		}
+		if err := setStepCompleted(manifest, step.Name, true); err != nil {
+			return err
 		}
 	}
+	return updateSkippedSteps(manifest, steps)
+}
 
-	// Bundle all sources used in the build
-	cmd := util.VerboseCommand("tar", "-czf", "out/sources.tar.gz", "sources")
-	cmd.Dir = path.Join(manifest.Directory)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to bundle sources: %v", err)
+// updateSkippedSteps rewrites the skipped steps of the output manifest, if written, once the build is done. Steps of a
+// plan run after the manifest step are then no longer recorded as skipped by the time the plan completes.
+func updateSkippedSteps(manifest model.Manifest, steps []Step) error {
+	skipped, err := skippedSteps(manifest, steps)
+	if err != nil {
+		return err
 	}
-
-	if err := writeManifest(manifest, manifest.OutDir()); err != nil {
-		return fmt.Errorf("failed to write manifest: %v", err)
+	file := path.Join(manifest.OutDir(), "manifest.yaml")
+	if slices.Equal(skipped, manifest.SkippedSteps) || !util.FileExists(file) {
+		return nil
 	}
-
-	if err := writeLicense(manifest); err != nil {
-		return fmt.Errorf("failed to package license file: %v", err)
+	written, err := pkg.ReadManifest(file)
+	if err != nil {
+		return err
 	}
+	written.SkippedSteps = skipped
+	return writeManifest(written, manifest.OutDir())
+}
 
-	if manifest.DockerOutput == model.DockerOutputContext {
-		log.Warnf("Docker output in 'context' mode; will not produce SBOM.")
-	} else if manifest.SkipGenerateBillOfMaterials {
-		log.Warnf("Input manifest set SkipGenerateBillOfMaterials; will not produce SBOM.")
-	} else {
-		if err := GenerateBillOfMaterials(manifest); err != nil {
-			return fmt.Errorf("failed to generate sbom: %v", err)
-		}
+// setupReproducible configures the environment, inherited by all build commands, for reproducible output.
+// Timestamps are taken from the istio commit, and -trimpath removes the working directory from binaries.
+func setupReproducible(manifest model.Manifest) error {
+	epoch, err := util.SourceDateEpoch(manifest.RepoDir("istio"))
+	if err != nil {
+		return err
 	}
-
-	return nil
+	log.Infof("Building reproducibly with SOURCE_DATE_EPOCH=%v", epoch)
+	if err := os.Setenv("SOURCE_DATE_EPOCH", epoch); err != nil {
+		return err
+	}
+	return os.Setenv("GOFLAGS", strings.TrimSpace(os.Getenv("GOFLAGS")+" -trimpath"))
 }
 
 // writeLicense copies the complete list of licenses for all dependant repos
 func writeLicense(manifest model.Manifest) error {
-	if err := os.MkdirAll(filepath.Join(manifest.OutDir(), "licenses"), 0o750); err != nil {
+	if err := os.MkdirAll(filepath.Join(manifest.OutDir(), manifest.ArtifactDir("licenses", "")), 0o750); err != nil {
 		return fmt.Errorf("failed to create license dir: %v", err)
 	}
 	for repo := range manifest.Dependencies.Get() {
@@ -114,9 +167,7 @@ func writeLicense(manifest model.Manifest) error {
 			continue
 		}
 		// Package as a tar.gz since there are hundreds of files
-		cmd := util.VerboseCommand("tar", "-czf", filepath.Join(manifest.OutDir(), "licenses", repo+".tar.gz"), ".")
-		cmd.Dir = src
-		if err := cmd.Run(); err != nil {
+		if err := util.TarGz(src, filepath.Join(manifest.OutDir(), manifest.ArtifactDir("licenses", ""), repo+".tar.gz"), "."); err != nil {
 			return fmt.Errorf("failed to compress license: %v", err)
 		}
 	}
@@ -0,0 +1,73 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+func TestSkippedSteps(t *testing.T) {
+	manifest := model.Manifest{Directory: t.TempDir()}
+	steps := []Step{{Name: "helm"}, {Name: "docker"}, {Name: "archive"}, {Name: "manifest"}}
+	// docker ran as a previous step of the plan
+	if err := setStepCompleted(manifest, "docker", true); err != nil {
+		t.Fatal(err)
+	}
+	defer func(steps, skip []string) { flags.steps, flags.skipSteps = steps, skip }(flags.steps, flags.skipSteps)
+	flags.steps = []string{"helm", "archive", "manifest"}
+	flags.skipSteps = []string{"archive"}
+
+	got, err := skippedSteps(manifest, steps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{FetchSourcesStep, "archive"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestValidateStepNames(t *testing.T) {
+	defer func(steps, skip []string) { flags.steps, flags.skipSteps = steps, skip }(flags.steps, flags.skipSteps)
+	flags.steps = []string{FetchSourcesStep, "helm"}
+	flags.skipSteps = nil
+	manifest := model.Manifest{Version: "1.24.0", BuildOutputs: model.BuildOutputSet{model.Helm: {}}}
+	if err := validateStepNames(manifest); err != nil {
+		t.Fatal(err)
+	}
+	flags.skipSteps = []string{"hlem"}
+	if err := validateStepNames(manifest); err == nil {
+		t.Fatal("expected an unknown step to be rejected")
+	}
+}
+
+func TestIstioctlBuiltOnce(t *testing.T) {
+	manifest := model.Manifest{Version: "1.24.0", BuildOutputs: model.BuildOutputSet{model.Archive: {}, model.Istioctl: {}}}
+	deps := map[string][]string{}
+	for _, s := range Steps(manifest) {
+		deps[s.Name] = s.DependsOn
+	}
+	if _, f := deps["istioctl-binaries"]; !f {
+		t.Fatalf("expected an istioctl-binaries step, got %v", deps)
+	}
+	for _, step := range []string{"archive", "istioctl"} {
+		if !slices.Contains(deps[step], "istioctl-binaries") {
+			t.Errorf("expected %v to depend on istioctl-binaries, got %v", step, deps[step])
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// lintValues are the values permutations each chart is rendered with. Charts without profiles ignore them.
+var lintValues = map[string][]string{
+	"default":   nil,
+	"ambient":   {"--set", "profile=ambient"},
+	"demo":      {"--set", "profile=demo"},
+	"openshift": {"--set", "profile=openshift"},
+}
+
+// LintCharts runs helm lint on each sanitized chart, and renders it with each values permutation, validating
+// the output against the Kubernetes schemas with kubeconform.
+func LintCharts(manifest model.Manifest) error {
+	for _, source := range sanitizedCharts(manifest) {
+		chart, dir := source.chart, source.dir
+		// Dependencies must be present to lint or render
+		dep := util.VerboseCommand("helm", "dep", "update")
+		dep.Dir = dir
+		if err := dep.Run(); err != nil {
+			return fmt.Errorf("dep update %v: %v", chart, err)
+		}
+		if err := util.VerboseCommand("helm", "lint", dir).Run(); err != nil {
+			return fmt.Errorf("lint %v: %v", chart, err)
+		}
+		for name, values := range lintValues {
+			rendered := &bytes.Buffer{}
+			c := util.VerboseCommand("helm", append([]string{"template", "release-lint", dir}, values...)...)
+			c.Stdout = rendered
+			if err := c.Run(); err != nil {
+				return fmt.Errorf("template %v (%v): %v", chart, name, err)
+			}
+			if err := kubeconform(manifest, rendered); err != nil {
+				return fmt.Errorf("schema validation %v (%v): %v", chart, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// kubeconform validates the rendered objects against the schemas of the newest Kubernetes version in the
+// manifest. Istio CRDs have no published schemas, so are skipped.
+func kubeconform(manifest model.Manifest, rendered *bytes.Buffer) error {
+	args := []string{"-strict", "-summary", "-ignore-missing-schemas"}
+	if n := len(manifest.KubernetesVersions); n > 0 {
+		args = append(args, "-kubernetes-version", manifest.KubernetesVersions[n-1])
+	}
+	c := util.VerboseCommand("kubeconform", args...)
+	c.Stdin = rendered
+	return c.Run()
+}
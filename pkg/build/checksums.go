@@ -0,0 +1,104 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// ChecksumsFile lists the sha256 of every file in a release, in the format of sha256sum, so a release fetched from
+// elsewhere can be verified in full.
+const ChecksumsFile = "SHA256SUMS"
+
+// writeChecksums writes the ChecksumsFile to the root of the release.
+func writeChecksums(manifest model.Manifest) error {
+	return WriteChecksums(manifest.OutDir())
+}
+
+// WriteChecksums writes the ChecksumsFile for the release in out, listing every other file in it.
+func WriteChecksums(out string) error {
+	lines := []string{}
+	err := filepath.WalkDir(out, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(out, p)
+		if err != nil {
+			return err
+		}
+		if rel == ChecksumsFile {
+			return nil
+		}
+		sha, err := fileSha256(p)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, fmt.Sprintf("%s  %s", sha, filepath.ToSlash(rel)))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read release: %v", err)
+	}
+	return os.WriteFile(path.Join(out, ChecksumsFile), []byte(strings.Join(lines, "\n")+"\n"), 0o644)
+}
+
+// ParseChecksums parses a ChecksumsFile, mapping each file to its sha256.
+func ParseChecksums(by []byte) (map[string]string, error) {
+	sums := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(by)), "\n") {
+		sha, name, f := strings.Cut(line, "  ")
+		if !f {
+			return nil, fmt.Errorf("invalid line in %v: %q", ChecksumsFile, line)
+		}
+		sums[name] = sha
+	}
+	return sums, nil
+}
+
+// AddChecksums updates the ChecksumsFile of the release in out with files, which are relative to out, such as reports
+// added to the release after it was built. Other entries are kept as is. A release without checksums is left as is.
+func AddChecksums(out string, files []string) error {
+	by, err := os.ReadFile(path.Join(out, ChecksumsFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	sums, err := ParseChecksums(by)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		sha, err := fileSha256(path.Join(out, f))
+		if err != nil {
+			return err
+		}
+		sums[filepath.ToSlash(f)] = sha
+	}
+	lines := []string{}
+	for _, name := range slices.Sorted(maps.Keys(sums)) {
+		lines = append(lines, fmt.Sprintf("%s  %s", sums[name], name))
+	}
+	return os.WriteFile(path.Join(out, ChecksumsFile), []byte(strings.Join(lines, "\n")+"\n"), 0o644)
+}
@@ -16,19 +16,36 @@ package build
 
 import (
 	"fmt"
+	"slices"
 
 	"github.com/spf13/cobra"
 	"istio.io/istio/pkg/log"
 
 	"github.com/alauda-mesh/release-builder/pkg"
+	"github.com/alauda-mesh/release-builder/pkg/model"
 	"github.com/alauda-mesh/release-builder/pkg/util"
 )
 
 var (
 	flags = struct {
 		manifest        string
+		testManifest    string
 		githubTokenFile string
 		buildBaseImages bool
+		pushgateway     string
+		metrics         string
+		steps           []string
+		skipSteps       []string
+		resume          bool
+		containerized   bool
+		depth           int
+		reference       string
+		cloneCache      string
+		profile         string
+		set             []string
+		force           bool
+		locked          bool
+		lockFile        string
 	}{
 		manifest: "example/manifest.yaml",
 	}
@@ -38,15 +55,37 @@ var (
 		SilenceUsage: true,
 		Args:         cobra.ExactArgs(0),
 		RunE: func(c *cobra.Command, _ []string) error {
-			inManifest, err := pkg.ReadInManifest(flags.manifest)
+			var inManifest model.InputManifest
+			var err error
+			if flags.testManifest != "" {
+				inManifest, err = pkg.TestManifest(flags.testManifest)
+			} else {
+				inManifest, err = pkg.ReadInManifest(flags.manifest, flags.profile, flags.set)
+			}
 			if err != nil {
 				return fmt.Errorf("failed to unmarshal manifest: %v", err)
 			}
+			if flags.locked {
+				lockFile := flags.lockFile
+				if lockFile == "" {
+					lockFile = pkg.LockFile(flags.manifest)
+				}
+				lock, err := pkg.ReadLock(lockFile)
+				if err != nil {
+					return err
+				}
+				if err := pkg.ApplyLock(&inManifest, lock); err != nil {
+					return fmt.Errorf("failed to apply %v: %v", lockFile, err)
+				}
+			}
 
 			manifest, err := pkg.InputManifestToManifest(inManifest)
 			if err != nil {
 				return fmt.Errorf("failed to setup manifest: %v", err)
 			}
+			if err := util.ConfigureNetwork(manifest.Network); err != nil {
+				return fmt.Errorf("failed to configure network: %v", err)
+			}
 
 			// Save these values as they are needed for git commits and PRs
 			savedIstioGit := inManifest.Dependencies.Get()["istio"].Git
@@ -54,14 +93,54 @@ var (
 			log.Infof("Saved Istio git:\n%+v", savedIstioGit)
 			log.Infof("Saved Istio branch:\n%+v", savedIstioBranch)
 
+			if err := validateStepNames(manifest); err != nil {
+				return err
+			}
+			if flags.resume && inManifest.Directory == "" {
+				return fmt.Errorf("--resume requires the manifest to set directory")
+			}
+			if flags.containerized && (inManifest.Directory == "" || manifest.BuildContainer == nil) {
+				return fmt.Errorf("--containerized requires the manifest to set directory and buildContainer")
+			}
+
 			if err := pkg.SetupWorkDir(manifest.Directory); err != nil {
 				return fmt.Errorf("failed to setup work dir: %v", err)
 			}
+			if !flags.resume && len(flags.steps) == 0 {
+				if err := resetState(manifest); err != nil {
+					return err
+				}
+			}
+
+			fetched, err := stepCompleted(manifest, FetchSourcesStep)
+			if err != nil {
+				return err
+			}
+			if fetched {
+				log.Infof("Resuming; sources already fetched in %v", manifest.WorkDir())
+			} else if stepSelected(FetchSourcesStep) {
+				if err := setStepCompleted(manifest, FetchSourcesStep, false); err != nil {
+					return err
+				}
+				cloneOpts := util.CloneOptions{Depth: flags.depth, Reference: flags.reference, CacheDir: flags.cloneCache}
+				err := pkg.Sources(manifest, cloneOpts)
+				util.LogTransferSummary()
+				if err != nil {
+					return fmt.Errorf("failed to fetch sources: %v", err)
+				}
+				if err := setStepCompleted(manifest, FetchSourcesStep, true); err != nil {
+					return err
+				}
+				log.Infof("Fetched all sources and setup working directory at %v", manifest.WorkDir())
+			}
 
-			if err := pkg.Sources(manifest); err != nil {
-				return fmt.Errorf("failed to fetch sources: %v", err)
+			// Checks are verified by every build, whether or not it fetches the sources, unless forced
+			if err := pkg.VerifyDependencyChecks(manifest); err != nil {
+				if !flags.force {
+					return fmt.Errorf("refusing to build from failing commits, pass --force to override: %v", err)
+				}
+				log.Warnf("Building despite failing dependency checks: %v", err)
 			}
-			log.Infof("Fetched all sources and setup working directory at %v", manifest.WorkDir())
 
 			if err := pkg.StandardizeManifest(&manifest); err != nil {
 				return fmt.Errorf("failed to standardize manifest: %v", err)
@@ -91,10 +170,59 @@ var (
 func init() {
 	buildCmd.PersistentFlags().StringVar(&flags.manifest, "manifest", flags.manifest,
 		"The manifest to build.")
+	buildCmd.PersistentFlags().StringVar(&flags.testManifest, "test-manifest", flags.testManifest,
+		"Build a fake release from a tiny built in istio repo in this directory, rather than --manifest, to test the builder itself.")
+	buildCmd.PersistentFlags().StringVar(&flags.profile, "profile", flags.profile,
+		"The manifest profile to apply. Example: daily")
+	buildCmd.PersistentFlags().StringArrayVar(&flags.set, "set", flags.set,
+		"Override a manifest field, in the form key=value. Keys are dot separated, such as dependencies.istio.branch. May be repeated.")
 	buildCmd.PersistentFlags().StringVar(&flags.githubTokenFile, "githubtoken", flags.githubTokenFile,
 		"The file containing a github token.")
 	buildCmd.PersistentFlags().BoolVar(&flags.buildBaseImages, "build-base-images", flags.buildBaseImages,
 		"When set scan base images for vulnerabilities and build new ones if needed.")
+	buildCmd.PersistentFlags().StringVar(&flags.metrics, "metrics", flags.metrics,
+		"The file to write build step metrics to, as json. Defaults to a file per run of --steps in the working directory.")
+	buildCmd.PersistentFlags().StringVar(&flags.pushgateway, "pushgateway", flags.pushgateway,
+		"The Prometheus pushgateway to push build step metrics to. Example: http://pushgateway:9091")
+	buildCmd.PersistentFlags().StringSliceVar(&flags.steps, "steps", flags.steps,
+		"The build steps to run. If unset, all steps are run. Example: fetch-sources,helm")
+	buildCmd.PersistentFlags().StringSliceVar(&flags.skipSteps, "skip-steps", flags.skipSteps,
+		"The build steps to skip. Steps that do not run are recorded in the output manifest. Example: rpm,docker")
+	buildCmd.PersistentFlags().BoolVar(&flags.resume, "resume", flags.resume,
+		"Resume a failed build, skipping the steps that already completed in the working directory.")
+	buildCmd.PersistentFlags().BoolVar(&flags.containerized, "containerized", flags.containerized,
+		"Run each build step inside the manifest's buildContainer image, so all builds use the same pinned toolchain.")
+	buildCmd.PersistentFlags().IntVar(&flags.depth, "depth", flags.depth,
+		"If set, shallow clone dependencies to this depth. Branches are always shallow cloned.")
+	buildCmd.PersistentFlags().StringVar(&flags.reference, "reference", flags.reference,
+		"A directory of local git mirrors, named <repo> or <repo>.git, to borrow objects from when cloning.")
+	buildCmd.PersistentFlags().StringVar(&flags.cloneCache, "clone-cache", flags.cloneCache,
+		"A directory to persist git mirrors of dependencies in, so later builds only fetch new commits.")
+	buildCmd.PersistentFlags().BoolVar(&flags.force, "force", flags.force,
+		"Build even if the requiredChecks of a dependency are failing on its resolved SHA.")
+	buildCmd.PersistentFlags().BoolVar(&flags.locked, "locked", flags.locked,
+		"Build the dependencies at the SHAs in the lock file written by release-builder lock, failing if it is out of date.")
+	buildCmd.PersistentFlags().StringVar(&flags.lockFile, "lockfile", flags.lockFile,
+		"The lock file to build with --locked. Defaults to manifest.lock.yaml alongside the manifest.")
+}
+
+// validateStepNames checks the steps passed to --steps and --skip-steps are steps of the build.
+func validateStepNames(manifest model.Manifest) error {
+	known := append([]string{FetchSourcesStep}, stepNames(Steps(manifest))...)
+	for _, name := range append(slices.Clone(flags.steps), flags.skipSteps...) {
+		if !slices.Contains(known, name) {
+			return fmt.Errorf("unknown step %q, expected one of %v", name, known)
+		}
+	}
+	return nil
+}
+
+// stepSelected returns true if the named step should run.
+func stepSelected(name string) bool {
+	if slices.Contains(flags.skipSteps, name) {
+		return false
+	}
+	return len(flags.steps) == 0 || slices.Contains(flags.steps, name)
 }
 
 func GetBuildCommand() *cobra.Command {
@@ -0,0 +1,122 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// toolchainVersionCommands are the commands printing the version of each tool that can be pinned.
+var toolchainVersionCommands = map[string][]string{
+	"go":   {"go", "version"},
+	"helm": {"helm", "version", "--short"},
+	"fpm":  {"fpm", "--version"},
+}
+
+// containerEnv are passed through to containerized steps.
+var containerEnv = []string{
+	"SOURCE_DATE_EPOCH", "GOFLAGS", "GITHUB_TOKEN", "GH_TOKEN", "DOCKER_CONFIG",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+}
+
+func containerRuntime(c *model.BuildContainer) string {
+	if c.Runtime != "" {
+		return c.Runtime
+	}
+	return "docker"
+}
+
+// verifyToolchain checks the build image provides the pinned tool versions.
+func verifyToolchain(c *model.BuildContainer) error {
+	tools := make([]string, 0, len(c.Toolchain))
+	for tool := range c.Toolchain {
+		tools = append(tools, tool)
+	}
+	sort.Strings(tools)
+	for _, tool := range tools {
+		want := c.Toolchain[tool]
+		cmd, f := toolchainVersionCommands[tool]
+		if !f {
+			return fmt.Errorf("unknown toolchain %v", tool)
+		}
+		out, err := util.RunWithOutput(containerRuntime(c), append([]string{"run", "--rm", "--entrypoint", cmd[0], c.Image}, cmd[1:]...)...)
+		if err != nil {
+			return fmt.Errorf("failed to get %v version in %v: %v", tool, c.Image, err)
+		}
+		if !strings.Contains(out, want) {
+			return fmt.Errorf("build image %v has %v %q, expected %v", c.Image, tool, strings.TrimSpace(out), want)
+		}
+		log.Infof("Verified build image has %v %v", tool, want)
+	}
+	return nil
+}
+
+// runContainerized runs the build step inside the build image, as `release-builder build --steps <step>`. The
+// working directory, manifest, and this binary are mounted at the same paths, along with the docker socket for
+// steps building images.
+func runContainerized(manifest model.Manifest, step string) error {
+	c := manifest.BuildContainer
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	manifestFile, err := filepath.Abs(flags.manifest)
+	if err != nil {
+		return err
+	}
+	args := []string{
+		"run", "--rm",
+		"-v", manifest.Directory + ":" + manifest.Directory,
+		"-v", manifestFile + ":" + manifestFile + ":ro",
+		"-v", exe + ":/usr/local/bin/release-builder:ro",
+		"-v", "/var/run/docker.sock:/var/run/docker.sock",
+		"-w", manifest.Directory,
+	}
+	for _, env := range containerEnv {
+		if _, f := os.LookupEnv(env); f {
+			// Only the name is passed, so values are not logged
+			args = append(args, "-e", env)
+		}
+	}
+	caBundle := ""
+	if util.NetworkConfig.CABundle != "" {
+		if caBundle, err = filepath.Abs(util.NetworkConfig.CABundle); err != nil {
+			return err
+		}
+		args = append(args, "-v", caBundle+":"+caBundle+":ro")
+	}
+	args = append(args, "--entrypoint", "/usr/local/bin/release-builder", c.Image, "build", "--manifest", manifestFile)
+	if caBundle != "" {
+		args = append(args, "--ca-bundle", caBundle)
+	}
+	if flags.profile != "" {
+		args = append(args, "--profile", flags.profile)
+	}
+	for _, o := range flags.set {
+		args = append(args, "--set", o)
+	}
+	// The checks were already verified, or forced, by the build running the step
+	args = append(args, "--steps", step, "--force")
+	return util.VerboseCommand(containerRuntime(c), args...).Run()
+}
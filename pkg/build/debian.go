@@ -46,10 +46,10 @@ func runDeb(manifest model.Manifest, envs []string, arch, output string) error {
 		return fmt.Errorf("failed to build sidecar.deb: %v", err)
 	}
 
-	if err := util.CopyFile(path.Join(manifest.RepoArchOutDir("istio", arch), "istio-sidecar.deb"), path.Join(manifest.OutDir(), "deb", output)); err != nil {
+	if err := util.CopyFile(path.Join(manifest.RepoArchOutDir("istio", arch), "istio-sidecar.deb"), path.Join(manifest.OutDir(), manifest.ArtifactDir("deb", arch), output)); err != nil {
 		return fmt.Errorf("failed to package istio-sidecar.deb: %v", err)
 	}
-	if err := util.CreateSha(path.Join(manifest.OutDir(), "deb", output)); err != nil {
+	if err := util.CreateSha(path.Join(manifest.OutDir(), manifest.ArtifactDir("deb", arch), output)); err != nil {
 		return fmt.Errorf("failed to package istio-sidecar.deb: %v", err)
 	}
 	return nil
@@ -0,0 +1,110 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"debug/elf"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// istioctlLdflags returns the ldflags istioctl is built with. With debug symbols, the debug info is kept, to be split
+// out after building, and a GNU build ID is added, which debuggers and debuginfod look the debug info up by.
+func istioctlLdflags(manifest model.Manifest) string {
+	if manifest.DebugSymbols {
+		return "-extldflags -static -B gobuildid"
+	}
+	return "-extldflags -static -s -w"
+}
+
+// splitIstioctlDebugInfo strips the debug info of the istioctl binaries. The debug info of the linux binaries is
+// written to a debug-symbols-<version>-linux-<arch>.tar.gz archive per architecture, in the debuginfod layout of
+// .build-id/<xx>/<rest of the build ID>.debug. llvm-objcopy is used, as it handles every platform and architecture.
+func splitIstioctlDebugInfo(manifest model.Manifest) error {
+	for _, arch := range archiveArchitectures(manifest) {
+		binary := path.Join(manifest.RepoOutDir("istio"), istioctlBinary(arch))
+		if !strings.HasPrefix(arch, "linux-") {
+			if err := util.VerboseCommand("llvm-objcopy", "--strip-debug", binary).Run(); err != nil {
+				return fmt.Errorf("failed to strip %v: %v", path.Base(binary), err)
+			}
+			continue
+		}
+		id, hasDebug, err := elfBuildID(binary)
+		if err != nil {
+			return fmt.Errorf("failed to read %v: %v", path.Base(binary), err)
+		}
+		// The binaries are split once, by the istioctl-binaries step that builds them, so always have debug info
+		// unless the build dropped the debug symbols ldflags
+		if !hasDebug {
+			return fmt.Errorf("%v has no debug info, expected it to be built with %q", path.Base(binary), istioctlLdflags(manifest))
+		}
+		if len(id) < 3 {
+			return fmt.Errorf("%v has no GNU build ID", path.Base(binary))
+		}
+		dir := path.Join(manifest.WorkDir(), "debug-symbols", arch)
+		debug := path.Join(dir, ".build-id", id[:2], id[2:]+".debug")
+		if err := os.MkdirAll(path.Dir(debug), 0o755); err != nil {
+			return err
+		}
+		if err := util.VerboseCommand("llvm-objcopy", "--only-keep-debug", binary, debug).Run(); err != nil {
+			return fmt.Errorf("failed to extract debug info of %v: %v", path.Base(binary), err)
+		}
+		if err := util.VerboseCommand("llvm-objcopy", "--strip-debug", "--add-gnu-debuglink="+debug, binary).Run(); err != nil {
+			return fmt.Errorf("failed to strip %v: %v", path.Base(binary), err)
+		}
+		archive := path.Join(manifest.OutDir(), fmt.Sprintf("debug-symbols-%s-%s.tar.gz", manifest.Version, arch))
+		if err := util.TarGz(dir, archive, ".build-id"); err != nil {
+			return fmt.Errorf("failed to archive debug symbols: %v", err)
+		}
+		if err := util.CreateSha(archive); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// elfBuildID returns the hex GNU build ID of the ELF binary, and whether it has debug info.
+func elfBuildID(file string) (string, bool, error) {
+	f, err := elf.Open(file)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+	hasDebug := f.Section(".debug_info") != nil || f.Section(".zdebug_info") != nil
+	s := f.Section(".note.gnu.build-id")
+	if s == nil {
+		return "", hasDebug, nil
+	}
+	note, err := s.Data()
+	if err != nil {
+		return "", hasDebug, err
+	}
+	// The note is the name and descriptor sizes, the type, then the name padded to 4 bytes, and the ID
+	if len(note) < 12 {
+		return "", hasDebug, fmt.Errorf("invalid build ID note")
+	}
+	nameSize, descSize := f.ByteOrder.Uint32(note[0:4]), f.ByteOrder.Uint32(note[4:8])
+	start := 12 + (nameSize+3)&^3
+	if uint32(len(note)) < start+descSize {
+		return "", hasDebug, fmt.Errorf("invalid build ID note")
+	}
+	return hex.EncodeToString(note[start : start+descSize]), hasDebug, nil
+}
@@ -15,8 +15,23 @@
 package build
 
 import (
+	"compress/gzip"
 	"fmt"
+	"io"
+	"os"
 	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"istio.io/istio/pkg/log"
 
 	"github.com/alauda-mesh/release-builder/pkg/model"
 	"github.com/alauda-mesh/release-builder/pkg/util"
@@ -25,8 +40,9 @@ import (
 // Docker builds all docker images and outputs them as tar.gz files
 // docker.save in the repos does most of the work, we just need to call this and copy the files over
 func Docker(manifest model.Manifest) error {
-	// Build both default and distroless variants
-	env := []string{"DOCKER_BUILD_VARIANTS=debug distroless"}
+	env := []string{"DOCKER_BUILD_VARIANTS=" + strings.Join(manifest.ImageVariants, " ")}
+	// Images are built on the verified bases, by digest
+	env = append(env, baseImageArgs(manifest.BaseImages)...)
 
 	if manifest.ProxyOverride != "" {
 		// Add the vars to tell Istio to use our own Envoy binary
@@ -42,10 +58,227 @@ func Docker(manifest model.Manifest) error {
 	}
 	if util.FileExists(path.Join(manifest.RepoOutDir("istio"), "docker")) {
 		// Some repos output docker files to the source repo
-		if err := util.CopyFilesToDir(path.Join(manifest.RepoOutDir("istio"), "docker"), path.Join(manifest.OutDir(), "docker")); err != nil {
+		if err := util.CopyFilesToDir(path.Join(manifest.RepoOutDir("istio"), "docker"), path.Join(manifest.OutDir(), manifest.ArtifactDir("docker", ""))); err != nil {
 			return fmt.Errorf("failed to package docker images: %v", err)
 		}
 	}
+	// Images loaded into the docker context are left as built
+	if manifest.DockerOutput != model.DockerOutputContext {
+		labels, err := imageLabels(manifest)
+		if err != nil {
+			return err
+		}
+		if err := labelImages(manifest, labels); err != nil {
+			return fmt.Errorf("failed to label docker images: %v", err)
+		}
+	}
+	if manifest.DockerOutput == model.DockerOutputOCI {
+		if err := exportOCILayouts(manifest); err != nil {
+			return fmt.Errorf("failed to export oci layouts: %v", err)
+		}
+	}
 
 	return nil
 }
+
+// ChannelLabel is the image label recording the release channel of the build.
+const ChannelLabel = "io.istio.channel"
+
+// imageLabels returns the labels added to every image of the release: the OCI labels tracing the image back to its
+// source, the channel, and the manifest image labels.
+func imageLabels(manifest model.Manifest) (map[string]string, error) {
+	labels := map[string]string{
+		"org.opencontainers.image.version": manifest.Version,
+		"org.opencontainers.image.created": buildDate().Format(time.RFC3339),
+	}
+	if istio := manifest.Dependencies.Istio; istio != nil {
+		if istio.Sha != "" {
+			labels["org.opencontainers.image.revision"] = istio.Sha
+		}
+		if istio.Git != "" {
+			labels["org.opencontainers.image.source"] = strings.TrimSuffix(istio.Git, ".git")
+		}
+	}
+	if manifest.Channel != "" {
+		labels[ChannelLabel] = string(manifest.Channel)
+	}
+	for k, v := range manifest.ImageLabels {
+		t, err := template.New(k).Option("missingkey=error").Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image label %v: %v", k, err)
+		}
+		b := &strings.Builder{}
+		if err := t.Execute(b, manifest); err != nil {
+			return nil, fmt.Errorf("invalid image label %v: %v", k, err)
+		}
+		labels[k] = b.String()
+	}
+	return labels, nil
+}
+
+// labelImages adds the labels to the config of each docker archive, writing it again with the same tag.
+func labelImages(manifest model.Manifest, labels map[string]string) error {
+	dir := path.Join(manifest.OutDir(), manifest.ArtifactDir("docker", ""))
+	if !util.FileExists(dir) {
+		return nil
+	}
+	archives, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, a := range archives {
+		if !strings.HasSuffix(a.Name(), ".tar.gz") {
+			continue
+		}
+		archive := path.Join(dir, a.Name())
+		opener := func() (io.ReadCloser, error) { return gzipFile(archive) }
+		m, err := tarball.LoadManifest(opener)
+		if err != nil || len(m) != 1 || len(m[0].RepoTags) == 0 {
+			return fmt.Errorf("failed to read %v: expected a single tagged image: %v", a.Name(), err)
+		}
+		tag, err := name.NewTag(m[0].RepoTags[0])
+		if err != nil {
+			return err
+		}
+		img, err := tarball.Image(opener, &tag)
+		if err != nil {
+			return fmt.Errorf("failed to read %v: %v", a.Name(), err)
+		}
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			return fmt.Errorf("failed to read %v config: %v", a.Name(), err)
+		}
+		config := *cfg.Config.DeepCopy()
+		if config.Labels == nil {
+			config.Labels = map[string]string{}
+		}
+		for k, v := range labels {
+			config.Labels[k] = v
+		}
+		labeled, err := mutate.Config(img, config)
+		if err != nil {
+			return err
+		}
+		// The archive is read lazily, so the labeled image is written alongside it first
+		if err := WriteImageArchive(archive+".tmp", tag, labeled); err != nil {
+			return fmt.Errorf("failed to write %v: %v", a.Name(), err)
+		}
+		if err := os.Rename(archive+".tmp", archive); err != nil {
+			return err
+		}
+	}
+	log.Infof("Labeled images with %v", labels)
+	return nil
+}
+
+// exportOCILayouts writes each docker archive, one per image and architecture, as an OCI image layout directory.
+func exportOCILayouts(manifest model.Manifest) error {
+	dir := path.Join(manifest.OutDir(), manifest.ArtifactDir("docker", ""))
+	archives, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, a := range archives {
+		if !strings.HasSuffix(a.Name(), ".tar.gz") {
+			continue
+		}
+		archive := path.Join(dir, a.Name())
+		img, err := tarball.Image(func() (io.ReadCloser, error) { return gzipFile(archive) }, nil)
+		if err != nil {
+			return fmt.Errorf("failed to read %v: %v", a.Name(), err)
+		}
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			return fmt.Errorf("failed to read %v config: %v", a.Name(), err)
+		}
+		out := path.Join(dir, "oci", strings.TrimSuffix(a.Name(), ".tar.gz"))
+		p, err := layout.Write(out, empty.Index)
+		if err != nil {
+			return err
+		}
+		if err := p.AppendImage(img,
+			layout.WithPlatform(v1.Platform{OS: cfg.OS, Architecture: cfg.Architecture, Variant: cfg.Variant}),
+			layout.WithAnnotations(map[string]string{"org.opencontainers.image.ref.name": manifest.Version}),
+		); err != nil {
+			return fmt.Errorf("failed to write %v: %v", out, err)
+		}
+		log.Infof("Exported %v as oci layout %v", a.Name(), out)
+	}
+	return nil
+}
+
+// PlatformIndex builds a multi-architecture manifest list of the images.
+func PlatformIndex(images []v1.Image) (v1.ImageIndex, error) {
+	var index v1.ImageIndex = empty.Index
+	index = mutate.IndexMediaType(index, types.DockerManifestList)
+	for _, img := range images {
+		mt, err := img.MediaType()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get mediatype: %w", err)
+		}
+
+		h, err := img.Digest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute digest: %w", err)
+		}
+
+		size, err := img.Size()
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute size: %w", err)
+		}
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get config file: %w", err)
+		}
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{
+			Add: img,
+			Descriptor: v1.Descriptor{
+				MediaType: mt,
+				Size:      size,
+				Digest:    h,
+				Platform: &v1.Platform{
+					Architecture: cfg.Architecture,
+					OS:           cfg.OS,
+					OSVersion:    cfg.OSVersion,
+					Variant:      cfg.Variant,
+					Features:     nil,
+				},
+			},
+		})
+	}
+	return index, nil
+}
+
+// gzipFile opens a gzipped file, closing the file along with the reader.
+func gzipFile(file string) (io.ReadCloser, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, f}, nil
+}
+
+// WriteImageArchive writes img, tagged as tag, to a gzipped archive as `docker save` would.
+func WriteImageArchive(file string, tag name.Tag, img v1.Image) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	if err := tarball.Write(tag, img, gz); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,56 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"testing"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+func TestImageLabels(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	manifest := model.Manifest{
+		Version: "1.25.0",
+		Channel: model.ChannelStable,
+		Dependencies: model.IstioDependencies{
+			Istio: &model.Dependency{Git: "https://github.com/istio/istio.git", Sha: "abc123"},
+		},
+		ImageLabels: map[string]string{
+			"org.opencontainers.image.source": "https://example.com/istio",
+			"example.com/release":             "istio-{{.Version}}",
+		},
+	}
+	labels, err := imageLabels(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"org.opencontainers.image.version":  "1.25.0",
+		"org.opencontainers.image.created":  "2023-11-14T22:13:20Z",
+		"org.opencontainers.image.revision": "abc123",
+		"org.opencontainers.image.source":   "https://example.com/istio",
+		ChannelLabel:                        "stable",
+		"example.com/release":               "istio-1.25.0",
+	}
+	for k, v := range want {
+		if labels[k] != v {
+			t.Errorf("label %v = %q, want %q", k, labels[k], v)
+		}
+	}
+	if len(labels) != len(want) {
+		t.Errorf("got labels %v, want %v", labels, want)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// docsManifest describes the contents of the docs bundle, so the website can check which sources it was built from.
+type docsManifest struct {
+	Version  string   `json:"version"`
+	IstioSha string   `json:"istioSha"`
+	ApiSha   string   `json:"apiSha"` //nolint: revive, stylecheck
+	Files    []string `json:"files"`
+}
+
+// Docs builds the istio.io reference docs for the release, packaged as istio-docs-<version>.tar.gz. This holds the
+// istioctl command reference, and the API reference generated in istio/api at the pinned SHA.
+func Docs(manifest model.Manifest) error {
+	name := "istio-docs-" + manifest.Version
+	work := path.Join(manifest.WorkDir(), "docs")
+	dir := path.Join(work, name)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+
+	if err := util.RunMake(manifest, "istio", nil, "istioctl"); err != nil {
+		return fmt.Errorf("failed to make istioctl: %v", err)
+	}
+	commands := path.Join(dir, "reference", "commands", "istioctl")
+	if err := os.MkdirAll(commands, 0o750); err != nil {
+		return err
+	}
+	istioctl := path.Join(path.Dir(manifest.RepoOutDir("istio")), "istioctl")
+	if err := util.VerboseCommand(istioctl, "collateral", "--html_fragment_with_front_matter", "-o", commands).Run(); err != nil {
+		return fmt.Errorf("failed to generate istioctl reference: %v", err)
+	}
+
+	if err := copyAPIDocs(manifest.RepoDir("api"), path.Join(dir, "reference", "config")); err != nil {
+		return fmt.Errorf("failed to copy api reference: %v", err)
+	}
+
+	files := []string{}
+	if err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	}); err != nil {
+		return err
+	}
+	sort.Strings(files)
+	m := docsManifest{
+		Version:  manifest.Version,
+		IstioSha: manifest.Dependencies.Istio.Sha,
+		ApiSha:   manifest.Dependencies.Api.Sha,
+		Files:    files,
+	}
+	by, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path.Join(dir, "manifest.yaml"), by, 0o640); err != nil {
+		return err
+	}
+
+	out := path.Join(manifest.OutDir(), manifest.ArtifactDir("docs", ""))
+	if err := os.MkdirAll(out, 0o750); err != nil {
+		return err
+	}
+	archive := path.Join(out, name+".tar.gz")
+	if err := util.TarGz(work, archive, name); err != nil {
+		return fmt.Errorf("failed to tar docs: %v", err)
+	}
+	if err := util.CreateSha(archive); err != nil {
+		return err
+	}
+	log.Infof("Built docs bundle %v with %d files", archive, len(files))
+	return nil
+}
+
+// copyAPIDocs copies the generated *.pb.html API reference pages from the api repo, keeping their paths.
+func copyAPIDocs(api string, dst string) error {
+	found := false
+	err := filepath.WalkDir(api, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if strings.HasPrefix(d.Name(), ".") || d.Name() == "vendor" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(d.Name(), ".pb.html") {
+			return nil
+		}
+		rel, err := filepath.Rel(api, p)
+		if err != nil {
+			return err
+		}
+		found = true
+		return util.CopyFile(p, path.Join(dst, rel))
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no api reference found in %v", api)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"text/template"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// addArchiveExtraFiles writes the extra files for the archives to dir, the root of an archive.
+func addArchiveExtraFiles(manifest model.Manifest, dir string) error {
+	for _, f := range manifest.ExtraFiles {
+		if !f.Archive {
+			continue
+		}
+		if err := writeExtraFile(manifest, f, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addChartExtraFiles writes the extra files for the chart in dir to it.
+func addChartExtraFiles(manifest model.Manifest, dir string) error {
+	if len(manifest.ExtraFiles) == 0 {
+		return nil
+	}
+	by, err := os.ReadFile(path.Join(dir, "Chart.yaml"))
+	if err != nil {
+		return err
+	}
+	metadata := chart.Metadata{}
+	if err := yaml.Unmarshal(by, &metadata); err != nil {
+		return fmt.Errorf("failed to unmarshal chart: %v", err)
+	}
+	for _, f := range manifest.ExtraFiles {
+		if !slices.Contains(f.Charts, "*") && !slices.Contains(f.Charts, metadata.Name) {
+			continue
+		}
+		if err := writeExtraFile(manifest, f, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeExtraFile executes the extra file template with the manifest, writing it to its path under dir.
+func writeExtraFile(manifest model.Manifest, f model.ExtraFile, dir string) error {
+	content := f.Content
+	if f.Source != "" {
+		by, err := os.ReadFile(path.Join(manifest.RepoDir("istio"), f.Source))
+		if err != nil {
+			return fmt.Errorf("failed to read extra file %v: %v", f.Path, err)
+		}
+		content = string(by)
+	}
+	t, err := template.New(f.Path).Option("missingkey=error").Parse(content)
+	if err != nil {
+		return fmt.Errorf("invalid extra file %v: %v", f.Path, err)
+	}
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, manifest); err != nil {
+		return fmt.Errorf("invalid extra file %v: %v", f.Path, err)
+	}
+	dst := path.Join(dir, f.Path)
+	if err := os.MkdirAll(path.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, buf.Bytes(), 0o644)
+}
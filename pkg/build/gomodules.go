@@ -0,0 +1,71 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// GoModules warms up the module cache of the build, first from the cache archive and then the proxy, so the make
+// targets need no access to proxy.golang.org. When building with -mod=vendor, the modules of each repo are vendored.
+func GoModules(manifest model.Manifest) error {
+	// Builds run with GOPATH set to the working directory, so this is the module cache they use
+	cache := path.Join(manifest.WorkDir(), "pkg", "mod")
+	if archive := manifest.GoModules.CacheArchive; archive != "" {
+		// The module cache is read only, so is only extracted once
+		if util.FileExists(cache) {
+			log.Infof("Module cache %v already exists, not extracting %v", cache, archive)
+		} else {
+			if err := os.MkdirAll(cache, 0o755); err != nil {
+				return err
+			}
+			if err := util.VerboseCommand("tar", "-xzf", archive, "-C", cache).Run(); err != nil {
+				return fmt.Errorf("failed to extract module cache %v: %v", archive, err)
+			}
+		}
+	}
+
+	repos := []string{}
+	for repo, dep := range manifest.Dependencies.Get() {
+		if dep != nil && util.FileExists(path.Join(manifest.RepoDir(repo), "go.mod")) {
+			repos = append(repos, repo)
+		}
+	}
+	sort.Strings(repos)
+	commands := [][]string{{"mod", "download"}}
+	if manifest.GoModules.Vendor {
+		commands = append(commands, []string{"mod", "vendor"})
+	}
+	for _, repo := range repos {
+		for _, args := range commands {
+			c := util.VerboseCommand("go", args...)
+			// Module commands do not accept -mod=vendor, so run without it
+			c.Env = append(util.StandardEnv(manifest), "GOFLAGS="+os.Getenv("GOFLAGS"))
+			c.Dir = manifest.RepoDir(repo)
+			if err := c.Run(); err != nil {
+				return fmt.Errorf("failed to run go %v for %v: %v", args[1], repo, err)
+			}
+		}
+	}
+	return nil
+}
@@ -53,7 +53,7 @@ func Grafana(manifest model.Manifest) error {
 		sanitized := strings.ReplaceAll(dashboard.Name(), ".gen.json", ".json")
 		if err := util.CopyFile(
 			path.Join(manifest.WorkDir(), "grafana", dashboard.Name()),
-			path.Join(manifest.OutDir(), "grafana", sanitized),
+			path.Join(manifest.OutDir(), manifest.ArtifactDir("grafana", ""), sanitized),
 		); err != nil {
 			return err
 		}
@@ -15,15 +15,26 @@
 package build
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"text/template"
 
 	"helm.sh/helm/v3/pkg/chart"
 	"istio.io/istio/pkg/log"
 	"sigs.k8s.io/yaml"
+	yamlv3 "sigs.k8s.io/yaml/goyaml.v3"
 
 	"github.com/alauda-mesh/release-builder/pkg/model"
 	"github.com/alauda-mesh/release-builder/pkg/util"
@@ -41,6 +52,13 @@ var (
 		regexp.MustCompile(`"tag": "latest"`),
 	}
 
+	// chartConcurrency bounds the charts packaged at once
+	chartConcurrency = runtime.NumCPU()
+
+	// The image variant is unset by default
+	variantRegex       = regexp.MustCompile(`variant: ""`)
+	quotedVariantRegex = regexp.MustCompile(`"variant": ""`)
+
 	// Currently tags are set as `gcr.io/istio-testing` or `gcr.io/istio-release`
 	hubs = []string{"gcr.io/istio-testing", "gcr.io/istio-release"}
 
@@ -70,6 +88,14 @@ var (
 	repoSampleHelmCharts = []string{
 		"manifests/sample-charts/ambient",
 	}
+
+	// operatorDefaults are the IstioOperator profiles, and the defaults embedded in the operator chart, which are
+	// compiled into istioctl and shipped in the archive. The operator chart is only present in older releases.
+	operatorDefaults = []string{
+		"manifests/profiles/*.yaml",
+		"manifests/charts/istio-operator/values.yaml",
+		"manifests/charts/istio-operator/files/*.yaml",
+	}
 )
 
 // Similar to sanitizeChart, but works on generic templates rather than only Helm charts.
@@ -94,6 +120,11 @@ func updateValues(manifest model.Manifest, p string) error {
 		contents = quotedTagRegex.ReplaceAllString(contents, fmt.Sprintf("\"tag\": \"%s\"", manifest.Version))
 	}
 
+	if v := manifest.DefaultVariant; v != "" && v != "default" && v != "debug" {
+		contents = variantRegex.ReplaceAllString(contents, fmt.Sprintf("variant: %s", v))
+		contents = quotedVariantRegex.ReplaceAllString(contents, fmt.Sprintf("\"variant\": \"%s\"", v))
+	}
+
 	err = os.WriteFile(p, []byte(contents), 0)
 	if err != nil {
 		return err
@@ -105,18 +136,115 @@ func updateValues(manifest model.Manifest, p string) error {
 // SanitizeAllCharts rewrites versions, tags, and hubs for helm charts. This is done independent of Helm
 // as it is required for both the helm charts and the archive
 func SanitizeAllCharts(manifest model.Manifest) error {
-	for _, chart := range helmCharts {
-		if err := stampChartForRelease(manifest, path.Join(manifest.RepoDir("istio"), chart)); err != nil {
-			return fmt.Errorf("failed to sanitize chart %v: %v", chart, err)
+	for _, chart := range sanitizedCharts(manifest) {
+		if err := stampChartForRelease(manifest, chart.dir); err != nil {
+			return fmt.Errorf("failed to sanitize chart %v: %v", chart.chart, err)
+		}
+	}
+	if err := sanitizeOperatorDefaults(manifest); err != nil {
+		return fmt.Errorf("failed to sanitize istioctl profiles: %v", err)
+	}
+	return nil
+}
+
+// sanitizeOperatorDefaults updates the hub and tag defaults of the IstioOperator profiles and operator chart, so
+// istioctl install uses the release images by default.
+func sanitizeOperatorDefaults(manifest model.Manifest) error {
+	for _, pattern := range operatorDefaults {
+		files, err := filepath.Glob(path.Join(manifest.RepoDir("istio"), pattern))
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if err := updateValues(manifest, f); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
+// chartSource is a chart, named by its path relative to the istio repo, or prefixed by its repo for other
+// repos, and the directory it is read from.
+type chartSource struct {
+	chart string
+	dir   string
+}
+
+// sanitizedCharts returns all charts stamped for release, including any additional charts released by the manifest.
+func sanitizedCharts(manifest model.Manifest) []chartSource {
+	charts := []chartSource{}
+	for _, chart := range helmCharts {
+		charts = append(charts, istioChart(manifest, chart))
+	}
+	for _, chart := range manifest.Charts {
+		if !slices.Contains(helmCharts, chart) {
+			charts = append(charts, istioChart(manifest, chart))
+		}
+	}
+	return append(charts, externalCharts(manifest)...)
+}
+
+// releasedCharts returns the charts released to the helm repo: the core charts, or the manifest charts, along with
+// the manifest external charts.
+func releasedCharts(manifest model.Manifest) []chartSource {
+	charts := repoHelmCharts
+	if len(manifest.Charts) > 0 {
+		charts = manifest.Charts
+	}
+	res := []chartSource{}
+	for _, chart := range charts {
+		res = append(res, istioChart(manifest, chart))
+	}
+	return append(res, externalCharts(manifest)...)
+}
+
+func istioChart(manifest model.Manifest, chart string) chartSource {
+	return chartSource{chart: chart, dir: path.Join(manifest.RepoDir("istio"), chart)}
+}
+
+func externalCharts(manifest model.Manifest) []chartSource {
+	res := []chartSource{}
+	for _, c := range manifest.ExternalCharts {
+		res = append(res, chartSource{chart: path.Join(c.Repo, c.Path), dir: path.Join(manifest.RepoDir(c.Repo), c.Path)})
+	}
+	return res
+}
+
 // 1. Updates the chart versions to the release version
 // 2. Updates values.yaml files with publishable defaults (hub/tag/etc)
 func stampChartForRelease(manifest model.Manifest, s string) error {
-	chartPath := path.Join(s, "Chart.yaml")
+	if err := stampChartMetadata(manifest, path.Join(s, "Chart.yaml")); err != nil {
+		return err
+	}
+	if err := updateValues(manifest, path.Join(s, "values.yaml")); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RestampChart updates an unpacked chart, built for the from release, to the versions of the to release. Image tags
+// of the from release in the values are replaced, so the chart deploys the promoted images.
+func RestampChart(from, to model.Manifest, dir string) error {
+	if err := stampChartMetadata(to, path.Join(dir, "Chart.yaml")); err != nil {
+		return err
+	}
+	p := path.Join(dir, "values.yaml")
+	read, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	contents := strings.ReplaceAll(string(read), "tag: "+from.Version, "tag: "+to.Version)
+	contents = strings.ReplaceAll(contents, fmt.Sprintf("\"tag\": \"%s\"", from.Version), fmt.Sprintf("\"tag\": \"%s\"", to.Version))
+	return os.WriteFile(p, []byte(contents), 0o644)
+}
+
+// stampChartMetadata sets the version and appVersion of a Chart.yaml, and of its local dependencies, to the release,
+// and merges in the manifest chart annotations.
+func stampChartMetadata(manifest model.Manifest, chartPath string) error {
 	currentVersion, err := os.ReadFile(chartPath)
 	if err != nil {
 		return err
@@ -128,40 +256,139 @@ func stampChartForRelease(manifest model.Manifest, s string) error {
 		return fmt.Errorf("failed to unmarshal chart: %v", err)
 	}
 
+	// Edit the yaml nodes in place, so comments and key ordering are kept and the diff against upstream is minimal
+	doc := yamlv3.Node{}
+	if err := yamlv3.Unmarshal(currentVersion, &doc); err != nil {
+		return fmt.Errorf("failed to parse chart: %v", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yamlv3.MappingNode {
+		return fmt.Errorf("chart %v is not a mapping", chartPath)
+	}
+	root := doc.Content[0]
+
 	// Update versions
-	chartFile.Version = manifest.Version
-	chartFile.AppVersion = manifest.Version
+	setYamlValue(root, "version", manifest.ChartVersion(chartFile.Name))
+	setYamlValue(root, "appVersion", manifest.ChartAppVersion(chartFile.Name))
 
 	// if chart has "file://" local/dev subchart dependencies, update with release version refs
 	// note that we do not really need to update the repo refs to something other than `file://`,
 	// as the full deps will be bundled in the `.tgz` either way.
-	if len(chartFile.Dependencies) > 0 {
-		for _, dep := range chartFile.Dependencies {
-			if strings.Contains(dep.Repository, "file://") {
-				dep.Version = manifest.Version
+	if deps := yamlValue(root, "dependencies"); deps != nil && deps.Kind == yamlv3.SequenceNode {
+		for _, dep := range deps.Content {
+			repo, name := yamlValue(dep, "repository"), yamlValue(dep, "name")
+			if repo != nil && name != nil && strings.Contains(repo.Value, "file://") {
+				setYamlValue(dep, "version", manifest.ChartVersion(name.Value))
 			}
 		}
 	}
 
-	// Write updated chart.yaml back out
-	contents, err := yaml.Marshal(chartFile)
+	annotations, err := chartAnnotations(manifest, chartFile.Name)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid chart annotations: %v", err)
+	}
+	if len(annotations) > 0 {
+		existing := yamlValue(root, "annotations")
+		if existing == nil || existing.Kind != yamlv3.MappingNode {
+			existing = &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+			setYamlNode(root, "annotations", existing)
+		}
+		keys := make([]string, 0, len(annotations))
+		for k := range annotations {
+			keys = append(keys, k)
+		}
+		// Sorted, so the stamped chart is reproducible
+		slices.Sort(keys)
+		for _, k := range keys {
+			setYamlValue(existing, k, annotations[k])
+		}
 	}
 
-	err = os.WriteFile(chartPath, contents, 0)
-	if err != nil {
+	// Write updated chart.yaml back out
+	buf := &bytes.Buffer{}
+	enc := yamlv3.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
 		return err
 	}
-
-	if err := updateValues(manifest, path.Join(s, "values.yaml")); err != nil {
+	if err := enc.Close(); err != nil {
 		return err
 	}
+
+	return os.WriteFile(chartPath, buf.Bytes(), 0)
+}
+
+// yamlValue returns the value of key in a yaml mapping node, or nil if it is not set.
+func yamlValue(mapping *yamlv3.Node, key string) *yamlv3.Node {
+	if mapping.Kind != yamlv3.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
 	return nil
 }
 
+// setYamlValue sets key in a yaml mapping node to a string, keeping its position and comments, or appends it.
+func setYamlValue(mapping *yamlv3.Node, key string, value string) {
+	if v := yamlValue(mapping, key); v != nil {
+		v.Kind, v.Tag, v.Value, v.Style, v.Content = yamlv3.ScalarNode, "!!str", value, 0, nil
+		return
+	}
+	mapping.Content = append(mapping.Content,
+		&yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: key},
+		&yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: value})
+}
+
+// setYamlNode sets key in a yaml mapping node to value, keeping its position, or appends it.
+func setYamlNode(mapping *yamlv3.Node, key string, value *yamlv3.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+// ChannelAnnotation is the chart annotation recording the release channel of the build.
+const ChannelAnnotation = "istio.io/channel"
+
+// chartAnnotations returns the manifest chart annotations of the chart, with each value executed as a template.
+// With publish.artifactHub set, the ArtifactHub annotations are set too, as is the channel annotation with a channel
+// set, unless the manifest overrides them.
+func chartAnnotations(manifest model.Manifest, chart string) (map[string]string, error) {
+	data := struct {
+		model.Manifest
+		Chart string
+	}{manifest, chart}
+	res := map[string]string{}
+	if manifest.Publish != nil && manifest.Publish.ArtifactHub != nil {
+		res["artifacthub.io/prerelease"] = fmt.Sprint(util.IsPrerelease(manifest.Version))
+		if l := manifest.Publish.ArtifactHub.License; l != "" {
+			res["artifacthub.io/license"] = l
+		}
+	}
+	if manifest.Channel != "" {
+		res[ChannelAnnotation] = string(manifest.Channel)
+	}
+	for k, v := range manifest.ChartAnnotations {
+		t, err := template.New(k).Option("missingkey=error").Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", k, err)
+		}
+		b := &strings.Builder{}
+		if err := t.Execute(b, data); err != nil {
+			return nil, fmt.Errorf("%v: %v", k, err)
+		}
+		res[k] = b.String()
+	}
+	return res, nil
+}
+
 func HelmCharts(manifest model.Manifest) error {
-	dst := path.Join(manifest.OutDir(), "helm")
+	dst := path.Join(manifest.OutDir(), manifest.ArtifactDir("helm", ""))
 	samplesDst := path.Join(dst, "samples")
 
 	if err := os.MkdirAll(path.Join(dst), 0o750); err != nil {
@@ -172,44 +399,132 @@ func HelmCharts(manifest model.Manifest) error {
 		return fmt.Errorf("failed to make destination directory %v: %v", dst, err)
 	}
 
+	type chartPackage struct {
+		chartSource
+		outDir string
+		dst    string
+	}
+	packages := []chartPackage{}
 	for _, chart := range repoSampleHelmCharts {
-		inDir := path.Join(manifest.RepoDir("istio"), chart)
-		outDir := path.Join(manifest.WorkDir(), "charts", "samples", chart)
+		packages = append(packages, chartPackage{istioChart(manifest, chart), path.Join(manifest.WorkDir(), "charts", "samples", chart), samplesDst})
+	}
+	for _, c := range releasedCharts(manifest) {
+		packages = append(packages, chartPackage{c, path.Join(manifest.WorkDir(), "charts", c.chart), dst})
+	}
 
-		if err := prepChartForPackaging(inDir, outDir); err != nil {
-			return err
+	// Charts are independent, so are packaged concurrently. Only their dependency updates are serialized.
+	locked := make([]*chartDependencies, len(packages))
+	errs := make([]error, len(packages))
+	sem := make(chan struct{}, chartConcurrency)
+	wg := sync.WaitGroup{}
+	for i, p := range packages {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			locked[i], errs[i] = packageChart(manifest, p.chartSource, p.outDir, p.dst)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	// Recorded in the order of the charts, so the file is reproducible
+	deps := []chartDependencies{}
+	for _, d := range locked {
+		if d != nil {
+			deps = append(deps, *d)
 		}
+	}
 
-		c := util.VerboseCommand("helm", "package", outDir)
-		c.Dir = samplesDst
-		if err := c.Run(); err != nil {
-			return fmt.Errorf("package %v: %v", chart, err)
-		}
+	by, err := json.MarshalIndent(deps, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path.Join(dst, "dependencies.json"), by, 0o644); err != nil {
+		return fmt.Errorf("failed to write chart dependencies: %v", err)
 	}
+	return nil
+}
 
-	for _, chart := range repoHelmCharts {
-		inDir := path.Join(manifest.RepoDir("istio"), chart)
-		outDir := path.Join(manifest.WorkDir(), "charts", chart)
+// chartDependencies are the dependencies of a chart, as locked by `helm dep update`.
+type chartDependencies struct {
+	Chart string `json:"chart"`
+	// Digest is the digest of the Chart.lock
+	Digest       string             `json:"digest"`
+	Dependencies []lockedDependency `json:"dependencies"`
+}
 
-		if err := prepChartForPackaging(inDir, outDir); err != nil {
-			return err
-		}
+type lockedDependency struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	Repository string `json:"repository"`
+	// Digest is the sha256 of the dependency archive vendored into the chart
+	Digest string `json:"digest,omitempty"`
+}
 
-		c := util.VerboseCommand("helm", "package", outDir)
-		c.Dir = dst
-		if err := c.Run(); err != nil {
-			return fmt.Errorf("package %v: %v", chart, err)
+// packageChart prepares the chart for packaging in outDir, and packages it to dst, returning its locked dependencies,
+// if it has any.
+func packageChart(manifest model.Manifest, source chartSource, outDir string, dst string) (*chartDependencies, error) {
+	chart := source.chart
+	if err := prepChartForPackaging(source.dir, outDir); err != nil {
+		return nil, err
+	}
+	if err := addChartExtraFiles(manifest, outDir); err != nil {
+		return nil, fmt.Errorf("chart %v: %v", chart, err)
+	}
+	deps, err := lockedDependencies(chart, outDir)
+	if err != nil {
+		return nil, err
+	}
+
+	c := util.VerboseCommand("helm", "package", outDir)
+	c.Dir = dst
+	if err := c.Run(); err != nil {
+		return nil, fmt.Errorf("package %v: %v", chart, err)
+	}
+	return deps, nil
+}
+
+// lockedDependencies returns the locked dependencies of the chart in dir, or nil if it has none.
+func lockedDependencies(name string, dir string) (*chartDependencies, error) {
+	by, err := os.ReadFile(path.Join(dir, "Chart.lock"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lock := chart.Lock{}
+	if err := yaml.Unmarshal(by, &lock); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %v Chart.lock: %v", name, err)
+	}
+	cd := chartDependencies{Chart: name, Digest: lock.Digest}
+	for _, d := range lock.Dependencies {
+		ld := lockedDependency{Name: d.Name, Version: d.Version, Repository: d.Repository}
+		if archive, err := os.ReadFile(path.Join(dir, "charts", fmt.Sprintf("%s-%s.tgz", d.Name, d.Version))); err == nil {
+			sum := sha256.Sum256(archive)
+			ld.Digest = "sha256:" + hex.EncodeToString(sum[:])
 		}
+		cd.Dependencies = append(cd.Dependencies, ld)
 	}
-	return nil
+	return &cd, nil
 }
 
+// depUpdateMu serializes `helm dep update`, which refreshes and reads the shared helm repository cache, so is not
+// safe to run concurrently.
+var depUpdateMu sync.Mutex
+
 func prepChartForPackaging(inDir, outDir string) error {
 	// before copying, do dep update if needed
 	// Helm will skip for us if the chart has no deps
 	depCmd := util.VerboseCommand("helm", "dep", "update")
 	depCmd.Dir = inDir
-	if err := depCmd.Run(); err != nil {
+	depUpdateMu.Lock()
+	err := depCmd.Run()
+	depUpdateMu.Unlock()
+	if err != nil {
 		return fmt.Errorf("dep update %v: %v", inDir, err)
 	}
 
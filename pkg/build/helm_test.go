@@ -41,6 +41,9 @@ func TestHelmUpdate(t *testing.T) {
 			filepath.Join("testdata", "chart-values-in.yaml"),
 			model.Manifest{
 				Version: "1.19.13-eks-8df270", // sufficiently oddball version string
+				ChartAnnotations: map[string]string{
+					"example.com/release": "{{.Chart}}-{{.Version}}",
+				},
 			},
 		},
 	}
@@ -79,6 +82,14 @@ func TestHelmUpdate(t *testing.T) {
 					t.Fatalf("dep version doesn't match: %+v", dep)
 				}
 			}
+
+			if got := chartFile.Annotations["example.com/release"]; got != chartFile.Name+"-"+tc.inputManifest.Version {
+				t.Fatalf("annotation doesn't match: %s", got)
+			}
+
+			if !bytes.Contains(updated, []byte("# To add a repo alias")) {
+				t.Fatalf("comments were not preserved: %s", updated)
+			}
 		})
 	}
 }
@@ -100,3 +111,27 @@ func createWritableTempVersion(t *testing.T, tmpDir, destFileName, sourceFilePat
 
 	return file
 }
+
+func TestSanitizeOperatorDefaults(t *testing.T) {
+	manifest := model.Manifest{Directory: t.TempDir(), Version: "1.24.1", Docker: "docker.io/istio"}
+	profiles := path.Join(manifest.RepoDir("istio"), "manifests", "profiles")
+	if err := os.MkdirAll(profiles, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	profile := path.Join(profiles, "ambient.yaml")
+	in := "apiVersion: install.istio.io/v1alpha1\nkind: IstioOperator\nspec:\n  hub: gcr.io/istio-testing\n  tag: latest\n"
+	if err := os.WriteFile(profile, []byte(in), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := sanitizeOperatorDefaults(manifest); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "apiVersion: install.istio.io/v1alpha1\nkind: IstioOperator\nspec:\n  hub: docker.io/istio\n  tag: 1.24.1\n"
+	if string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// ImagesFile lists the images of the release, at the root of the release and of each archive.
+const ImagesFile = "images.yaml"
+
+// ImageList is the format of images.yaml, consumed by mirroring tooling and offline installers.
+type ImageList struct {
+	Version string      `json:"version"`
+	Images  []ImageInfo `json:"images"`
+}
+
+// ImageInfo is a single architecture of an image.
+type ImageInfo struct {
+	Name    string `json:"name"`
+	Variant string `json:"variant,omitempty"`
+	// Tag is the tag the image is published with. Multi-arch images share a tag, so have no arch suffix.
+	Tag          string `json:"tag"`
+	Architecture string `json:"architecture"`
+	// Digest is the digest of the image config, which is the image ID, and does not change as the image is pushed.
+	Digest string `json:"digest"`
+	// Size is the size of the compressed image archive, in bytes.
+	Size int64 `json:"size"`
+}
+
+// Images writes images.yaml, listing each image archive of the release, to the root of the release.
+func Images(manifest model.Manifest) error {
+	dir := path.Join(manifest.OutDir(), manifest.ArtifactDir("docker", ""))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read docker output: %v", err)
+	}
+	archives := []os.DirEntry{}
+	archs := map[string]int{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".tar.gz") {
+			continue
+		}
+		archives = append(archives, e)
+		name, variant, _ := model.ImageNameVariant(e.Name(), manifest.ImageVariants)
+		archs[name+"/"+variant]++
+	}
+
+	list := ImageList{Version: manifest.Version, Images: []ImageInfo{}}
+	for _, a := range archives {
+		p := path.Join(dir, a.Name())
+		img, err := tarball.Image(func() (io.ReadCloser, error) { return gzipFile(p) }, nil)
+		if err != nil {
+			return fmt.Errorf("failed to read image %v: %v", a.Name(), err)
+		}
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			return fmt.Errorf("failed to read image %v: %v", a.Name(), err)
+		}
+		digest, err := img.ConfigName()
+		if err != nil {
+			return fmt.Errorf("failed to read image %v: %v", a.Name(), err)
+		}
+		info, err := a.Info()
+		if err != nil {
+			return err
+		}
+		name, variant, arch := model.ImageNameVariant(a.Name(), manifest.ImageVariants)
+		tag := manifest.Version
+		if variant != "" {
+			tag += "-" + variant
+		}
+		// Single arch images are published with their arch suffix, as publish does
+		if archs[name+"/"+variant] == 1 && arch != "" {
+			tag += "-" + arch
+		}
+		list.Images = append(list.Images, ImageInfo{
+			Name:         name,
+			Variant:      variant,
+			Tag:          tag,
+			Architecture: cfg.Architecture,
+			Digest:       digest.String(),
+			Size:         info.Size(),
+		})
+	}
+	sort.Slice(list.Images, func(i, j int) bool {
+		a, b := list.Images[i], list.Images[j]
+		if a.Tag != b.Tag || a.Name != b.Name {
+			return a.Name+":"+a.Tag < b.Name+":"+b.Tag
+		}
+		return a.Architecture < b.Architecture
+	})
+
+	by, err := yaml.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(manifest.OutDir(), ImagesFile), by, 0o644)
+}
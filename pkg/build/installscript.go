@@ -0,0 +1,130 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+var installScript = template.Must(template.New("install").Parse(`#!/bin/sh
+#
+# Downloads and verifies the Istio {{ .Version }} release archive for this platform.
+# Generated by release-builder; the checksums are pinned to the release.
+
+set -e
+
+ISTIO_VERSION="{{ .Version }}"
+DOWNLOAD_URL="{{ .DownloadURL }}"
+
+OS="${TARGET_OS:-$(uname)}"
+case "${OS}" in
+  Darwin) OSEXT="osx" ;;
+  Linux) OSEXT="linux" ;;
+  *) echo "Unsupported OS ${OS}" >&2; exit 1 ;;
+esac
+
+ARCH="${TARGET_ARCH:-$(uname -m)}"
+case "${ARCH}" in
+  x86_64|amd64) ARCH="amd64" ;;
+  armv8*|aarch64*|arm64) ARCH="arm64" ;;
+  armv*) ARCH="armv7" ;;
+esac
+
+case "${OSEXT}-${ARCH}" in
+{{- range .Archives }}
+  {{ .Platform }}) SHA256="{{ .Sha }}" ;;
+{{- end }}
+  *) echo "Istio ${ISTIO_VERSION} is not available for ${OSEXT}-${ARCH}" >&2; exit 1 ;;
+esac
+
+NAME="istio-${ISTIO_VERSION}"
+FILENAME="${NAME}-${OSEXT}-${ARCH}.tar.gz"
+URL="${DOWNLOAD_URL}/${ISTIO_VERSION}/${FILENAME}"
+
+echo "Downloading ${NAME} from ${URL} ..."
+curl -fsLO "${URL}"
+
+if command -v sha256sum >/dev/null 2>&1; then
+  ACTUAL="$(sha256sum "${FILENAME}" | cut -d' ' -f1)"
+else
+  ACTUAL="$(shasum -a 256 "${FILENAME}" | cut -d' ' -f1)"
+fi
+if [ "${ACTUAL}" != "${SHA256}" ]; then
+  echo "Checksum mismatch for ${FILENAME}: got ${ACTUAL}, expected ${SHA256}" >&2
+  rm -f "${FILENAME}"
+  exit 1
+fi
+
+tar -xzf "${FILENAME}"
+rm "${FILENAME}"
+
+echo ""
+echo "Istio ${ISTIO_VERSION} has been downloaded to ${NAME}. Add istioctl to your path with:"
+echo "  export PATH=\"\$PATH:$(pwd)/${NAME}/bin\""
+`))
+
+type installArchive struct {
+	Platform string
+	Sha      string
+}
+
+// InstallScript generates a downloadIstio style install script, pinned to the version and checksums of the
+// release archives. Windows archives are zips, so are not installed by the script.
+func InstallScript(manifest model.Manifest) error {
+	return WriteInstallScript(manifest, manifest.OutDir())
+}
+
+// WriteInstallScript writes the install script for the release archives in out.
+func WriteInstallScript(manifest model.Manifest, out string) error {
+	archives := []installArchive{}
+	for _, arch := range archiveArchitectures(manifest) {
+		if strings.HasPrefix(arch, "win") {
+			continue
+		}
+		archive := fmt.Sprintf("istio-%s-%s.tar.gz", manifest.Version, arch)
+		sha, err := os.ReadFile(path.Join(out, archive+".sha256"))
+		if err != nil {
+			return fmt.Errorf("failed to read checksum of %v: %v", archive, err)
+		}
+		archives = append(archives, installArchive{Platform: arch, Sha: strings.Fields(string(sha))[0]})
+	}
+	buf := &bytes.Buffer{}
+	if err := installScript.Execute(buf, map[string]any{
+		"Version":     manifest.Version,
+		"DownloadURL": strings.TrimSuffix(manifest.InstallScript.DownloadURL, "/"),
+		"Archives":    archives,
+	}); err != nil {
+		return fmt.Errorf("failed to render install script: %v", err)
+	}
+	script := path.Join(out, manifest.InstallScript.Name)
+	if err := os.WriteFile(script, buf.Bytes(), 0o755); err != nil {
+		return fmt.Errorf("failed to write %v: %v", script, err)
+	}
+	if err := util.CreateSha(script); err != nil {
+		return err
+	}
+	log.Infof("Wrote %v", script)
+	return nil
+}
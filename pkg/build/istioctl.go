@@ -0,0 +1,141 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// Istioctl creates stand alone istioctl archives for each platform, containing just istioctl, its license,
+// and shell completion files, from the binaries built by makeIstioctl.
+func Istioctl(manifest model.Manifest) error {
+	for _, arch := range archiveArchitectures(manifest) {
+		if err := createStandaloneIstioctl(arch, arch, manifest); err != nil {
+			return err
+		}
+		// Handle creating additional archives of the older deprecated names.
+		// TODO - When we no longer need the older archives we can remove this creation.
+		if arch == "osx-amd64" || arch == "win-amd64" {
+			if err := createStandaloneIstioctl(arch, arch[:strings.IndexByte(arch, '-')], manifest); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// createStandaloneIstioctl packages the istioctl binary for arch into an archive named for archiveArch.
+func createStandaloneIstioctl(arch string, archiveArch string, manifest model.Manifest) error {
+	out := path.Join(manifest.WorkDir(), "istioctl", archiveArch)
+	if err := os.MkdirAll(out, 0o750); err != nil {
+		return err
+	}
+	binary, err := copyIstioctl(manifest, arch, out)
+	if err != nil {
+		return err
+	}
+	if err := util.CopyFile(path.Join(manifest.RepoDir("istio"), "LICENSE"), path.Join(out, "LICENSE")); err != nil {
+		return err
+	}
+	files := []string{binary, "LICENSE"}
+	for _, file := range []string{"istioctl.bash", "_istioctl"} {
+		if err := util.CopyFile(path.Join(manifest.RepoOutDir("istio"), file), path.Join(out, file)); err != nil {
+			return err
+		}
+		files = append(files, file)
+	}
+	_, err = packageStandaloneIstioctl(manifest, "istioctl", archiveArch, out, files)
+	return err
+}
+
+// packageStandaloneIstioctl archives the files in out to <name>-<version>-<archiveArch>, in the output directory,
+// returning the name of the archive.
+func packageStandaloneIstioctl(manifest model.Manifest, name string, archiveArch string, out string, files []string) (string, error) {
+	// Windows should use zip, linux and osx tar
+	var istioctlArchive string
+	if strings.HasPrefix(archiveArch, "win") {
+		istioctlArchive = fmt.Sprintf("%s-%s-%s.zip", name, manifest.Version, archiveArch)
+		if err := util.ZipFiles(out, istioctlArchive, files...); err != nil {
+			return "", fmt.Errorf("failed to zip istioctl: %v", err)
+		}
+	} else {
+		istioctlArchive = fmt.Sprintf("%s-%s-%s.tar.gz", name, manifest.Version, archiveArch)
+		if err := util.TarGz(out, istioctlArchive, files...); err != nil {
+			return "", fmt.Errorf("failed to tar istioctl: %v", err)
+		}
+	}
+	// Move file over to the output directory. Also add a log message.
+	archivePath := path.Join(out, istioctlArchive)
+	dest := path.Join(manifest.OutDir(), istioctlArchive)
+	log.Infof("Moving %v -> %v", archivePath, dest)
+	if err := os.Rename(archivePath, dest); err != nil {
+		return "", fmt.Errorf("failed to package %v istioctl archive: %v", archiveArch, err)
+	}
+
+	// Create a SHA of the archive
+	if err := util.CreateSha(dest); err != nil {
+		return "", fmt.Errorf("failed to package %v: %v", dest, err)
+	}
+	return istioctlArchive, nil
+}
+
+// goBuildIstioctl builds istioctl for every archive platform with the istio go build script, which stamps the
+// version as make would, skipping the rest of the make machinery. The completions are generated by the binary for the
+// host.
+func goBuildIstioctl(manifest model.Manifest) error {
+	out := manifest.RepoOutDir("istio")
+	for _, arch := range archiveArchitectures(manifest) {
+		goos, goarch, _ := strings.Cut(arch, "-")
+		switch goos {
+		case "osx":
+			goos = "darwin"
+		case "win":
+			goos = "windows"
+		}
+		env := []string{"GOOS=" + goos, "LDFLAGS=" + istioctlLdflags(manifest)}
+		if goarch == "armv7" {
+			env = append(env, "GOARCH=arm", "GOARM=7")
+		} else {
+			env = append(env, "GOARCH="+goarch)
+		}
+		c := util.VerboseCommand("common/scripts/gobuild.sh", path.Join(out, istioctlBinary(arch)), "./istioctl/cmd/istioctl")
+		c.Env = append(util.StandardEnv(manifest), env...)
+		c.Dir = manifest.RepoDir("istio")
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("failed to build istioctl for %v: %v", arch, err)
+		}
+	}
+
+	host := path.Join(out, istioctlBinary("linux-"+runtime.GOARCH))
+	for shell, file := range map[string]string{"bash": "istioctl.bash", "zsh": "_istioctl"} {
+		completion, err := util.RunWithOutput(host, "completion", shell)
+		if err != nil {
+			return fmt.Errorf("failed to generate %v completion: %v", shell, err)
+		}
+		if err := os.WriteFile(path.Join(out, file), []byte(completion), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
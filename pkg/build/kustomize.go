@@ -0,0 +1,101 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+var kustomizeImageRegex = regexp.MustCompile(`image: "?([^"\s]+)"?`)
+
+// Kustomize renders the charts, for each profile, into a kustomize base under kustomize/bases, and an overlay under
+// kustomize/overlays listing the release images, so their hub and tag can be changed. Each overlay is validated with
+// kustomize build.
+func Kustomize(manifest model.Manifest) error {
+	cfg := manifest.Kustomize
+	out := path.Join(manifest.OutDir(), manifest.ArtifactDir("kustomize", ""))
+	for _, profile := range cfg.Profiles {
+		base := path.Join(out, "bases", profile)
+		if err := os.MkdirAll(base, 0o750); err != nil {
+			return err
+		}
+		charts, err := renderProfile(manifest, profile, cfg.Namespace)
+		if err != nil {
+			return err
+		}
+		resources := []string{}
+		images := map[string]string{}
+		for _, c := range charts {
+			file := c.release + ".yaml"
+			if err := os.WriteFile(path.Join(base, file), c.yaml, 0o644); err != nil {
+				return err
+			}
+			resources = append(resources, file)
+			for _, m := range kustomizeImageRegex.FindAllStringSubmatch(string(c.yaml), -1) {
+				if ref := m[1]; strings.HasPrefix(ref, manifest.Docker+"/") {
+					// Tags may carry a variant, such as 1.25.0-distroless, so are recorded as rendered
+					if i := strings.LastIndex(ref, ":"); i > len(manifest.Docker) {
+						images[ref[:i]] = ref[i+1:]
+					}
+				}
+			}
+		}
+		if err := writeYaml(path.Join(base, "kustomization.yaml"), kustomization(nil, resources)); err != nil {
+			return err
+		}
+
+		overlay := path.Join(out, "overlays", profile)
+		if err := os.MkdirAll(overlay, 0o750); err != nil {
+			return err
+		}
+		kustomizeImages := []map[string]string{}
+		for _, image := range sortedKeys(images) {
+			kustomizeImages = append(kustomizeImages, map[string]string{"name": image, "newName": image, "newTag": images[image]})
+		}
+		if err := writeYaml(path.Join(overlay, "kustomization.yaml"),
+			kustomization(kustomizeImages, []string{path.Join("..", "..", "bases", profile)})); err != nil {
+			return err
+		}
+		// The output is only built to validate the overlay
+		c := util.VerboseCommand("kustomize", "build", overlay)
+		c.Stdout = nil
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("kustomize build %v: %v", profile, err)
+		}
+		log.Infof("Wrote kustomize base and overlay for profile %v", profile)
+	}
+	return nil
+}
+
+func kustomization(images []map[string]string, resources []string) map[string]any {
+	k := map[string]any{
+		"apiVersion": "kustomize.config.k8s.io/v1beta1",
+		"kind":       "Kustomization",
+		"resources":  resources,
+	}
+	if len(images) > 0 {
+		k["images"] = images
+	}
+	return k
+}
@@ -0,0 +1,98 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// BuildMatrix builds the control plane binaries of each build flavor, for each architecture, and packages them as
+// istio-<flavor>-<version>-linux-<arch>.tar.gz, with every binary suffixed by the flavor, so they are not mistaken for
+// the release binaries.
+func BuildMatrix(manifest model.Manifest) error {
+	out := path.Join(manifest.OutDir(), manifest.ArtifactDir("builds", ""))
+	if err := os.MkdirAll(out, 0o750); err != nil {
+		return err
+	}
+	for _, flavor := range manifest.BuildMatrix {
+		for _, arch := range flavorArchitectures(manifest, flavor) {
+			if err := buildFlavor(manifest, flavor, arch, out); err != nil {
+				return fmt.Errorf("failed to build %v for %v: %v", flavor.Name, arch, err)
+			}
+		}
+	}
+	return nil
+}
+
+// flavorArchitectures returns the linux architectures the flavor is built for. Race builds need cgo, so are only built
+// for the host.
+func flavorArchitectures(manifest model.Manifest, flavor model.BuildFlavor) []string {
+	if flavor.Race {
+		return []string{runtime.GOARCH}
+	}
+	archs := []string{}
+	for _, plat := range manifest.Architectures {
+		_, arch, _ := strings.Cut(plat, "/")
+		archs = append(archs, arch)
+	}
+	return archs
+}
+
+// flavorEnv returns the environment of the istio go build script for the flavor. Unlike release builds, the debug
+// info is kept, along with a GNU build ID for debuggers to look it up by. Race builds use cgo, so are not static.
+func flavorEnv(flavor model.BuildFlavor, arch string) []string {
+	env := []string{"GOOS=linux", "GOARCH=" + arch, "DEBUG=1"}
+	if flavor.GcFlags != "" {
+		env = append(env, "GCFLAGS="+flavor.GcFlags)
+	}
+	if flavor.Race {
+		return append(env, "CGO_ENABLED=1", "GOBUILDFLAGS=-race", "LDFLAGS=-B gobuildid")
+	}
+	return append(env, "LDFLAGS=-extldflags -static -B gobuildid")
+}
+
+func buildFlavor(manifest model.Manifest, flavor model.BuildFlavor, arch string, out string) error {
+	name := fmt.Sprintf("istio-%s-%s-linux-%s", flavor.Name, manifest.Version, arch)
+	dir := path.Join(manifest.WorkDir(), "builds", name)
+	files := []string{}
+	for _, pkg := range flavor.Binaries {
+		binary := path.Base(pkg) + "-" + flavor.Name
+		c := util.VerboseCommand("common/scripts/gobuild.sh", path.Join(dir, binary), pkg)
+		c.Env = append(util.StandardEnv(manifest), flavorEnv(flavor, arch)...)
+		c.Dir = manifest.RepoDir("istio")
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("failed to build %v: %v", pkg, err)
+		}
+		files = append(files, binary)
+	}
+	archive := path.Join(out, name+".tar.gz")
+	if err := util.TarGz(dir, archive, files...); err != nil {
+		return err
+	}
+	if err := util.CreateSha(archive); err != nil {
+		return err
+	}
+	log.WithLabels("artifact", flavor.Name).Infof("Built %v", archive)
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// ReleaseMetadata describes a release, so downstream automation can introspect it without re-deriving
+// this information.
+type ReleaseMetadata struct {
+	Version   string `json:"version"`
+	BuildDate string `json:"buildDate"`
+	Channel   string `json:"channel,omitempty"`
+	// Dirty is set if any repo had uncommitted changes, so the SHAs do not fully describe the release
+	Dirty bool `json:"dirty,omitempty"`
+	// Repos maps each repo to its resolved SHA
+	Repos map[string]string `json:"repos"`
+	// Images maps each docker archive, such as pilot-distroless, to its image config digest
+	Images map[string]string `json:"images,omitempty"`
+	// Charts maps each packaged chart to its version
+	Charts map[string]string `json:"charts,omitempty"`
+	// Files maps each file in the release, relative to the release root, to its sha256
+	Files map[string]string `json:"files"`
+}
+
+// writeReleaseMetadata writes release-metadata.json to the root of the release.
+func writeReleaseMetadata(manifest model.Manifest) error {
+	return WriteReleaseMetadata(manifest, manifest.OutDir())
+}
+
+// WriteReleaseMetadata writes release-metadata.json for the release in out.
+func WriteReleaseMetadata(manifest model.Manifest, out string) error {
+	md := ReleaseMetadata{
+		Version:   manifest.Version,
+		BuildDate: buildDate().Format(time.RFC3339),
+		Channel:   string(manifest.Channel),
+		Repos:     map[string]string{},
+		Images:    map[string]string{},
+		Charts:    map[string]string{},
+		Files:     map[string]string{},
+	}
+	for repo, dep := range manifest.Dependencies.Get() {
+		if dep != nil && dep.Sha != "" {
+			md.Repos[repo] = dep.Sha
+		}
+		if dep != nil && dep.Dirty {
+			md.Dirty = true
+		}
+	}
+
+	dockerDir := manifest.ArtifactDir("docker", "")
+	helmDir := manifest.ArtifactDir("helm", "")
+	err := filepath.WalkDir(out, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(out, p)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// OCI layouts duplicate the docker archives
+			if rel == path.Join(dockerDir, "oci") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(rel, ".sha256") || rel == "release-metadata.json" || rel == ChecksumsFile {
+			return nil
+		}
+		sha, err := fileSha256(p)
+		if err != nil {
+			return err
+		}
+		md.Files[rel] = sha
+		switch {
+		case filepath.Dir(rel) == dockerDir && strings.HasSuffix(rel, ".tar.gz"):
+			img, err := tarball.Image(func() (io.ReadCloser, error) { return gzipFile(p) }, nil)
+			if err != nil {
+				return fmt.Errorf("failed to read image %v: %v", rel, err)
+			}
+			digest, err := img.ConfigName()
+			if err != nil {
+				return fmt.Errorf("failed to read image %v: %v", rel, err)
+			}
+			md.Images[strings.TrimSuffix(filepath.Base(rel), ".tar.gz")] = digest.String()
+		case filepath.Dir(rel) == helmDir && strings.HasSuffix(rel, ".tgz"):
+			name, version := model.ChartNameVersion(filepath.Base(rel))
+			md.Charts[name] = version
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read release: %v", err)
+	}
+
+	by, err := json.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(out, "release-metadata.json"), by, 0o644)
+}
+
+// buildDate is the SOURCE_DATE_EPOCH for reproducible builds, or the current time.
+func buildDate() time.Time {
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(epoch, 0).UTC()
+	}
+	return time.Now().UTC()
+}
+
+func fileSha256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash %v: %v", file, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
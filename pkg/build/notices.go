@@ -0,0 +1,150 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// thirdPartyLicense is a single module and the license it is used under
+type thirdPartyLicense struct {
+	Module  string
+	URL     string
+	License string
+}
+
+func noticesDir(manifest model.Manifest) string {
+	return path.Join(manifest.WorkDir(), "notices")
+}
+
+// Notices scans the Go modules of all dependency repos with go-licenses, writing a NOTICES file and
+// aggregating each repo's licenses/ directory into LICENSES/. These are included in the release archive,
+// and NOTICES is also written to the output directory. The build fails if a disallowed license is found.
+func Notices(manifest model.Manifest) error {
+	dir := noticesDir(manifest)
+	if err := os.MkdirAll(path.Join(dir, "LICENSES"), 0o750); err != nil {
+		return err
+	}
+
+	repos := []string{}
+	for repo, dep := range manifest.Dependencies.Get() {
+		if dep != nil {
+			repos = append(repos, repo)
+		}
+	}
+	sort.Strings(repos)
+
+	found := map[string]thirdPartyLicense{}
+	for _, repo := range repos {
+		if _, err := os.Stat(path.Join(manifest.RepoDir(repo), "go.mod")); err != nil {
+			log.Infof("skipping license scan for %v, not a go module", repo)
+			continue
+		}
+		licenses, err := scanLicenses(manifest, repo)
+		if err != nil {
+			return fmt.Errorf("failed to scan licenses for %v: %v", repo, err)
+		}
+		for _, l := range licenses {
+			found[l.Module] = l
+		}
+		if src := path.Join(manifest.RepoDir(repo), "licenses"); dirExists(src) {
+			if err := util.CopyDir(src, path.Join(dir, "LICENSES", repo)); err != nil {
+				return err
+			}
+		}
+	}
+
+	licenses := make([]thirdPartyLicense, 0, len(found))
+	for _, l := range found {
+		licenses = append(licenses, l)
+	}
+	sort.Slice(licenses, func(i, j int) bool {
+		return licenses[i].Module < licenses[j].Module
+	})
+
+	if err := checkDisallowedLicenses(licenses, manifest.Licenses.Disallowed); err != nil {
+		return err
+	}
+
+	notices := renderNotices(manifest.Version, licenses)
+	if err := os.WriteFile(path.Join(dir, "NOTICES"), notices, 0o644); err != nil {
+		return fmt.Errorf("failed to write notices: %v", err)
+	}
+	return os.WriteFile(path.Join(manifest.OutDir(), "NOTICES"), notices, 0o644)
+}
+
+func scanLicenses(manifest model.Manifest, repo string) ([]thirdPartyLicense, error) {
+	buf := &bytes.Buffer{}
+	cmd := util.VerboseCommand("go-licenses", "csv", "./...")
+	cmd.Dir = manifest.RepoDir(repo)
+	cmd.Env = util.StandardEnv(manifest)
+	cmd.Stdout = buf
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	records, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse go-licenses output: %v", err)
+	}
+	licenses := []thirdPartyLicense{}
+	for _, r := range records {
+		if len(r) != 3 {
+			return nil, fmt.Errorf("unexpected go-licenses output: %v", r)
+		}
+		licenses = append(licenses, thirdPartyLicense{Module: r[0], URL: r[1], License: r[2]})
+	}
+	return licenses, nil
+}
+
+func checkDisallowedLicenses(licenses []thirdPartyLicense, disallowed []string) error {
+	bad := []string{}
+	for _, l := range licenses {
+		for _, d := range disallowed {
+			if strings.EqualFold(l.License, d) {
+				bad = append(bad, fmt.Sprintf("%v (%v)", l.Module, l.License))
+			}
+		}
+	}
+	if len(bad) > 0 {
+		return fmt.Errorf("found disallowed licenses: %v", strings.Join(bad, ", "))
+	}
+	return nil
+}
+
+func renderNotices(version string, licenses []thirdPartyLicense) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "Istio %s includes the following third party software.\n", version)
+	fmt.Fprintf(buf, "The license text for each can be found in LICENSES/.\n\n")
+	for _, l := range licenses {
+		fmt.Fprintf(buf, "%s\n  License: %s\n  Source: %s\n\n", l.Module, l.License, l.URL)
+	}
+	return buf.Bytes()
+}
+
+func dirExists(dir string) bool {
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,272 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// crdFile is the file, in the istio repo, holding all Istio CRDs.
+const crdFile = "manifests/charts/base/files/crd-all.gen.yaml"
+
+// OLMBundle creates an OLM bundle, containing the ClusterServiceVersion, CRDs, and bundle annotations,
+// for the operator. The bundle is packaged as an archive, with a bundle.Dockerfile to build the bundle image.
+func OLMBundle(manifest model.Manifest) error {
+	cfg := manifest.OLM
+	bundle := path.Join(manifest.WorkDir(), "olm")
+	if err := os.MkdirAll(path.Join(bundle, "manifests"), 0o750); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Join(bundle, "metadata"), 0o750); err != nil {
+		return err
+	}
+
+	owned, err := writeBundleCRDs(manifest, path.Join(bundle, "manifests"))
+	if err != nil {
+		return err
+	}
+	rules, err := operatorRules(manifest)
+	if err != nil {
+		return err
+	}
+	if err := writeYaml(path.Join(bundle, "manifests", cfg.Package+".clusterserviceversion.yaml"),
+		clusterServiceVersion(manifest, owned, rules)); err != nil {
+		return err
+	}
+
+	annotations := bundleAnnotations(cfg)
+	if err := writeYaml(path.Join(bundle, "metadata", "annotations.yaml"), map[string]any{"annotations": annotations}); err != nil {
+		return err
+	}
+	dockerfile := []string{"FROM scratch"}
+	for _, k := range sortedKeys(annotations) {
+		dockerfile = append(dockerfile, fmt.Sprintf("LABEL %s=%s", k, annotations[k]))
+	}
+	dockerfile = append(dockerfile, "COPY manifests /manifests/", "COPY metadata /metadata/", "")
+	if err := os.WriteFile(path.Join(bundle, "bundle.Dockerfile"), []byte(strings.Join(dockerfile, "\n")), 0o644); err != nil {
+		return err
+	}
+
+	out := path.Join(manifest.OutDir(), manifest.ArtifactDir("olm", ""))
+	if err := os.MkdirAll(out, 0o750); err != nil {
+		return err
+	}
+	archive := path.Join(out, fmt.Sprintf("%s-olm-bundle-%s.tar.gz", cfg.Package, manifest.Version))
+	if err := util.TarGz(bundle, archive, "bundle.Dockerfile", "manifests", "metadata"); err != nil {
+		return fmt.Errorf("failed to package olm bundle: %v", err)
+	}
+	if err := util.CreateSha(archive); err != nil {
+		return fmt.Errorf("failed to package %v: %v", archive, err)
+	}
+	return nil
+}
+
+// writeBundleCRDs copies each CRD into the bundle, returning the owned CRDs for the ClusterServiceVersion.
+func writeBundleCRDs(manifest model.Manifest, dir string) ([]map[string]any, error) {
+	by, err := os.ReadFile(path.Join(manifest.RepoDir("istio"), crdFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read crds: %v", err)
+	}
+	owned := []map[string]any{}
+	for _, doc := range strings.Split(string(by), "\n---") {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		crd := struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Spec struct {
+				Group string `json:"group"`
+				Names struct {
+					Kind   string `json:"kind"`
+					Plural string `json:"plural"`
+				} `json:"names"`
+				Versions []struct {
+					Name    string `json:"name"`
+					Storage bool   `json:"storage"`
+				} `json:"versions"`
+			} `json:"spec"`
+		}{}
+		if err := yaml.Unmarshal([]byte(doc), &crd); err != nil {
+			return nil, fmt.Errorf("failed to parse crd: %v", err)
+		}
+		if crd.Metadata.Name == "" {
+			continue
+		}
+		for _, v := range crd.Spec.Versions {
+			if v.Storage {
+				owned = append(owned, map[string]any{
+					"name":        crd.Metadata.Name,
+					"kind":        crd.Spec.Names.Kind,
+					"version":     v.Name,
+					"displayName": crd.Spec.Names.Kind,
+				})
+			}
+		}
+		file := fmt.Sprintf("%s_%s.yaml", crd.Spec.Group, crd.Spec.Names.Plural)
+		if err := os.WriteFile(path.Join(dir, file), []byte(strings.TrimPrefix(doc, "\n")+"\n"), 0o644); err != nil {
+			return nil, err
+		}
+	}
+	return owned, nil
+}
+
+// operatorRules returns the cluster permissions of the operator: the rules of each ClusterRole rendered by the charts
+// it installs, followed by the configured rules.
+func operatorRules(manifest model.Manifest) ([]model.PolicyRule, error) {
+	cfg := manifest.OLM
+	rendered := &bytes.Buffer{}
+	for _, chart := range cfg.Charts {
+		cmd := util.VerboseCommand("helm", "template", path.Base(chart), path.Join(manifest.RepoDir("istio"), chart))
+		cmd.Stdout = rendered
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("template %v: %v", chart, err)
+		}
+		rendered.WriteString("\n---\n")
+	}
+	rules, err := clusterRoleRules(rendered.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("charts %v render no ClusterRole to grant the operator", cfg.Charts)
+	}
+	return append(rules, cfg.Rules...), nil
+}
+
+// clusterRoleRules returns the rules of each ClusterRole in the rendered yaml, without duplicates.
+func clusterRoleRules(rendered []byte) ([]model.PolicyRule, error) {
+	rules := []model.PolicyRule{}
+	seen := map[string]struct{}{}
+	for _, doc := range strings.Split(string(rendered), "\n---") {
+		role := struct {
+			Kind  string             `json:"kind"`
+			Rules []model.PolicyRule `json:"rules"`
+		}{}
+		if err := yaml.Unmarshal([]byte(doc), &role); err != nil {
+			return nil, fmt.Errorf("failed to parse rendered chart: %v", err)
+		}
+		if role.Kind != "ClusterRole" {
+			continue
+		}
+		for _, r := range role.Rules {
+			key := fmt.Sprintf("%+v", r)
+			if _, f := seen[key]; f {
+				continue
+			}
+			seen[key] = struct{}{}
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
+
+func clusterServiceVersion(manifest model.Manifest, owned []map[string]any, rules []model.PolicyRule) map[string]any {
+	cfg := manifest.OLM
+	image := fmt.Sprintf("%s:%s", cfg.OperatorImage, manifest.Version)
+	labels := map[string]string{"app.kubernetes.io/name": cfg.Package}
+	return map[string]any{
+		"apiVersion": "operators.coreos.com/v1alpha1",
+		"kind":       "ClusterServiceVersion",
+		"metadata": map[string]any{
+			"name": fmt.Sprintf("%s.v%s", cfg.Package, manifest.Version),
+			"annotations": map[string]string{
+				"containerImage": image,
+				"capabilities":   "Basic Install",
+				"repository":     "https://github.com/istio/istio",
+			},
+		},
+		"spec": map[string]any{
+			"displayName": "Istio",
+			"description": "Istio is an open platform to connect, manage, and secure microservices.",
+			"version":     manifest.Version,
+			"provider":    map[string]string{"name": "Istio"},
+			"keywords":    []string{"istio", "service-mesh"},
+			"links":       []map[string]string{{"name": "Istio", "url": "https://istio.io/"}},
+			"installModes": []map[string]any{
+				{"type": "OwnNamespace", "supported": false},
+				{"type": "SingleNamespace", "supported": false},
+				{"type": "MultiNamespace", "supported": false},
+				{"type": "AllNamespaces", "supported": true},
+			},
+			"customresourcedefinitions": map[string]any{"owned": owned},
+			"install": map[string]any{
+				"strategy": "deployment",
+				"spec": map[string]any{
+					"clusterPermissions": []map[string]any{{
+						"serviceAccountName": cfg.Package,
+						"rules":              rules,
+					}},
+					"deployments": []map[string]any{{
+						"name": cfg.Package,
+						"spec": map[string]any{
+							"replicas": 1,
+							"selector": map[string]any{"matchLabels": labels},
+							"template": map[string]any{
+								"metadata": map[string]any{"labels": labels},
+								"spec": map[string]any{
+									"serviceAccountName": cfg.Package,
+									"containers": []map[string]any{{
+										"name":  cfg.Package,
+										"image": image,
+									}},
+								},
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func bundleAnnotations(cfg *model.OLMConfig) map[string]string {
+	return map[string]string{
+		"operators.operatorframework.io.bundle.mediatype.v1":       "registry+v1",
+		"operators.operatorframework.io.bundle.manifests.v1":       "manifests/",
+		"operators.operatorframework.io.bundle.metadata.v1":        "metadata/",
+		"operators.operatorframework.io.bundle.package.v1":         cfg.Package,
+		"operators.operatorframework.io.bundle.channels.v1":        strings.Join(cfg.Channels, ","),
+		"operators.operatorframework.io.bundle.channel.default.v1": cfg.Channels[0],
+	}
+}
+
+func writeYaml(file string, obj any) error {
+	by, err := yaml.Marshal(obj)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %v: %v", file, err)
+	}
+	return os.WriteFile(file, by, 0o644)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,117 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+const renderedRoles = `---
+# Source: istiod/templates/clusterrole.yaml
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: istiod-clusterrole-istio-system
+rules:
+  - apiGroups: ["networking.istio.io"]
+    resources: ["*"]
+    verbs: ["get", "watch", "list"]
+  - apiGroups: [""]
+    resources: ["configmaps"]
+    verbs: ["create", "get", "list", "watch", "update"]
+---
+# Source: istiod/templates/role.yaml
+apiVersion: rbac.authorization.k8s.io/v1
+kind: Role
+metadata:
+  name: istiod
+rules:
+  - apiGroups: [""]
+    resources: ["secrets"]
+    verbs: ["create", "get", "watch", "list", "update", "delete"]
+---
+# Source: istiod/templates/reader-clusterrole.yaml
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: istio-reader-clusterrole-istio-system
+rules:
+  - apiGroups: ["networking.istio.io"]
+    resources: ["*"]
+    verbs: ["get", "watch", "list"]
+  - nonResourceURLs: ["/debug/*"]
+    verbs: ["get"]
+`
+
+func TestClusterRoleRules(t *testing.T) {
+	rules, err := clusterRoleRules([]byte(renderedRoles))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The namespaced Role is not granted, and the rule shared by both ClusterRoles is granted once
+	want := []model.PolicyRule{
+		{APIGroups: []string{"networking.istio.io"}, Resources: []string{"*"}, Verbs: []string{"get", "watch", "list"}},
+		{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"create", "get", "list", "watch", "update"}},
+		{NonResourceURLs: []string{"/debug/*"}, Verbs: []string{"get"}},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Fatalf("expected rules %+v, got %+v", want, rules)
+	}
+}
+
+func TestClusterServiceVersionPermissions(t *testing.T) {
+	manifest := model.Manifest{
+		Version: "1.24.0",
+		OLM:     &model.OLMConfig{Package: "sailoperator", OperatorImage: "quay.io/sail-dev/sail-operator", Channels: []string{"stable"}},
+	}
+	rules, err := clusterRoleRules([]byte(renderedRoles))
+	if err != nil {
+		t.Fatal(err)
+	}
+	by, err := yaml.Marshal(clusterServiceVersion(manifest, nil, rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	csv := struct {
+		Spec struct {
+			Install struct {
+				Spec struct {
+					ClusterPermissions []struct {
+						ServiceAccountName string             `json:"serviceAccountName"`
+						Rules              []model.PolicyRule `json:"rules"`
+					} `json:"clusterPermissions"`
+				} `json:"spec"`
+			} `json:"install"`
+		} `json:"spec"`
+	}{}
+	if err := yaml.Unmarshal(by, &csv); err != nil {
+		t.Fatal(err)
+	}
+	perms := csv.Spec.Install.Spec.ClusterPermissions
+	if len(perms) != 1 || perms[0].ServiceAccountName != "sailoperator" || !reflect.DeepEqual(perms[0].Rules, rules) {
+		t.Fatalf("expected the rendered rules granted to sailoperator, got %+v", perms)
+	}
+	for _, r := range perms[0].Rules {
+		if slices.Contains(r.APIGroups, "*") {
+			t.Fatalf("expected no rule for all api groups, got %+v", r)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"istio.io/istio/pkg/log"
+	yamlv3 "sigs.k8s.io/yaml/goyaml.v3"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// PinnedChartSuffix is appended to the chart version of charts referencing images by digest.
+const PinnedChartSuffix = "-pinned"
+
+// PinnedCharts packages each chart again, with a -pinned version suffix, with the images in its values referenced
+// by digest rather than tag. The digests are those the images are published with: the manifest list of multi-arch
+// images, or the image itself.
+func PinnedCharts(manifest model.Manifest) error {
+	digests, err := ImageDigests(manifest)
+	if err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp("", "pinned-charts")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	helmDir := path.Join(manifest.OutDir(), manifest.ArtifactDir("helm", ""))
+	packaged := []string{}
+	if err := filepath.WalkDir(helmDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(p, ".tgz") {
+			return err
+		}
+		packaged = append(packaged, p)
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, p := range packaged {
+		chart, version := model.ChartNameVersion(filepath.Base(p))
+		if strings.HasSuffix(version, PinnedChartSuffix) {
+			continue
+		}
+		dir, err := os.MkdirTemp(tmpDir, chart)
+		if err != nil {
+			return err
+		}
+		if err := util.VerboseCommand("tar", "-xzf", p, "-C", dir).Run(); err != nil {
+			return fmt.Errorf("failed to extract %v: %v", filepath.Base(p), err)
+		}
+		chartDir := path.Join(dir, chart)
+		if err := pinChart(manifest, chartDir, digests); err != nil {
+			return fmt.Errorf("failed to pin %v: %v", chart, err)
+		}
+		c := util.VerboseCommand("helm", "package", chartDir)
+		c.Dir = filepath.Dir(p)
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("package %v: %v", chart, err)
+		}
+	}
+	return nil
+}
+
+// ImageDigests returns the digest each image of the default variant is published with, keyed by image name.
+func ImageDigests(manifest model.Manifest) (map[string]string, error) {
+	dir := path.Join(manifest.OutDir(), manifest.ArtifactDir("docker", ""))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read docker output: %v", err)
+	}
+	variant := manifest.DefaultVariant
+	if variant == "default" {
+		variant = ""
+	}
+	images := map[string][]v1.Image{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".tar.gz") {
+			continue
+		}
+		name, v, _ := model.ImageNameVariant(e.Name(), manifest.ImageVariants)
+		if v != variant {
+			continue
+		}
+		p := path.Join(dir, e.Name())
+		img, err := tarball.Image(func() (io.ReadCloser, error) { return gzipFile(p) }, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image %v: %v", e.Name(), err)
+		}
+		images[name] = append(images[name], img)
+	}
+	digests := map[string]string{}
+	for name, imgs := range images {
+		var digest v1.Hash
+		if len(imgs) == 1 {
+			digest, err = imgs[0].Digest()
+		} else {
+			var index v1.ImageIndex
+			if index, err = PlatformIndex(imgs); err == nil {
+				digest, err = index.Digest()
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute digest of %v: %v", name, err)
+		}
+		digests[name] = digest.String()
+	}
+	return digests, nil
+}
+
+// pinChart suffixes the version of the unpacked chart in dir, and replaces each image in its values, and those of
+// its subcharts, with a reference by digest.
+func pinChart(manifest model.Manifest, dir string, digests map[string]string) error {
+	chartPath := path.Join(dir, "Chart.yaml")
+	doc, err := readYamlDoc(chartPath)
+	if err != nil {
+		return err
+	}
+	version := yamlValue(doc.Content[0], "version")
+	if version == nil {
+		return fmt.Errorf("chart has no version")
+	}
+	setYamlValue(doc.Content[0], "version", version.Value+PinnedChartSuffix)
+	if err := writeYamlDoc(chartPath, doc); err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() != "values.yaml" {
+			return err
+		}
+		values, err := readYamlDoc(p)
+		if err != nil {
+			return err
+		}
+		if pinImages(values.Content[0], manifest.Docker, digests) {
+			log.Infof("Pinned images of %v", p)
+			return writeYamlDoc(p, values)
+		}
+		return nil
+	})
+}
+
+// pinImages replaces each `image: <name>` of a released image in the yaml with its reference by digest, returning
+// whether any were replaced. Images already referenced by a full reference are left as is.
+func pinImages(node *yamlv3.Node, hub string, digests map[string]string) bool {
+	pinned := false
+	switch node.Kind {
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k, v := node.Content[i], node.Content[i+1]
+			if k.Value == "image" && v.Kind == yamlv3.ScalarNode {
+				if digest, f := digests[v.Value]; f {
+					v.Value, v.Tag, v.Style = fmt.Sprintf("%s/%s@%s", hub, v.Value, digest), "!!str", 0
+					pinned = true
+				}
+				continue
+			}
+			pinned = pinImages(v, hub, digests) || pinned
+		}
+	case yamlv3.SequenceNode:
+		for _, c := range node.Content {
+			pinned = pinImages(c, hub, digests) || pinned
+		}
+	}
+	return pinned
+}
+
+// readYamlDoc parses a yaml file, which must be a mapping, keeping its comments.
+func readYamlDoc(file string) (*yamlv3.Node, error) {
+	by, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	doc := &yamlv3.Node{}
+	if err := yamlv3.Unmarshal(by, doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %v", file, err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yamlv3.MappingNode {
+		return nil, fmt.Errorf("%v is not a mapping", file)
+	}
+	return doc, nil
+}
+
+// writeYamlDoc writes a yaml document parsed by readYamlDoc.
+func writeYamlDoc(file string, doc *yamlv3.Node) error {
+	buf := &bytes.Buffer{}
+	enc := yamlv3.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(file, buf.Bytes(), 0o644)
+}
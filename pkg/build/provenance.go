@@ -0,0 +1,97 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// VerifyBaseImages verifies the cosign signatures of each base image, returning the images with their
+// verified digests recorded.
+func VerifyBaseImages(images []model.BaseImage) ([]model.BaseImage, error) {
+	verified := make([]model.BaseImage, 0, len(images))
+	for _, image := range images {
+		digest, err := verifyImage(image)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify base image %v: %v", image.Image, err)
+		}
+		log.Infof("Verified base image %v@%v", image.Image, digest)
+		image.Digest = digest
+		verified = append(verified, image)
+	}
+	return verified, nil
+}
+
+// baseImageArgs returns the variables passing each base image with a BuildArg, pinned to its verified digest, to
+// the docker builds.
+func baseImageArgs(images []model.BaseImage) []string {
+	args := []string{}
+	for _, image := range images {
+		if image.BuildArg != "" {
+			args = append(args, image.BuildArg+"="+image.Reference())
+		}
+	}
+	return args
+}
+
+// pinBuildContainer returns the build container running the verified digest of its image, if it is one of the
+// base images, so containerized steps run the tools that were verified.
+func pinBuildContainer(c *model.BuildContainer, images []model.BaseImage) *model.BuildContainer {
+	if c == nil {
+		return nil
+	}
+	for _, image := range images {
+		if image.Image == c.Image {
+			pinned := *c
+			pinned.Image = image.Reference()
+			return &pinned
+		}
+	}
+	return c
+}
+
+// verifyImage runs cosign verify against the image, returning the digest covered by the signature.
+func verifyImage(image model.BaseImage) (string, error) {
+	args := []string{"verify", "--output", "json"}
+	if image.Key != "" {
+		args = append(args, "--key", image.Key)
+	} else {
+		args = append(args, "--certificate-identity", image.Identity, "--certificate-oidc-issuer", image.Issuer)
+	}
+	out, err := util.RunWithOutput("cosign", append(args, image.Image)...)
+	if err != nil {
+		return "", err
+	}
+	payloads := []struct {
+		Critical struct {
+			Image struct {
+				Digest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+		} `json:"critical"`
+	}{}
+	if err := json.Unmarshal([]byte(out), &payloads); err != nil {
+		return "", fmt.Errorf("failed to parse cosign output: %v", err)
+	}
+	if len(payloads) == 0 || payloads[0].Critical.Image.Digest == "" {
+		return "", fmt.Errorf("no verified signatures found")
+	}
+	return payloads[0].Critical.Image.Digest, nil
+}
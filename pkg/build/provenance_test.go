@@ -0,0 +1,47 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+func TestPinnedBaseImages(t *testing.T) {
+	images := []model.BaseImage{
+		{Image: "gcr.io/distroless/static-debian12", BuildArg: "BASE_IMAGE", Digest: "sha256:aaa"},
+		{Image: "gcr.io/istio-testing/build-tools:master", Digest: "sha256:bbb"},
+	}
+	if got, want := baseImageArgs(images), []string{"BASE_IMAGE=gcr.io/distroless/static-debian12@sha256:aaa"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got build args %v, want %v", got, want)
+	}
+
+	c := &model.BuildContainer{Image: "gcr.io/istio-testing/build-tools:master"}
+	if got, want := pinBuildContainer(c, images).Image, "gcr.io/istio-testing/build-tools:master@sha256:bbb"; got != want {
+		t.Fatalf("got build container %v, want %v", got, want)
+	}
+	if c.Image != "gcr.io/istio-testing/build-tools:master" {
+		t.Fatalf("build container of the input manifest was modified: %v", c.Image)
+	}
+	other := &model.BuildContainer{Image: "gcr.io/istio-testing/other:master"}
+	if got := pinBuildContainer(other, images); got != other {
+		t.Fatalf("build container not in the base images was pinned: %v", got.Image)
+	}
+	if pinBuildContainer(nil, images) != nil {
+		t.Fatal("expected no build container")
+	}
+}
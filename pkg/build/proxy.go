@@ -0,0 +1,112 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// Proxy prepares the manifest proxy, which the istio build embeds in the images and packages. The proxy pinned by the
+// istio repo must match the proxy dependency. Proxies built from source are packaged into the work directory, as the
+// istio build fetches them, and the wasm filters are fetched for the archive.
+func Proxy(manifest model.Manifest) error {
+	sha, err := pinnedDependency(manifest, "proxy", manifest.Dependencies.Proxy)
+	if err != nil {
+		return err
+	}
+	if manifest.Proxy.URL == "" {
+		if err := buildProxy(manifest, sha); err != nil {
+			return fmt.Errorf("failed to build proxy: %v", err)
+		}
+	}
+	for _, filter := range manifest.Proxy.WasmFilters {
+		url := fmt.Sprintf("%s/%s-%s.wasm", manifest.Proxy.URL, filter, sha)
+		if err := util.VerboseCommand("curl", "-fLSs", "--retry", "5", "--create-dirs", "-o", proxyWasmFilter(manifest, filter), url).Run(); err != nil {
+			return fmt.Errorf("failed to fetch wasm filter %v: %v", filter, err)
+		}
+	}
+	return nil
+}
+
+// pinnedDependency returns the SHA of the repo pinned by the istio.deps of the istio repo, which must match the
+// dependency, if set.
+func pinnedDependency(manifest model.Manifest, repo string, dep *model.Dependency) (string, error) {
+	by, err := os.ReadFile(path.Join(manifest.RepoDir("istio"), "istio.deps"))
+	if err != nil {
+		return "", err
+	}
+	deps := []model.IstioDep{}
+	if err := json.Unmarshal(by, &deps); err != nil {
+		return "", fmt.Errorf("failed to read istio.deps: %v", err)
+	}
+	for _, d := range deps {
+		if d.RepoName != repo || d.LastStableSHA == "" {
+			continue
+		}
+		if dep != nil && dep.Sha != "" && dep.Sha != d.LastStableSHA {
+			return "", fmt.Errorf("%v dependency %v does not match the %v pinned by istio", repo, dep.Sha, d.LastStableSHA)
+		}
+		return d.LastStableSHA, nil
+	}
+	return "", fmt.Errorf("istio.deps does not pin %v", repo)
+}
+
+// buildProxy builds envoy from the proxy repo, and packages it as envoy-alpha-<sha>[-<arch>].tar.gz, with the
+// usr/local/bin/envoy layout the istio build extracts. Envoy is only built for the host architecture.
+func buildProxy(manifest model.Manifest, sha string) error {
+	for _, plat := range manifest.Architectures {
+		if plat != "linux/"+runtime.GOARCH {
+			return fmt.Errorf("the proxy can only be built for linux/%v, not %v; set the proxy url", runtime.GOARCH, plat)
+		}
+	}
+	c := util.VerboseCommand(manifest.Proxy.Build[0], manifest.Proxy.Build[1:]...)
+	c.Dir = manifest.RepoDir("proxy")
+	c.Env = util.StandardEnv(manifest)
+	if err := c.Run(); err != nil {
+		return err
+	}
+	dir := path.Join(manifest.WorkDir(), "proxy-build")
+	if err := util.CopyFile(path.Join(manifest.RepoDir("proxy"), manifest.Proxy.Output), path.Join(dir, "usr", "local", "bin", "envoy")); err != nil {
+		return err
+	}
+	// For backwards compatibility, amd64 has no suffix
+	suffix := ""
+	if runtime.GOARCH != "amd64" {
+		suffix = "-" + runtime.GOARCH
+	}
+	archive := path.Join(manifest.WorkDir(), "proxy", fmt.Sprintf("envoy-alpha-%s%s.tar.gz", sha, suffix))
+	if err := os.MkdirAll(path.Dir(archive), 0o750); err != nil {
+		return err
+	}
+	if err := util.TarGz(dir, archive, "usr"); err != nil {
+		return err
+	}
+	log.Infof("Built proxy %v as %v", sha, archive)
+	return nil
+}
+
+// proxyWasmFilter returns the path the wasm filter of the manifest proxy is fetched to.
+func proxyWasmFilter(manifest model.Manifest, filter string) string {
+	return path.Join(manifest.WorkDir(), "proxy", filter+".wasm")
+}
@@ -0,0 +1,307 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"istio.io/istio/pkg/log"
+	"sigs.k8s.io/yaml"
+	yamlv3 "sigs.k8s.io/yaml/goyaml.v3"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// RenamesFile records the renames applied to the release, at the root of the release.
+const RenamesFile = "renames.yaml"
+
+// RenameMapping is the format of renames.yaml.
+type RenameMapping struct {
+	Binaries map[string]string `json:"binaries,omitempty"`
+	Images   map[string]string `json:"images,omitempty"`
+	// Files maps the renamed artifacts, relative to the root of the release, to their new paths.
+	Files map[string]string `json:"files,omitempty"`
+}
+
+// Rename applies the renames of the manifest to the built artifacts: image archives are renamed and retagged, image
+// references in the charts and release archives are rewritten, and binaries are renamed in the release archives and
+// standalone archives.
+func Rename(manifest model.Manifest) error {
+	mapping := RenameMapping{
+		Binaries: manifest.Renames.Binaries,
+		Images:   manifest.Renames.Images,
+		Files:    map[string]string{},
+	}
+	if _, f := manifest.BuildOutputs[model.Docker]; f && manifest.DockerOutput != model.DockerOutputContext {
+		if err := renameImages(manifest, mapping.Files); err != nil {
+			return fmt.Errorf("failed to rename images: %v", err)
+		}
+		// Images are listed by name, so the list is written again
+		if util.FileExists(path.Join(manifest.OutDir(), ImagesFile)) {
+			if err := Images(manifest); err != nil {
+				return err
+			}
+		}
+	}
+	if _, f := manifest.BuildOutputs[model.Helm]; f && util.IsValidSemver(manifest.Version) {
+		if err := renameChartImages(manifest); err != nil {
+			return fmt.Errorf("failed to rename chart images: %v", err)
+		}
+	}
+	if _, f := manifest.BuildOutputs[model.Archive]; f {
+		if err := renameArchives(manifest); err != nil {
+			return fmt.Errorf("failed to rename archives: %v", err)
+		}
+	}
+	if _, f := manifest.BuildOutputs[model.Istioctl]; f {
+		if err := renameStandaloneIstioctl(manifest, mapping.Files); err != nil {
+			return fmt.Errorf("failed to rename istioctl archives: %v", err)
+		}
+	}
+
+	by, err := yaml.Marshal(mapping)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(manifest.OutDir(), RenamesFile), by, 0o644)
+}
+
+// renameImages renames and retags the docker archives of renamed images, recording the renamed files.
+func renameImages(manifest model.Manifest, files map[string]string) error {
+	dir := path.Join(manifest.OutDir(), manifest.ArtifactDir("docker", ""))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read docker output: %v", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".tar.gz") {
+			continue
+		}
+		imageName, variant, arch := model.ImageNameVariant(e.Name(), manifest.ImageVariants)
+		renamed, f := manifest.Renames.Images[imageName]
+		if !f {
+			continue
+		}
+		src := path.Join(dir, e.Name())
+		img, err := tarball.Image(func() (io.ReadCloser, error) { return gzipFile(src) }, nil)
+		if err != nil {
+			return fmt.Errorf("failed to read image %v: %v", e.Name(), err)
+		}
+		// Tag the image as publishing expects to find it once loaded
+		ref := fmt.Sprintf("%s/%s:%s", manifest.Docker, renamed, manifest.Version)
+		for _, suffix := range []string{variant, arch} {
+			if suffix != "" {
+				ref += "-" + suffix
+			}
+		}
+		tag, err := name.NewTag(ref)
+		if err != nil {
+			return err
+		}
+		fname := renamed + strings.TrimPrefix(e.Name(), imageName)
+		if err := WriteImageArchive(path.Join(dir, fname), tag, img); err != nil {
+			return fmt.Errorf("failed to write %v: %v", fname, err)
+		}
+		if err := os.Remove(src); err != nil {
+			return err
+		}
+		log.Infof("Renamed image %v to %v", e.Name(), fname)
+		files[path.Join(manifest.ArtifactDir("docker", ""), e.Name())] = path.Join(manifest.ArtifactDir("docker", ""), fname)
+	}
+	return nil
+}
+
+// renameChartImages rewrites the image references of each packaged chart, packaging it again.
+func renameChartImages(manifest model.Manifest) error {
+	tmpDir, err := os.MkdirTemp("", "rename-charts")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	helmDir := path.Join(manifest.OutDir(), manifest.ArtifactDir("helm", ""))
+	packaged := []string{}
+	if err := filepath.WalkDir(helmDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(p, ".tgz") {
+			return err
+		}
+		packaged = append(packaged, p)
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, p := range packaged {
+		chart, _ := model.ChartNameVersion(filepath.Base(p))
+		dir, err := os.MkdirTemp(tmpDir, chart)
+		if err != nil {
+			return err
+		}
+		if err := util.VerboseCommand("tar", "-xzf", p, "-C", dir).Run(); err != nil {
+			return fmt.Errorf("failed to extract %v: %v", filepath.Base(p), err)
+		}
+		chartDir := path.Join(dir, chart)
+		renamed, err := renameValuesImages(chartDir, manifest.Renames.Images)
+		if err != nil {
+			return fmt.Errorf("failed to rename images of %v: %v", chart, err)
+		}
+		if !renamed {
+			continue
+		}
+		// The chart name and version are unchanged, so the package replaces the original
+		c := util.VerboseCommand("helm", "package", chartDir)
+		c.Dir = filepath.Dir(p)
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("package %v: %v", chart, err)
+		}
+	}
+	return nil
+}
+
+// renameArchives renames the binaries and rewrites the image references of each release archive, creating it again.
+func renameArchives(manifest model.Manifest) error {
+	for _, arch := range archiveArchitectures(manifest) {
+		out := archiveDir(manifest, arch)
+		for from, to := range manifest.Renames.Binaries {
+			for _, ext := range []string{"", ".exe"} {
+				if !util.FileExists(path.Join(out, "bin", from+ext)) {
+					continue
+				}
+				if err := os.Rename(path.Join(out, "bin", from+ext), path.Join(out, "bin", to+ext)); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := renameValuesImages(path.Join(out, "manifests"), manifest.Renames.Images); err != nil {
+			return err
+		}
+		if images := path.Join(manifest.OutDir(), ImagesFile); util.FileExists(images) {
+			if err := util.CopyFile(images, path.Join(out, ImagesFile)); err != nil {
+				return err
+			}
+		}
+		if err := createArchives(arch, manifest, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameStandaloneIstioctl renames the istioctl binary of the standalone archives, which are named after it,
+// recording the renamed files.
+func renameStandaloneIstioctl(manifest model.Manifest, files map[string]string) error {
+	renamed, f := manifest.Renames.Binaries["istioctl"]
+	if !f {
+		return nil
+	}
+	workDir := path.Join(manifest.WorkDir(), "istioctl")
+	entries, err := os.ReadDir(workDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		archiveArch := e.Name()
+		out := path.Join(workDir, archiveArch)
+		ext := ""
+		if util.FileExists(path.Join(out, "istioctl.exe")) || util.FileExists(path.Join(out, renamed+".exe")) {
+			ext = ".exe"
+		}
+		// The binary is already renamed if the step ran before
+		if !util.FileExists(path.Join(out, renamed+ext)) || util.FileExists(path.Join(out, "istioctl"+ext)) {
+			if err := os.Rename(path.Join(out, "istioctl"+ext), path.Join(out, renamed+ext)); err != nil {
+				return err
+			}
+		}
+		archive, err := packageStandaloneIstioctl(manifest, renamed, archiveArch,
+			out, []string{renamed + ext, "LICENSE", "istioctl.bash", "_istioctl"})
+		if err != nil {
+			return err
+		}
+		original := fmt.Sprintf("istioctl-%s-%s%s", manifest.Version, archiveArch, strings.TrimPrefix(archive,
+			fmt.Sprintf("%s-%s-%s", renamed, manifest.Version, archiveArch)))
+		for _, file := range []string{original, original + ".sha256"} {
+			if err := os.Remove(path.Join(manifest.OutDir(), file)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		files[original] = archive
+	}
+	return nil
+}
+
+// renameValuesImages rewrites the renamed images in each values.yaml under dir, returning whether any were renamed.
+func renameValuesImages(dir string, images map[string]string) (bool, error) {
+	renamed := false
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() != "values.yaml" {
+			return err
+		}
+		values, err := readYamlDoc(p)
+		if err != nil {
+			return err
+		}
+		if renameImageRefs(values.Content[0], images) {
+			renamed = true
+			log.Infof("Renamed images of %v", p)
+			return writeYamlDoc(p, values)
+		}
+		return nil
+	})
+	return renamed, err
+}
+
+// renameImageRefs replaces the name of each renamed image in `image:` values of the yaml, which may be a bare name,
+// such as pilot, or a full reference, such as docker.io/istio/pilot@sha256:..., returning whether any were replaced.
+func renameImageRefs(node *yamlv3.Node, images map[string]string) bool {
+	renamed := false
+	switch node.Kind {
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k, v := node.Content[i], node.Content[i+1]
+			if k.Value == "image" && v.Kind == yamlv3.ScalarNode {
+				prefix, rest := "", v.Value
+				if i := strings.LastIndex(v.Value, "/"); i >= 0 {
+					prefix, rest = v.Value[:i+1], v.Value[i+1:]
+				}
+				imageName := rest
+				if i := strings.IndexAny(rest, ":@"); i >= 0 {
+					imageName = rest[:i]
+				}
+				if to, f := images[imageName]; f {
+					v.Value = prefix + to + strings.TrimPrefix(rest, imageName)
+					renamed = true
+				}
+				continue
+			}
+			renamed = renameImageRefs(v, images) || renamed
+		}
+	case yamlv3.SequenceNode:
+		for _, c := range node.Content {
+			renamed = renameImageRefs(c, images) || renamed
+		}
+	}
+	return renamed
+}
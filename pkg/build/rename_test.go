@@ -0,0 +1,68 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+func TestRenameStandaloneIstioctlTwice(t *testing.T) {
+	manifest := model.Manifest{
+		Version:   "1.24.0",
+		Directory: t.TempDir(),
+		Renames:   &model.RenameConfig{Binaries: map[string]string{"istioctl": "alaudactl"}},
+	}
+	out := path.Join(manifest.WorkDir(), "istioctl", "linux-amd64")
+	if err := os.MkdirAll(out, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"istioctl", "LICENSE", "istioctl.bash", "_istioctl"} {
+		if err := os.WriteFile(path.Join(out, file), []byte(file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(manifest.OutDir(), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := packageStandaloneIstioctl(manifest, "istioctl", "linux-amd64", out,
+		[]string{"istioctl", "LICENSE", "istioctl.bash", "_istioctl"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"istioctl-1.24.0-linux-amd64.tar.gz": "alaudactl-1.24.0-linux-amd64.tar.gz"}
+	// A resumed build runs the step again, after the binary is renamed and the original archive removed
+	for i := 0; i < 2; i++ {
+		files := map[string]string{}
+		if err := renameStandaloneIstioctl(manifest, files); err != nil {
+			t.Fatalf("run %d: %v", i+1, err)
+		}
+		if !reflect.DeepEqual(files, want) {
+			t.Fatalf("run %d: expected renamed files %v, got %v", i+1, want, files)
+		}
+		if util.FileExists(path.Join(out, "istioctl")) || !util.FileExists(path.Join(out, "alaudactl")) {
+			t.Fatalf("run %d: expected istioctl to be renamed to alaudactl", i+1)
+		}
+		if util.FileExists(path.Join(manifest.OutDir(), "istioctl-1.24.0-linux-amd64.tar.gz")) ||
+			!util.FileExists(path.Join(manifest.OutDir(), "alaudactl-1.24.0-linux-amd64.tar.gz.sha256")) {
+			t.Fatalf("run %d: expected the istioctl archive to be replaced by the alaudactl archive", i+1)
+		}
+	}
+}
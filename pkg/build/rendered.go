@@ -0,0 +1,103 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// profileChart is a chart rendered for a profile, and the helm release name it is rendered as.
+type profileChart struct {
+	chart   string
+	release string
+}
+
+var (
+	// profileCharts are the charts rendered for every profile
+	profileCharts = []profileChart{
+		{"manifests/charts/base", "istio-base"},
+		{"manifests/charts/istio-control/istio-discovery", "istiod"},
+	}
+	// ambientProfileCharts are also rendered for the ambient profile
+	ambientProfileCharts = []profileChart{
+		{"manifests/charts/istio-cni", "istio-cni"},
+		{"manifests/charts/ztunnel", "ztunnel"},
+	}
+)
+
+// renderedChart is the output of helm template for a chart.
+type renderedChart struct {
+	release string
+	yaml    []byte
+}
+
+// renderProfile renders the charts of the profile with helm template, for installing to namespace.
+func renderProfile(manifest model.Manifest, profile string, namespace string) ([]renderedChart, error) {
+	charts := profileCharts
+	if profile == "ambient" {
+		charts = append(charts[:len(charts):len(charts)], ambientProfileCharts...)
+	}
+	res := []renderedChart{}
+	for _, c := range charts {
+		rendered := &bytes.Buffer{}
+		cmd := util.VerboseCommand("helm", "template", c.release, path.Join(manifest.RepoDir("istio"), c.chart),
+			"--namespace", namespace, "--include-crds", "--set", "profile="+profile)
+		cmd.Stdout = rendered
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("template %v (%v): %v", c.chart, profile, err)
+		}
+		res = append(res, renderedChart{c.release, rendered.Bytes()})
+	}
+	return res, nil
+}
+
+// RenderedManifests renders the charts for each profile into a single yaml file, manifests/<profile>.yaml, to be
+// installed with kubectl apply.
+func RenderedManifests(manifest model.Manifest) error {
+	cfg := manifest.RenderedManifests
+	out := path.Join(manifest.OutDir(), manifest.ArtifactDir("manifests", ""))
+	if err := os.MkdirAll(out, 0o750); err != nil {
+		return err
+	}
+	for _, profile := range cfg.Profiles {
+		charts, err := renderProfile(manifest, profile, cfg.Namespace)
+		if err != nil {
+			return err
+		}
+		buf := &bytes.Buffer{}
+		for _, c := range charts {
+			fmt.Fprintf(buf, "# Rendered from the %v chart of Istio %v\n", c.release, manifest.Version)
+			buf.Write(c.yaml)
+			buf.WriteString("---\n")
+		}
+		file := path.Join(out, profile+".yaml")
+		if err := os.WriteFile(file, buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+		if err := util.CreateSha(file); err != nil {
+			return fmt.Errorf("failed to package %v: %v", file, err)
+		}
+		log.Infof("Wrote rendered manifests for profile %v", profile)
+	}
+	return nil
+}
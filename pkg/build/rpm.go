@@ -16,9 +16,14 @@ package build
 
 import (
 	"fmt"
+	"os"
 	"path"
+	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/Masterminds/semver/v3"
+
 	"github.com/alauda-mesh/release-builder/pkg/model"
 	"github.com/alauda-mesh/release-builder/pkg/util"
 )
@@ -36,6 +41,14 @@ func Rpm(manifest model.Manifest) error {
 		if err := runRpm(manifest, envs, arch, output); err != nil {
 			return fmt.Errorf("failed to run rpm for arch %s: %v", arch, err)
 		}
+		if manifest.Rpm == nil {
+			continue
+		}
+		for _, d := range manifest.Rpm.Distros {
+			if err := distroRpm(manifest, arch, path.Join(manifest.OutDir(), manifest.ArtifactDir("rpm", arch), output), d); err != nil {
+				return fmt.Errorf("failed to build %v rpm for arch %s: %v", d.Name, arch, err)
+			}
+		}
 	}
 	return nil
 }
@@ -44,11 +57,149 @@ func runRpm(manifest model.Manifest, envs []string, arch, output string) error {
 	if err := util.RunMake(manifest, "istio", envs, "rpm/fpm"); err != nil {
 		return fmt.Errorf("failed to build sidecar.rpm: %v", err)
 	}
-	if err := util.CopyFile(path.Join(manifest.RepoArchOutDir("istio", arch), "istio-sidecar.rpm"), path.Join(manifest.OutDir(), "rpm", output)); err != nil {
-		return fmt.Errorf("failed to package istio-sidecar.rpm: %v", err)
+	src := path.Join(manifest.RepoArchOutDir("istio", arch), "istio-sidecar.rpm")
+	dst := path.Join(manifest.OutDir(), manifest.ArtifactDir("rpm", arch), output)
+	if manifest.Rpm == nil {
+		if err := util.CopyFile(src, dst); err != nil {
+			return fmt.Errorf("failed to package istio-sidecar.rpm: %v", err)
+		}
+	} else {
+		if err := setRpmVersion(manifest, src, dst); err != nil {
+			return fmt.Errorf("failed to set version of istio-sidecar.rpm: %v", err)
+		}
+		if manifest.Rpm.SigningKey != "" {
+			if err := signRpm(manifest.Rpm, dst); err != nil {
+				return err
+			}
+		}
 	}
-	if err := util.CreateSha(path.Join(manifest.OutDir(), "rpm", output)); err != nil {
+	if err := util.CreateSha(dst); err != nil {
 		return fmt.Errorf("failed to package istio-sidecar.rpm: %v", err)
 	}
 	return nil
 }
+
+// rpmVersion returns the rpm version and release of the version. rpm versions cannot contain `-`, so the
+// pre-release of a semver version is moved to the release, as 0.<pre-release>, sorting before the final release.
+func rpmVersion(version string, release string) (string, string) {
+	if release == "" {
+		release = "1"
+	}
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return strings.ReplaceAll(version, "-", "_"), release
+	}
+	if v.Prerelease() != "" {
+		release = "0." + strings.ReplaceAll(v.Prerelease(), "-", "_")
+	}
+	return fmt.Sprintf("%d.%d.%d", v.Major(), v.Minor(), v.Patch()), release
+}
+
+// setRpmVersion writes the rpm package src to dst with the version, release, and epoch of the manifest. The
+// packaging make target only sets the version, so the package is converted with fpm, which keeps its files, scripts,
+// and dependencies.
+func setRpmVersion(manifest model.Manifest, src string, dst string) error {
+	version, release := rpmVersion(manifest.Version, manifest.Rpm.Release)
+	args := []string{"-s", "rpm", "-t", "rpm", "-f", "-p", dst, "--version", version, "--iteration", release}
+	if manifest.Rpm.Epoch != 0 {
+		args = append(args, "--epoch", strconv.Itoa(manifest.Rpm.Epoch))
+	}
+	if err := os.MkdirAll(path.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	return util.VerboseCommand("fpm", append(args, src)...).Run()
+}
+
+// distroRpm builds the distro variant of the generic rpm package. The package is repackaged from its files, with the
+// systemd units moved to the unit directory of the distro, and the dependencies of the distro added.
+func distroRpm(manifest model.Manifest, arch string, generic string, distro model.RpmDistro) error {
+	staging, err := os.MkdirTemp("", "rpm-"+distro.Name)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	extract := util.VerboseCommand("sh", "-c", fmt.Sprintf("rpm2cpio %q | cpio -idm --quiet", generic))
+	extract.Dir = staging
+	if err := extract.Run(); err != nil {
+		return fmt.Errorf("failed to extract %v: %v", path.Base(generic), err)
+	}
+	if units := path.Join(staging, "lib", "systemd", "system"); util.FileExists(units) && path.Clean(distro.UnitDir) != "/lib/systemd/system" {
+		if err := os.MkdirAll(path.Dir(path.Join(staging, distro.UnitDir)), 0o755); err != nil {
+			return err
+		}
+		if err := os.Rename(units, path.Join(staging, distro.UnitDir)); err != nil {
+			return err
+		}
+	}
+
+	info, err := util.RunWithOutput("rpm", "-qp", "--queryformat", "%{NAME}\n%{URL}\n%{LICENSE}\n%{VENDOR}\n%{SUMMARY}", generic)
+	if err != nil {
+		return err
+	}
+	fields := strings.SplitN(info, "\n", 5)
+	if len(fields) != 5 {
+		return fmt.Errorf("unexpected package info %q", info)
+	}
+	requires, err := util.RunWithOutput("rpm", "-qpR", generic)
+	if err != nil {
+		return err
+	}
+	depends := slices.Clone(distro.Depends)
+	for _, r := range strings.Split(requires, "\n") {
+		// rpmlib requirements are added by rpmbuild itself
+		if r = strings.TrimSpace(r); r != "" && !strings.HasPrefix(r, "rpmlib(") && !slices.Contains(depends, r) {
+			depends = append(depends, r)
+		}
+	}
+
+	version, release := rpmVersion(manifest.Version, manifest.Rpm.Release)
+	dst := path.Join(path.Dir(generic), distro.PackageName(manifest.Version, arch))
+	args := []string{
+		"-s", "dir", "-t", "rpm", "-f", "-C", staging, "-p", dst, "-a", model.RpmArch(arch),
+		"-n", fields[0], "--version", version, "--iteration", release, "--rpm-dist", distro.Dist,
+		"--url", fields[1], "--license", fields[2], "--vendor", fields[3], "--description", fields[4],
+	}
+	if manifest.Rpm.Epoch != 0 {
+		args = append(args, "--epoch", strconv.Itoa(manifest.Rpm.Epoch))
+	}
+	for _, d := range depends {
+		args = append(args, "--depends", d)
+	}
+	// Scripts are not extracted with the files, so the post install script of the repo is added again
+	if script := path.Join(manifest.RepoDir("istio"), "tools", "packaging", "postinst.sh"); util.FileExists(script) {
+		args = append(args, "--after-install", script)
+	}
+	if err := util.VerboseCommand("fpm", append(args, ".")...).Run(); err != nil {
+		return fmt.Errorf("failed to package %v: %v", path.Base(dst), err)
+	}
+	if manifest.Rpm.SigningKey != "" {
+		if err := signRpm(manifest.Rpm, dst); err != nil {
+			return err
+		}
+	}
+	return util.CreateSha(dst)
+}
+
+// signRpm signs the rpm package with rpmsign, and verifies the signature with the public key, if set.
+func signRpm(config *model.RpmConfig, file string) error {
+	if err := util.VerboseCommand("rpmsign", "--addsign", "--define", "_gpg_name "+config.SigningKey, file).Run(); err != nil {
+		return fmt.Errorf("failed to sign %v: %v", path.Base(file), err)
+	}
+	if config.PublicKeyFile == "" {
+		return nil
+	}
+	// The key is imported to a temporary database, rather than trusted by the builder
+	db, err := os.MkdirTemp("", "rpmdb")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(db)
+	if err := util.VerboseCommand("rpmkeys", "--dbpath", db, "--import", config.PublicKeyFile).Run(); err != nil {
+		return fmt.Errorf("failed to import %v: %v", config.PublicKeyFile, err)
+	}
+	out, err := util.RunWithOutput("rpmkeys", "--dbpath", db, "--checksig", file)
+	if err != nil || !strings.Contains(out, "signatures OK") {
+		return fmt.Errorf("failed to verify signature of %v: %v %v", path.Base(file), out, err)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import "testing"
+
+func TestRpmVersion(t *testing.T) {
+	cases := []struct {
+		version, release         string
+		wantVersion, wantRelease string
+	}{
+		{"1.24.0", "", "1.24.0", "1"},
+		{"1.24.0", "3", "1.24.0", "3"},
+		{"1.24.0-rc.1", "", "1.24.0", "0.rc.1"},
+		{"1.24.0-alpha.0-dev", "2", "1.24.0", "0.alpha.0_dev"},
+		{"master-20240101", "", "master_20240101", "1"},
+	}
+	for _, tc := range cases {
+		version, release := rpmVersion(tc.version, tc.release)
+		if version != tc.wantVersion || release != tc.wantRelease {
+			t.Errorf("rpmVersion(%q, %q) = %q, %q, want %q, %q", tc.version, tc.release, version, release, tc.wantVersion, tc.wantRelease)
+		}
+	}
+}
@@ -39,7 +39,7 @@ func GenerateBillOfMaterials(manifest model.Manifest) error {
 		manifest.Version)
 
 	// construct all the docker image tarball names as bom currently cannot accept directory as input
-	dockerDir := path.Join(manifest.OutDir(), "docker")
+	dockerDir := path.Join(manifest.OutDir(), manifest.ArtifactDir("docker", ""))
 	dockerImages := []string{}
 	if err := filepath.WalkDir(dockerDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
@@ -57,7 +57,7 @@ func GenerateBillOfMaterials(manifest model.Manifest) error {
 	// Run bom generator to generate the software bill of materials(SBOM) for istio.
 	log.Infof("Generating Software Bill of Materials for istio release artifacts")
 	if err := util.VerboseCommand("bom", "--log-level", "error", "generate", "--name", "Istio Release "+manifest.Version,
-		"--namespace", releaseSbomNamespace, "--ignore", "licenses,'*.sha256',docker", "--dirs", manifest.OutDir(),
+		"--namespace", releaseSbomNamespace, "--ignore", fmt.Sprintf("%s,'*.sha256',%s", manifest.ArtifactDir("licenses", ""), manifest.ArtifactDir("docker", "")), "--dirs", manifest.OutDir(),
 		"--image-archive", strings.Join(dockerImages, ","), "--output", releaseSbomFile).Run(); err != nil {
 		return fmt.Errorf("couldn't generate sbom for istio release artifacts: %v", err)
 	}
@@ -135,8 +135,7 @@ func Scanner(manifest model.Manifest, githubToken, git, branch string) error {
 	cmd := util.VerboseCommand("tools/build-base-images.sh")
 	cmd.Env = util.StandardEnv(manifest)
 	cmd.Env = append(cmd.Env, buildImageEnv...)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
+	cmd.Stdout, cmd.Stderr = util.CommandOutput(log.WithLabels("repo", "istio"))
 	cmd.Dir = istioDir
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to build base images: %v", err)
@@ -0,0 +1,95 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// The completion state of each step is recorded as a marker file in the working directory, so a failed build
+// can be resumed. A file per step allows steps of a plan to run concurrently.
+func stateDir(manifest model.Manifest) string {
+	return path.Join(manifest.WorkDir(), "build-state")
+}
+
+// stepCompleted returns true if the step completed in a previous run, and --resume is set.
+func stepCompleted(manifest model.Manifest, name string) (bool, error) {
+	if !flags.resume {
+		return false, nil
+	}
+	return stepMarked(manifest, name)
+}
+
+// stepMarked returns true if the step is recorded as completed, by this or a previous run.
+func stepMarked(manifest model.Manifest, name string) (bool, error) {
+	_, err := os.Stat(path.Join(stateDir(manifest), name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read build state: %v", err)
+	}
+	return true, nil
+}
+
+// setStepCompleted records whether the step completed. Steps are marked incomplete when they start, so a
+// step that fails after a previous success is run again on resume.
+func setStepCompleted(manifest model.Manifest, name string, completed bool) error {
+	marker := path.Join(stateDir(manifest), name)
+	if !completed {
+		if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to write build state: %v", err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(stateDir(manifest), 0o750); err != nil {
+		return fmt.Errorf("failed to write build state: %v", err)
+	}
+	if err := os.WriteFile(marker, nil, 0o640); err != nil {
+		return fmt.Errorf("failed to write build state: %v", err)
+	}
+	return nil
+}
+
+// skippedSteps returns the steps that did not run: those not selected by this run, unless completed by a previous
+// run, such as another step of a plan.
+func skippedSteps(manifest model.Manifest, steps []Step) ([]string, error) {
+	skipped := []string{}
+	for _, name := range append([]string{FetchSourcesStep}, stepNames(steps)...) {
+		if stepSelected(name) {
+			continue
+		}
+		done, err := stepMarked(manifest, name)
+		if err != nil {
+			return nil, err
+		}
+		if !done {
+			skipped = append(skipped, name)
+		}
+	}
+	return skipped, nil
+}
+
+// resetState clears the completed steps, for a fresh build of all steps.
+func resetState(manifest model.Manifest) error {
+	if err := os.RemoveAll(stateDir(manifest)); err != nil {
+		return fmt.Errorf("failed to reset build state: %v", err)
+	}
+	return nil
+}
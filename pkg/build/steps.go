@@ -0,0 +1,178 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"slices"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/plugin"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// FetchSourcesStep is the name of the step fetching all sources. It runs before Build, and is
+// handled by the build command rather than Build itself.
+const FetchSourcesStep = "fetch-sources"
+
+// Step is a single stage of the build.
+type Step struct {
+	Name string
+	// DependsOn lists the steps that must complete before this step can run.
+	DependsOn []string
+	Run       func(manifest model.Manifest) error
+}
+
+// Steps returns the build steps required by the manifest, in the order they are run.
+// This is the source of truth for both the build itself and any exported plan.
+func Steps(manifest model.Manifest) []Step {
+	steps := []Step{}
+	add := func(output model.BuildOutput, step Step) {
+		if _, f := manifest.BuildOutputs[output]; f {
+			steps = append(steps, step)
+		}
+	}
+
+	// The proxy and ztunnel are embedded by the images and packages, and the proxy wasm filters by the archive
+	var embedDeps []string
+	archiveDeps := []string{"sanitize-charts"}
+	if manifest.Proxy != nil {
+		steps = append(steps, Step{Name: "proxy", Run: Proxy})
+		embedDeps = append(embedDeps, "proxy")
+		archiveDeps = append(archiveDeps, "proxy")
+	}
+	if manifest.Ztunnel != nil {
+		steps = append(steps, Step{Name: "ztunnel", Run: Ztunnel})
+		embedDeps = append(embedDeps, "ztunnel")
+	}
+	add(model.Docker, Step{Name: "docker", DependsOn: embedDeps, Run: Docker})
+	if manifest.DockerOutput != model.DockerOutputContext {
+		add(model.Docker, Step{Name: "images", DependsOn: []string{"docker"}, Run: Images})
+		if _, f := manifest.BuildOutputs[model.Docker]; f {
+			archiveDeps = append(archiveDeps, "images")
+		}
+	}
+	steps = append(steps, Step{Name: "sanitize-charts", Run: SanitizeAllCharts})
+	if util.IsValidSemver(manifest.Version) {
+		add(model.Helm, Step{Name: "chart-lint", DependsOn: []string{"sanitize-charts"}, Run: LintCharts})
+		add(model.Helm, Step{Name: "helm", DependsOn: []string{"sanitize-charts", "chart-lint"}, Run: HelmCharts})
+		if manifest.Kustomize != nil {
+			add(model.Helm, Step{Name: "kustomize", DependsOn: []string{"helm"}, Run: Kustomize})
+		}
+		if manifest.RenderedManifests != nil {
+			steps = append(steps, Step{Name: "rendered-manifests", DependsOn: []string{"sanitize-charts"}, Run: RenderedManifests})
+			archiveDeps = append(archiveDeps, "rendered-manifests")
+		}
+		if manifest.ValuesChanges != nil {
+			add(model.Helm, Step{Name: "values-changes", DependsOn: []string{"helm"}, Run: ValuesChanges})
+		}
+		if _, f := manifest.BuildOutputs[model.Docker]; f && manifest.PinnedCharts && manifest.DockerOutput != model.DockerOutputContext {
+			add(model.Helm, Step{Name: "pinned-charts", DependsOn: []string{"helm", "docker"}, Run: PinnedCharts})
+		}
+	} else {
+		log.Warnf("Invalid Semantic Version. Skipping Charts build")
+	}
+	add(model.Debian, Step{Name: "debian", DependsOn: embedDeps, Run: Debian})
+	add(model.Rpm, Step{Name: "rpm", DependsOn: embedDeps, Run: Rpm})
+	if len(manifest.WasmExtensions) > 0 {
+		steps = append(steps, Step{Name: "wasm", Run: Wasm})
+		archiveDeps = append(archiveDeps, "wasm")
+	}
+	if len(manifest.BuildMatrix) > 0 {
+		steps = append(steps, Step{Name: "build-matrix", Run: BuildMatrix})
+	}
+	if manifest.Licenses != nil {
+		steps = append(steps, Step{Name: "notices", Run: Notices})
+		archiveDeps = append(archiveDeps, "notices")
+	}
+	// istioctl is built once for every platform, for both the release archives and the standalone archives
+	_, archive := manifest.BuildOutputs[model.Archive]
+	_, istioctl := manifest.BuildOutputs[model.Istioctl]
+	if archive || istioctl {
+		steps = append(steps, Step{Name: "istioctl-binaries", DependsOn: []string{"sanitize-charts"}, Run: makeIstioctl})
+	}
+	add(model.Archive, Step{Name: "archive", DependsOn: append(archiveDeps, "istioctl-binaries"), Run: Archive})
+	if manifest.InstallScript != nil {
+		add(model.Archive, Step{Name: "install-script", DependsOn: []string{"archive"}, Run: InstallScript})
+	}
+	add(model.Istioctl, Step{Name: "istioctl", DependsOn: []string{"istioctl-binaries"}, Run: Istioctl})
+	if manifest.WindowsPackages != nil {
+		steps = append(steps, Step{Name: "windows-packages", DependsOn: []string{"istioctl"}, Run: WindowsPackages})
+	}
+	add(model.Grafana, Step{Name: "grafana", Run: Grafana})
+	add(model.Docs, Step{Name: "docs", Run: Docs})
+	if manifest.OLM != nil {
+		steps = append(steps, Step{Name: "olm", Run: OLMBundle})
+	}
+	if manifest.Renames != nil {
+		// Renames rewrite the artifacts built above, so run after them
+		steps = append(steps, Step{Name: "rename", DependsOn: stepNames(steps), Run: Rename})
+	}
+
+	steps = append(steps,
+		Step{Name: "bundle-sources", Run: bundleSources},
+		Step{Name: "manifest", Run: func(manifest model.Manifest) error { return writeManifest(manifest, manifest.OutDir()) }},
+		Step{Name: "licenses", Run: writeLicense},
+	)
+	artifactSteps := stepNames(steps)
+	for _, p := range manifest.Plugins {
+		if p.Phase != plugin.Build {
+			continue
+		}
+		deps := p.DependsOn
+		if len(deps) == 0 {
+			deps = artifactSteps
+		}
+		steps = append(steps, Step{Name: plugin.StepName(p), DependsOn: deps, Run: func(manifest model.Manifest) error {
+			return plugin.Run(p, manifest, manifest.WorkDir(), manifest.OutDir())
+		}})
+	}
+	if manifest.GoModules != nil {
+		// The module cache is warmed up before any step builds
+		for i := range steps {
+			steps[i].DependsOn = append(slices.Clip(steps[i].DependsOn), "go-modules")
+		}
+		steps = append([]Step{{Name: "go-modules", Run: GoModules}}, steps...)
+	}
+	// The metadata covers the other artifacts, so runs after them
+	steps = append(steps, Step{Name: "metadata", DependsOn: stepNames(steps), Run: writeReleaseMetadata})
+
+	if manifest.DockerOutput == model.DockerOutputContext {
+		log.Warnf("Docker output in 'context' mode; will not produce SBOM.")
+	} else if manifest.SkipGenerateBillOfMaterials {
+		log.Warnf("Input manifest set SkipGenerateBillOfMaterials; will not produce SBOM.")
+	} else {
+		// The SBOM covers everything in the output directory, so runs after the other artifacts
+		steps = append(steps, Step{Name: "sbom", DependsOn: stepNames(steps), Run: GenerateBillOfMaterials})
+	}
+	// The checksums cover every file in the output directory, including the SBOM
+	steps = append(steps, Step{Name: "checksums", DependsOn: stepNames(steps), Run: writeChecksums})
+	return steps
+}
+
+// stepNames returns the names of the steps.
+func stepNames(steps []Step) []string {
+	names := make([]string, 0, len(steps))
+	for _, s := range steps {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+// bundleSources bundles all sources used in the build
+func bundleSources(manifest model.Manifest) error {
+	return util.TarGz(manifest.Directory, "out/sources.tar.gz", "sources")
+}
@@ -0,0 +1,128 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// ValuesChanges writes a report of the default values added, removed, and changed in each chart since the
+// previous release to values-changes.md in the helm output.
+func ValuesChanges(manifest model.Manifest) error {
+	cfg := manifest.ValuesChanges
+	helmDir := filepath.Join(manifest.OutDir(), manifest.ArtifactDir("helm", ""))
+	charts, err := filepath.Glob(filepath.Join(helmDir, "*.tgz"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(charts)
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "# Helm values changes from %s to %s\n", cfg.PreviousVersion, manifest.Version)
+	for _, chart := range charts {
+		name, _ := model.ChartNameVersion(filepath.Base(chart))
+		current, err := chartValues(chart)
+		if err != nil {
+			return fmt.Errorf("failed to read values of %v: %v", name, err)
+		}
+		previous, err := chartValues(name, "--repo", cfg.HelmRepo, "--version", cfg.PreviousVersion)
+		if err != nil {
+			// New charts are not published for the previous release
+			log.Warnf("No values for %v %v, reporting all values as added: %v", name, cfg.PreviousVersion, err)
+			previous = map[string]string{}
+		}
+		writeValuesChanges(b, name, previous, current)
+	}
+	return os.WriteFile(filepath.Join(helmDir, "values-changes.md"), []byte(b.String()), 0o644)
+}
+
+// chartValues returns the default values of the chart, flattened to dotted keys.
+func chartValues(chart string, args ...string) (map[string]string, error) {
+	out, err := util.RunWithOutput("helm", append([]string{"show", "values", chart}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]any{}
+	if err := yaml.Unmarshal([]byte(out), &values); err != nil {
+		return nil, err
+	}
+	flat := map[string]string{}
+	flattenValues("", values, flat)
+	return flat, nil
+}
+
+// flattenValues flattens nested maps to dotted keys. Lists and scalars are leaves, rendered as json.
+func flattenValues(prefix string, values map[string]any, flat map[string]string) {
+	for k, v := range values {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if m, ok := v.(map[string]any); ok && len(m) > 0 {
+			flattenValues(key, m, flat)
+			continue
+		}
+		by, _ := yaml.Marshal(v)
+		flat[key] = strings.TrimSpace(string(by))
+	}
+}
+
+func writeValuesChanges(b *strings.Builder, chart string, previous, current map[string]string) {
+	added, removed, changed := []string{}, []string{}, []string{}
+	for k, v := range current {
+		if old, f := previous[k]; !f {
+			added = append(added, k)
+		} else if old != v {
+			changed = append(changed, k)
+		}
+	}
+	for k := range previous {
+		if _, f := current[k]; !f {
+			removed = append(removed, k)
+		}
+	}
+	if len(added)+len(removed)+len(changed) == 0 {
+		return
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+
+	fmt.Fprintf(b, "\n## %s\n", chart)
+	section := func(title string, keys []string, line func(k string) string) {
+		if len(keys) == 0 {
+			return
+		}
+		fmt.Fprintf(b, "\n### %s\n\n", title)
+		for _, k := range keys {
+			fmt.Fprintf(b, "* %s\n", line(k))
+		}
+	}
+	section("Added", added, func(k string) string { return fmt.Sprintf("`%s`: `%s`", k, current[k]) })
+	section("Removed", removed, func(k string) string { return fmt.Sprintf("`%s` (was `%s`)", k, previous[k]) })
+	section("Changed", changed, func(k string) string {
+		return fmt.Sprintf("`%s`: `%s` -> `%s`", k, previous[k], current[k])
+	})
+}
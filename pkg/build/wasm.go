@@ -0,0 +1,100 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// Media types of Wasm OCI artifacts, as loaded by Istio's WasmPlugin.
+const (
+	wasmConfigMediaType types.MediaType = "application/vnd.module.wasm.config.v1+json"
+	wasmLayerMediaType  types.MediaType = "application/vnd.module.wasm.content.layer.v1+wasm"
+)
+
+// Wasm builds the Wasm extensions, writing each as <name>-<version>.wasm, and as an OCI image layout
+// under oci/<name> for publishing.
+func Wasm(manifest model.Manifest) error {
+	out := path.Join(manifest.OutDir(), manifest.ArtifactDir("wasm", ""))
+	if err := os.MkdirAll(out, 0o750); err != nil {
+		return err
+	}
+	for _, w := range manifest.WasmExtensions {
+		if err := buildWasm(manifest, w, out); err != nil {
+			return fmt.Errorf("failed to build wasm extension %v: %v", w.Name, err)
+		}
+	}
+	return nil
+}
+
+func buildWasm(manifest model.Manifest, w model.WasmExtension, out string) error {
+	logger := log.WithLabels("artifact", w.Name)
+	dir := path.Join(manifest.RepoDir(w.RepoName()), w.Path)
+	command := w.Build
+	if len(command) == 0 {
+		command = []string{"make", "build"}
+	}
+	cmd := util.VerboseCommand(command[0], command[1:]...)
+	cmd.Dir = dir
+	cmd.Env = util.StandardEnv(manifest)
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+
+	module := path.Join(out, wasmModuleName(manifest, w))
+	if err := util.CopyFile(path.Join(dir, w.Output), module); err != nil {
+		return err
+	}
+	if err := util.CreateSha(module); err != nil {
+		return err
+	}
+
+	by, err := os.ReadFile(module)
+	if err != nil {
+		return err
+	}
+	img, err := mutate.Append(empty.Image, mutate.Addendum{Layer: static.NewLayer(by, wasmLayerMediaType)})
+	if err != nil {
+		return err
+	}
+	img = mutate.ConfigMediaType(mutate.MediaType(img, types.OCIManifestSchema1), wasmConfigMediaType)
+	oci := path.Join(out, "oci", w.Name)
+	p, err := layout.Write(oci, empty.Index)
+	if err != nil {
+		return err
+	}
+	if err := p.AppendImage(img, layout.WithAnnotations(map[string]string{"org.opencontainers.image.ref.name": manifest.Version})); err != nil {
+		return fmt.Errorf("failed to write %v: %v", oci, err)
+	}
+	logger.Infof("Built wasm extension %v as %v and oci layout %v", w.Name, module, oci)
+	return nil
+}
+
+// wasmModuleName returns the released file name of the extension's module.
+func wasmModuleName(manifest model.Manifest, w model.WasmExtension) string {
+	return fmt.Sprintf("%s-%s.wasm", w.Name, manifest.Version)
+}
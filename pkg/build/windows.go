@@ -0,0 +1,130 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"text/template"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+var chocolateyNuspec = template.Must(template.New("nuspec").Parse(`<?xml version="1.0" encoding="utf-8"?>
+<package xmlns="http://schemas.microsoft.com/packaging/2015/06/nuspec.xsd">
+  <metadata>
+    <id>istioctl</id>
+    <version>{{ .Version }}</version>
+    <title>istioctl</title>
+    <authors>Istio Authors</authors>
+    <projectUrl>https://istio.io/</projectUrl>
+    <licenseUrl>https://github.com/istio/istio/blob/master/LICENSE</licenseUrl>
+    <requireLicenseAcceptance>false</requireLicenseAcceptance>
+    <summary>Istio configuration command-line utility</summary>
+    <description>istioctl is a command-line utility to configure, debug, and diagnose Istio service meshes.</description>
+    <tags>istio istioctl kubernetes service-mesh</tags>
+  </metadata>
+  <files>
+    <file src="tools\**" target="tools" />
+  </files>
+</package>
+`))
+
+var chocolateyInstall = template.Must(template.New("install").Parse(`$ErrorActionPreference = 'Stop'
+$toolsDir = "$(Split-Path -parent $MyInvocation.MyCommand.Definition)"
+
+Install-ChocolateyZipPackage -PackageName 'istioctl' ` + "`" + `
+  -Url64bit '{{ .URL }}' ` + "`" + `
+  -Checksum64 '{{ .Sha }}' ` + "`" + `
+  -ChecksumType64 'sha256' ` + "`" + `
+  -UnzipLocation "$toolsDir"
+`))
+
+// windowsArch is the platform of the istioctl archive the windows packages install.
+const windowsArch = "win-amd64"
+
+// WindowsPackages generates a Scoop manifest and Chocolatey package sources for the windows istioctl archive.
+func WindowsPackages(manifest model.Manifest) error {
+	if !slices.Contains(archiveArchitectures(manifest), windowsArch) {
+		return fmt.Errorf("windowsPackages requires istioctl to be built for %v", windowsArch)
+	}
+	archive := fmt.Sprintf("istioctl-%s-%s.zip", manifest.Version, windowsArch)
+	if !util.FileExists(path.Join(manifest.OutDir(), archive)) {
+		return fmt.Errorf("windowsPackages requires the %v istioctl archive %v, which was not built", windowsArch, archive)
+	}
+	sha, err := os.ReadFile(path.Join(manifest.OutDir(), archive+".sha256"))
+	if err != nil {
+		return fmt.Errorf("failed to read checksum of %v: %v", archive, err)
+	}
+	data := map[string]string{
+		"Version": manifest.Version,
+		"URL":     fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(manifest.WindowsPackages.DownloadURL, "/"), manifest.Version, archive),
+		"Sha":     strings.Fields(string(sha))[0],
+	}
+	out := path.Join(manifest.OutDir(), manifest.ArtifactDir("windows", ""))
+
+	scoop, err := json.MarshalIndent(map[string]any{
+		"version":     manifest.Version,
+		"description": "Istio configuration command-line utility",
+		"homepage":    "https://istio.io/",
+		"license":     "Apache-2.0",
+		"architecture": map[string]any{
+			"64bit": map[string]string{
+				"url":  data["URL"],
+				"hash": data["Sha"],
+			},
+		},
+		"bin": "istioctl.exe",
+	}, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := writeWindowsFile(path.Join(out, "scoop", "istioctl.json"), scoop); err != nil {
+		return err
+	}
+
+	for file, tmpl := range map[string]*template.Template{
+		"istioctl.nuspec":             chocolateyNuspec,
+		"tools/chocolateyinstall.ps1": chocolateyInstall,
+	} {
+		buf := &bytes.Buffer{}
+		if err := tmpl.Execute(buf, data); err != nil {
+			return fmt.Errorf("failed to render %v: %v", file, err)
+		}
+		if err := writeWindowsFile(path.Join(out, "chocolatey", file), buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeWindowsFile(file string, contents []byte) error {
+	if err := os.MkdirAll(path.Dir(file), 0o750); err != nil {
+		return err
+	}
+	if err := os.WriteFile(file, contents, 0o644); err != nil {
+		return fmt.Errorf("failed to write %v: %v", file, err)
+	}
+	log.Infof("Wrote %v", file)
+	return nil
+}
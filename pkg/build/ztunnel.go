@@ -0,0 +1,91 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// Ztunnel fetches, or builds, the ztunnel pinned by the istio repo into the work directory, where the istio build
+// fetches it for the images, and releases the binaries as ztunnel/ztunnel-<version>-linux-<arch>.
+func Ztunnel(manifest model.Manifest) error {
+	sha, err := pinnedDependency(manifest, "ztunnel", manifest.Dependencies.Ztunnel)
+	if err != nil {
+		return err
+	}
+	work := path.Join(manifest.WorkDir(), "ztunnel")
+	if err := os.MkdirAll(work, 0o750); err != nil {
+		return err
+	}
+	if manifest.Ztunnel.URL == "" {
+		if err := buildZtunnel(manifest, path.Join(work, fmt.Sprintf("ztunnel-%s-%s", sha, runtime.GOARCH))); err != nil {
+			return fmt.Errorf("failed to build ztunnel: %v", err)
+		}
+	}
+	out := path.Join(manifest.OutDir(), manifest.ArtifactDir("ztunnel", ""))
+	for _, plat := range manifest.Architectures {
+		_, arch, _ := strings.Cut(plat, "/")
+		binary := path.Join(work, fmt.Sprintf("ztunnel-%s-%s", sha, arch))
+		if manifest.Ztunnel.URL != "" {
+			url := fmt.Sprintf("%s/ztunnel-%s-%s", manifest.Ztunnel.URL, sha, arch)
+			if err := util.VerboseCommand("curl", "-fLSs", "--retry", "5", "-o", binary, url).Run(); err != nil {
+				return fmt.Errorf("failed to fetch ztunnel for %v: %v", arch, err)
+			}
+		}
+		dst := path.Join(out, fmt.Sprintf("ztunnel-%s-linux-%s", manifest.Version, arch))
+		if err := util.CopyFile(binary, dst); err != nil {
+			return err
+		}
+		if err := os.Chmod(dst, 0o755); err != nil {
+			return err
+		}
+		if err := util.CreateSha(dst); err != nil {
+			return err
+		}
+	}
+	log.Infof("Released ztunnel %v", sha)
+	return nil
+}
+
+// buildZtunnel builds ztunnel from the ztunnel repo with cargo, as the istio build would. It is only built for the host
+// architecture.
+func buildZtunnel(manifest model.Manifest, binary string) error {
+	for _, plat := range manifest.Architectures {
+		if plat != "linux/"+runtime.GOARCH {
+			return fmt.Errorf("ztunnel can only be built for linux/%v, not %v; set the ztunnel url", runtime.GOARCH, plat)
+		}
+	}
+	profile := manifest.Ztunnel.Profile
+	c := util.VerboseCommand("cargo", "build", "--profile="+profile)
+	c.Dir = manifest.RepoDir("ztunnel")
+	c.Env = util.StandardEnv(manifest)
+	if err := c.Run(); err != nil {
+		return err
+	}
+	// The dev profile is written to the debug directory
+	if profile == "dev" {
+		profile = "debug"
+	}
+	return util.CopyFile(path.Join(manifest.RepoDir("ztunnel"), "out", "rust", profile, "ztunnel"), binary)
+}
@@ -0,0 +1,151 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/minio/minio-go/v7"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/publish"
+)
+
+// TestBuildAndPublish builds the --test-manifest release, stages it in MinIO, and publishes it from there to a local
+// registry and MinIO.
+func TestBuildAndPublish(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping end to end test in short mode")
+	}
+	for _, bin := range []string{"docker", "helm", "make", "git"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%v is required: %v", bin, err)
+		}
+	}
+	if err := exec.Command("docker", "info").Run(); err != nil {
+		t.Skipf("docker is not available: %v", err)
+	}
+
+	registry := runContainer(t, 5000, "registry:2")
+	s3 := runContainer(t, 9000,
+		"-e", "MINIO_ROOT_USER=release-builder", "-e", "MINIO_ROOT_PASSWORD=release-builder",
+		"minio/minio", "server", "/data")
+	waitReady(t, "http://"+registry+"/v2/")
+	waitReady(t, "http://"+s3+"/minio/health/live")
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "release-builder")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "release-builder")
+	ctx := context.Background()
+	publish.S3ClientConfig = model.S3ClientConfig{Endpoint: "http://" + s3, PathStyle: true}
+	client, err := publish.NewS3Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bucket := range []string{"staging", "releases", "charts"} {
+		if err := client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := t.TempDir()
+	run(t, "build", "--test-manifest", dir, "--skip-steps", "chart-lint")
+	run(t, "publish", "--release", filepath.Join(dir, "build", "out"), "--s3bucket", "staging", "--s3docker")
+	// Flags keep their values between runs, so --s3docker is reset explicitly
+	run(t, "publish", "--release", "s3://staging/1.0.0", "--s3bucket", "releases", "--s3docker=false",
+		"--helmbucket", "charts", "--helmhub", registry+"/charts", "--dockerhub", registry)
+
+	for _, obj := range []struct{ bucket, key string }{
+		{"staging", "1.0.0/SHA256SUMS"},
+		{"staging", "1.0.0/docker/pilot-debug.tar.gz"},
+		{"releases", "1.0.0/manifest.yaml"},
+		{"releases", "1.0.0/rpm/istio-sidecar.rpm"},
+		{"releases", "1.0.0/rpm/istio-sidecar.rpm.sha256"},
+		{"charts", "index.yaml"},
+		{"charts", "base-1.0.0.tgz"},
+		{"charts", "samples/ambient-1.0.0.tgz"},
+	} {
+		if _, err := client.StatObject(ctx, obj.bucket, obj.key, minio.StatObjectOptions{}); err != nil {
+			t.Errorf("s3://%s/%s not published: %v", obj.bucket, obj.key, err)
+		}
+	}
+	if _, err := client.StatObject(ctx, "releases", "1.0.0/docker/pilot-debug.tar.gz", minio.StatObjectOptions{}); err == nil {
+		t.Errorf("docker archives published to s3://releases without --s3docker")
+	}
+	for _, ref := range []string{"charts/base:1.0.0", "charts/samples/ambient:1.0.0", "pilot:1.0.0-debug"} {
+		ref, err := name.ParseReference(registry + "/" + ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := remote.Head(ref); err != nil {
+			t.Errorf("%v not published: %v", ref, err)
+		}
+	}
+}
+
+// run executes the release builder with args.
+func run(t *testing.T, args ...string) {
+	t.Helper()
+	root := GetRootCmd(args)
+	root.SetArgs(args)
+	if err := root.Execute(); err != nil {
+		t.Fatalf("%v failed: %v", strings.Join(args, " "), err)
+	}
+}
+
+// runContainer starts a container publishing port on a random local port, and returns its address.
+func runContainer(t *testing.T, port int, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("docker", append([]string{"run", "-d", "--rm", "-p", fmt.Sprintf("127.0.0.1::%d", port)}, args...)...).Output()
+	if err != nil {
+		t.Fatalf("failed to start %v: %v", args, err)
+	}
+	id := strings.TrimSpace(string(out))
+	t.Cleanup(func() {
+		_ = exec.Command("docker", "rm", "-f", id).Run()
+	})
+	addr, err := exec.Command("docker", "port", id, fmt.Sprint(port)).Output()
+	if err != nil {
+		t.Fatalf("failed to get port of %v: %v", args, err)
+	}
+	return strings.TrimSpace(strings.Split(string(addr), "\n")[0])
+}
+
+// waitReady waits for url to respond successfully.
+func waitReady(t *testing.T, url string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Minute)
+	for {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v not ready: %v", url, err)
+		}
+		time.Sleep(time.Second)
+	}
+}
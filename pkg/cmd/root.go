@@ -17,10 +17,19 @@ package cmd
 import (
 	"github.com/spf13/cobra"
 
+	"github.com/alauda-mesh/release-builder/pkg/announce"
 	"github.com/alauda-mesh/release-builder/pkg/branch"
 	"github.com/alauda-mesh/release-builder/pkg/build"
+	"github.com/alauda-mesh/release-builder/pkg/diff"
+	"github.com/alauda-mesh/release-builder/pkg/lock"
+	"github.com/alauda-mesh/release-builder/pkg/nextversion"
+	"github.com/alauda-mesh/release-builder/pkg/plan"
 	"github.com/alauda-mesh/release-builder/pkg/publish"
+	"github.com/alauda-mesh/release-builder/pkg/tag"
+	"github.com/alauda-mesh/release-builder/pkg/test"
+	"github.com/alauda-mesh/release-builder/pkg/util"
 	"github.com/alauda-mesh/release-builder/pkg/validate"
+	"github.com/alauda-mesh/release-builder/pkg/verify"
 )
 
 // GetRootCmd returns the root of the cobra command-tree.
@@ -30,11 +39,60 @@ func GetRootCmd(args []string) *cobra.Command {
 		Short:        "Istio build, release, and publishing tool.",
 		SilenceUsage: true,
 	}
+	logFormat := "text"
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logFormat,
+		"The log format. One of text, json. In json mode each line includes the current step, repo, and artifact.")
+	progress := false
+	rootCmd.PersistentFlags().BoolVar(&progress, "progress", progress,
+		"Report progress of uploads, clones, and pushes, and summarize the transfers. Progress bars are drawn on a terminal, "+
+			"otherwise progress is logged periodically.")
+	s3 := &publish.S3ClientConfig
+	rootCmd.PersistentFlags().StringVar(&s3.Endpoint, "s3-endpoint", s3.Endpoint,
+		"The S3 compatible storage URL. Defaults to $S3_ENDPOINT, or AWS.")
+	rootCmd.PersistentFlags().StringVar(&s3.Region, "s3-region", s3.Region,
+		"The S3 bucket region, for storage not supporting region lookups.")
+	rootCmd.PersistentFlags().BoolVar(&s3.PathStyle, "s3-path-style", s3.PathStyle,
+		"Use path-style S3 bucket addressing, as required by some MinIO deployments.")
+	rootCmd.PersistentFlags().StringVar(&s3.CredentialsFile, "s3-credentials-file", s3.CredentialsFile,
+		"An AWS shared credentials file to read static S3 credentials from, rather than the environment.")
+	rootCmd.PersistentFlags().StringVar(&s3.Profile, "s3-profile", s3.Profile,
+		"The profile to read from --s3-credentials-file.")
+	rootCmd.PersistentFlags().BoolVar(&s3.InsecureSkipVerify, "s3-insecure-skip-verify", s3.InsecureSkipVerify,
+		"Skip TLS verification of the S3 endpoint.")
+	network := &util.NetworkConfig
+	rootCmd.PersistentFlags().StringVar(&network.HTTPProxy, "http-proxy", network.HTTPProxy,
+		"The proxy for http requests, from the builder and the commands it runs. Defaults to $HTTP_PROXY.")
+	rootCmd.PersistentFlags().StringVar(&network.HTTPSProxy, "https-proxy", network.HTTPSProxy,
+		"The proxy for https requests, from the builder and the commands it runs. Defaults to $HTTPS_PROXY.")
+	rootCmd.PersistentFlags().StringVar(&network.NoProxy, "no-proxy", network.NoProxy,
+		"The hosts to connect to directly, rather than through the proxy. Defaults to $NO_PROXY.")
+	rootCmd.PersistentFlags().StringVar(&network.CABundle, "ca-bundle", network.CABundle,
+		"A PEM file of additional CA certificates to trust, such as for a TLS intercepting proxy.")
+	rootCmd.PersistentPreRunE = func(c *cobra.Command, _ []string) error {
+		util.EnableProgress(progress)
+		if err := util.ConfigureNetwork(nil); err != nil {
+			return err
+		}
+		return util.ConfigureLogging(logFormat)
+	}
 
 	rootCmd.AddCommand(build.GetBuildCommand())
 	rootCmd.AddCommand(validate.GetValidateCommand())
 	rootCmd.AddCommand(publish.GetPublishCommand())
+	rootCmd.AddCommand(publish.GetUnpublishCommand())
+	rootCmd.AddCommand(publish.GetPromoteCommand())
+	rootCmd.AddCommand(publish.GetPruneCommand())
+	rootCmd.AddCommand(publish.GetRebuildImagesCommand())
+	rootCmd.AddCommand(lock.GetLockCommand())
 	rootCmd.AddCommand(branch.GetBranchCommand())
+	rootCmd.AddCommand(plan.GetPlanCommand())
+	rootCmd.AddCommand(verify.GetVerifyCommand())
+	rootCmd.AddCommand(test.GetTestCommand())
+	rootCmd.AddCommand(diff.GetDiffCommand())
+	rootCmd.AddCommand(diff.GetUpstreamDiffCommand())
+	rootCmd.AddCommand(tag.GetTagCommand())
+	rootCmd.AddCommand(nextversion.GetNextVersionCommand())
+	rootCmd.AddCommand(announce.GetAnnounceCommand())
 
 	return rootCmd
 }
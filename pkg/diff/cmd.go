@@ -0,0 +1,80 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diff
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	flags = struct {
+		old    string
+		new    string
+		bucket string
+		hub    string
+		output string
+	}{}
+	diffCmd = &cobra.Command{
+		Use:          "diff",
+		Short:        "Reports the differences between two releases of Istio",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(0),
+		RunE: func(c *cobra.Command, _ []string) error {
+			if flags.old == "" || flags.new == "" {
+				return fmt.Errorf("--old and --new must be passed")
+			}
+			oldRelease, err := loadRelease(flags.old, flags.bucket, flags.hub)
+			if err != nil {
+				return fmt.Errorf("failed to load %v: %v", flags.old, err)
+			}
+			defer oldRelease.cleanup()
+			newRelease, err := loadRelease(flags.new, flags.bucket, flags.hub)
+			if err != nil {
+				return fmt.Errorf("failed to load %v: %v", flags.new, err)
+			}
+			defer newRelease.cleanup()
+
+			report, err := Report(oldRelease, newRelease)
+			if err != nil {
+				return err
+			}
+			if flags.output == "" {
+				_, err = os.Stdout.WriteString(report)
+				return err
+			}
+			return os.WriteFile(flags.output, []byte(report), 0o644)
+		},
+	}
+)
+
+func init() {
+	diffCmd.PersistentFlags().StringVar(&flags.old, "old", flags.old,
+		"The previous release. Either a release directory, or a version published to --bucket.")
+	diffCmd.PersistentFlags().StringVar(&flags.new, "new", flags.new,
+		"The new release. Either a release directory, or a version published to --bucket.")
+	diffCmd.PersistentFlags().StringVar(&flags.bucket, "bucket", flags.bucket,
+		"The bucket published releases are read from. Example: istio-release/releases")
+	diffCmd.PersistentFlags().StringVar(&flags.hub, "hub", flags.hub,
+		"The hub published release images are read from. Defaults to the hub in the published manifest.")
+	diffCmd.PersistentFlags().StringVar(&flags.output, "output", flags.output,
+		"The file to write the markdown report to. Defaults to stdout.")
+}
+
+func GetDiffCommand() *cobra.Command {
+	return diffCmd
+}
@@ -0,0 +1,235 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diff
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// sizedExtensions are the artifacts whose size changes are reported.
+var sizedExtensions = []string{".tar.gz", ".zip", ".tgz", ".deb", ".rpm"}
+
+// Report renders a markdown report of the differences between two releases.
+func Report(oldRelease, newRelease release) (string, error) {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "# Release diff: %s → %s\n\n", oldRelease.manifest.Version, newRelease.manifest.Version)
+
+	writeFiles(b, oldRelease, newRelease)
+	writeImages(b, oldRelease, newRelease)
+	if err := writeCharts(b, oldRelease, newRelease); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func writeFiles(b *strings.Builder, oldRelease, newRelease release) {
+	added, removed, common := compareKeys(oldRelease.files, newRelease.files)
+	b.WriteString("## Files\n\n")
+	if len(added)+len(removed) == 0 {
+		b.WriteString("No files added or removed.\n\n")
+	}
+	for _, f := range added {
+		fmt.Fprintf(b, "- Added `%s`\n", f)
+	}
+	for _, f := range removed {
+		fmt.Fprintf(b, "- Removed `%s`\n", f)
+	}
+	if len(added)+len(removed) > 0 {
+		b.WriteString("\n")
+	}
+
+	b.WriteString("## Sizes\n\n| Artifact | Old | New | Change |\n| --- | --- | --- | --- |\n")
+	for _, f := range common {
+		if !hasSizedExtension(f) {
+			continue
+		}
+		o, n := oldRelease.files[f], newRelease.files[f]
+		change := "n/a"
+		if o > 0 {
+			change = fmt.Sprintf("%+.1f%%", float64(n-o)/float64(o)*100)
+		}
+		fmt.Fprintf(b, "| `%s` | %d | %d | %s |\n", f, o, n, change)
+	}
+	b.WriteString("\n")
+}
+
+func writeImages(b *strings.Builder, oldRelease, newRelease release) {
+	b.WriteString("## Images\n\n")
+	added, removed, common := compareKeys(oldRelease.images, newRelease.images)
+	if len(added)+len(removed)+len(common) == 0 {
+		b.WriteString("No images found.\n\n")
+		return
+	}
+	b.WriteString("| Image | Old | New |\n| --- | --- | --- |\n")
+	for _, i := range common {
+		o, n := oldRelease.images[i], newRelease.images[i]
+		if o == n {
+			n = "unchanged"
+		}
+		fmt.Fprintf(b, "| `%s` | `%s` | `%s` |\n", i, o, n)
+	}
+	for _, i := range added {
+		fmt.Fprintf(b, "| `%s` | added | `%s` |\n", i, newRelease.images[i])
+	}
+	for _, i := range removed {
+		fmt.Fprintf(b, "| `%s` | `%s` | removed |\n", i, oldRelease.images[i])
+	}
+	b.WriteString("\n")
+}
+
+func writeCharts(b *strings.Builder, oldRelease, newRelease release) error {
+	b.WriteString("## Charts\n\n")
+	added, removed, common := compareKeys(oldRelease.charts, newRelease.charts)
+	for _, c := range added {
+		fmt.Fprintf(b, "- Added chart `%s`\n", c)
+	}
+	for _, c := range removed {
+		fmt.Fprintf(b, "- Removed chart `%s`\n", c)
+	}
+	for _, c := range common {
+		for _, kind := range []string{"values", "template"} {
+			o, err := chartContent(kind, oldRelease.charts[c], oldRelease.manifest.Version)
+			if err != nil {
+				return fmt.Errorf("failed to read %v %v: %v", c, kind, err)
+			}
+			n, err := chartContent(kind, newRelease.charts[c], newRelease.manifest.Version)
+			if err != nil {
+				return fmt.Errorf("failed to read %v %v: %v", c, kind, err)
+			}
+			d, err := unifiedDiff(c+" "+kind, o, n)
+			if err != nil {
+				return err
+			}
+			if d == "" {
+				continue
+			}
+			fmt.Fprintf(b, "<details><summary><code>%s</code> %s</summary>\n\n```diff\n%s```\n\n</details>\n\n", c, kind, d)
+		}
+	}
+	return nil
+}
+
+// chartContent returns the values, or rendered templates, of a packaged chart, with the version normalized.
+func chartContent(kind, chart, version string) ([]byte, error) {
+	var content []byte
+	var err error
+	if kind == "values" {
+		content, err = chartValues(chart)
+	} else {
+		buf := &bytes.Buffer{}
+		c := util.VerboseCommand("helm", "template", "release-diff", chart)
+		c.Stdout = buf
+		err = c.Run()
+		content = buf.Bytes()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.ReplaceAll(content, []byte(version), []byte("{version}")), nil
+}
+
+// chartValues reads the top level values.yaml from a packaged chart.
+func chartValues(chart string) ([]byte, error) {
+	f, err := os.Open(chart)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no values.yaml found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Charts are packaged as <chart>/values.yaml; subcharts are nested deeper
+		if strings.Count(h.Name, "/") == 1 && filepath.Base(h.Name) == "values.yaml" {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+// unifiedDiff returns the unified diff between old and new, or an empty string if they are equal.
+func unifiedDiff(label string, oldContent, newContent []byte) (string, error) {
+	if bytes.Equal(oldContent, newContent) {
+		return "", nil
+	}
+	dir, err := os.MkdirTemp("", "release-diff")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	oldFile, newFile := filepath.Join(dir, "old"), filepath.Join(dir, "new")
+	if err := os.WriteFile(oldFile, oldContent, 0o644); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(newFile, newContent, 0o644); err != nil {
+		return "", err
+	}
+	out, err := exec.Command("diff", "-u", "--label", "old/"+label, "--label", "new/"+label, oldFile, newFile).Output()
+	// diff exits 1 when the files differ
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+		return "", fmt.Errorf("failed to diff %v: %v", label, err)
+	}
+	return string(out), nil
+}
+
+// compareKeys returns the keys only in newMap, only in oldMap, and in both, each sorted.
+func compareKeys[V any](oldMap, newMap map[string]V) (added, removed, common []string) {
+	for k := range newMap {
+		if _, f := oldMap[k]; f {
+			common = append(common, k)
+		} else {
+			added = append(added, k)
+		}
+	}
+	for k := range oldMap {
+		if _, f := newMap[k]; !f {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(common)
+	return added, removed, common
+}
+
+func hasSizedExtension(f string) bool {
+	for _, ext := range sizedExtensions {
+		if strings.HasSuffix(f, ext) {
+			return true
+		}
+	}
+	return false
+}
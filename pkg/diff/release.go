@@ -0,0 +1,243 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diff
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/minio/minio-go/v7"
+	"istio.io/istio/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg"
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/publish"
+)
+
+// release is the content of a release relevant to the report. Paths have the version replaced with
+// {version}, so artifacts match between releases.
+type release struct {
+	manifest model.Manifest
+	// files maps each file to its size
+	files map[string]int64
+	// images maps each docker archive, such as pilot-distroless, to its image config digest
+	images map[string]string
+	// charts maps each chart name to its packaged chart file
+	charts map[string]string
+	// tmpDir holds any downloaded charts
+	tmpDir string
+}
+
+func (r release) cleanup() {
+	if r.tmpDir != "" {
+		_ = os.RemoveAll(r.tmpDir)
+	}
+}
+
+// loadRelease loads a release directory, or, if source is not a directory, the version published to bucket.
+func loadRelease(source, bucket, hub string) (release, error) {
+	if info, err := os.Stat(source); err == nil && info.IsDir() {
+		return loadLocalRelease(source)
+	}
+	if bucket == "" {
+		return release{}, fmt.Errorf("%v is not a directory, and no --bucket was passed", source)
+	}
+	return loadPublishedRelease(source, bucket, hub)
+}
+
+func loadLocalRelease(dir string) (release, error) {
+	manifest, err := pkg.ReadManifest(filepath.Join(dir, "manifest.yaml"))
+	if err != nil {
+		return release{}, err
+	}
+	r := release{manifest: manifest, files: map[string]int64{}, images: map[string]string{}, charts: map[string]string{}}
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		r.files[normalize(rel, manifest.Version)] = info.Size()
+		return nil
+	})
+	if err != nil {
+		return release{}, fmt.Errorf("failed to read release: %v", err)
+	}
+
+	dockerDir := filepath.Join(dir, manifest.ArtifactDir("docker", ""))
+	archives, _ := os.ReadDir(dockerDir)
+	for _, a := range archives {
+		if !strings.HasSuffix(a.Name(), ".tar.gz") {
+			continue
+		}
+		digest, err := archiveDigest(filepath.Join(dockerDir, a.Name()))
+		if err != nil {
+			return release{}, fmt.Errorf("failed to read image %v: %v", a.Name(), err)
+		}
+		r.images[strings.TrimSuffix(a.Name(), ".tar.gz")] = digest
+	}
+
+	charts, _ := filepath.Glob(filepath.Join(dir, manifest.ArtifactDir("helm", ""), "*.tgz"))
+	for _, c := range charts {
+		r.charts[chartName(filepath.Base(c))] = c
+	}
+	return r, nil
+}
+
+// loadPublishedRelease loads the file listing and charts from the bucket, and image digests from the hub.
+// Docker archives are not published to the bucket, so the standard set of images is resolved on the hub.
+func loadPublishedRelease(version, bucket, hub string) (release, error) {
+	ctx := context.Background()
+	client, err := publish.NewS3Client(ctx)
+	if err != nil {
+		return release{}, err
+	}
+	bucketName, objectPrefix, _ := strings.Cut(bucket, "/")
+	prefix := path.Join(objectPrefix, version)
+	by, err := publish.FetchObject(client, bucketName, prefix, "manifest.yaml")
+	if err != nil {
+		return release{}, fmt.Errorf("failed to fetch published manifest: %v", err)
+	}
+	manifest := model.Manifest{}
+	if err := yaml.Unmarshal(by, &manifest); err != nil {
+		return release{}, fmt.Errorf("failed to unmarshal published manifest: %v", err)
+	}
+	tmpDir, err := os.MkdirTemp("", "release-diff")
+	if err != nil {
+		return release{}, err
+	}
+	r := release{
+		manifest: manifest, files: map[string]int64{}, images: map[string]string{}, charts: map[string]string{},
+		tmpDir: tmpDir,
+	}
+	helmDir := manifest.ArtifactDir("helm", "")
+	for obj := range client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: prefix + "/", Recursive: true}) {
+		if obj.Err != nil {
+			return r, fmt.Errorf("failed to list objects: %v", obj.Err)
+		}
+		rel := strings.TrimPrefix(obj.Key, prefix+"/")
+		r.files[normalize(rel, version)] = obj.Size
+		if path.Dir(rel) == helmDir && strings.HasSuffix(rel, ".tgz") {
+			dest := filepath.Join(tmpDir, path.Base(rel))
+			if err := client.FGetObject(ctx, bucketName, obj.Key, dest, minio.GetObjectOptions{}); err != nil {
+				return r, fmt.Errorf("failed to fetch %v: %v", obj.Key, err)
+			}
+			r.charts[chartName(path.Base(rel))] = dest
+		}
+	}
+
+	if hub == "" {
+		hub = manifest.Docker
+	}
+	variants := manifest.ImageVariants
+	if len(variants) == 0 {
+		variants = []string{"debug", "distroless"}
+	}
+	for _, image := range []string{"pilot", "proxyv2", "install-cni", "ztunnel"} {
+		for _, variant := range variants {
+			archive := image + "-" + variant
+			img := publish.Image{NewTag: fmt.Sprintf("%s/%s:%s", hub, image, version), Variant: variant}
+			digest, err := remoteDigest(img.NewReference(""))
+			if err != nil {
+				log.Warnf("failed to resolve %v: %v", img.NewReference(""), err)
+				continue
+			}
+			r.images[archive] = digest
+		}
+	}
+	return r, nil
+}
+
+// archiveDigest returns the config digest, or image ID, of a gzipped docker archive.
+func archiveDigest(archive string) (string, error) {
+	img, err := tarball.Image(func() (io.ReadCloser, error) {
+		f, err := os.Open(archive)
+		if err != nil {
+			return nil, err
+		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return readCloser{gz, f}, nil
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	digest, err := img.ConfigName()
+	if err != nil {
+		return "", err
+	}
+	return digest.String(), nil
+}
+
+// remoteDigest returns the config digest, or image ID, of the linux/amd64 image for the reference.
+func remoteDigest(reference string) (string, error) {
+	ref, err := name.ParseReference(reference)
+	if err != nil {
+		return "", err
+	}
+	img, err := remote.Image(ref, remote.WithAuthFromKeychain(authn.DefaultKeychain),
+		remote.WithPlatform(v1.Platform{OS: "linux", Architecture: "amd64"}))
+	if err != nil {
+		return "", err
+	}
+	digest, err := img.ConfigName()
+	if err != nil {
+		return "", err
+	}
+	return digest.String(), nil
+}
+
+// readCloser closes both the gzip reader and the underlying file.
+type readCloser struct {
+	io.Reader
+	file *os.File
+}
+
+func (r readCloser) Close() error {
+	return r.file.Close()
+}
+
+// normalize replaces the version in a path, so artifacts match between releases.
+func normalize(p, version string) string {
+	return strings.ReplaceAll(p, version, "{version}")
+}
+
+// chartName returns the name of a packaged chart, such as istiod for istiod-1.25.0.tgz.
+func chartName(file string) string {
+	name, _ := model.ChartNameVersion(file)
+	return name
+}
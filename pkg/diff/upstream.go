@@ -0,0 +1,325 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diff
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/publish"
+)
+
+var (
+	upstreamFlags = struct {
+		release     string
+		version     string
+		hub         string
+		downloadURL string
+		chartsURL   string
+		output      string
+	}{
+		hub:         "docker.io/istio",
+		downloadURL: "https://github.com/istio/istio/releases/download",
+		chartsURL:   "https://istio-release.storage.googleapis.com/charts",
+	}
+	upstreamCmd = &cobra.Command{
+		Use:          "upstream-diff",
+		Short:        "Reports the differences between a release and the upstream Istio release it is based on",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(0),
+		RunE: func(c *cobra.Command, _ []string) error {
+			if upstreamFlags.release == "" || upstreamFlags.version == "" {
+				return fmt.Errorf("--release and --upstream must be passed")
+			}
+			ours, err := loadLocalRelease(upstreamFlags.release)
+			if err != nil {
+				return fmt.Errorf("failed to load %v: %v", upstreamFlags.release, err)
+			}
+			upstream, err := loadUpstreamRelease(ours, upstreamFlags.version)
+			defer upstream.cleanup()
+			if err != nil {
+				return fmt.Errorf("failed to load upstream %v: %v", upstreamFlags.version, err)
+			}
+
+			report, err := UpstreamReport(upstreamFlags.release, ours, upstream)
+			if err != nil {
+				return err
+			}
+			if upstreamFlags.output == "" {
+				_, err = os.Stdout.WriteString(report)
+				return err
+			}
+			return os.WriteFile(upstreamFlags.output, []byte(report), 0o644)
+		},
+	}
+)
+
+func init() {
+	upstreamCmd.PersistentFlags().StringVar(&upstreamFlags.release, "release", upstreamFlags.release,
+		"The release directory to compare.")
+	upstreamCmd.PersistentFlags().StringVar(&upstreamFlags.version, "upstream", upstreamFlags.version,
+		"The upstream Istio version the release is based on. Example: 1.24.2")
+	upstreamCmd.PersistentFlags().StringVar(&upstreamFlags.hub, "upstreamhub", upstreamFlags.hub,
+		"The hub upstream images are read from.")
+	upstreamCmd.PersistentFlags().StringVar(&upstreamFlags.downloadURL, "upstreamdownloadurl", upstreamFlags.downloadURL,
+		"The base URL upstream release archives are downloaded from, with the version appended.")
+	upstreamCmd.PersistentFlags().StringVar(&upstreamFlags.chartsURL, "upstreamchartsurl", upstreamFlags.chartsURL,
+		"The helm repository upstream charts are downloaded from.")
+	upstreamCmd.PersistentFlags().StringVar(&upstreamFlags.output, "output", upstreamFlags.output,
+		"The file to write the markdown report to. Defaults to stdout.")
+}
+
+func GetUpstreamDiffCommand() *cobra.Command {
+	return upstreamCmd
+}
+
+// upstreamRelease is the upstream counterpart of a release. The upstream archives are only downloaded for
+// archives in the release, as the upstream file listing is not known.
+type upstreamRelease struct {
+	release
+	// archives maps each normalized archive name to its downloaded file
+	archives map[string]string
+}
+
+// loadUpstreamRelease resolves the upstream images, and downloads the archives and charts, matching those of ours.
+func loadUpstreamRelease(ours release, version string) (upstreamRelease, error) {
+	tmpDir, err := os.MkdirTemp("", "upstream-diff")
+	if err != nil {
+		return upstreamRelease{}, err
+	}
+	r := upstreamRelease{
+		release: release{
+			manifest: model.Manifest{Version: version},
+			files:    map[string]int64{}, images: map[string]string{}, charts: map[string]string{},
+			tmpDir: tmpDir,
+		},
+		archives: map[string]string{},
+	}
+
+	for archive := range ours.images {
+		image, variant, arch := publish.GetImageNameVariant(archive, ours.manifest.ImageVariants)
+		// Upstream digests are resolved for linux/amd64
+		if arch != "" {
+			continue
+		}
+		img := publish.Image{NewTag: fmt.Sprintf("%s/%s:%s", upstreamFlags.hub, image, version), Variant: variant}
+		digest, err := remoteDigest(img.NewReference(""))
+		if err != nil {
+			log.Warnf("failed to resolve %v: %v", img.NewReference(""), err)
+			continue
+		}
+		r.images[archive] = digest
+	}
+
+	for f := range ours.files {
+		if !isBinaryArchive(f) {
+			continue
+		}
+		file := strings.ReplaceAll(f, "{version}", version)
+		dest := filepath.Join(tmpDir, file)
+		found, err := download(fmt.Sprintf("%s/%s/%s", upstreamFlags.downloadURL, version, file), dest)
+		if err != nil {
+			return r, err
+		}
+		if found {
+			r.archives[f] = dest
+		}
+	}
+
+	for chart := range ours.charts {
+		file := fmt.Sprintf("%s-%s.tgz", chart, version)
+		dest := filepath.Join(tmpDir, file)
+		found, err := download(upstreamFlags.chartsURL+"/"+file, dest)
+		if err != nil {
+			return r, err
+		}
+		if found {
+			r.charts[chart] = dest
+		}
+	}
+	return r, nil
+}
+
+// isBinaryArchive returns whether a normalized release file is a top level istio or istioctl archive.
+func isBinaryArchive(f string) bool {
+	return !strings.Contains(f, "/") && strings.HasSuffix(f, ".tar.gz") &&
+		(strings.HasPrefix(f, "istio-{version}-") || strings.HasPrefix(f, "istioctl-{version}-"))
+}
+
+// download fetches url to dest, returning false if it does not exist.
+func download(url string, dest string) (bool, error) {
+	client := &http.Client{Timeout: 10 * time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return false, fmt.Errorf("failed to download %v: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		log.Warnf("%v not found upstream", url)
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("failed to download %v: %v", url, resp.Status)
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		return false, fmt.Errorf("failed to download %v: %v", url, err)
+	}
+	return true, nil
+}
+
+// UpstreamReport renders a markdown report of every difference between a release and its upstream release, for
+// security review of the downstream changes.
+func UpstreamReport(name string, ours release, upstream upstreamRelease) (string, error) {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "# Downstream delta: %s → upstream %s\n\n", ours.manifest.Version, upstream.manifest.Version)
+	fmt.Fprintf(b, "Compares `%s` against upstream Istio %s. Diffs show upstream as old, and this release as new.\n\n",
+		name, upstream.manifest.Version)
+
+	writeUpstreamImages(b, ours, upstream)
+	if err := writeBinaries(b, ours, upstream); err != nil {
+		return "", err
+	}
+	if err := writeCharts(b, upstream.release, ours); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func writeUpstreamImages(b *strings.Builder, ours release, upstream upstreamRelease) {
+	b.WriteString("## Images\n\n| Image | Upstream | Ours |\n| --- | --- | --- |\n")
+	images := []string{}
+	for i := range ours.images {
+		images = append(images, i)
+	}
+	sort.Strings(images)
+	for _, i := range images {
+		o, u := ours.images[i], upstream.images[i]
+		switch {
+		case u == "":
+			fmt.Fprintf(b, "| `%s` | not found | `%s` |\n", i, o)
+		case u == o:
+			fmt.Fprintf(b, "| `%s` | `%s` | identical |\n", i, u)
+		default:
+			fmt.Fprintf(b, "| `%s` | `%s` | `%s` |\n", i, u, o)
+		}
+	}
+	b.WriteString("\n")
+}
+
+// writeBinaries reports the files within each archive whose contents differ from upstream.
+func writeBinaries(b *strings.Builder, ours release, upstream upstreamRelease) error {
+	b.WriteString("## Binaries\n\n")
+	archives := []string{}
+	for f := range ours.files {
+		if isBinaryArchive(f) {
+			archives = append(archives, f)
+		}
+	}
+	sort.Strings(archives)
+	if len(archives) == 0 {
+		b.WriteString("No archives found.\n\n")
+		return nil
+	}
+	for _, a := range archives {
+		u, f := upstream.archives[a]
+		if !f {
+			fmt.Fprintf(b, "- `%s`: not found upstream\n", a)
+			continue
+		}
+		oursHashes, err := archiveHashes(filepath.Join(upstreamFlags.release, strings.ReplaceAll(a, "{version}", ours.manifest.Version)),
+			ours.manifest.Version)
+		if err != nil {
+			return fmt.Errorf("failed to read %v: %v", a, err)
+		}
+		upstreamHashes, err := archiveHashes(u, upstream.manifest.Version)
+		if err != nil {
+			return fmt.Errorf("failed to read upstream %v: %v", a, err)
+		}
+		added, removed, common := compareKeys(upstreamHashes, oursHashes)
+		changed := []string{}
+		for _, c := range common {
+			if upstreamHashes[c] != oursHashes[c] {
+				changed = append(changed, c)
+			}
+		}
+		if len(added)+len(removed)+len(changed) == 0 {
+			fmt.Fprintf(b, "- `%s`: identical\n", a)
+			continue
+		}
+		fmt.Fprintf(b, "- `%s`:\n", a)
+		for _, c := range changed {
+			fmt.Fprintf(b, "  - Changed `%s`: `%s` → `%s`\n", c, upstreamHashes[c], oursHashes[c])
+		}
+		for _, c := range added {
+			fmt.Fprintf(b, "  - Added `%s`: `%s`\n", c, oursHashes[c])
+		}
+		for _, c := range removed {
+			fmt.Fprintf(b, "  - Removed `%s`\n", c)
+		}
+	}
+	b.WriteString("\n")
+	return nil
+}
+
+// archiveHashes returns the sha256 of each regular file in a gzipped tar archive, with the version normalized.
+func archiveHashes(archive string, version string) (map[string]string, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	hashes := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return hashes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		sha := sha256.New()
+		if _, err := io.Copy(sha, tr); err != nil {
+			return nil, err
+		}
+		hashes[normalize(h.Name, version)] = hex.EncodeToString(sha.Sum(nil))
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// LockFile is the default lock file of a manifest, written alongside it.
+func LockFile(manifestFile string) string {
+	return filepath.Join(filepath.Dir(manifestFile), "manifest.lock.yaml")
+}
+
+// LockDependencies resolves each dependency of the manifest, including branches and auto dependencies, to the SHA
+// it would be built from. The sources are fetched to a temporary directory to resolve them.
+func LockDependencies(in model.InputManifest) (model.ManifestLock, error) {
+	dir, err := os.MkdirTemp("", "release-builder-lock")
+	if err != nil {
+		return model.ManifestLock{}, err
+	}
+	defer os.RemoveAll(dir)
+	in.Directory = dir
+	manifest, err := InputManifestToManifest(in)
+	if err != nil {
+		return model.ManifestLock{}, fmt.Errorf("failed to setup manifest: %v", err)
+	}
+	for repo, dep := range manifest.Dependencies.Get() {
+		if dep != nil && dep.LocalPath != "" {
+			return model.ManifestLock{}, fmt.Errorf("%v is copied from %v, which cannot be locked", repo, dep.LocalPath)
+		}
+	}
+	if err := SetupWorkDir(dir); err != nil {
+		return model.ManifestLock{}, err
+	}
+	// Only the resolved SHAs are needed, not the history
+	if err := Sources(manifest, util.CloneOptions{Depth: 1}); err != nil {
+		return model.ManifestLock{}, fmt.Errorf("failed to fetch sources: %v", err)
+	}
+
+	lock := model.ManifestLock{Version: in.Version, Dependencies: map[string]model.Dependency{}}
+	for repo, dep := range manifest.Dependencies.Get() {
+		if dep == nil {
+			continue
+		}
+		sha, err := GetSha(manifest.RepoDir(repo), "HEAD")
+		if err != nil {
+			return model.ManifestLock{}, fmt.Errorf("failed to get SHA for %v: %v", repo, err)
+		}
+		lock.Dependencies[repo] = model.Dependency{Git: dep.Git, Sha: strings.TrimSpace(sha)}
+		log.Infof("Locked %v %v to %v", repo, dep.Ref(), strings.TrimSpace(sha))
+	}
+	return lock, nil
+}
+
+// WriteLock writes the lock file.
+func WriteLock(lock model.ManifestLock, file string) error {
+	by, err := yaml.Marshal(lock)
+	if err != nil {
+		return fmt.Errorf("failed to marshal lock: %v", err)
+	}
+	if err := os.WriteFile(file, by, 0o644); err != nil {
+		return fmt.Errorf("failed to write lock: %v", err)
+	}
+	return nil
+}
+
+// ReadLock reads a lock file.
+func ReadLock(file string) (model.ManifestLock, error) {
+	lock := model.ManifestLock{}
+	by, err := os.ReadFile(file)
+	if err != nil {
+		return lock, fmt.Errorf("failed to read lock file: %v", err)
+	}
+	if err := yaml.Unmarshal(by, &lock); err != nil {
+		return lock, fmt.Errorf("failed to unmarshal lock file: %v", err)
+	}
+	return lock, nil
+}
+
+// ApplyLock pins each dependency of the manifest to its locked SHA. The lock must cover exactly the dependencies of
+// the manifest, from the same git sources, or it is out of date.
+func ApplyLock(in *model.InputManifest, lock model.ManifestLock) error {
+	if lock.Version != in.Version {
+		return fmt.Errorf("lock is for version %v, not %v; rerun release-builder lock", lock.Version, in.Version)
+	}
+	for repo, dep := range in.Dependencies.Get() {
+		locked, f := lock.Dependencies[repo]
+		switch {
+		case dep == nil && !f:
+			continue
+		case dep == nil || !f || locked.Git != dep.Git:
+			return fmt.Errorf("lock of %v is out of date; rerun release-builder lock", repo)
+		}
+		log.Infof("Building %v at locked %v", repo, locked.Sha)
+		*dep = model.Dependency{
+			Git:              dep.Git,
+			Sha:              locked.Sha,
+			RequiredChecks:   dep.RequiredChecks,
+			GoVersionEnabled: dep.GoVersionEnabled,
+			Auth:             dep.Auth,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lock
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg"
+)
+
+var (
+	flags = struct {
+		manifest string
+		profile  string
+		set      []string
+		output   string
+	}{
+		manifest: "example/manifest.yaml",
+	}
+	lockCmd = &cobra.Command{
+		Use:          "lock",
+		Short:        "Resolves the dependencies of a manifest to exact SHAs, for building with build --locked",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(0),
+		RunE: func(c *cobra.Command, _ []string) error {
+			inManifest, err := pkg.ReadInManifest(flags.manifest, flags.profile, flags.set)
+			if err != nil {
+				return fmt.Errorf("failed to unmarshal manifest: %v", err)
+			}
+			lock, err := pkg.LockDependencies(inManifest)
+			if err != nil {
+				return fmt.Errorf("failed to lock dependencies: %v", err)
+			}
+			output := flags.output
+			if output == "" {
+				output = pkg.LockFile(flags.manifest)
+			}
+			if err := pkg.WriteLock(lock, output); err != nil {
+				return err
+			}
+			log.Infof("Wrote lock to %v", output)
+			return nil
+		},
+	}
+)
+
+func init() {
+	lockCmd.PersistentFlags().StringVar(&flags.manifest, "manifest", flags.manifest,
+		"The manifest to lock.")
+	lockCmd.PersistentFlags().StringVar(&flags.profile, "profile", flags.profile,
+		"The manifest profile to apply. Example: daily")
+	lockCmd.PersistentFlags().StringArrayVar(&flags.set, "set", flags.set,
+		"Override a manifest field, in the form key=value. Keys are dot separated, such as dependencies.istio.branch. May be repeated.")
+	lockCmd.PersistentFlags().StringVar(&flags.output, "output", flags.output,
+		"The lock file to write. Defaults to manifest.lock.yaml alongside the manifest.")
+}
+
+func GetLockCommand() *cobra.Command {
+	return lockCmd
+}
@@ -0,0 +1,60 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+func TestApplyLock(t *testing.T) {
+	auth := &model.GitAuth{SSHKeyFile: "/secrets/deploy-key"}
+	in := model.InputManifest{
+		Version: "1.24.0",
+		Dependencies: model.IstioDependencies{
+			Istio: &model.Dependency{
+				Git:            "git@github.com:example/istio",
+				Branch:         "release-1.24",
+				RequiredChecks: []string{"integ-tests"},
+				Auth:           auth,
+			},
+		},
+	}
+	lock := model.ManifestLock{
+		Version: "1.24.0",
+		Dependencies: map[string]model.Dependency{
+			"istio": {Git: "git@github.com:example/istio", Sha: "0123456789abcdef0123456789abcdef01234567"},
+		},
+	}
+	if err := ApplyLock(&in, lock); err != nil {
+		t.Fatal(err)
+	}
+	want := model.Dependency{
+		Git:            "git@github.com:example/istio",
+		Sha:            "0123456789abcdef0123456789abcdef01234567",
+		RequiredChecks: []string{"integ-tests"},
+		Auth:           auth,
+	}
+	if !reflect.DeepEqual(*in.Dependencies.Istio, want) {
+		t.Fatalf("expected the locked dependency %+v, got %+v", want, *in.Dependencies.Istio)
+	}
+
+	lock.Dependencies["istio"] = model.Dependency{Git: "git@github.com:other/istio", Sha: "0123456"}
+	if err := ApplyLock(&in, lock); err == nil {
+		t.Fatal("expected a lock of another git source to be out of date")
+	}
+}
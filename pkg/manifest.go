@@ -17,14 +17,24 @@ package pkg
 import (
 	"fmt"
 	"os"
+	"path"
+	"regexp"
+	"slices"
 	"strings"
 
+	"github.com/Masterminds/semver/v3"
 	"istio.io/istio/pkg/log"
 	"sigs.k8s.io/yaml"
 
 	"github.com/alauda-mesh/release-builder/pkg/model"
 )
 
+// wasmNamePattern restricts wasm extension names to valid OCI repository and file names.
+var wasmNamePattern = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*$`)
+
+// flavorNamePattern restricts build flavor names, which suffix file names.
+var flavorNamePattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
 func InputManifestToManifest(in model.InputManifest) (model.Manifest, error) {
 	wd := in.Directory
 	if wd == "" {
@@ -34,24 +44,17 @@ func InputManifestToManifest(in model.InputManifest) (model.Manifest, error) {
 			return model.Manifest{}, fmt.Errorf("failed to create working directory: %v", err)
 		}
 	}
-	outputs := map[model.BuildOutput]struct{}{}
-	for _, o := range in.BuildOutputs {
-		switch strings.ToLower(o) {
-		case "docker":
-			outputs[model.Docker] = struct{}{}
-		case "helm":
-			outputs[model.Helm] = struct{}{}
-		case "debian":
-			outputs[model.Debian] = struct{}{}
-		case "archive":
-			outputs[model.Archive] = struct{}{}
-		case "grafana":
-			outputs[model.Grafana] = struct{}{}
-		case "scanner":
-			outputs[model.Scanner] = struct{}{}
-		default:
-			return model.Manifest{}, fmt.Errorf("unknown build output: %v", o)
+	outputs := model.BuildOutputSet{}
+	for _, name := range in.BuildOutputs.Components {
+		o, err := model.ParseBuildOutput(name)
+		if err != nil {
+			return model.Manifest{}, err
 		}
+		outputs[o] = struct{}{}
+	}
+	if _, f := outputs[model.Archive]; f {
+		// The standalone istioctl archives are released alongside the release archives
+		outputs[model.Istioctl] = struct{}{}
 	}
 	if len(outputs) == 0 {
 		outputs[model.Docker] = struct{}{}
@@ -61,30 +64,359 @@ func InputManifestToManifest(in model.InputManifest) (model.Manifest, error) {
 		outputs[model.Archive] = struct{}{}
 		outputs[model.Grafana] = struct{}{}
 		outputs[model.Scanner] = struct{}{}
+		outputs[model.Istioctl] = struct{}{}
 	}
 	do := in.DockerOutput
 	if do == "" {
 		do = model.DockerOutputTar
 	}
+	if in.Channel != "" && !slices.Contains(model.Channels, in.Channel) {
+		return model.Manifest{}, fmt.Errorf("invalid channel %q, expected one of %v", in.Channel, model.Channels)
+	}
 	arch := in.Architectures
 	if len(arch) == 0 {
 		// Default to just amd64. In the future we may want to include arm64 by default
 		arch = []string{"linux/amd64"}
 	}
+	for _, plat := range arch {
+		goos, a, _ := strings.Cut(plat, "/")
+		if goos != "linux" || !slices.Contains(model.SupportedArchitectures, a) {
+			return model.Manifest{}, fmt.Errorf("unsupported architecture %v, must be linux/ one of %v", plat, model.SupportedArchitectures)
+		}
+	}
+	if err := validateLayout(in.BuildOutputs.Layout, in.Version, arch); err != nil {
+		return model.Manifest{}, err
+	}
+	variants := in.ImageVariants
+	if len(variants) == 0 {
+		variants = []string{"debug", "distroless"}
+	}
+	if in.DefaultVariant != "" && !containsVariant(variants, in.DefaultVariant) {
+		return model.Manifest{}, fmt.Errorf("default variant %v is not one of the built image variants %v", in.DefaultVariant, variants)
+	}
+	if _, f := outputs[model.Docs]; f && in.Dependencies.Api == nil {
+		return model.Manifest{}, fmt.Errorf("the docs output requires the api dependency")
+	}
+	if in.WindowsPackages != nil {
+		if in.WindowsPackages.DownloadURL == "" {
+			return model.Manifest{}, fmt.Errorf("windowsPackages requires downloadURL")
+		}
+		if _, f := outputs[model.Istioctl]; !f {
+			return model.Manifest{}, fmt.Errorf("windowsPackages requires the istioctl output")
+		}
+	}
+	for _, i := range in.BaseImages {
+		if i.Image == "" {
+			return model.Manifest{}, fmt.Errorf("base images require an image")
+		}
+		if i.Key == "" && (i.Identity == "" || i.Issuer == "") {
+			return model.Manifest{}, fmt.Errorf("base image %v requires either a key, or an identity and issuer", i.Image)
+		}
+	}
+	for _, n := range in.Notifications {
+		switch n.Type {
+		case "slack", "teams", "webhook":
+		default:
+			return model.Manifest{}, fmt.Errorf("unknown notification type %q", n.Type)
+		}
+	}
+	for _, d := range in.Downstream {
+		if d.Name == "" || d.Repo.Git == "" {
+			return model.Manifest{}, fmt.Errorf("downstream repos require a name and repo.git")
+		}
+		for _, e := range d.Edits {
+			if _, err := regexp.Compile(e.Match); err != nil {
+				return model.Manifest{}, fmt.Errorf("invalid match for %v in downstream %v: %v", e.File, d.Name, err)
+			}
+		}
+	}
+	if c := in.BuildContainer; c != nil {
+		if c.Image == "" {
+			return model.Manifest{}, fmt.Errorf("buildContainer requires an image")
+		}
+		if c.Runtime != "" && c.Runtime != "docker" && c.Runtime != "podman" {
+			return model.Manifest{}, fmt.Errorf("unknown buildContainer runtime %q", c.Runtime)
+		}
+	}
+	plugins := map[string]struct{}{}
+	for _, p := range in.Plugins {
+		if p.Name == "" || len(p.Command) == 0 {
+			return model.Manifest{}, fmt.Errorf("plugins require a name and command")
+		}
+		if p.Phase != "build" && p.Phase != "publish" {
+			return model.Manifest{}, fmt.Errorf("plugin %v has unknown phase %q", p.Name, p.Phase)
+		}
+		if _, f := plugins[p.Name]; f {
+			return model.Manifest{}, fmt.Errorf("duplicate plugin %v", p.Name)
+		}
+		plugins[p.Name] = struct{}{}
+	}
+	for _, r := range in.Registries {
+		switch r.Type {
+		case "", "basic", "quay-token":
+			if r.PasswordEnv == "" && r.PasswordFile == "" {
+				return model.Manifest{}, fmt.Errorf("registry %v requires passwordEnv or passwordFile", r.Hub)
+			}
+		case "ecr", "gcr", "acr":
+		default:
+			return model.Manifest{}, fmt.Errorf("unknown registry type %q", r.Type)
+		}
+	}
+	if v := in.ValuesChanges; v != nil && (v.PreviousVersion == "" || v.HelmRepo == "") {
+		return model.Manifest{}, fmt.Errorf("valuesChanges requires previousVersion and helmRepo")
+	}
+	if c := in.CRDCompatibility; c != nil && (c.PreviousVersion == "" || c.HelmRepo == "") {
+		return model.Manifest{}, fmt.Errorf("crdCompatibility requires previousVersion and helmRepo")
+	}
+	var olm *model.OLMConfig
+	if in.OLM != nil {
+		if in.OLM.Package == "" || in.OLM.OperatorImage == "" {
+			return model.Manifest{}, fmt.Errorf("olm requires package and operatorImage")
+		}
+		o := *in.OLM
+		if len(o.Channels) == 0 {
+			o.Channels = []string{"stable"}
+		}
+		if len(o.Charts) == 0 {
+			o.Charts = []string{"manifests/charts/istio-control/istio-discovery"}
+		}
+		for _, r := range o.Rules {
+			if slices.Contains(r.APIGroups, "*") && slices.Contains(r.Resources, "*") {
+				return model.Manifest{}, fmt.Errorf("olm rules must not grant all resources, got %+v", r)
+			}
+		}
+		olm = &o
+	}
+	wasm := map[string]struct{}{}
+	for _, w := range in.WasmExtensions {
+		if !wasmNamePattern.MatchString(w.Name) {
+			return model.Manifest{}, fmt.Errorf("invalid wasm extension name %q", w.Name)
+		}
+		if _, f := wasm[w.Name]; f {
+			return model.Manifest{}, fmt.Errorf("duplicate wasm extension %v", w.Name)
+		}
+		wasm[w.Name] = struct{}{}
+		if w.Repo.Git == "" && w.Repo.LocalPath == "" {
+			return model.Manifest{}, fmt.Errorf("wasm extension %v requires a repo", w.Name)
+		}
+		if !strings.HasSuffix(w.Output, ".wasm") {
+			return model.Manifest{}, fmt.Errorf("wasm extension %v output must be a .wasm module", w.Name)
+		}
+	}
+	matrix := []model.BuildFlavor{}
+	for _, f := range in.BuildMatrix {
+		if !flavorNamePattern.MatchString(f.Name) {
+			return model.Manifest{}, fmt.Errorf("invalid build flavor name %q", f.Name)
+		}
+		for _, o := range matrix {
+			if o.Name == f.Name {
+				return model.Manifest{}, fmt.Errorf("duplicate build flavor %v", f.Name)
+			}
+		}
+		if len(f.Binaries) == 0 {
+			f.Binaries = []string{"./pilot/cmd/pilot-discovery", "./pilot/cmd/pilot-agent"}
+		}
+		matrix = append(matrix, f)
+	}
+	var proxy *model.ProxyConfig
+	if in.Proxy != nil {
+		p := *in.Proxy
+		if in.ProxyOverride != "" {
+			return model.Manifest{}, fmt.Errorf("proxy and proxyOverride cannot both be set")
+		}
+		if p.URL == "" && len(p.WasmFilters) > 0 {
+			return model.Manifest{}, fmt.Errorf("proxy wasm filters require a proxy url")
+		}
+		if p.URL == "" && in.Dependencies.Proxy == nil {
+			return model.Manifest{}, fmt.Errorf("building the proxy from source requires the proxy dependency")
+		}
+		if len(p.Build) == 0 {
+			p.Build = []string{"make", "build_envoy"}
+		}
+		if p.Output == "" {
+			p.Output = "bazel-bin/envoy"
+		}
+		proxy = &p
+	}
+	var ztunnel *model.ZtunnelConfig
+	if in.Ztunnel != nil {
+		z := *in.Ztunnel
+		if z.URL == "" && in.Dependencies.Ztunnel == nil {
+			return model.Manifest{}, fmt.Errorf("building ztunnel from source requires the ztunnel dependency")
+		}
+		if z.Profile == "" {
+			z.Profile = "release"
+		}
+		ztunnel = &z
+	}
+	for repo, dep := range in.Dependencies.Get() {
+		if dep == nil || dep.Auth == nil {
+			continue
+		}
+		methods := 0
+		for _, m := range []string{dep.Auth.SSHKeyFile, dep.Auth.TokenEnv, dep.Auth.Netrc} {
+			if m != "" {
+				methods++
+			}
+		}
+		if methods > 1 {
+			return model.Manifest{}, fmt.Errorf("dependency %v may only set one of sshKeyFile, tokenEnv, and netrc", repo)
+		}
+	}
+	// Charts are only built for semver releases, which the suffix must keep valid
+	if _, err := semver.NewVersion(in.Version); err == nil {
+		suffixes := []string{in.ChartVersionSuffix}
+		for _, c := range in.ChartVersions {
+			suffixes = append(suffixes, c.ChartVersionSuffix)
+		}
+		for _, suffix := range suffixes {
+			if _, err := semver.NewVersion(in.Version + suffix); suffix != "" && err != nil {
+				return model.Manifest{}, fmt.Errorf("chart version suffix %q does not form a valid version: %v", suffix, err)
+			}
+		}
+	}
+	for _, f := range in.ExtraFiles {
+		if (f.Source == "") == (f.Content == "") {
+			return model.Manifest{}, fmt.Errorf("extra file %v requires one of source or content", f.Path)
+		}
+		if f.Path == "" || path.IsAbs(f.Path) || path.Clean(f.Path) != f.Path || f.Path == ".." || strings.HasPrefix(f.Path, "../") {
+			return model.Manifest{}, fmt.Errorf("extra file path %q must be a relative path", f.Path)
+		}
+		if !f.Archive && len(f.Charts) == 0 {
+			return model.Manifest{}, fmt.Errorf("extra file %v must be added to the archive or charts", f.Path)
+		}
+	}
+	for _, c := range in.ExternalCharts {
+		if dep, f := in.Dependencies.Get()[c.Repo]; !f || dep == nil || c.Repo == "istio" {
+			return model.Manifest{}, fmt.Errorf("external chart %v must be in a dependency other than istio", c.Path)
+		}
+		if c.Path == "" || path.IsAbs(c.Path) || path.Clean(c.Path) != c.Path || c.Path == ".." || strings.HasPrefix(c.Path, "../") {
+			return model.Manifest{}, fmt.Errorf("external chart path %q must be a relative path", c.Path)
+		}
+	}
+	if in.Renames != nil {
+		for _, renames := range []map[string]string{in.Renames.Binaries, in.Renames.Images} {
+			for from, to := range renames {
+				if from == "" || to == "" || strings.ContainsAny(from+to, "/:@") {
+					return model.Manifest{}, fmt.Errorf("invalid rename %q to %q", from, to)
+				}
+			}
+		}
+	}
+	var rpm *model.RpmConfig
+	if in.Rpm != nil {
+		if in.Rpm.PublicKeyFile != "" && in.Rpm.SigningKey == "" {
+			return model.Manifest{}, fmt.Errorf("rpm publicKeyFile requires signingKey")
+		}
+		r := *in.Rpm
+		r.Distros = nil
+		for _, d := range in.Rpm.Distros {
+			def := model.DefaultRpmDistros[d.Name]
+			if d.Dist == "" {
+				d.Dist = def.Dist
+			}
+			if d.UnitDir == "" {
+				d.UnitDir = def.UnitDir
+			}
+			if d.Depends == nil {
+				d.Depends = def.Depends
+			}
+			if d.Dist == "" || d.UnitDir == "" {
+				return model.Manifest{}, fmt.Errorf("rpm distro %v requires dist and unitDir", d.Name)
+			}
+			r.Distros = append(r.Distros, d)
+		}
+		rpm = &r
+	}
+	var installScript *model.InstallScriptConfig
+	if in.InstallScript != nil {
+		if in.InstallScript.DownloadURL == "" {
+			return model.Manifest{}, fmt.Errorf("installScript requires downloadURL")
+		}
+		if _, f := outputs[model.Archive]; !f {
+			return model.Manifest{}, fmt.Errorf("installScript requires the archive output")
+		}
+		s := *in.InstallScript
+		if s.Name == "" {
+			s.Name = "downloadIstio"
+		}
+		installScript = &s
+	}
 	return model.Manifest{
 		Dependencies:                in.Dependencies,
 		Version:                     in.Version,
 		Docker:                      in.Docker,
 		DockerOutput:                do,
+		Channel:                     in.Channel,
+		ImageLabels:                 in.ImageLabels,
 		Directory:                   wd,
 		BuildOutputs:                outputs,
 		ProxyOverride:               in.ProxyOverride,
+		Proxy:                       proxy,
+		Ztunnel:                     ztunnel,
 		GrafanaDashboards:           in.GrafanaDashboards,
 		SkipGenerateBillOfMaterials: in.SkipGenerateBillOfMaterials,
 		Architectures:               arch,
+		KubernetesVersions:          in.KubernetesVersions,
+		Reproducible:                in.Reproducible,
+		FastIstioctl:                in.FastIstioctl,
+		DebugSymbols:                in.DebugSymbols,
+		BuildMatrix:                 matrix,
+		Licenses:                    in.Licenses,
+		ImageVariants:               variants,
+		DefaultVariant:              in.DefaultVariant,
+		Layout:                      in.BuildOutputs.Layout,
+		BrewRepo:                    in.BrewRepo,
+		KrewRepo:                    in.KrewRepo,
+		Downstream:                  in.Downstream,
+		WindowsPackages:             in.WindowsPackages,
+		Rpm:                         rpm,
+		OLM:                         olm,
+		Kustomize:                   renderConfig(in.Kustomize),
+		RenderedManifests:           renderConfig(in.RenderedManifests),
+		InstallScript:               installScript,
+		WasmExtensions:              in.WasmExtensions,
+		ValuesChanges:               in.ValuesChanges,
+		CRDCompatibility:            in.CRDCompatibility,
+		SizeBudgets:                 in.SizeBudgets,
+		Plugins:                     in.Plugins,
+		BuildContainer:              in.BuildContainer,
+		GoModules:                   in.GoModules,
+		BaseImages:                  in.BaseImages,
+		Charts:                      in.Charts,
+		ExternalCharts:              in.ExternalCharts,
+		ChartVersionSuffix:          in.ChartVersionSuffix,
+		AppVersion:                  in.AppVersion,
+		ChartVersions:               in.ChartVersions,
+		ChartChannels:               in.ChartChannels,
+		ChartAnnotations:            in.ChartAnnotations,
+		PinnedCharts:                in.PinnedCharts,
+		ExtraFiles:                  in.ExtraFiles,
+		Renames:                     in.Renames,
+		Publish:                     in.Publish,
+		Notifications:               in.Notifications,
+		Network:                     in.Network,
+		Git:                         in.Git,
+		Registries:                  in.Registries,
 	}, nil
 }
 
+// containsVariant checks if the variant is built. The `default` and `debug` variants are equivalent.
+func containsVariant(variants []string, variant string) bool {
+	normalize := func(v string) string {
+		if v == "default" {
+			return "debug"
+		}
+		return v
+	}
+	for _, v := range variants {
+		if normalize(v) == normalize(variant) {
+			return true
+		}
+	}
+	return false
+}
+
 func ReadManifest(manifestFile string) (model.Manifest, error) {
 	manifest := model.Manifest{}
 	by, err := os.ReadFile(manifestFile)
@@ -105,21 +437,41 @@ func validateManifestDependencies(dependencies model.IstioDependencies) error {
 			log.Warnf("missing dependency: %v", repo)
 			continue
 		}
-		if dep.Branch != "" || dep.Sha != "" || dep.Auto != "" {
+		if dep.Branch != "" || dep.Sha != "" || dep.Tag != "" || dep.Auto != "" {
 			if dep.Git == "" {
-				return fmt.Errorf("%v has branch/sha/auto selected without git source", repo)
+				return fmt.Errorf("%v has branch/sha/tag/auto selected without git source", repo)
 			}
 		}
+		if dep.Auto == model.Green && (dep.Branch == "" || dep.StatusCheck == "") {
+			return fmt.Errorf("%v has auto green selected without branch and statusCheck", repo)
+		}
+		if len(dep.RequiredChecks) > 0 && dep.Git == "" {
+			return fmt.Errorf("%v has requiredChecks without git source", repo)
+		}
 	}
 	return nil
 }
 
-func ReadInManifest(manifestFile string) (model.InputManifest, error) {
+// ReadInManifest reads an input manifest. Template expressions in the manifest are resolved, the profile,
+// if set, is merged, and then overrides, in the form key=value, are applied.
+func ReadInManifest(manifestFile string, profile string, overrides []string) (model.InputManifest, error) {
 	manifest := model.InputManifest{}
 	by, err := os.ReadFile(manifestFile)
 	if err != nil {
 		return manifest, fmt.Errorf("failed to read manifest file: %v", err)
 	}
+	by, err = renderManifest(by)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to render manifest file: %v", err)
+	}
+	by, err = applyProfile(by, profile)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to apply profile %v: %v", profile, err)
+	}
+	by, err = applyOverrides(by, overrides)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to override manifest: %v", err)
+	}
 	if err := yaml.Unmarshal(by, &manifest); err != nil {
 		return manifest, fmt.Errorf("failed to unmarshal manifest file: %v", err)
 	}
@@ -128,3 +480,64 @@ func ReadInManifest(manifestFile string) (model.InputManifest, error) {
 	}
 	return manifest, nil
 }
+
+// renderConfig returns the render config with its defaults set, or nil if unset.
+func renderConfig(in *model.RenderConfig) *model.RenderConfig {
+	if in == nil {
+		return nil
+	}
+	c := *in
+	if len(c.Profiles) == 0 {
+		c.Profiles = []string{"default", "ambient", "minimal"}
+	}
+	if c.Namespace == "" {
+		c.Namespace = "istio-system"
+	}
+	return &c
+}
+
+// validateLayout checks each layout is of a known kind, and renders, for every architecture, to a relative path within
+// the output directory, which neither is, nor is nested with, the directory of another kind.
+func validateLayout(layout map[string]string, version string, architectures []string) error {
+	for kind := range layout {
+		if !slices.Contains(model.LayoutKinds, kind) {
+			return fmt.Errorf("unknown layout kind %q, expected one of %v", kind, model.LayoutKinds)
+		}
+	}
+	archs := []string{""}
+	for _, plat := range architectures {
+		_, a, _ := strings.Cut(plat, "/")
+		archs = append(archs, a)
+	}
+	dirs := map[string]string{}
+	for _, kind := range model.LayoutKinds {
+		l, f := layout[kind]
+		if !f {
+			dirs[kind] = kind
+			continue
+		}
+		for _, a := range archs {
+			dir, err := model.RenderLayout(l, version, a)
+			if err != nil {
+				return fmt.Errorf("invalid layout for %v: %v", kind, err)
+			}
+			if path.IsAbs(dir) || dir == "." || dir == ".." || strings.HasPrefix(dir, "../") {
+				return fmt.Errorf("layout for %v must be a relative path within the output directory, got %q", kind, dir)
+			}
+			dirs[kind+"/"+a] = dir
+		}
+	}
+	for k1, d1 := range dirs {
+		for k2, d2 := range dirs {
+			kind1, _, _ := strings.Cut(k1, "/")
+			kind2, _, _ := strings.Cut(k2, "/")
+			if kind1 == kind2 {
+				continue
+			}
+			if d1 == d2 || strings.HasPrefix(d1, d2+"/") {
+				return fmt.Errorf("layouts for %v and %v overlap, at %v and %v", kind1, kind2, d1, d2)
+			}
+		}
+	}
+	return nil
+}
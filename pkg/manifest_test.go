@@ -0,0 +1,126 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+func TestReadManifestBuildOutputs(t *testing.T) {
+	in := model.Manifest{
+		Version:      "1.24.0",
+		BuildOutputs: model.BuildOutputSet{model.Docker: {}, model.Helm: {}, model.Debian: {}},
+		CRDCompatibility: &model.CRDCompatibilityConfig{
+			PreviousVersion: "1.23.0",
+			HelmRepo:        "https://istio-release.storage.googleapis.com/charts",
+		},
+	}
+	by, err := yaml.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "manifest.yaml")
+	if err := os.WriteFile(file, by, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadManifest(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.BuildOutputs, in.BuildOutputs) {
+		t.Fatalf("expected outputs %v, got %v", in.BuildOutputs, got.BuildOutputs)
+	}
+	if !reflect.DeepEqual(got.CRDCompatibility, in.CRDCompatibility) {
+		t.Fatalf("expected crdCompatibility %v, got %v", in.CRDCompatibility, got.CRDCompatibility)
+	}
+}
+
+func TestReadManifestUnknownOutput(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "manifest.yaml")
+	if err := os.WriteFile(file, []byte("version: 1.24.0\noutputs: [docker, bogus]\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadManifest(file); err == nil {
+		t.Fatal("expected an unknown output to fail")
+	}
+}
+
+func TestArchiveImpliesIstioctl(t *testing.T) {
+	m, err := InputManifestToManifest(model.InputManifest{
+		Version:      "1.24.0",
+		Directory:    t.TempDir(),
+		BuildOutputs: model.Outputs{Components: []string{"archive"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, f := m.BuildOutputs[model.Istioctl]; !f {
+		t.Fatalf("expected archive to imply istioctl, got %v", m.BuildOutputs)
+	}
+}
+
+func TestValidateLayout(t *testing.T) {
+	archs := []string{"linux/amd64", "linux/arm64"}
+	cases := []struct {
+		name   string
+		layout map[string]string
+		valid  bool
+	}{
+		{"default", nil, true},
+		{"per arch", map[string]string{"deb": "packages/{{.Version}}/{{.Arch}}", "rpm": "packages/{{.Version}}/rpm/{{.Arch}}"}, false},
+		{"separate", map[string]string{"deb": "deb/{{.Version}}/{{.Arch}}", "rpm": "rpm/{{.Version}}/{{.Arch}}"}, true},
+		{"unknown kind", map[string]string{"debs": "packages"}, false},
+		{"absolute", map[string]string{"deb": "/tmp/deb"}, false},
+		{"escape", map[string]string{"deb": "../deb"}, false},
+		{"root", map[string]string{"deb": "{{.Arch}}"}, false},
+		{"same dir", map[string]string{"deb": "packages", "rpm": "packages"}, false},
+		{"default dir", map[string]string{"helm": "docker"}, false},
+		{"nested", map[string]string{"docker": "helm/images"}, false},
+		{"bad template", map[string]string{"deb": "{{.Bogus}}"}, false},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateLayout(tt.layout, "1.24.0", archs)
+			if tt.valid && err != nil {
+				t.Fatalf("expected a valid layout, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatal("expected an invalid layout")
+			}
+		})
+	}
+}
+
+func TestOLMRules(t *testing.T) {
+	olm := &model.OLMConfig{Package: "sailoperator", OperatorImage: "quay.io/sail-dev/sail-operator"}
+	m, err := InputManifestToManifest(model.InputManifest{Version: "1.24.0", Directory: t.TempDir(), OLM: olm})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"manifests/charts/istio-control/istio-discovery"}; !reflect.DeepEqual(m.OLM.Charts, want) {
+		t.Fatalf("expected charts %v, got %v", want, m.OLM.Charts)
+	}
+	olm.Rules = []model.PolicyRule{{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"get"}}}
+	if _, err := InputManifestToManifest(model.InputManifest{Version: "1.24.0", Directory: t.TempDir(), OLM: olm}); err == nil {
+		t.Fatal("expected a rule granting all resources to fail")
+	}
+}
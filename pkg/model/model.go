@@ -15,10 +15,15 @@
 package model
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"path"
+	"regexp"
 	"runtime"
+	"sort"
+	"strings"
+	"text/template"
 )
 
 type (
@@ -34,6 +39,8 @@ const (
 	Archive
 	Grafana
 	Scanner
+	Istioctl
+	Docs
 
 	// Deps will resolve by looking at the istio.deps file in istio/istio
 	Deps string = "deps"
@@ -42,8 +49,67 @@ const (
 	// ProxyWorkspace will resolve by looking at the WORKSPACE file in istio/proxy.
 	// This should only be used to resolve Envoy dep SHA.
 	ProxyWorkspace string = "proxy_workspace"
+	// Green will resolve to the latest commit on the branch passing the dependency's StatusCheck.
+	Green string = "green"
 )
 
+// buildOutputNames are the names of each BuildOutput, as used in the `outputs` of the manifests.
+var buildOutputNames = map[BuildOutput]string{
+	Docker:   "docker",
+	Helm:     "helm",
+	Debian:   "debian",
+	Rpm:      "rpm",
+	Archive:  "archive",
+	Grafana:  "grafana",
+	Scanner:  "scanner",
+	Istioctl: "istioctl",
+	Docs:     "docs",
+}
+
+func (o BuildOutput) String() string {
+	return buildOutputNames[o]
+}
+
+// ParseBuildOutput returns the BuildOutput with the given name.
+func ParseBuildOutput(name string) (BuildOutput, error) {
+	for o, n := range buildOutputNames {
+		if strings.EqualFold(n, name) {
+			return o, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown build output: %v", name)
+}
+
+// BuildOutputSet is the set of components built. It is serialized as a list of output names, so the components a
+// release was built with are known when its manifest is read back.
+type BuildOutputSet map[BuildOutput]struct{}
+
+func (s BuildOutputSet) MarshalJSON() ([]byte, error) {
+	names := make([]string, 0, len(s))
+	for o := range s {
+		names = append(names, o.String())
+	}
+	sort.Strings(names)
+	return json.Marshal(names)
+}
+
+func (s *BuildOutputSet) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	set := BuildOutputSet{}
+	for _, n := range names {
+		o, err := ParseBuildOutput(n)
+		if err != nil {
+			return err
+		}
+		set[o] = struct{}{}
+	}
+	*s = set
+	return nil
+}
+
 // Dependency defines a git dependency for the build
 type Dependency struct {
 	// Git repository to pull from. Required if branch or sha is set
@@ -52,18 +118,56 @@ type Dependency struct {
 	Branch string `json:"branch,omitempty"`
 	// Checkout the git SHA
 	Sha string `json:"sha,omitempty"`
-	// Copy the local path. Note this still needs to be a git repo.
+	// Checkout the git tag
+	Tag string `json:"tag,omitempty"`
+	// Copy the local path, rather than cloning. Note this still needs to be a git repo, but it may
+	// have uncommitted changes.
 	LocalPath string `json:"localpath,omitempty"`
 	// Auto will fetch the SHA to use based on other repos. Currently this supports reading
 	// istio.deps from istio/istio only.
 	Auto string `json:"auto,omitempty"`
+	// StatusCheck is the GitHub status or check run name a commit must pass to be selected with `auto: green`.
+	StatusCheck string `json:"statusCheck,omitempty"`
+	// RequiredChecks are the GitHub statuses or check runs, such as prow jobs, that must pass on the resolved SHA
+	// before building, unless the build is forced.
+	RequiredChecks []string `json:"requiredChecks,omitempty"`
 	// If true, go version semantic will be used for tagging the git repo, e.g. v1.2.3.
 	GoVersionEnabled bool `json:"goversionenabled,omitempty"`
+	// Dirty is set in the output manifest when the sources had uncommitted changes, so the SHA does
+	// not fully describe what was built.
+	Dirty bool `json:"dirty,omitempty"`
+	// Auth configures how the repo is fetched, if it requires credentials.
+	Auth *GitAuth `json:"auth,omitempty"`
+}
+
+// GitAuth configures the credentials a git dependency is fetched with. Only one method may be set.
+type GitAuth struct {
+	// SSHKeyFile is a private key to fetch with over ssh.
+	SSHKeyFile string `json:"sshKeyFile,omitempty"`
+	// TokenEnv is an environment variable holding a token, sent as the password of https requests.
+	TokenEnv string `json:"tokenEnv,omitempty"`
+	// Username is the user the token is sent as. Defaults to oauth2, as accepted by GitLab and GitHub.
+	Username string `json:"username,omitempty"`
+	// Netrc is a netrc file holding the login and password of the git host, for https requests.
+	Netrc string `json:"netrc,omitempty"`
+}
+
+// GitConfig configures how git dependencies are fetched.
+type GitConfig struct {
+	// Mirrors rewrites repo URLs starting with a key to start with its value, to fetch from a mirror. The longest
+	// matching prefix is used. Repos are still tagged and released against their original URL.
+	// Example: {"https://github.com/istio/": "https://gitlab.example.com/mirrors/istio/"}
+	Mirrors map[string]string `json:"mirrors,omitempty"`
+	// Retries is how many times a failed clone or fetch is retried, with exponential backoff. Defaults to 3.
+	Retries int `json:"retries,omitempty"`
 }
 
 // Ref returns the git reference of a dependency.
 func (d Dependency) Ref() string {
 	ref := d.Branch
+	if d.Tag != "" {
+		ref = d.Tag
+	}
 	if d.Sha != "" {
 		ref = d.Sha
 	}
@@ -83,6 +187,9 @@ type IstioDependencies struct {
 	Enhancements   *Dependency `json:"enhancements"`
 	ReleaseBuilder *Dependency `json:"release-builder"`
 	CommonFiles    *Dependency `json:"common-files"`
+	// Charts and SailOperator are only sources of external charts
+	Charts       *Dependency `json:"charts"`
+	SailOperator *Dependency `json:"sail-operator"`
 }
 
 func (i *IstioDependencies) Get() map[string]*Dependency {
@@ -98,17 +205,19 @@ func (i *IstioDependencies) Get() map[string]*Dependency {
 		"release-builder": i.ReleaseBuilder,
 		"common-files":    i.CommonFiles,
 		"enhancements":    i.Enhancements,
+		"charts":          i.Charts,
+		"sail-operator":   i.SailOperator,
 	}
 }
 
-// MarshalJSON writes the dependencies, exposing just the SHA
+// MarshalJSON writes the dependencies, exposing just the SHA, and the git source and auth the repo is tagged with
 func (i IstioDependencies) MarshalJSON() ([]byte, error) {
 	deps := make(map[string]Dependency)
 	for repo, dep := range i.Get() {
 		if dep == nil {
 			continue
 		}
-		deps[repo] = Dependency{Sha: dep.Sha, GoVersionEnabled: dep.GoVersionEnabled}
+		deps[repo] = Dependency{Git: dep.Git, Sha: dep.Sha, GoVersionEnabled: dep.GoVersionEnabled, Dirty: dep.Dirty, Auth: dep.Auth}
 	}
 	return json.Marshal(deps)
 }
@@ -118,6 +227,15 @@ func (i *IstioDependencies) Set(repo string, dependency Dependency) {
 	*dp = dependency
 }
 
+// ManifestLock pins the dependencies of a manifest to exact SHAs, so the sources to be built can be reviewed before
+// building them.
+type ManifestLock struct {
+	// Version is the version of the locked manifest.
+	Version string `json:"version"`
+	// Dependencies maps each dependency to its git source and locked SHA.
+	Dependencies map[string]Dependency `json:"dependencies"`
+}
+
 type DockerOutput string
 
 const (
@@ -125,8 +243,647 @@ const (
 	DockerOutputTar DockerOutput = "tar"
 	// DockerOutputContext loads docker images into the local docker context
 	DockerOutputContext DockerOutput = "context"
+	// DockerOutputOCI outputs docker images to tar files on disk, and additionally exports each as an OCI image
+	// layout directory under docker/oci, for offline distribution
+	DockerOutputOCI DockerOutput = "oci"
 )
 
+// Channel is the schedule a build is released on.
+type Channel string
+
+const (
+	ChannelDaily  Channel = "daily"
+	ChannelWeekly Channel = "weekly"
+	ChannelStable Channel = "stable"
+)
+
+// Channels are the supported release channels.
+var Channels = []Channel{ChannelDaily, ChannelWeekly, ChannelStable}
+
+// Outputs defines what components to build, and where their artifacts are written. For compatibility, this may
+// also be written as just the list of components.
+type Outputs struct {
+	// Components to build. This allows building only some components.
+	Components []string `json:"components,omitempty"`
+	// Layout maps an artifact directory, one of LayoutKinds, to a path template relative to the output directory.
+	// Templates may use {{.Version}} and {{.Arch}}.
+	// Example: {"deb": "packages/{{.Version}}/{{.Arch}}"}
+	Layout map[string]string `json:"layout,omitempty"`
+}
+
+func (o *Outputs) UnmarshalJSON(b []byte) error {
+	var components []string
+	if err := json.Unmarshal(b, &components); err == nil {
+		o.Components = components
+		return nil
+	}
+	type outputs Outputs
+	return json.Unmarshal(b, (*outputs)(o))
+}
+
+// LicenseConfig configures third party license aggregation.
+type LicenseConfig struct {
+	// Disallowed lists license types, such as GPL-3.0, that fail the build if used by any dependency.
+	Disallowed []string `json:"disallowed,omitempty"`
+}
+
+// WindowsPackageConfig configures the Scoop and Chocolatey packages for istioctl.
+type WindowsPackageConfig struct {
+	// DownloadURL is the base URL release archives are downloaded from, with the version appended.
+	// Example: https://github.com/istio/istio/releases/download
+	DownloadURL string `json:"downloadURL"`
+	// ScoopRepo is the Scoop bucket to open manifest updates against on publish.
+	ScoopRepo *Dependency `json:"scoopRepo,omitempty"`
+	// ChocolateyRepo is the repo holding the Chocolatey package sources to open updates against on publish.
+	ChocolateyRepo *Dependency `json:"chocolateyRepo,omitempty"`
+}
+
+// ProxyConfig configures the istio/proxy (Envoy) build embedded in the release. The proxy is the one pinned by the
+// istio repo, which must match the proxy dependency, if set.
+type ProxyConfig struct {
+	// URL is the base URL the pinned proxy build is fetched from, such as a bucket, as
+	// envoy-alpha-<sha>[-<arch>].tar.gz. If unset, envoy is built from the proxy dependency.
+	// Example: https://storage.googleapis.com/istio-build/proxy
+	URL string `json:"url,omitempty"`
+	// Build is the command building envoy in the proxy repo. Defaults to `make build_envoy`.
+	Build []string `json:"build,omitempty"`
+	// Output is the envoy binary built, relative to the proxy repo. Defaults to bazel-bin/envoy.
+	Output string `json:"output,omitempty"`
+	// WasmFilters are the wasm filters of the proxy build, fetched from URL as <name>-<sha>.wasm, and shipped in the
+	// archive extensions.
+	// Example: []string{"stats", "metadata_exchange"}
+	WasmFilters []string `json:"wasmFilters,omitempty"`
+}
+
+// ZtunnelConfig configures the ztunnel build embedded in the release. The ztunnel is the one pinned by the istio repo,
+// which must match the ztunnel dependency, if set.
+type ZtunnelConfig struct {
+	// URL is the base URL the pinned ztunnel binaries are fetched from, such as a bucket, as ztunnel-<sha>-<arch>.
+	// If unset, ztunnel is built from the ztunnel dependency with cargo.
+	// Example: https://storage.googleapis.com/istio-build/ztunnel
+	URL string `json:"url,omitempty"`
+	// Profile is the cargo profile ztunnel is built from source with. Defaults to release.
+	Profile string `json:"profile,omitempty"`
+}
+
+// BuildFlavor is an additional build of the control plane binaries, with different compiler flags.
+type BuildFlavor struct {
+	// Name suffixes the binaries and archives of the flavor.
+	// Example: debug
+	Name string `json:"name"`
+	// GcFlags are passed to go build as -gcflags.
+	// Example: all=-N -l, disabling optimizations and inlining for debuggers.
+	GcFlags string `json:"gcflags,omitempty"`
+	// Race builds with the race detector. This requires cgo, so race builds are only made for the host architecture.
+	Race bool `json:"race,omitempty"`
+	// Binaries are the go packages built, relative to the istio repo. Defaults to pilot-discovery and pilot-agent.
+	// Example: ./pilot/cmd/pilot-discovery
+	Binaries []string `json:"binaries,omitempty"`
+}
+
+// WasmExtension is a Wasm extension built from source and shipped with the release.
+type WasmExtension struct {
+	// Name identifies the extension. The module is released as <name>-<version>.wasm, and pushed as the
+	// OCI artifact <hub>/<name>.
+	Name string `json:"name"`
+	// Repo is the repo the extension is built from. It is tagged with the release version, like other sources.
+	Repo Dependency `json:"repo"`
+	// Path is the directory of the extension in the repo. Defaults to the repo root.
+	Path string `json:"path,omitempty"`
+	// Build is the command building the extension, run in Path with VERSION set. Defaults to `make build`.
+	Build []string `json:"build,omitempty"`
+	// Output is the built module, relative to Path.
+	// Example: plugin.wasm
+	Output string `json:"output"`
+}
+
+// RepoName returns the name of the extension's repo in the working directory.
+func (w WasmExtension) RepoName() string {
+	return "wasm-" + w.Name
+}
+
+// ChartVersionConfig overrides how a chart is versioned.
+type ChartVersionConfig struct {
+	// ChartVersionSuffix is appended to the version to form the chart version.
+	ChartVersionSuffix string `json:"chartVersionSuffix,omitempty"`
+	// AppVersion is the chart appVersion.
+	AppVersion string `json:"appVersion,omitempty"`
+}
+
+// ExtraFile is a file, templated with the manifest, added to the release archives and charts.
+type ExtraFile struct {
+	// Source is the template file, relative to the istio repo. Either Source or Content must be set.
+	Source string `json:"source,omitempty"`
+	// Content is the template, inline. Example: Istio {{.Version}}
+	Content string `json:"content,omitempty"`
+	// Path is the path of the file, relative to the root of the archive or chart. Example: EULA.txt
+	Path string `json:"path"`
+	// Archive adds the file to the release archives.
+	Archive bool `json:"archive,omitempty"`
+	// Charts adds the file to the charts, by name. "*" adds it to every chart.
+	Charts []string `json:"charts,omitempty"`
+}
+
+// ExternalChart is a helm chart in a dependency repo other than istio.
+type ExternalChart struct {
+	// Repo is the dependency the chart is in. Example: sail-operator
+	Repo string `json:"repo"`
+	// Path is the chart directory, relative to the repo. Example: chart
+	Path string `json:"path"`
+}
+
+// RenameConfig renames artifacts, and rewrites the references to them, for downstream distributions.
+type RenameConfig struct {
+	// Binaries renames binaries in the archives, and the standalone archives named after them.
+	// Example: {istioctl: alaudactl}
+	Binaries map[string]string `json:"binaries,omitempty"`
+	// Images renames images, and their references in the charts. Example: {pilot: asm-pilot}
+	Images map[string]string `json:"images,omitempty"`
+}
+
+// GoModulesConfig configures how the Go modules of the build are fetched.
+type GoModulesConfig struct {
+	// Proxy is the GOPROXY to fetch modules from, or off to use only the module cache.
+	// Example: https://goproxy.example.com
+	Proxy string `json:"proxy,omitempty"`
+	// SumDB is the GOSUMDB to verify modules with, or off.
+	SumDB string `json:"sumdb,omitempty"`
+	// CacheArchive is a tar.gz of a module cache, extracted to the module cache before building.
+	CacheArchive string `json:"cacheArchive,omitempty"`
+	// Vendor vendors the modules of each repo before building, and builds with -mod=vendor.
+	Vendor bool `json:"vendor,omitempty"`
+}
+
+// RpmConfig configures the rpm packages.
+type RpmConfig struct {
+	// Epoch is the epoch of the packages. Zero leaves the epoch unset.
+	Epoch int `json:"epoch,omitempty"`
+	// Release is the release of the packages. Defaults to 1. Pre-release versions are released as 0.<pre-release>,
+	// so they sort before the final release.
+	Release string `json:"release,omitempty"`
+	// SigningKey is the name of the gpg key, in the keyring of the builder, to sign the packages with rpmsign.
+	SigningKey string `json:"signingKey,omitempty"`
+	// PublicKeyFile is the armored public key of SigningKey, the signatures are verified with after signing.
+	PublicKeyFile string `json:"publicKeyFile,omitempty"`
+	// Distros are built as distro specific variants of the package, in addition to the generic package.
+	Distros []RpmDistro `json:"distros,omitempty"`
+}
+
+// RpmDistro is a distro specific variant of the rpm package.
+type RpmDistro struct {
+	// Name is the distro. el8, el9, and amazon2023 default the other fields.
+	Name string `json:"name"`
+	// Dist is the dist tag of the package release and file name. Example: el9
+	Dist string `json:"dist,omitempty"`
+	// UnitDir is the directory the systemd units are installed to. Example: /usr/lib/systemd/system
+	UnitDir string `json:"unitDir,omitempty"`
+	// Depends are packages the variant depends on, in addition to the dependencies of the generic package.
+	Depends []string `json:"depends,omitempty"`
+}
+
+// DefaultRpmDistros are the defaults of the distros rpm variants are commonly built for.
+var DefaultRpmDistros = map[string]RpmDistro{
+	"el8":        {Name: "el8", Dist: "el8", UnitDir: "/usr/lib/systemd/system", Depends: []string{"iptables", "iproute"}},
+	"el9":        {Name: "el9", Dist: "el9", UnitDir: "/usr/lib/systemd/system", Depends: []string{"iptables-nft", "iproute"}},
+	"amazon2023": {Name: "amazon2023", Dist: "amzn2023", UnitDir: "/usr/lib/systemd/system", Depends: []string{"iptables-nft", "iproute"}},
+}
+
+// PackageName returns the file name of the variant for the version and architecture, such as
+// istio-sidecar-1.24.0.el9.x86_64.rpm.
+func (d RpmDistro) PackageName(version string, arch string) string {
+	return fmt.Sprintf("istio-sidecar-%s.%s.%s.rpm", version, d.Dist, RpmArch(arch))
+}
+
+// RpmArch returns the rpm name of the architecture, such as x86_64 for amd64.
+func RpmArch(arch string) string {
+	switch arch {
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	}
+	return arch
+}
+
+// SizeBudgets are the maximum sizes of the artifacts, in MiB. Unset budgets are not checked.
+type SizeBudgets struct {
+	// Istioctl is the maximum size of the istioctl binary.
+	Istioctl int64 `json:"istioctl,omitempty"`
+	// Archive is the maximum size of each release archive.
+	Archive int64 `json:"archive,omitempty"`
+	// Images is the maximum size of each image archive, by image name. "*" applies to images not listed.
+	Images map[string]int64 `json:"images,omitempty"`
+	// ImageLayers is the maximum number of layers of each image.
+	ImageLayers int `json:"imageLayers,omitempty"`
+	// Warn logs exceeded budgets as warnings, rather than failing validation.
+	Warn bool `json:"warn,omitempty"`
+}
+
+// InstallScriptConfig configures the generated install script, which downloads and verifies the release archive.
+type InstallScriptConfig struct {
+	// DownloadURL is the base URL release archives are downloaded from, with the version appended.
+	// Example: https://github.com/istio/istio/releases/download
+	DownloadURL string `json:"downloadURL"`
+	// Name is the file name of the script. Defaults to downloadIstio.
+	Name string `json:"name,omitempty"`
+}
+
+// BuildContainer is an image providing the build toolchain, for reproducible builds across machines.
+type BuildContainer struct {
+	// Image is the build image, ideally pinned by digest. It must be able to run the release-builder binary.
+	Image string `json:"image"`
+	// Runtime is the container runtime, either docker (the default) or podman.
+	Runtime string `json:"runtime,omitempty"`
+	// Toolchain maps the tools go, helm, and fpm to the version the image must provide. Example: go: 1.24.0
+	Toolchain map[string]string `json:"toolchain,omitempty"`
+}
+
+// Plugin is an external command run as a build or publish step, such as an internal compliance scan.
+// The manifest is passed as json on stdin.
+type Plugin struct {
+	// Name identifies the plugin. The step running it is named plugin-<name>.
+	Name string `json:"name"`
+	// Phase is either build or publish.
+	Phase string `json:"phase"`
+	// Command is the command and arguments to run.
+	Command []string `json:"command"`
+	// DependsOn lists the build steps that must complete before the plugin runs. Defaults to all standard
+	// build steps producing artifacts.
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// Env sets additional environment variables for the command.
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// ValuesChangesConfig configures the report of chart values changes.
+type ValuesChangesConfig struct {
+	// PreviousVersion is the release to compare against.
+	PreviousVersion string `json:"previousVersion"`
+	// HelmRepo is the helm repo the previous release's charts are published to.
+	// Example: https://istio-release.storage.googleapis.com/charts
+	HelmRepo string `json:"helmRepo"`
+}
+
+// CRDCompatibilityConfig configures the check that the CRDs are upgrade compatible with a previous release.
+type CRDCompatibilityConfig struct {
+	// PreviousVersion is the release to compare against.
+	PreviousVersion string `json:"previousVersion"`
+	// HelmRepo is the helm repo the previous release's charts are published to.
+	HelmRepo string `json:"helmRepo"`
+}
+
+// OLMConfig configures the OLM bundle for the operator.
+type OLMConfig struct {
+	// Package is the OLM package name.
+	// Example: sailoperator
+	Package string `json:"package"`
+	// OperatorImage is the operator image, without tag. The release version is used as the tag.
+	// Example: quay.io/sail-dev/sail-operator
+	OperatorImage string `json:"operatorImage"`
+	// Channels are the catalog channels the bundle is published to. The first channel is the default.
+	// Defaults to stable.
+	Channels []string `json:"channels,omitempty"`
+	// Charts are the charts, in the istio repo, the operator installs. The rules of each ClusterRole they render are
+	// granted to the operator, as Kubernetes only lets it create roles with permissions it holds.
+	// Defaults to the istiod chart.
+	// Example: [manifests/charts/istio-control/istio-discovery, manifests/charts/istio-cni]
+	Charts []string `json:"charts,omitempty"`
+	// Rules are further cluster permissions granted to the operator, such as to manage the resources it installs.
+	Rules []PolicyRule `json:"rules,omitempty"`
+}
+
+// PolicyRule is a cluster permission granted to the OLM operator, as in a Kubernetes ClusterRole.
+type PolicyRule struct {
+	APIGroups       []string `json:"apiGroups,omitempty"`
+	Resources       []string `json:"resources,omitempty"`
+	ResourceNames   []string `json:"resourceNames,omitempty"`
+	NonResourceURLs []string `json:"nonResourceURLs,omitempty"`
+	Verbs           []string `json:"verbs"`
+}
+
+// RenderConfig configures rendering the charts for each profile.
+type RenderConfig struct {
+	// Profiles are the istio profiles rendered. Defaults to default, ambient, and minimal.
+	Profiles []string `json:"profiles,omitempty"`
+	// Namespace is the namespace the charts are rendered for. Defaults to istio-system.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// BaseImage is an upstream image verified with cosign before building.
+type BaseImage struct {
+	// Image is the image reference.
+	// Example: gcr.io/distroless/static-debian12
+	Image string `json:"image"`
+	// Key is the cosign public key to verify against. If unset, keyless verification against Identity and Issuer is done.
+	Key string `json:"key,omitempty"`
+	// Identity is the expected certificate identity for keyless verification.
+	Identity string `json:"identity,omitempty"`
+	// Issuer is the expected OIDC issuer for keyless verification.
+	Issuer string `json:"issuer,omitempty"`
+	// BuildArg is the variable the image, pinned to its verified digest, is passed to the docker builds as, so images
+	// are built on the verified base rather than whatever the tag points to when pulled.
+	// Example: BASE_IMAGE
+	BuildArg string `json:"buildArg,omitempty"`
+	// Digest is the verified digest of the image. This is recorded during the build.
+	Digest string `json:"digest,omitempty"`
+}
+
+// Reference returns the image pinned to its verified digest, or the image as configured if it is not verified.
+func (b BaseImage) Reference() string {
+	if b.Digest == "" {
+		return b.Image
+	}
+	return b.Image + "@" + b.Digest
+}
+
+// PublishConfig defines where a release is published to, and how it is signed.
+// Each field is the default for the matching publish flag.
+type PublishConfig struct {
+	DockerHub  string   `json:"dockerhub,omitempty"`
+	DockerTags []string `json:"dockertags,omitempty"`
+	S3Bucket   string   `json:"s3bucket,omitempty"`
+	HelmBucket string   `json:"helmbucket,omitempty"`
+	HelmHub    string   `json:"helmhub,omitempty"`
+	Github     string   `json:"github,omitempty"`
+	CosignKey  string   `json:"cosignkey,omitempty"`
+	// Directory, if set, receives everything the other targets would be sent, rather than publishing to them.
+	Directory string `json:"directory,omitempty"`
+	// GrafanaTokenEnv is an environment variable holding the grafana.com API key, used if --grafanatoken is not set.
+	GrafanaTokenEnv string `json:"grafanaTokenEnv,omitempty"`
+	// S3 configures how objects are written to the s3 bucket.
+	S3 *S3Options `json:"s3,omitempty"`
+	// CDN configures cache invalidation of the overwritten paths after publishing.
+	CDN *CDNConfig `json:"cdn,omitempty"`
+	// S3Client configures the connection to S3 compatible storage, such as R2 or MinIO.
+	S3Client *S3ClientConfig `json:"s3client,omitempty"`
+	// S3Mirrors are additional buckets the s3 archive is uploaded to, such as mirrors in other regions.
+	S3Mirrors []S3Destination `json:"s3mirrors,omitempty"`
+	// Retention configures which versions `prune` removes from the s3 bucket.
+	Retention *RetentionConfig `json:"retention,omitempty"`
+	// RequireApproval requires a signed approval from a second release manager before publish, promote-version,
+	// rebuild-images, unpublish, or prune change anything.
+	RequireApproval bool `json:"requireApproval,omitempty"`
+	// Artifactory publishes the release archives and charts to Artifactory repositories.
+	Artifactory *ArtifactoryConfig `json:"artifactory,omitempty"`
+	// Nexus publishes the release archives and packages to Sonatype Nexus repositories.
+	Nexus *NexusConfig `json:"nexus,omitempty"`
+	// HelmChannels are the helm repositories charts in a channel other than stable are published to, keyed by
+	// channel. Stable charts are published to HelmBucket and HelmHub.
+	HelmChannels map[string]HelmChannel `json:"helmChannels,omitempty"`
+	// ArtifactHub publishes the ArtifactHub repository metadata alongside the charts, and annotates the charts for it.
+	ArtifactHub *ArtifactHubConfig `json:"artifactHub,omitempty"`
+}
+
+// ArtifactHubConfig is the ArtifactHub metadata of the chart repositories.
+type ArtifactHubConfig struct {
+	// RepositoryID is the ID ArtifactHub assigned the stable repository, which verifies it is published by its owners.
+	RepositoryID string `json:"repositoryID,omitempty"`
+	// Owners may claim the repository on ArtifactHub.
+	Owners []ArtifactHubOwner `json:"owners,omitempty"`
+	// License is the SPDX license of the charts. Example: Apache-2.0
+	License string `json:"license,omitempty"`
+}
+
+// ArtifactHubOwner is an owner of a chart repository.
+type ArtifactHubOwner struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email"`
+}
+
+// HelmChannel is where the charts of a channel are published.
+type HelmChannel struct {
+	// Bucket is the S3 bucket the charts, and their index.yaml, are published to.
+	Bucket string `json:"bucket,omitempty"`
+	// Hub is the oci registry the charts are published to.
+	Hub string `json:"hub,omitempty"`
+	// ArtifactHubRepositoryID is the ID ArtifactHub assigned the repository of the channel.
+	ArtifactHubRepositoryID string `json:"artifactHubRepositoryID,omitempty"`
+}
+
+// NexusConfig configures publishing to Sonatype Nexus, through its components REST API.
+type NexusConfig struct {
+	// URL is the Nexus base URL. Example: https://nexus.example.com
+	URL string `json:"url"`
+	// RawRepo is the raw repository the release files are uploaded to, under the version.
+	RawRepo string `json:"rawRepo,omitempty"`
+	// YumRepo is the yum repository the rpm packages are uploaded to, under the version.
+	YumRepo string `json:"yumRepo,omitempty"`
+	// AptRepo is the apt repository the deb packages are uploaded to.
+	AptRepo string `json:"aptRepo,omitempty"`
+	// Username is the user to authenticate as.
+	Username string `json:"username,omitempty"`
+	// UsernameEnv is an environment variable holding the username, used if Username is not set.
+	UsernameEnv string `json:"usernameEnv,omitempty"`
+	// PasswordEnv is an environment variable holding the password, or user token.
+	PasswordEnv string `json:"passwordEnv"`
+}
+
+// ArtifactoryConfig configures publishing to Artifactory, through its REST API.
+type ArtifactoryConfig struct {
+	// URL is the Artifactory base URL. Example: https://artifactory.example.com/artifactory
+	URL string `json:"url"`
+	// GenericRepo is the generic repository the release files are deployed to, under the version.
+	GenericRepo string `json:"genericRepo,omitempty"`
+	// HelmRepo is the helm repository the charts are deployed to.
+	HelmRepo string `json:"helmRepo,omitempty"`
+	// Username is the user to authenticate as. If neither it nor UsernameEnv is set, the password is sent as an
+	// access token.
+	Username string `json:"username,omitempty"`
+	// UsernameEnv is an environment variable holding the username.
+	UsernameEnv string `json:"usernameEnv,omitempty"`
+	// PasswordEnv is an environment variable holding the password, API key, or access token.
+	PasswordEnv string `json:"passwordEnv"`
+	// Properties are set on every deployed artifact. Values are templates executed with the manifest.
+	// Example: {"version": "{{.Version}}", "channel": "stable"}
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// RetentionConfig configures which published versions are kept by `prune`. Releases are always kept.
+type RetentionConfig struct {
+	// KeepDailies is the number of most recently published dev builds, such as 1.25-alpha.<sha>, to keep. All are kept
+	// if unset.
+	KeepDailies *int `json:"keepDailies,omitempty"`
+	// KeepPrereleases is the number of newest pre-releases, such as 1.25.0-rc.1, to keep. All are kept if unset.
+	KeepPrereleases *int `json:"keepPrereleases,omitempty"`
+}
+
+// NetworkConfig configures how outbound traffic leaves a restricted network. It applies to the builder itself, and
+// to the git, helm, docker, and other commands it runs.
+type NetworkConfig struct {
+	// HTTPProxy is the proxy for http requests. Defaults to $HTTP_PROXY.
+	HTTPProxy string `json:"httpProxy,omitempty"`
+	// HTTPSProxy is the proxy for https requests. Defaults to $HTTPS_PROXY.
+	HTTPSProxy string `json:"httpsProxy,omitempty"`
+	// NoProxy lists the hosts to connect to directly, as in $NO_PROXY.
+	NoProxy string `json:"noProxy,omitempty"`
+	// CABundle is a PEM file of additional CA certificates to trust, such as for a TLS intercepting proxy.
+	CABundle string `json:"caBundle,omitempty"`
+}
+
+// S3ClientConfig configures the connection to S3 compatible storage.
+type S3ClientConfig struct {
+	// Endpoint is the storage URL. Defaults to $S3_ENDPOINT, or AWS.
+	Endpoint string `json:"endpoint,omitempty"`
+	// Region is the bucket region, for storage not supporting region lookups.
+	Region string `json:"region,omitempty"`
+	// PathStyle uses path-style bucket addressing, rather than virtual-host addressing.
+	PathStyle bool `json:"pathStyle,omitempty"`
+	// CredentialsFile is an AWS shared credentials file to read static credentials from, rather than the environment.
+	CredentialsFile string `json:"credentialsFile,omitempty"`
+	// Profile is the profile to read from the credentials file. Defaults to $AWS_PROFILE, or default.
+	Profile string `json:"profile,omitempty"`
+	// InsecureSkipVerify skips TLS verification, for internal deployments with self-signed certificates.
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// S3Destination is a bucket, and the storage it is in, the s3 archive is uploaded to.
+type S3Destination struct {
+	// Bucket is the bucket, and optional prefix, to upload to. Example: istio-release-eu/releases
+	Bucket string `json:"bucket"`
+	// Client configures the connection to the bucket. Nothing is inherited from the s3client of the primary bucket,
+	// nor from the environment, so the bucket is accessed anonymously without a credentials file.
+	Client S3ClientConfig `json:"client,omitempty"`
+}
+
+// CDNConfig configures the CDN serving the s3 and helm buckets. Paths are invalidated relative to the bucket.
+type CDNConfig struct {
+	// CloudFrontDistribution is the ID of a CloudFront distribution to invalidate.
+	CloudFrontDistribution string `json:"cloudfrontDistribution,omitempty"`
+	// PurgeURL is the base URL each changed path is sent a PURGE request to.
+	PurgeURL string `json:"purgeURL,omitempty"`
+	// PurgeURLEnv is an environment variable holding the purge URL, for URLs carrying a secret.
+	PurgeURLEnv string `json:"purgeURLEnv,omitempty"`
+}
+
+// S3Options configures the objects written by the s3 publish, as required by bucket policies.
+type S3Options struct {
+	// SSE is the server-side encryption to request, either s3 (SSE-S3) or kms (SSE-KMS).
+	SSE string `json:"sse,omitempty"`
+	// KMSKeyID is the KMS key used for kms encryption.
+	KMSKeyID string `json:"kmsKeyID,omitempty"`
+	// ACL is a canned object ACL, such as public-read.
+	ACL string `json:"acl,omitempty"`
+	// StorageClass is the storage class of the objects, such as STANDARD_IA.
+	StorageClass string `json:"storageClass,omitempty"`
+	// CacheControl maps a file name suffix, such as .tar.gz or index.yaml, to the Cache-Control header for
+	// matching objects. The longest matching suffix is used.
+	CacheControl map[string]string `json:"cacheControl,omitempty"`
+	// Tags are set as object tags on every uploaded object, so lifecycle policies and cost attribution can select
+	// releases. Values are templates executed with the manifest. Example: {"release": "{{.Version}}", "team": "mesh"}
+	Tags map[string]string `json:"tags,omitempty"`
+	// ObjectLock retains the versioned objects of releases, but not pre-releases, with S3 Object Lock, so they cannot
+	// be overwritten or deleted. Aliases and the latest mirror are not locked. The bucket must have Object Lock enabled.
+	ObjectLock *S3ObjectLock `json:"objectLock,omitempty"`
+	// Docker uploads the docker image archives along with the rest of the release. They are skipped by default, as
+	// the images are published to a registry, but a release staged in a bucket needs them to publish its images.
+	Docker bool `json:"docker,omitempty"`
+}
+
+// S3ObjectLock configures the retention of published objects.
+type S3ObjectLock struct {
+	// Mode is the retention mode, either GOVERNANCE or COMPLIANCE.
+	Mode string `json:"mode"`
+	// Days is how long objects are retained for.
+	Days int `json:"days"`
+}
+
+// Notification is a chat or webhook endpoint notified of build and publish results.
+type Notification struct {
+	// Type is one of slack, teams, or webhook. Webhooks receive the full result as json.
+	Type string `json:"type"`
+	// URL is the endpoint to post to.
+	URL string `json:"url,omitempty"`
+	// URLEnv is an environment variable holding the URL, so secret URLs are not recorded in the release.
+	URLEnv string `json:"urlEnv,omitempty"`
+}
+
+// DownstreamRepo is a repo, such as docs or a GitOps repo, referencing the release version.
+type DownstreamRepo struct {
+	// Name identifies the repo in logs and the PR branch name.
+	Name string     `json:"name"`
+	Repo Dependency `json:"repo"`
+	// Edits are applied to the repo to update its references.
+	Edits []FileEdit `json:"edits"`
+	// TokenEnv is an environment variable holding the GitHub token for the repo. Defaults to the publish token.
+	TokenEnv string `json:"tokenEnv,omitempty"`
+	// GithubApp authenticates as a GitHub App installation, rather than with a token.
+	GithubApp *GithubApp `json:"githubApp,omitempty"`
+}
+
+// FileEdit replaces matches of a regex in a file.
+type FileEdit struct {
+	// File is the path of the file in the repo.
+	File string `json:"file"`
+	// Match is the regex to replace. Capture groups can be referenced in the replacement as ${1}.
+	Match string `json:"match"`
+	// Replace is a template of the replacement, executed with the manifest. Example: istio_version: {{.Version}}
+	Replace string `json:"replace"`
+}
+
+// GithubApp identifies a GitHub App installation.
+type GithubApp struct {
+	AppID          int64 `json:"appID"`
+	InstallationID int64 `json:"installationID"`
+	// PrivateKeyFile is the PEM encoded private key of the app.
+	PrivateKeyFile string `json:"privateKeyFile"`
+}
+
+// RegistryAuth configures the credentials for a hub. Secrets are read from the environment or files, so
+// they are not recorded in the release.
+type RegistryAuth struct {
+	// Hub is the registry, optionally with a path prefix, the credentials are used for.
+	// Example: harbor.example.com/istio
+	Hub string `json:"hub"`
+	// Type is one of basic (the default, for Harbor and Quay robot accounts), quay-token, ecr, gcr, or acr.
+	// ecr, gcr, and acr exchange the ambient cloud credentials for a registry token.
+	Type string `json:"type,omitempty"`
+	// Username is the username for basic credentials.
+	Username string `json:"username,omitempty"`
+	// UsernameEnv is an environment variable holding the username for basic credentials.
+	UsernameEnv string `json:"usernameEnv,omitempty"`
+	// PasswordEnv is an environment variable holding the password, robot secret, or token.
+	PasswordEnv string `json:"passwordEnv,omitempty"`
+	// PasswordFile is a file holding the password, robot secret, or token.
+	PasswordFile string `json:"passwordFile,omitempty"`
+}
+
+// SupportedArchitectures are the linux architectures images, packages, and archives can be built for.
+var SupportedArchitectures = []string{"amd64", "arm64", "s390x", "ppc64le"}
+
+// ImageVariantBuilt returns whether the image is built, and published, in the variant. install-cni is only built as
+// the default variant, saved as debug.
+func ImageVariantBuilt(image string, variant string) bool {
+	return image != "install-cni" || variant == "default" || variant == "debug"
+}
+
+// ImageNameVariant determines the name of the image (eg, pilot), variant (eg, distroless), and architecture of a
+// docker archive from its file name, given the image variants built. The default variant is saved as debug, and
+// debug and distroless are always recognized. amd64 archives have no architecture suffix, so have an empty arch.
+func ImageNameVariant(fname string, variants []string) (name string, variant string, arch string) {
+	imageName := strings.Split(fname, ".")[0]
+	for _, a := range SupportedArchitectures {
+		if a != "amd64" && strings.HasSuffix(imageName, "-"+a) {
+			arch = a
+			imageName = strings.TrimSuffix(imageName, "-"+a)
+			break
+		}
+	}
+	known := append([]string{"debug", "distroless"}, variants...)
+	// Longer variants are matched first, so a variant is not mistaken for one it ends with
+	sort.SliceStable(known, func(i, j int) bool { return len(known[i]) > len(known[j]) })
+	for _, v := range known {
+		if v == "default" {
+			v = "debug"
+		}
+		if v != "" && strings.HasSuffix(imageName, "-"+v) {
+			variant = v
+			imageName = strings.TrimSuffix(imageName, "-"+v)
+			break
+		}
+	}
+	name = imageName
+	return
+}
+
 // Manifest defines what is in a release
 type InputManifest struct {
 	// Dependencies declares all git repositories used to build this release
@@ -137,6 +894,12 @@ type InputManifest struct {
 	Docker string `json:"docker"`
 	// DockerOutput specifies where docker images are written.
 	DockerOutput DockerOutput `json:"dockerOutput"`
+	// Channel is the release channel of the build: daily, weekly, or stable. It is recorded in the image labels, chart
+	// annotations, and release metadata, and names the aliases the release is published to, such as latest-daily.
+	Channel Channel `json:"channel,omitempty"`
+	// ImageLabels are added to every image, along with the OCI version, revision, source, and created labels, which
+	// they may override. Values are templates executed with the manifest. Example: {"org.opencontainers.image.vendor": "Alauda"}
+	ImageLabels map[string]string `json:"imageLabels,omitempty"`
 	// Architectures defines the architectures to build for.
 	// Note: this impacts only docker and deb/rpm; istioctl is always built in additional platforms.
 	// Example: []string{"linux/amd64", "linux/arm64"}.
@@ -147,13 +910,114 @@ type InputManifest struct {
 	// ProxyOverride specifies a URL to an Envoy binary to use instead of the default proxy
 	// The binary will be pulled from `$proxyOverride/envoy-alpha-SHA.tar.gz`
 	ProxyOverride string `json:"proxyOverride"`
-	// BuildOutputs defines what components to build. This allows building only some components.
-	BuildOutputs []string `json:"outputs"`
+	// Proxy embeds a pinned istio/proxy build, fetched or built from source, in the images, packages, and archives.
+	Proxy *ProxyConfig `json:"proxy,omitempty"`
+	// Ztunnel embeds the ztunnel pinned by the istio repo, fetched or built from source, in the images, and releases
+	// its binaries.
+	Ztunnel *ZtunnelConfig `json:"ztunnel,omitempty"`
+	// BuildOutputs defines what components to build, and where their artifacts are written.
+	BuildOutputs Outputs `json:"outputs"`
 	// GrafanaDashboards defines a mapping of dashboard name -> ID of the dashboard on grafana.com
 	GrafanaDashboards map[string]int `json:"dashboards"`
 	// BillOfMaterials flag determines if a Bill of Materials should be produced
 	// by the build.
 	SkipGenerateBillOfMaterials bool `json:"skipGenerateBillOfMaterials"`
+	// KubernetesVersions defines the Kubernetes versions the charts are rendered against during validation.
+	// Example: []string{"1.29.0", "1.32.0"}.
+	KubernetesVersions []string `json:"kubernetesVersions,omitempty"`
+	// Reproducible normalizes timestamps, archive ordering, and ownership so artifacts are bit-for-bit reproducible.
+	Reproducible bool `json:"reproducible,omitempty"`
+	// FastIstioctl builds istioctl directly with go build, rather than make, for builds needing only istioctl, such as
+	// PR validation.
+	FastIstioctl bool `json:"fastIstioctl,omitempty"`
+	// DebugSymbols strips the debug info from istioctl into separate debug symbol archives, rather than building
+	// istioctl without it.
+	DebugSymbols bool `json:"debugSymbols,omitempty"`
+	// BuildMatrix adds builds of the control plane binaries with other compiler flags, such as debug or race builds,
+	// alongside the optimized release build.
+	BuildMatrix []BuildFlavor `json:"buildMatrix,omitempty"`
+	// Licenses enables third party license aggregation and checks.
+	Licenses *LicenseConfig `json:"licenses,omitempty"`
+	// ImageVariants defines the docker image variants to build. `default` and `debug` are the same variant, saved and
+	// published with a -debug suffix.
+	// Example: []string{"debug", "distroless"}.
+	ImageVariants []string `json:"imageVariants,omitempty"`
+	// DefaultVariant is the image variant stamped into the helm charts and profiles. If unset, the charts default is kept.
+	DefaultVariant string `json:"defaultVariant,omitempty"`
+	// BrewRepo is the Homebrew tap to open istioctl formula updates against on publish.
+	BrewRepo *Dependency `json:"brewRepo,omitempty"`
+	// KrewRepo is the krew index to open istioctl plugin manifest updates against on publish.
+	KrewRepo *Dependency `json:"krewRepo,omitempty"`
+	// Downstream repos are sent PRs updating their version references after a successful publish.
+	Downstream []DownstreamRepo `json:"downstream,omitempty"`
+	// WindowsPackages enables Scoop and Chocolatey packages for istioctl.
+	WindowsPackages *WindowsPackageConfig `json:"windowsPackages,omitempty"`
+	// Rpm configures the version fields and signing of the rpm packages.
+	Rpm *RpmConfig `json:"rpm,omitempty"`
+	// OLM enables an OLM bundle for the operator, for publishing to an OperatorHub catalog.
+	OLM *OLMConfig `json:"olm,omitempty"`
+	// Kustomize renders the charts into kustomize bases and overlays, for users not consuming helm.
+	Kustomize *RenderConfig `json:"kustomize,omitempty"`
+	// RenderedManifests renders the charts for each profile into plain yaml, under manifests and in the archives, for
+	// users installing with kubectl apply.
+	RenderedManifests *RenderConfig `json:"renderedManifests,omitempty"`
+	// InstallScript enables an install script pinned to the release archive checksums.
+	InstallScript *InstallScriptConfig `json:"installScript,omitempty"`
+	// WasmExtensions are built and shipped as .wasm files in the archive and as OCI artifacts.
+	WasmExtensions []WasmExtension `json:"wasmExtensions,omitempty"`
+	// ValuesChanges enables a report of the chart values changed since the previous release.
+	ValuesChanges *ValuesChangesConfig `json:"valuesChanges,omitempty"`
+	// CRDCompatibility enables validating the CRDs are upgrade compatible with the previous release.
+	CRDCompatibility *CRDCompatibilityConfig `json:"crdCompatibility,omitempty"`
+	// SizeBudgets limit the size of the artifacts, checked by validate to catch accidental bloat.
+	SizeBudgets *SizeBudgets `json:"sizeBudgets,omitempty"`
+	// Plugins are external commands run as additional build or publish steps.
+	Plugins []Plugin `json:"plugins,omitempty"`
+	// BuildContainer is the pinned image build steps run in with --containerized.
+	BuildContainer *BuildContainer `json:"buildContainer,omitempty"`
+	// GoModules configures fetching Go modules, for builds without access to proxy.golang.org.
+	GoModules *GoModulesConfig `json:"goModules,omitempty"`
+	// BaseImages are upstream images, such as the distroless base or build container, whose signatures are
+	// verified before building.
+	BaseImages []BaseImage `json:"baseImages,omitempty"`
+	// Charts are the helm charts, relative to the istio repo, released to the helm repo. Defaults to the core charts.
+	// Example: []string{"manifests/charts/base", "manifests/charts/istio-control/istio-discovery"}.
+	Charts []string `json:"charts,omitempty"`
+	// ExternalCharts are helm charts from other dependency repos, stamped and released along with the charts.
+	ExternalCharts []ExternalChart `json:"externalCharts,omitempty"`
+	// ChartVersionSuffix is appended to the version to form the chart versions, with the appVersion left as the version.
+	// Example: -alauda.3
+	ChartVersionSuffix string `json:"chartVersionSuffix,omitempty"`
+	// AppVersion overrides the appVersion of the charts. Defaults to the version.
+	AppVersion string `json:"appVersion,omitempty"`
+	// ChartVersions overrides the chart version suffix and appVersion per chart, keyed by chart name.
+	ChartVersions map[string]ChartVersionConfig `json:"chartVersions,omitempty"`
+	// ChartChannels publishes charts, keyed by chart name, to a channel other than stable, such as experimental.
+	// Each channel is published to its own helm repository, configured by publish.helmChannels.
+	ChartChannels map[string]string `json:"chartChannels,omitempty"`
+	// PinnedCharts also packages each chart with a -pinned version suffix, referencing images by digest rather than
+	// tag, for clusters requiring digest references.
+	PinnedCharts bool `json:"pinnedCharts,omitempty"`
+	// ChartAnnotations are merged into the annotations of every released chart. Values are templates executed with
+	// the manifest, and the chart name as .Chart. Example: {"artifacthub.io/license": "Apache-2.0"}
+	ChartAnnotations map[string]string `json:"chartAnnotations,omitempty"`
+	// ExtraFiles are templated with the release and added to the archives and charts, such as an EULA.
+	ExtraFiles []ExtraFile `json:"extraFiles,omitempty"`
+	// Renames rename binaries and images for downstream distributions, after they are built.
+	Renames *RenameConfig `json:"renames,omitempty"`
+	// Publish defines the default publish targets and signing config, used when not set by publish flags.
+	Publish *PublishConfig `json:"publish,omitempty"`
+	// Notifications are sent when a build or publish completes.
+	Notifications []Notification `json:"notifications,omitempty"`
+	// Network configures the proxy and CA bundle for all outbound traffic.
+	Network *NetworkConfig `json:"network,omitempty"`
+	// Git configures how git dependencies are fetched, such as from a mirror.
+	Git *GitConfig `json:"git,omitempty"`
+	// Registries configures credentials per hub. Hubs without credentials use the ambient docker login.
+	Registries []RegistryAuth `json:"registries,omitempty"`
+	// Profiles are named partial manifests, such as daily, rc, or stable, merged over this manifest when
+	// selected with --profile. Maps are merged, while other values, including lists, are replaced.
+	Profiles map[string]any `json:"profiles,omitempty"`
 }
 
 // Manifest defines what is in a release
@@ -166,6 +1030,12 @@ type Manifest struct {
 	Docker string `json:"docker"`
 	// DockerOutput specifies where docker images are written.
 	DockerOutput DockerOutput `json:"dockerOutput"`
+	// Channel is the release channel of the build: daily, weekly, or stable. It is recorded in the image labels, chart
+	// annotations, and release metadata, and names the aliases the release is published to, such as latest-daily.
+	Channel Channel `json:"channel,omitempty"`
+	// ImageLabels are added to every image, along with the OCI version, revision, source, and created labels, which
+	// they may override. Values are templates executed with the manifest. Example: {"org.opencontainers.image.vendor": "Alauda"}
+	ImageLabels map[string]string `json:"imageLabels,omitempty"`
 	// Architectures defines the architectures to build for.
 	// Note: this impacts only docker and deb/rpm; istioctl is always built in additional platforms.
 	// Example: []string{"linux/amd64", "linux/arm64"}.
@@ -176,14 +1046,164 @@ type Manifest struct {
 	// ProxyOverride specifies a URL to an Envoy binary to use instead of the default proxy
 	// The binary will be pulled from `$proxyOverride/envoy-alpha-SHA.tar.gz`
 	ProxyOverride string `json:"-"`
+	// Proxy embeds a pinned istio/proxy build, fetched or built from source, in the images, packages, and archives.
+	Proxy *ProxyConfig `json:"proxy,omitempty"`
+	// Ztunnel embeds the ztunnel pinned by the istio repo, fetched or built from source, in the images, and releases
+	// its binaries.
+	Ztunnel *ZtunnelConfig `json:"ztunnel,omitempty"`
 	// BuildOutputs defines what components to build. This allows building only some components.
-	BuildOutputs map[BuildOutput]struct{} `json:"-"`
+	BuildOutputs BuildOutputSet `json:"outputs,omitempty"`
+	// Layout maps artifact directories to path templates. See Outputs.
+	Layout map[string]string `json:"layout,omitempty"`
 	// GrafanaDashboards defines a mapping of dashboard name -> ID of the dashboard on grafana.com
 	// Note: this tool is not yet smart enough to create dashboards that do not already exist, it can only update dashboards.
 	GrafanaDashboards map[string]int `json:"dashboards"`
 	// BillOfMaterials flag determines if a Bill of Materials should be produced
 	// by the build.
 	SkipGenerateBillOfMaterials bool `json:"skipGenerateBillOfMaterials"`
+	// KubernetesVersions defines the Kubernetes versions the charts are rendered against during validation.
+	// Example: []string{"1.29.0", "1.32.0"}.
+	KubernetesVersions []string `json:"kubernetesVersions,omitempty"`
+	// Reproducible normalizes timestamps, archive ordering, and ownership so artifacts are bit-for-bit reproducible.
+	Reproducible bool `json:"reproducible,omitempty"`
+	// FastIstioctl builds istioctl directly with go build, rather than make, for builds needing only istioctl, such as
+	// PR validation.
+	FastIstioctl bool `json:"fastIstioctl,omitempty"`
+	// DebugSymbols strips the debug info from istioctl into separate debug symbol archives, rather than building
+	// istioctl without it.
+	DebugSymbols bool `json:"debugSymbols,omitempty"`
+	// BuildMatrix adds builds of the control plane binaries with other compiler flags, such as debug or race builds,
+	// alongside the optimized release build.
+	BuildMatrix []BuildFlavor `json:"buildMatrix,omitempty"`
+	// Licenses enables third party license aggregation and checks.
+	Licenses *LicenseConfig `json:"licenses,omitempty"`
+	// ImageVariants defines the docker image variants to build. `default` and `debug` are the same variant, saved and
+	// published with a -debug suffix.
+	// Example: []string{"debug", "distroless"}.
+	ImageVariants []string `json:"imageVariants,omitempty"`
+	// DefaultVariant is the image variant stamped into the helm charts and profiles. If unset, the charts default is kept.
+	DefaultVariant string `json:"defaultVariant,omitempty"`
+	// BrewRepo is the Homebrew tap to open istioctl formula updates against on publish.
+	BrewRepo *Dependency `json:"brewRepo,omitempty"`
+	// KrewRepo is the krew index to open istioctl plugin manifest updates against on publish.
+	KrewRepo *Dependency `json:"krewRepo,omitempty"`
+	// Downstream repos are sent PRs updating their version references after a successful publish.
+	Downstream []DownstreamRepo `json:"downstream,omitempty"`
+	// WindowsPackages enables Scoop and Chocolatey packages for istioctl.
+	WindowsPackages *WindowsPackageConfig `json:"windowsPackages,omitempty"`
+	// Rpm configures the version fields and signing of the rpm packages.
+	Rpm *RpmConfig `json:"rpm,omitempty"`
+	// OLM enables an OLM bundle for the operator, for publishing to an OperatorHub catalog.
+	OLM *OLMConfig `json:"olm,omitempty"`
+	// Kustomize renders the charts into kustomize bases and overlays, for users not consuming helm.
+	Kustomize *RenderConfig `json:"kustomize,omitempty"`
+	// RenderedManifests renders the charts for each profile into plain yaml, under manifests and in the archives, for
+	// users installing with kubectl apply.
+	RenderedManifests *RenderConfig `json:"renderedManifests,omitempty"`
+	// InstallScript enables an install script pinned to the release archive checksums.
+	InstallScript *InstallScriptConfig `json:"installScript,omitempty"`
+	// WasmExtensions are built and shipped as .wasm files in the archive and as OCI artifacts.
+	WasmExtensions []WasmExtension `json:"wasmExtensions,omitempty"`
+	// ValuesChanges enables a report of the chart values changed since the previous release.
+	ValuesChanges *ValuesChangesConfig `json:"valuesChanges,omitempty"`
+	// CRDCompatibility enables validating the CRDs are upgrade compatible with the previous release.
+	CRDCompatibility *CRDCompatibilityConfig `json:"crdCompatibility,omitempty"`
+	// SizeBudgets limit the size of the artifacts, checked by validate to catch accidental bloat.
+	SizeBudgets *SizeBudgets `json:"sizeBudgets,omitempty"`
+	// Plugins are external commands run as additional build or publish steps.
+	Plugins []Plugin `json:"plugins,omitempty"`
+	// BuildContainer is the pinned image build steps run in with --containerized.
+	BuildContainer *BuildContainer `json:"buildContainer,omitempty"`
+	// GoModules configures fetching Go modules, for builds without access to proxy.golang.org.
+	GoModules *GoModulesConfig `json:"goModules,omitempty"`
+	// BaseImages are upstream images, such as the distroless base or build container, whose signatures are
+	// verified before building.
+	BaseImages []BaseImage `json:"baseImages,omitempty"`
+	// Charts are the helm charts, relative to the istio repo, released to the helm repo. Defaults to the core charts.
+	// Example: []string{"manifests/charts/base", "manifests/charts/istio-control/istio-discovery"}.
+	Charts []string `json:"charts,omitempty"`
+	// ExternalCharts are helm charts from other dependency repos, stamped and released along with the charts.
+	ExternalCharts []ExternalChart `json:"externalCharts,omitempty"`
+	// ChartVersionSuffix is appended to the version to form the chart versions, with the appVersion left as the version.
+	// Example: -alauda.3
+	ChartVersionSuffix string `json:"chartVersionSuffix,omitempty"`
+	// AppVersion overrides the appVersion of the charts. Defaults to the version.
+	AppVersion string `json:"appVersion,omitempty"`
+	// ChartVersions overrides the chart version suffix and appVersion per chart, keyed by chart name.
+	ChartVersions map[string]ChartVersionConfig `json:"chartVersions,omitempty"`
+	// ChartChannels publishes charts, keyed by chart name, to a channel other than stable, such as experimental.
+	// Each channel is published to its own helm repository, configured by publish.helmChannels.
+	ChartChannels map[string]string `json:"chartChannels,omitempty"`
+	// PinnedCharts also packages each chart with a -pinned version suffix, referencing images by digest rather than
+	// tag, for clusters requiring digest references.
+	PinnedCharts bool `json:"pinnedCharts,omitempty"`
+	// ChartAnnotations are merged into the annotations of every released chart. Values are templates executed with
+	// the manifest, and the chart name as .Chart. Example: {"artifacthub.io/license": "Apache-2.0"}
+	ChartAnnotations map[string]string `json:"chartAnnotations,omitempty"`
+	// ExtraFiles are templated with the release and added to the archives and charts, such as an EULA.
+	ExtraFiles []ExtraFile `json:"extraFiles,omitempty"`
+	// Renames rename binaries and images for downstream distributions, after they are built.
+	Renames *RenameConfig `json:"renames,omitempty"`
+	// Publish defines the default publish targets and signing config, used when not set by publish flags.
+	Publish *PublishConfig `json:"publish,omitempty"`
+	// Notifications are sent when a build or publish completes.
+	Notifications []Notification `json:"notifications,omitempty"`
+	// Network configures the proxy and CA bundle for all outbound traffic.
+	Network *NetworkConfig `json:"network,omitempty"`
+	// Git configures how git dependencies are fetched, such as from a mirror.
+	Git *GitConfig `json:"git,omitempty"`
+	// Registries configures credentials per hub. Hubs without credentials use the ambient docker login.
+	Registries []RegistryAuth `json:"registries,omitempty"`
+	// SkippedSteps are the build steps that did not run, whether skipped with --skip-steps or not selected by --steps.
+	SkippedSteps []string `json:"skippedSteps,omitempty"`
+}
+
+// ChartVersion returns the version the chart is released with.
+func (m Manifest) ChartVersion(chart string) string {
+	suffix := m.ChartVersionSuffix
+	if c, f := m.ChartVersions[chart]; f && c.ChartVersionSuffix != "" {
+		suffix = c.ChartVersionSuffix
+	}
+	return m.Version + suffix
+}
+
+// ChartAppVersion returns the appVersion the chart is released with.
+func (m Manifest) ChartAppVersion(chart string) string {
+	if c, f := m.ChartVersions[chart]; f && c.AppVersion != "" {
+		return c.AppVersion
+	}
+	if m.AppVersion != "" {
+		return m.AppVersion
+	}
+	return m.Version
+}
+
+// StableChannel is the channel of charts not assigned one by ChartChannels.
+const StableChannel = "stable"
+
+// ChartChannel returns the channel the chart is published to.
+func (m Manifest) ChartChannel(chart string) string {
+	if c, f := m.ChartChannels[chart]; f && c != "" {
+		return c
+	}
+	return StableChannel
+}
+
+// ChartFile returns the file name of the packaged chart.
+func (m Manifest) ChartFile(chart string) string {
+	return fmt.Sprintf("%s-%s.tgz", chart, m.ChartVersion(chart))
+}
+
+// chartFilePattern matches packaged chart file names, such as istiod-1.25.0.tgz. Chart names do not
+// contain a dash followed by a digit, so the version starts at the first one.
+var chartFilePattern = regexp.MustCompile(`^(.+?)-(\d.*)\.tgz$`)
+
+// ChartNameVersion splits a packaged chart file name, such as istiod-1.25.0.tgz, into its name and version.
+func ChartNameVersion(file string) (string, string) {
+	if m := chartFilePattern.FindStringSubmatch(file); m != nil {
+		return m[1], m[2]
+	}
+	return strings.TrimSuffix(file, ".tgz"), ""
 }
 
 // RepoDir is a helper to return the working directory for a repo
@@ -208,6 +1228,27 @@ func (m Manifest) RepoArchOutDir(repo string, arch string) string {
 	return path.Join(m.Directory, "work", "src", "istio.io", repo, "out", "linux_"+arch, "release")
 }
 
+// ProxyURL returns the base URL the istio build fetches envoy from, for the manifest proxy. Proxies built from source
+// are packaged into the work directory.
+func (m Manifest) ProxyURL() string {
+	if m.Proxy == nil {
+		return ""
+	}
+	if m.Proxy.URL != "" {
+		return m.Proxy.URL
+	}
+	return "file://" + path.Join(m.WorkDir(), "proxy")
+}
+
+// ZtunnelURL returns the base URL the istio build fetches ztunnel from, for the manifest ztunnel. The binaries are
+// fetched or built into the work directory first, so they are also released.
+func (m Manifest) ZtunnelURL() string {
+	if m.Ztunnel == nil {
+		return ""
+	}
+	return "file://" + path.Join(m.WorkDir(), "ztunnel")
+}
+
 // WorkDir is a help to return the work directory
 func (m Manifest) WorkDir() string {
 	return path.Join(m.Directory, "work")
@@ -223,6 +1264,40 @@ func (m Manifest) OutDir() string {
 	return path.Join(m.Directory, "out")
 }
 
+// ArtifactDir returns the directory, relative to the output directory, that artifacts of the given kind
+// are written to. arch is only used by per-architecture artifacts, such as deb and rpm.
+func (m Manifest) ArtifactDir(kind string, arch string) string {
+	layout, f := m.Layout[kind]
+	if !f {
+		return kind
+	}
+	dir, err := RenderLayout(layout, m.Version, arch)
+	if err != nil {
+		// Layouts are validated when the manifest is read
+		return kind
+	}
+	return dir
+}
+
+// LayoutKinds are the artifact directories whose location may be set by a layout.
+var LayoutKinds = []string{
+	"builds", "deb", "docker", "docs", "grafana", "helm", "kustomize", "licenses", "manifests", "olm", "rpm", "wasm",
+	"windows", "ztunnel",
+}
+
+// RenderLayout renders a layout path template.
+func RenderLayout(layout string, version string, arch string) (string, error) {
+	t, err := template.New("layout").Option("missingkey=error").Parse(layout)
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, struct{ Version, Arch string }{version, arch}); err != nil {
+		return "", err
+	}
+	return path.Clean(buf.String()), nil
+}
+
 // IstioDep identifies a external dependency of Istio.
 type IstioDep struct {
 	Comment       string `json:"_comment,omitempty"`
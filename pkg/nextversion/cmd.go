@@ -0,0 +1,58 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nextversion
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+var (
+	flags = struct {
+		bump string
+		pre  string
+	}{
+		bump: "patch",
+	}
+	nextVersionCmd = &cobra.Command{
+		Use:          "next-version <version>",
+		Short:        "Prints the version following a release of Istio",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			next, err := util.NextVersion(args[0], flags.bump, flags.pre)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintln(c.OutOrStdout(), next)
+			return err
+		},
+	}
+)
+
+func init() {
+	nextVersionCmd.PersistentFlags().StringVar(&flags.bump, "bump", flags.bump,
+		"The part of the version to bump. One of major, minor, patch, prerelease, or release, which drops the pre-release.")
+	nextVersionCmd.PersistentFlags().StringVar(&flags.pre, "pre", flags.pre,
+		"The pre-release kind, one of alpha, beta, or rc. With major, minor, or patch, starts the first pre-release of the new version. "+
+			"With prerelease, moves to the first pre-release of the kind.")
+}
+
+func GetNextVersionCommand() *cobra.Command {
+	return nextVersionCmd
+}
@@ -0,0 +1,142 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// Result is the outcome of a build or publish, as sent to generic webhooks.
+type Result struct {
+	// Action is either build or publish
+	Action    string             `json:"action"`
+	Version   string             `json:"version"`
+	Success   bool               `json:"success"`
+	Error     string             `json:"error,omitempty"`
+	Steps     []util.StepMetrics `json:"steps"`
+	Artifacts []string           `json:"artifacts,omitempty"`
+}
+
+// Notify sends the result of the action to each notification configured in the manifest. dir is the
+// release directory artifacts are listed from. Failures to notify are logged, but never fail the release.
+func Notify(manifest model.Manifest, action string, dir string, metrics *util.Metrics, err error) {
+	if len(manifest.Notifications) == 0 {
+		return
+	}
+	result := Result{
+		Action:    action,
+		Version:   manifest.Version,
+		Success:   err == nil,
+		Steps:     metrics.Steps,
+		Artifacts: artifacts(dir),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	for _, n := range manifest.Notifications {
+		if err := send(n, result); err != nil {
+			log.Warnf("failed to send %v notification: %v", n.Type, err)
+		}
+	}
+}
+
+func send(n model.Notification, result Result) error {
+	url := n.URL
+	if n.URLEnv != "" {
+		url = os.Getenv(n.URLEnv)
+	}
+	if url == "" {
+		return fmt.Errorf("no url configured")
+	}
+	var payload any
+	switch n.Type {
+	case "slack":
+		payload = map[string]string{"text": summary(result)}
+	case "teams":
+		payload = map[string]string{
+			"@type":    "MessageCard",
+			"@context": "http://schema.org/extensions",
+			"summary":  fmt.Sprintf("Istio %s %s", result.Version, result.Action),
+			"text":     summary(result),
+		}
+	case "webhook":
+		payload = result
+	default:
+		return fmt.Errorf("unknown notification type %q", n.Type)
+	}
+	by, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(url, "application/json", bytes.NewReader(by))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%v %v", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// summary renders the result as markdown, for chat notifications.
+func summary(result Result) string {
+	b := &strings.Builder{}
+	if result.Success {
+		fmt.Fprintf(b, "Istio %s %s succeeded\n", result.Version, result.Action)
+	} else {
+		fmt.Fprintf(b, "Istio %s %s FAILED: %s\n", result.Version, result.Action, result.Error)
+	}
+	for _, s := range result.Steps {
+		status := "ok"
+		if s.Error != "" {
+			status = "failed"
+		}
+		fmt.Fprintf(b, "- %s: %s in %.0fs\n", s.Name, status, s.WallSeconds)
+	}
+	if len(result.Artifacts) > 0 {
+		fmt.Fprintf(b, "%d artifacts\n", len(result.Artifacts))
+	}
+	return b.String()
+}
+
+// artifacts lists the files in the release directory.
+func artifacts(dir string) []string {
+	files := []string{}
+	_ = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if rel, err := filepath.Rel(dir, p); err == nil {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return files
+}
@@ -0,0 +1,86 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/alauda-mesh/release-builder/pkg"
+)
+
+var (
+	flags = struct {
+		manifest string
+		format   string
+		image    string
+		runner   string
+		profile  string
+		set      []string
+	}{
+		manifest: "example/manifest.yaml",
+		format:   "json",
+		image:    "gcr.io/istio-testing/build-tools:master",
+		runner:   "self-hosted",
+	}
+	planCmd = &cobra.Command{
+		Use:          "plan",
+		Short:        "Exports the build plan of a release as a machine-readable pipeline spec",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(0),
+		RunE: func(c *cobra.Command, _ []string) error {
+			inManifest, err := pkg.ReadInManifest(flags.manifest, flags.profile, flags.set)
+			if err != nil {
+				return fmt.Errorf("failed to unmarshal manifest: %v", err)
+			}
+			if inManifest.Directory == "" {
+				return fmt.Errorf("manifest must set directory, as the steps of a plan share a working directory")
+			}
+
+			manifest, err := pkg.InputManifestToManifest(inManifest)
+			if err != nil {
+				return fmt.Errorf("failed to setup manifest: %v", err)
+			}
+
+			out, err := Export(NewPlan(manifest, flags.manifest, flags.profile, flags.set), flags.format)
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.Write(out)
+			return err
+		},
+	}
+)
+
+func init() {
+	planCmd.PersistentFlags().StringVar(&flags.manifest, "manifest", flags.manifest,
+		"The manifest to plan.")
+	planCmd.PersistentFlags().StringVar(&flags.profile, "profile", flags.profile,
+		"The manifest profile to apply. Example: daily")
+	planCmd.PersistentFlags().StringArrayVar(&flags.set, "set", flags.set,
+		"Override a manifest field, in the form key=value. Keys are dot separated, such as dependencies.istio.branch. May be repeated.")
+	planCmd.PersistentFlags().StringVar(&flags.format, "format", flags.format,
+		"The format to export. One of json, tekton, github-actions.")
+	planCmd.PersistentFlags().StringVar(&flags.image, "image", flags.image,
+		"The build image steps run in, for the tekton format.")
+	planCmd.PersistentFlags().StringVar(&flags.runner, "runner", flags.runner,
+		"The runner label jobs run on, for the github-actions format. All jobs must share a working directory.")
+}
+
+func GetPlanCommand() *cobra.Command {
+	return planCmd
+}
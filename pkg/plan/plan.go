@@ -0,0 +1,146 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/build"
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// Step is a single executable unit of a plan.
+type Step struct {
+	Name      string   `json:"name"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+	Command   []string `json:"command"`
+}
+
+// Plan is the resolved DAG of steps for a release.
+type Plan struct {
+	Version string `json:"version"`
+	Steps   []Step `json:"steps"`
+}
+
+// NewPlan resolves the steps for the manifest. Each step is run with `release-builder build --steps`,
+// so the build steps remain the single source of truth. The profile and overrides are passed to each step.
+// The release is validated, then published to the targets set in the manifest publish config.
+func NewPlan(manifest model.Manifest, manifestFile string, profile string, overrides []string) Plan {
+	p := Plan{Version: manifest.Version}
+	buildCommand := func(step string) []string {
+		cmd := []string{"release-builder", "build", "--manifest", manifestFile}
+		if profile != "" {
+			cmd = append(cmd, "--profile", profile)
+		}
+		for _, o := range overrides {
+			cmd = append(cmd, "--set", o)
+		}
+		return append(cmd, "--steps", step)
+	}
+	p.Steps = append(p.Steps, Step{
+		Name:    build.FetchSourcesStep,
+		Command: buildCommand(build.FetchSourcesStep),
+	})
+	all := []string{build.FetchSourcesStep}
+	for _, s := range build.Steps(manifest) {
+		p.Steps = append(p.Steps, Step{
+			Name:      s.Name,
+			DependsOn: append([]string{build.FetchSourcesStep}, s.DependsOn...),
+			Command:   buildCommand(s.Name),
+		})
+		all = append(all, s.Name)
+	}
+	p.Steps = append(p.Steps, Step{
+		Name:      "validate",
+		DependsOn: all,
+		Command:   []string{"release-builder", "validate", "--release", manifest.OutDir()},
+	})
+	p.Steps = append(p.Steps, Step{
+		Name:      "publish",
+		DependsOn: []string{"validate"},
+		Command:   []string{"release-builder", "publish", "--release", manifest.OutDir()},
+	})
+	return p
+}
+
+// Export renders the plan in the given format.
+func Export(p Plan, format string) ([]byte, error) {
+	switch format {
+	case "json":
+		return json.MarshalIndent(p, "", "  ")
+	case "tekton":
+		return yaml.Marshal(tektonPipeline(p))
+	case "github-actions":
+		return yaml.Marshal(githubWorkflow(p))
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func tektonPipeline(p Plan) map[string]any {
+	tasks := []map[string]any{}
+	for _, s := range p.Steps {
+		tasks = append(tasks, map[string]any{
+			"name":     s.Name,
+			"runAfter": s.DependsOn,
+			"workspaces": []map[string]any{
+				{"name": "release", "workspace": "release"},
+			},
+			"taskSpec": map[string]any{
+				"workspaces": []map[string]any{{"name": "release"}},
+				"steps": []map[string]any{{
+					"name":       s.Name,
+					"image":      flags.image,
+					"workingDir": "$(workspaces.release.path)",
+					"command":    s.Command,
+				}},
+			},
+		})
+	}
+	return map[string]any{
+		"apiVersion": "tekton.dev/v1",
+		"kind":       "Pipeline",
+		"metadata": map[string]any{
+			"name": "istio-release-" + strings.ReplaceAll(p.Version, ".", "-"),
+		},
+		"spec": map[string]any{
+			"workspaces": []map[string]any{{"name": "release"}},
+			"tasks":      tasks,
+		},
+	}
+}
+
+func githubWorkflow(p Plan) map[string]any {
+	jobs := map[string]any{}
+	for _, s := range p.Steps {
+		jobs[s.Name] = map[string]any{
+			"needs":   s.DependsOn,
+			"runs-on": flags.runner,
+			"steps": []map[string]any{{
+				"name": s.Name,
+				"run":  strings.Join(s.Command, " "),
+			}},
+		}
+	}
+	return map[string]any{
+		"name": "Istio release " + p.Version,
+		"on":   map[string]any{"workflow_dispatch": map[string]any{}},
+		"jobs": jobs,
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+const (
+	// Build plugins run as build steps, after the standard artifacts are built
+	Build = "build"
+	// Publish plugins run after the standard publish steps
+	Publish = "publish"
+)
+
+// StepName is the name of the build or publish step running the plugin.
+func StepName(p model.Plugin) string {
+	return "plugin-" + p.Name
+}
+
+// Run executes the plugin in dir. The manifest is passed as json on stdin, and the directories and version
+// in the environment:
+//
+//	RELEASE_VERSION: the version being released
+//	RELEASE_WORK_DIR: the working directory, with the sources under sources/ during builds
+//	RELEASE_OUT_DIR: the directory of release artifacts the plugin may add to
+func Run(p model.Plugin, manifest model.Manifest, workDir, outDir string) error {
+	by, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %v", err)
+	}
+	cmd := util.VerboseCommand(p.Command[0], p.Command[1:]...)
+	cmd.Dir = workDir
+	cmd.Stdin = bytes.NewReader(by)
+	cmd.Env = append(os.Environ(),
+		"RELEASE_VERSION="+manifest.Version,
+		"RELEASE_WORK_DIR="+workDir,
+		"RELEASE_OUT_DIR="+outDir,
+	)
+	for k, v := range p.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("plugin %v failed: %v", p.Name, err)
+	}
+	return nil
+}
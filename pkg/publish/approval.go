@@ -0,0 +1,224 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// Approval is the payload of a release approval token. A token is issued by a release manager
+// other than the one running the publish, and authorizes a single action on a single version.
+type Approval struct {
+	// Action is the command approved: publish, the default, which promote-version also requires for the promoted
+	// version, unpublish, rebuild-images, for the patch version, or prune, with no version.
+	Action   string    `json:"action,omitempty"`
+	Version  string    `json:"version"`
+	Approver string    `json:"approver"`
+	Expires  time.Time `json:"expires"`
+}
+
+// ParseApprovalToken decodes a token of the form <base64 payload>.<base64 ed25519 signature>.
+// The signature is returned so it can be verified against the approver's key.
+func ParseApprovalToken(token string) (Approval, []byte, []byte, error) {
+	payloadPart, sigPart, ok := strings.Cut(strings.TrimSpace(token), ".")
+	if !ok {
+		return Approval{}, nil, nil, fmt.Errorf("malformed approval token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(payloadPart)
+	if err != nil {
+		return Approval{}, nil, nil, fmt.Errorf("failed to decode approval payload: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigPart)
+	if err != nil {
+		return Approval{}, nil, nil, fmt.Errorf("failed to decode approval signature: %v", err)
+	}
+	approval := Approval{}
+	if err := json.Unmarshal(payload, &approval); err != nil {
+		return Approval{}, nil, nil, fmt.Errorf("failed to unmarshal approval payload: %v", err)
+	}
+	return approval, payload, sig, nil
+}
+
+// readApprovalKeys reads a mapping of release manager name -> base64 encoded ed25519 public key.
+func readApprovalKeys(file string) (map[string]ed25519.PublicKey, error) {
+	by, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read approval keys: %v", err)
+	}
+	raw := map[string]string{}
+	if err := yaml.Unmarshal(by, &raw); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal approval keys: %v", err)
+	}
+	keys := map[string]ed25519.PublicKey{}
+	for name, k := range raw {
+		key, err := base64.StdEncoding.DecodeString(k)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key for %v", name)
+		}
+		keys[name] = key
+	}
+	return keys, nil
+}
+
+// maxApprovalValidity bounds how far in the future an approval may expire, so approvals cannot be issued
+// to last indefinitely.
+const maxApprovalValidity = 7 * 24 * time.Hour
+
+// readPublisherKey reads a base64 encoded ed25519 private key, or its seed.
+func readPublisherKey(file string) (ed25519.PrivateKey, error) {
+	by, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read publisher key: %v", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(by)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode publisher key: %v", err)
+	}
+	switch len(key) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(key), nil
+	case ed25519.PrivateKeySize:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("invalid publisher key")
+	}
+}
+
+// publisherIdentity returns the release manager whose key the publisher holds. The publisher proves it by signing
+// the approval payload, which is verified against the release manager keys, so the identity is not self-asserted.
+func publisherIdentity(keys map[string]ed25519.PublicKey, keyFile string, payload []byte) (string, error) {
+	if keyFile == "" {
+		return "", fmt.Errorf("--publisherkey is required when approval is required")
+	}
+	private, err := readPublisherKey(keyFile)
+	if err != nil {
+		return "", err
+	}
+	sig := ed25519.Sign(private, payload)
+	for name, key := range keys {
+		if ed25519.Verify(key, payload, sig) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("publisher key is not the key of a known release manager")
+}
+
+// VerifyApproval enforces the two-person rule: the action must be approved by a release manager
+// other than the publisher, with an unexpired token signed by that manager's key for exactly this action and version.
+// The publisher is identified by their own release manager key.
+func VerifyApproval(action, version, tokenFile, keysFile, publisherKeyFile string) error {
+	if tokenFile == "" || keysFile == "" {
+		return fmt.Errorf("--approvaltoken and --approvalkeys are required when approval is required")
+	}
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return fmt.Errorf("failed to read approval token: %v", err)
+	}
+	keys, err := readApprovalKeys(keysFile)
+	if err != nil {
+		return err
+	}
+	approval, payload, sig, err := ParseApprovalToken(string(token))
+	if err != nil {
+		return err
+	}
+	key, f := keys[approval.Approver]
+	if !f {
+		return fmt.Errorf("approver %q is not a known release manager", approval.Approver)
+	}
+	if !ed25519.Verify(key, payload, sig) {
+		return fmt.Errorf("approval signature from %q is invalid", approval.Approver)
+	}
+	approved := approval.Action
+	if approved == "" {
+		approved = "publish"
+	}
+	if approved != action {
+		return fmt.Errorf("approval is for %v, not %v", approved, action)
+	}
+	if approval.Version != version {
+		return fmt.Errorf("approval is for version %q, not %q", approval.Version, version)
+	}
+	if approval.Expires.IsZero() {
+		return fmt.Errorf("approval from %q has no expiry", approval.Approver)
+	}
+	if time.Now().After(approval.Expires) {
+		return fmt.Errorf("approval from %q expired at %v", approval.Approver, approval.Expires)
+	}
+	if time.Until(approval.Expires) > maxApprovalValidity {
+		return fmt.Errorf("approval from %q expires at %v, more than %v from now", approval.Approver, approval.Expires, maxApprovalValidity)
+	}
+	publisher, err := publisherIdentity(keys, publisherKeyFile, payload)
+	if err != nil {
+		return err
+	}
+	if publisher == approval.Approver {
+		return fmt.Errorf("approval must come from a release manager other than the publisher %q", publisher)
+	}
+	return Audit(version, "approval", map[string]string{
+		"action":    action,
+		"approver":  approval.Approver,
+		"publisher": publisher,
+		"expires":   approval.Expires.String(),
+	})
+}
+
+// approved is the action and version already approved in this run, so promote-version, which approves publishing
+// the promoted version before creating it, is not asked for the token again when it is published.
+var approved string
+
+// checkApproval verifies the approval of action on version, if required by the manifest or --requireapproval. The
+// check must run before anything is changed.
+func checkApproval(required bool, action, version string) error {
+	if !required && !flags.requireapproval {
+		return nil
+	}
+	if approved == action+"/"+version {
+		return nil
+	}
+	if err := VerifyApproval(action, version, flags.approvaltoken, flags.approvalkeys, flags.publisherkey); err != nil {
+		return fmt.Errorf("%v approval failed: %v", action, err)
+	}
+	approved = action + "/" + version
+	return nil
+}
+
+// requiresApproval returns whether the publish config requires approval.
+func requiresApproval(p *model.PublishConfig) bool {
+	return p != nil && p.RequireApproval
+}
+
+// addApprovalFlags adds the flags identifying the approval, and the publisher, to a command that needs approval.
+func addApprovalFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&flags.requireapproval, "requireapproval", flags.requireapproval,
+		"Require a signed approval from a second release manager, even if the manifest does not set publish.requireApproval.")
+	fs.StringVar(&flags.approvaltoken, "approvaltoken", flags.approvaltoken,
+		"The file containing the signed approval token.")
+	fs.StringVar(&flags.approvalkeys, "approvalkeys", flags.approvalkeys,
+		"The file containing the release manager name -> ed25519 public key mapping used to verify approvals.")
+	fs.StringVar(&flags.publisherkey, "publisherkey", flags.publisherkey,
+		"The file containing the base64 ed25519 private key of the release manager running the command, identifying them for approval.")
+}
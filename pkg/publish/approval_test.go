@@ -0,0 +1,201 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// approvalToken signs the approval with key, as a release manager issues it.
+func approvalToken(t *testing.T, approval Approval, key ed25519.PrivateKey) string {
+	t.Helper()
+	payload, err := json.Marshal(approval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(ed25519.Sign(key, payload))
+}
+
+func TestParseApprovalToken(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Approval{Version: "1.25.0", Approver: "alice", Expires: time.Now().Add(time.Hour).Truncate(time.Second).UTC()}
+	got, payload, sig, err := ParseApprovalToken(approvalToken(t, want, key) + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if !ed25519.Verify(key.Public().(ed25519.PublicKey), payload, sig) {
+		t.Fatal("expected the returned signature to verify the payload")
+	}
+	for _, token := range []string{"", "no-signature", "!!!.abc", "e30.!!!", base64.RawURLEncoding.EncodeToString([]byte("[]")) + ".abc"} {
+		if _, _, _, err := ParseApprovalToken(token); err == nil {
+			t.Errorf("expected %q to be rejected", token)
+		}
+	}
+}
+
+func TestVerifyApproval(t *testing.T) {
+	dir := t.TempDir()
+	keys := map[string]ed25519.PrivateKey{}
+	publicKeys := []string{}
+	for _, name := range []string{"alice", "bob"} {
+		public, private, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[name] = private
+		publicKeys = append(publicKeys, fmt.Sprintf("%s: %s", name, base64.StdEncoding.EncodeToString(public)))
+	}
+	_, unknown, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keysFile := filepath.Join(dir, "keys.yaml")
+	if err := os.WriteFile(keysFile, []byte(strings.Join(publicKeys, "\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeKey := func(name string, key ed25519.PrivateKey) string {
+		f := filepath.Join(dir, name)
+		if err := os.WriteFile(f, []byte(base64.StdEncoding.EncodeToString(key.Seed())), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	bobKey := writeKey("bob.key", keys["bob"])
+	aliceKey := writeKey("alice.key", keys["alice"])
+	unknownKey := writeKey("unknown.key", unknown)
+
+	valid := Approval{Version: "1.25.0", Approver: "alice", Expires: time.Now().Add(time.Hour)}
+	cases := []struct {
+		name         string
+		approval     Approval
+		signer       ed25519.PrivateKey
+		publisherKey string
+		err          string
+	}{
+		{
+			name:         "valid",
+			approval:     valid,
+			signer:       keys["alice"],
+			publisherKey: bobKey,
+		},
+		{
+			name:         "other version",
+			approval:     Approval{Version: "1.24.0", Approver: "alice", Expires: valid.Expires},
+			signer:       keys["alice"],
+			publisherKey: bobKey,
+			err:          "approval is for version \"1.24.0\"",
+		},
+		{
+			name:         "explicit publish",
+			approval:     Approval{Action: "publish", Version: "1.25.0", Approver: "alice", Expires: valid.Expires},
+			signer:       keys["alice"],
+			publisherKey: bobKey,
+		},
+		{
+			name:         "other action",
+			approval:     Approval{Action: "unpublish", Version: "1.25.0", Approver: "alice", Expires: valid.Expires},
+			signer:       keys["alice"],
+			publisherKey: bobKey,
+			err:          "approval is for unpublish, not publish",
+		},
+		{
+			name:         "expired",
+			approval:     Approval{Version: "1.25.0", Approver: "alice", Expires: time.Now().Add(-time.Minute)},
+			signer:       keys["alice"],
+			publisherKey: bobKey,
+			err:          "expired",
+		},
+		{
+			name:         "no expiry",
+			approval:     Approval{Version: "1.25.0", Approver: "alice"},
+			signer:       keys["alice"],
+			publisherKey: bobKey,
+			err:          "has no expiry",
+		},
+		{
+			name:         "expiry too far",
+			approval:     Approval{Version: "1.25.0", Approver: "alice", Expires: time.Now().Add(maxApprovalValidity + time.Hour)},
+			signer:       keys["alice"],
+			publisherKey: bobKey,
+			err:          "more than",
+		},
+		{
+			name:         "unknown approver",
+			approval:     Approval{Version: "1.25.0", Approver: "mallory", Expires: valid.Expires},
+			signer:       unknown,
+			publisherKey: bobKey,
+			err:          "not a known release manager",
+		},
+		{
+			name:         "signed by another key",
+			approval:     valid,
+			signer:       keys["bob"],
+			publisherKey: bobKey,
+			err:          "signature from \"alice\" is invalid",
+		},
+		{
+			name:         "self approval",
+			approval:     valid,
+			signer:       keys["alice"],
+			publisherKey: aliceKey,
+			err:          "other than the publisher",
+		},
+		{
+			name:         "unknown publisher",
+			approval:     valid,
+			signer:       keys["alice"],
+			publisherKey: unknownKey,
+			err:          "publisher key is not the key of a known release manager",
+		},
+		{
+			name:     "no publisher key",
+			approval: valid,
+			signer:   keys["alice"],
+			err:      "--publisherkey is required",
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tokenFile := filepath.Join(t.TempDir(), "token")
+			if err := os.WriteFile(tokenFile, []byte(approvalToken(t, tt.approval, tt.signer)), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			err := VerifyApproval("publish", "1.25.0", tokenFile, keysFile, tt.publisherKey)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("expected approval to verify, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
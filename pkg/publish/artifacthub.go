@@ -0,0 +1,78 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"istio.io/istio/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+const (
+	// artifactHubRepoFile is the repository metadata ArtifactHub reads from alongside index.yaml.
+	artifactHubRepoFile = "artifacthub-repo.yml"
+	// artifactHubTag is the tag ArtifactHub reads the repository metadata of an oci chart from.
+	artifactHubTag = "artifacthub.io"
+
+	artifactHubConfigMediaType types.MediaType = "application/vnd.cncf.artifacthub.config.v1+yaml"
+	artifactHubLayerMediaType  types.MediaType = "application/vnd.cncf.artifacthub.repository-metadata.layer.v1.yaml"
+)
+
+// artifactHubRepo is the format of artifacthub-repo.yml.
+type artifactHubRepo struct {
+	RepositoryID string                   `json:"repositoryID,omitempty"`
+	Owners       []model.ArtifactHubOwner `json:"owners,omitempty"`
+}
+
+// artifactHubMetadata returns the repository metadata of a chart repository with the ArtifactHub repository ID, or nil
+// if publish.artifactHub is not set.
+func artifactHubMetadata(manifest model.Manifest, repositoryID string) ([]byte, error) {
+	if manifest.Publish == nil || manifest.Publish.ArtifactHub == nil {
+		return nil, nil
+	}
+	by, err := yaml.Marshal(artifactHubRepo{RepositoryID: repositoryID, Owners: manifest.Publish.ArtifactHub.Owners})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %v: %v", artifactHubRepoFile, err)
+	}
+	return by, nil
+}
+
+// pushArtifactHubMetadata pushes the repository metadata to an oci chart repository, as ArtifactHub expects.
+func pushArtifactHubMetadata(repo string, metadata []byte, keychain authn.Keychain) error {
+	ref, err := name.NewTag(repo + ":" + artifactHubTag)
+	if err != nil {
+		return fmt.Errorf("failed to parse %v: %v", repo, err)
+	}
+	img, err := mutate.Append(empty.Image, mutate.Addendum{Layer: static.NewLayer(metadata, artifactHubLayerMediaType)})
+	if err != nil {
+		return err
+	}
+	img = mutate.ConfigMediaType(mutate.MediaType(img, types.OCIManifestSchema1), artifactHubConfigMediaType)
+	if err := remote.Write(ref, img, remote.WithAuthFromKeychain(keychain)); err != nil {
+		return fmt.Errorf("failed to push %v: %v", ref, err)
+	}
+	log.Infof("Pushed ArtifactHub metadata to %v", ref)
+	return nil
+}
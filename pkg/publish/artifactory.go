@@ -0,0 +1,207 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// artifactory deploys files to an Artifactory instance.
+type artifactory struct {
+	config     model.ArtifactoryConfig
+	username   string
+	password   string
+	properties string
+}
+
+// Artifactory deploys the release files to the generic repository, under the version, and the charts to the helm
+// repository, which Artifactory indexes itself. Every artifact is deployed with the configured properties.
+func Artifactory(manifest model.Manifest, config model.ArtifactoryConfig) error {
+	a, err := newArtifactory(manifest, config)
+	if err != nil {
+		return err
+	}
+	if config.GenericRepo != "" {
+		err := filepath.WalkDir(manifest.Directory, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(manifest.Directory, p)
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if rel == manifest.ArtifactDir("docker", "") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			u, err := a.deploy(p, path.Join(config.GenericRepo, manifest.Version, filepath.ToSlash(rel)))
+			if err != nil {
+				return err
+			}
+			recordObject(u)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to deploy to %v: %v", config.GenericRepo, err)
+		}
+	}
+	if config.HelmRepo != "" {
+		helmDir := filepath.Join(manifest.Directory, manifest.ArtifactDir("helm", ""))
+		for _, dir := range append([]string{""}, chartSubtypeDir...) {
+			entries, err := os.ReadDir(filepath.Join(helmDir, dir))
+			if err != nil {
+				return err
+			}
+			for _, f := range entries {
+				if filepath.Ext(f.Name()) != ".tgz" {
+					continue
+				}
+				u, err := a.deploy(filepath.Join(helmDir, dir, f.Name()), path.Join(config.HelmRepo, dir, f.Name()))
+				if err != nil {
+					return fmt.Errorf("failed to deploy to %v: %v", config.HelmRepo, err)
+				}
+				chart, version := model.ChartNameVersion(f.Name())
+				recordChart(chart, version, u)
+			}
+		}
+	}
+	return nil
+}
+
+func newArtifactory(manifest model.Manifest, config model.ArtifactoryConfig) (*artifactory, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("artifactory url must be set")
+	}
+	password := os.Getenv(config.PasswordEnv)
+	if password == "" {
+		return nil, fmt.Errorf("artifactory credentials not found in $%v", config.PasswordEnv)
+	}
+	username := config.Username
+	if config.UsernameEnv != "" {
+		username = os.Getenv(config.UsernameEnv)
+	}
+	props, err := executeValues(manifest, config.Properties)
+	if err != nil {
+		return nil, fmt.Errorf("invalid artifactory properties: %v", err)
+	}
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	// Properties are passed as matrix parameters of the deploy URL
+	matrix := ""
+	for _, k := range keys {
+		matrix += ";" + url.PathEscape(k) + "=" + url.PathEscape(props[k])
+	}
+	return &artifactory{config: config, username: username, password: password, properties: matrix}, nil
+}
+
+// deploy uploads the file to the repository path, returning its URL. A deploy by checksum is tried first, so
+// content Artifactory already has is not uploaded again. Otherwise the content is uploaded with its checksums,
+// which Artifactory verifies.
+func (a *artifactory) deploy(file string, repoPath string) (string, error) {
+	sums, err := fileChecksums(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to checksum %v: %v", file, err)
+	}
+	u := strings.TrimSuffix(a.config.URL, "/") + "/" + repoPath
+	status, err := a.put(u, nil, sums, true)
+	if err != nil {
+		return "", err
+	}
+	if status == http.StatusNotFound {
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		if _, err := a.put(u, f, sums, false); err != nil {
+			return "", err
+		}
+	}
+	log.Infof("Deployed %v to %v", file, u)
+	return u, nil
+}
+
+// put sends a deploy request, returning the status code. A 404 of a checksum deploy is not an error, as it means
+// the content must be uploaded.
+func (a *artifactory) put(u string, body io.Reader, sums map[string]string, checksumDeploy bool) (int, error) {
+	req, err := http.NewRequest(http.MethodPut, u+a.properties, body)
+	if err != nil {
+		return 0, err
+	}
+	if a.username != "" {
+		req.SetBasicAuth(a.username, a.password)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+a.password)
+	}
+	req.Header.Set("X-Checksum-Sha1", sums["sha1"])
+	req.Header.Set("X-Checksum-Sha256", sums["sha256"])
+	req.Header.Set("X-Checksum", sums["md5"])
+	if checksumDeploy {
+		req.Header.Set("X-Checksum-Deploy", "true")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to deploy %v: %v", u, err)
+	}
+	defer resp.Body.Close()
+	if checksumDeploy && resp.StatusCode == http.StatusNotFound {
+		return resp.StatusCode, nil
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("failed to deploy %v: %v %v", u, resp.Status, string(msg))
+	}
+	return resp.StatusCode, nil
+}
+
+// fileChecksums returns the md5, sha1, and sha256 of the file, as Artifactory verifies them.
+func fileChecksums(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, s1, s256 := md5.New(), sha1.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(m, s1, s256), f); err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"md5":    hex.EncodeToString(m.Sum(nil)),
+		"sha1":   hex.EncodeToString(s1.Sum(nil)),
+		"sha256": hex.EncodeToString(s256.Sum(nil)),
+	}, nil
+}
@@ -0,0 +1,60 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"istio.io/istio/pkg/log"
+)
+
+// AuditEntry is a single record in the publish audit log.
+type AuditEntry struct {
+	Time    time.Time         `json:"time"`
+	Version string            `json:"version"`
+	Action  string            `json:"action"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Audit appends an entry to the audit log, if one is configured with --auditlog. Entries are always
+// logged as well, so the information is not lost if no audit log file is set.
+func Audit(version, action string, details map[string]string) error {
+	entry := AuditEntry{
+		Time:    time.Now().UTC(),
+		Version: version,
+		Action:  action,
+		Details: details,
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %v", err)
+	}
+	log.Infof("audit: %s", string(line))
+	if flags.auditlog == "" {
+		return nil
+	}
+	f, err := os.OpenFile(flags.auditlog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %v: %v", flags.auditlog, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log %v: %v", flags.auditlog, err)
+	}
+	return nil
+}
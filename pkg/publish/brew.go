@@ -0,0 +1,98 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"bytes"
+	"text/template"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+var brewFormula = template.Must(template.New("formula").Parse(`class Istioctl < Formula
+  desc "Istio configuration command-line utility"
+  homepage "https://istio.io/"
+  version "{{ .Version }}"
+  license "Apache-2.0"
+
+  on_macos do
+    on_arm do
+      url "{{ .DarwinArm64.URL }}"
+      sha256 "{{ .DarwinArm64.Sha }}"
+    end
+    on_intel do
+      url "{{ .DarwinAmd64.URL }}"
+      sha256 "{{ .DarwinAmd64.Sha }}"
+    end
+  end
+
+  on_linux do
+    on_arm do
+      url "{{ .LinuxArm64.URL }}"
+      sha256 "{{ .LinuxArm64.Sha }}"
+    end
+    on_intel do
+      url "{{ .LinuxAmd64.URL }}"
+      sha256 "{{ .LinuxAmd64.Sha }}"
+    end
+  end
+
+  def install
+    bin.install "istioctl"
+    bash_completion.install "istioctl.bash" => "istioctl"
+    zsh_completion.install "_istioctl"
+  end
+
+  test do
+    assert_match version.to_s, shell_output("#{bin}/istioctl version --remote=false")
+  end
+end
+`))
+
+// Brew opens a PR updating the istioctl formula in the Homebrew tap.
+func Brew(manifest model.Manifest, downloadURL string, token string) error {
+	formula, err := brewFormulaFor(manifest, downloadURL)
+	if err != nil {
+		return err
+	}
+	return updatePackageRepo(manifest, "homebrew-tap", manifest.BrewRepo, map[string][]byte{
+		"Formula/istioctl.rb": formula,
+	}, token)
+}
+
+func brewFormulaFor(manifest model.Manifest, downloadURL string) ([]byte, error) {
+	artifacts, err := istioctlArtifacts(manifest, downloadURL)
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]any{"Version": manifest.Version}
+	for key, platform := range map[string][2]string{
+		"DarwinArm64": {"darwin", "arm64"},
+		"DarwinAmd64": {"darwin", "amd64"},
+		"LinuxArm64":  {"linux", "arm64"},
+		"LinuxAmd64":  {"linux", "amd64"},
+	} {
+		a, err := findArtifact(artifacts, platform[0], platform[1])
+		if err != nil {
+			return nil, err
+		}
+		data[key] = a
+	}
+	buf := &bytes.Buffer{}
+	if err := brewFormula.Execute(buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
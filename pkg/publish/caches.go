@@ -0,0 +1,81 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// VerifyCaches checks that every published image resolves to the same digest when pulled through
+// each of the given pull-through caches. A cache is given as a registry prefix replacing the registry
+// of the hub, for example `mirror.gcr.io` or `harbor.example.com/dockerhub-proxy`.
+// Users behind a cache that still serves a stale manifest for a tag would otherwise silently get an old image.
+func VerifyCaches(manifest model.Manifest, hub string, tags []string, caches []string) error {
+	if len(tags) == 0 {
+		tags = []string{manifest.Version}
+	}
+	dockerArchives, err := os.ReadDir(path.Join(manifest.Directory, manifest.ArtifactDir("docker", "")))
+	if err != nil {
+		return fmt.Errorf("failed to read docker output of release: %v", err)
+	}
+
+	keychain := util.Keychain(manifest.Registries)
+	var errs []error
+	for img, archs := range imageIndex(manifest, dockerArchives, hub, tags) {
+		published := publishedReference(img, archs)
+		ref, err := name.ParseReference(published)
+		if err != nil {
+			return fmt.Errorf("failed to parse %v: %v", published, err)
+		}
+		want, err := remote.Head(ref, remote.WithAuthFromKeychain(keychain))
+		if err != nil {
+			return fmt.Errorf("failed to resolve %v: %v", published, err)
+		}
+		for _, cache := range caches {
+			cached := cacheReference(cache, ref)
+			cachedRef, err := name.ParseReference(cached)
+			if err != nil {
+				return fmt.Errorf("failed to parse %v: %v", cached, err)
+			}
+			got, err := remote.Head(cachedRef, remote.WithAuthFromKeychain(keychain))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to resolve %v through cache: %v", cached, err))
+				continue
+			}
+			if got.Digest != want.Digest {
+				errs = append(errs, fmt.Errorf("cache %v serves %v for %v, expected %v", cache, got.Digest, published, want.Digest))
+				continue
+			}
+			log.Infof("Verified %v resolves to %v", cached, want.Digest)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// cacheReference rewrites a reference to be pulled through the given cache prefix.
+func cacheReference(cache string, ref name.Reference) string {
+	return fmt.Sprintf("%s/%s:%s", strings.TrimSuffix(cache, "/"), ref.Context().RepositoryStr(), ref.Identifier())
+}
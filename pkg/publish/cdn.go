@@ -0,0 +1,95 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// changedPaths returns the paths overwritten by the publish, which a CDN may be serving stale copies of.
+// Versioned objects are new, so are never stale.
+func changedPaths(s3bucket string, aliases []string, latest string, helmbucket string) []string {
+	paths := []string{}
+	if s3bucket != "" {
+		_, prefix := splitBucket(s3bucket)
+		for _, alias := range aliases {
+			paths = append(paths, "/"+path.Join(prefix, alias))
+		}
+		if latest != "" {
+			paths = append(paths, "/"+path.Join(prefix, latest)+"/*")
+		}
+	}
+	if helmbucket != "" {
+		_, prefix := splitBucket(helmbucket)
+		paths = append(paths, "/"+path.Join(prefix, "index.yaml"))
+	}
+	return paths
+}
+
+// InvalidateCDN invalidates the paths in the configured CloudFront distribution and purge URL.
+func InvalidateCDN(cdn model.CDNConfig, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	if cdn.CloudFrontDistribution != "" {
+		args := append([]string{"cloudfront", "create-invalidation", "--distribution-id", cdn.CloudFrontDistribution, "--paths"}, paths...)
+		if err := util.VerboseCommand("aws", args...).Run(); err != nil {
+			return fmt.Errorf("failed to invalidate cloudfront distribution %v: %v", cdn.CloudFrontDistribution, err)
+		}
+	}
+	purgeURL := cdn.PurgeURL
+	if cdn.PurgeURLEnv != "" {
+		purgeURL = os.Getenv(cdn.PurgeURLEnv)
+	}
+	if purgeURL != "" {
+		for _, p := range paths {
+			if err := purge(strings.TrimSuffix(purgeURL, "/") + p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// purge sends a PURGE request for the url, as supported by Varnish, Fastly, and most caching proxies.
+func purge(url string) error {
+	req, err := http.NewRequest("PURGE", url, nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to purge %v: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to purge %v: %v %v", url, resp.StatusCode, string(body))
+	}
+	log.Infof("Purged %v", url)
+	return nil
+}
@@ -19,12 +19,15 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"istio.io/istio/pkg/log"
 
 	"github.com/alauda-mesh/release-builder/pkg"
 	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/notify"
+	"github.com/alauda-mesh/release-builder/pkg/plugin"
 	"github.com/alauda-mesh/release-builder/pkg/util"
 )
 
@@ -37,11 +40,39 @@ var (
 		helmbucket   string
 		helmhub      string
 		s3alias      []string
+		s3redirect   bool
+		s3latest     string
+		s3           model.S3Options
+		s3mirrors    []model.S3Destination
 		github       string
 		githubtoken  string
 		grafanatoken string
 		cosignkey    string
-	}{}
+		verifycaches []string
+		downloadurl  string
+		directory    string
+
+		requireapproval bool
+		approvaltoken   string
+		approvalkeys    string
+		publisherkey    string
+		publisher       string
+		auditlog        string
+
+		lock        bool
+		locktimeout time.Duration
+
+		metrics     string
+		pushgateway string
+
+		uploadpublished bool
+
+		force     bool
+		overwrite bool
+	}{
+		publisher:   os.Getenv("USER"),
+		locktimeout: 30 * time.Minute,
+	}
 	publishCmd = &cobra.Command{
 		Use:          "publish",
 		Short:        "Publish a release of Istio",
@@ -54,12 +85,31 @@ var (
 
 			log.Infof("Publishing Istio release from: %v", flags.release)
 
-			manifest, err := pkg.ReadManifest(path.Join(flags.release, "manifest.yaml"))
+			release := flags.release
+			if strings.HasPrefix(release, "s3://") {
+				dir, err := FetchRelease(release)
+				defer os.RemoveAll(dir)
+				if err != nil {
+					return fmt.Errorf("failed to fetch release: %v", err)
+				}
+				if err := VerifyRelease(dir); err != nil {
+					return fmt.Errorf("fetched release failed verification: %v", err)
+				}
+				release = dir
+			}
+
+			manifest, err := pkg.ReadManifest(path.Join(release, "manifest.yaml"))
 			if err != nil {
 				return fmt.Errorf("failed to read manifest from release: %v", err)
 			}
-			manifest.Directory = path.Clean(flags.release)
+			manifest.Directory = path.Clean(release)
 			util.YamlLog("Manifest", manifest)
+			applyManifestDefaults(manifest)
+			applyChannel(manifest)
+			// A fetched release is fetched with the network flags, as its manifest is only read once fetched
+			if err := util.ConfigureNetwork(manifest.Network); err != nil {
+				return fmt.Errorf("failed to configure network: %v", err)
+			}
 
 			return Publish(manifest)
 		},
@@ -68,7 +118,7 @@ var (
 
 func init() {
 	publishCmd.PersistentFlags().StringVar(&flags.release, "release", flags.release,
-		"The directory with the Istio release binary.")
+		"The directory with the Istio release binary, or a release previously uploaded to S3. Example: s3://istio-staging/1.25.0")
 	publishCmd.PersistentFlags().StringVar(&flags.dockerhub, "dockerhub", flags.dockerhub,
 		"The docker hub to push images to. Example: docker.io/istio.")
 	publishCmd.PersistentFlags().StringSliceVar(&flags.dockertags, "dockertags", flags.dockertags,
@@ -81,6 +131,24 @@ func init() {
 		"The oci registry to publish helm to. Example: gcr.io/istio-release/charts.")
 	publishCmd.PersistentFlags().StringSliceVar(&flags.s3alias, "s3aliases", flags.s3alias,
 		"Alias to publish to S3. Example: latest")
+	publishCmd.PersistentFlags().BoolVar(&flags.s3redirect, "s3aliasredirect", flags.s3redirect,
+		"Also set website redirect metadata on alias objects, pointing to the version.")
+	publishCmd.PersistentFlags().StringVar(&flags.s3latest, "s3latest", flags.s3latest,
+		"If set, mirror the release files under this prefix, without the version in their names, if this is the newest release. Example: latest")
+	publishCmd.PersistentFlags().StringVar(&flags.s3.SSE, "s3sse", flags.s3.SSE,
+		"Server-side encryption for s3 objects, either s3 or kms.")
+	publishCmd.PersistentFlags().StringVar(&flags.s3.KMSKeyID, "s3kmskey", flags.s3.KMSKeyID,
+		"KMS key ID for kms s3 encryption.")
+	publishCmd.PersistentFlags().StringVar(&flags.s3.ACL, "s3acl", flags.s3.ACL,
+		"Canned ACL for s3 objects, such as public-read.")
+	publishCmd.PersistentFlags().StringVar(&flags.s3.StorageClass, "s3storageclass", flags.s3.StorageClass,
+		"Storage class for s3 objects.")
+	publishCmd.PersistentFlags().StringToStringVar(&flags.s3.CacheControl, "s3cachecontrol", flags.s3.CacheControl,
+		"Cache-Control headers for s3 objects, by file name suffix. Example: .tar.gz=max-age=31536000")
+	publishCmd.PersistentFlags().StringToStringVar(&flags.s3.Tags, "s3tags", flags.s3.Tags,
+		"Object tags for every uploaded s3 object. Values are templates executed with the manifest. Example: release={{.Version}},team=mesh")
+	publishCmd.PersistentFlags().BoolVar(&flags.s3.Docker, "s3docker", flags.s3.Docker,
+		"Upload the docker image archives to s3, so the release can be published, including its images, with --release s3://...")
 	publishCmd.PersistentFlags().StringVar(&flags.github, "github", flags.github,
 		"The Github org to trigger a release, and tag, for. Example: istio.")
 	publishCmd.PersistentFlags().StringVar(&flags.githubtoken, "githubtoken", flags.githubtoken,
@@ -89,12 +157,135 @@ func init() {
 		"The file containing a grafana.com API token.")
 	publishCmd.PersistentFlags().StringVar(&flags.cosignkey, "cosignkey", flags.cosignkey,
 		"A key for signing images, as passed to cosign using 'cosign sign --key <x>'")
+	publishCmd.PersistentFlags().StringSliceVar(&flags.verifycaches, "verifycaches", flags.verifycaches,
+		"Pull-through cache prefixes to verify published images through. Example: mirror.gcr.io")
+	publishCmd.PersistentFlags().StringVar(&flags.downloadurl, "downloadurl", flags.downloadurl,
+		"The base URL release archives are downloaded from, with the version appended. When set, istioctl package manager "+
+			"updates are opened against the manifest brewRepo and krewRepo. Example: https://github.com/istio/istio/releases/download")
+	publishCmd.PersistentFlags().StringVar(&flags.directory, "directory", flags.directory,
+		"Rather than publishing, write everything the other targets would be sent into this directory, for inspection or mirroring.")
+	addApprovalFlags(publishCmd.PersistentFlags())
+	publishCmd.PersistentFlags().StringVar(&flags.auditlog, "auditlog", flags.auditlog,
+		"The file to append audit log entries to.")
+	publishCmd.PersistentFlags().BoolVar(&flags.lock, "lock", flags.lock,
+		"Hold a lock in the --s3bucket and --helmbucket while publishing, so concurrent publishes do not race on aliases and index.yaml.")
+	addLockFlags(publishCmd.PersistentFlags())
+	publishCmd.PersistentFlags().StringVar(&flags.metrics, "metrics", flags.metrics,
+		"The file to write publish step metrics to, as json.")
+	publishCmd.PersistentFlags().StringVar(&flags.pushgateway, "pushgateway", flags.pushgateway,
+		"The Prometheus pushgateway to push publish step metrics to. Example: http://pushgateway:9091")
+	publishCmd.PersistentFlags().BoolVar(&flags.uploadpublished, "uploadpublished", flags.uploadpublished,
+		"Upload published.yaml, listing every published destination, to the version in --s3bucket.")
+	publishCmd.PersistentFlags().BoolVar(&flags.force, "force", flags.force,
+		"Overwrite image tags of the version that already exist in the registry with different images.")
+	publishCmd.PersistentFlags().BoolVar(&flags.overwrite, "overwrite", flags.overwrite,
+		"Overwrite objects of the version that already exist in --s3bucket with different checksums.")
 }
 
 func GetPublishCommand() *cobra.Command {
 	return publishCmd
 }
 
+// applyS3ClientDefaults sets the S3 connection, where not set by flags, from the manifest's publish.s3client.
+func applyS3ClientDefaults(c *model.S3ClientConfig) {
+	if c == nil {
+		return
+	}
+	setDefault := func(flag *string, value string) {
+		if *flag == "" {
+			*flag = value
+		}
+	}
+	setDefault(&S3ClientConfig.Endpoint, c.Endpoint)
+	setDefault(&S3ClientConfig.Region, c.Region)
+	setDefault(&S3ClientConfig.CredentialsFile, c.CredentialsFile)
+	setDefault(&S3ClientConfig.Profile, c.Profile)
+	S3ClientConfig.PathStyle = S3ClientConfig.PathStyle || c.PathStyle
+	S3ClientConfig.InsecureSkipVerify = S3ClientConfig.InsecureSkipVerify || c.InsecureSkipVerify
+}
+
+// applyManifestDefaults sets any publish targets and signing config, not set by flags, from the manifest.
+func applyManifestDefaults(manifest model.Manifest) {
+	p := manifest.Publish
+	if p == nil {
+		return
+	}
+	setDefault := func(flag *string, value string) {
+		if *flag == "" {
+			*flag = value
+		}
+	}
+	setDefault(&flags.dockerhub, p.DockerHub)
+	setDefault(&flags.s3bucket, p.S3Bucket)
+	setDefault(&flags.helmbucket, p.HelmBucket)
+	setDefault(&flags.helmhub, p.HelmHub)
+	setDefault(&flags.github, p.Github)
+	setDefault(&flags.cosignkey, p.CosignKey)
+	setDefault(&flags.directory, p.Directory)
+	if len(flags.dockertags) == 0 {
+		// Copied, as applyChannel rewrites the tags in place
+		flags.dockertags = append([]string(nil), p.DockerTags...)
+	}
+	flags.s3mirrors = p.S3Mirrors
+	applyS3ClientDefaults(p.S3Client)
+	if p.S3 != nil {
+		setDefault(&flags.s3.SSE, p.S3.SSE)
+		setDefault(&flags.s3.KMSKeyID, p.S3.KMSKeyID)
+		setDefault(&flags.s3.ACL, p.S3.ACL)
+		setDefault(&flags.s3.StorageClass, p.S3.StorageClass)
+		if len(flags.s3.CacheControl) == 0 {
+			flags.s3.CacheControl = p.S3.CacheControl
+		}
+		if len(flags.s3.Tags) == 0 {
+			flags.s3.Tags = p.S3.Tags
+		}
+		if flags.s3.ObjectLock == nil {
+			flags.s3.ObjectLock = p.S3.ObjectLock
+		}
+		flags.s3.Docker = flags.s3.Docker || p.S3.Docker
+	}
+}
+
+// applyChannel names the aliases, latest mirror, and docker tags after the channel of the release, such as
+// latest-daily, so each channel points to its own newest release. The version tag is left as is, as the released
+// charts refer to it.
+func applyChannel(manifest model.Manifest) {
+	if manifest.Channel == "" {
+		return
+	}
+	for i, alias := range flags.s3alias {
+		flags.s3alias[i] = channelName(alias, manifest.Channel)
+	}
+	if flags.s3latest != "" {
+		flags.s3latest = channelName(flags.s3latest, manifest.Channel)
+	}
+	for i, tag := range flags.dockertags {
+		if tag == manifest.Version {
+			continue
+		}
+		flags.dockertags[i] = channelName(tag, manifest.Channel)
+	}
+}
+
+// channelName suffixes name with the channel, unless it already is.
+func channelName(name string, channel model.Channel) string {
+	if strings.HasSuffix(name, "-"+string(channel)) {
+		return name
+	}
+	return name + "-" + string(channel)
+}
+
+// updatesAliases returns whether the aliases and latest mirror are updated to the release. They point users at the
+// newest stable release, so are never updated for pre-releases, except on the daily and weekly channels, whose dev
+// builds are always pre-releases.
+func updatesAliases(manifest model.Manifest) bool {
+	switch manifest.Channel {
+	case model.ChannelDaily, model.ChannelWeekly:
+		return true
+	}
+	return !util.IsPrerelease(manifest.Version)
+}
+
 func validateFlags() error {
 	if flags.release == "" {
 		return fmt.Errorf("--release required")
@@ -103,50 +294,214 @@ func validateFlags() error {
 }
 
 func Publish(manifest model.Manifest) error {
+	metrics := util.NewMetrics(manifest.Version)
+	published = Published{}
+	publishErr := publish(manifest, metrics)
+	util.LogTransferSummary()
+	// Partial publishes are recorded as well, so what was published can be cleaned up
+	uploadBucket := ""
+	if flags.uploadpublished && publishErr == nil {
+		uploadBucket = flags.s3bucket
+	}
+	if err := WritePublished(manifest, uploadBucket); err != nil {
+		if publishErr == nil {
+			publishErr = err
+		} else {
+			log.Warnf("%v", err)
+		}
+	}
+	if flags.metrics != "" {
+		if err := metrics.Write(flags.metrics); err != nil {
+			log.Warnf("failed to write publish metrics: %v", err)
+		}
+	}
+	if flags.pushgateway != "" {
+		if err := metrics.Push(flags.pushgateway, "release-builder-publish"); err != nil {
+			log.Warnf("failed to push publish metrics: %v", err)
+		}
+	}
+	notify.Notify(manifest, "publish", manifest.Directory, metrics, publishErr)
+	return publishErr
+}
+
+func publish(manifest model.Manifest, metrics *util.Metrics) error {
+	// The approval gate must run before anything is mutated
+	if err := checkApproval(requiresApproval(manifest.Publish), "publish", manifest.Version); err != nil {
+		return err
+	}
+	if flags.directory != "" {
+		return metrics.Time("directory", "", func() error { return Directory(manifest, flags.directory) })
+	}
+	var locks heldLocks
+	if flags.lock {
+		buckets := lockBuckets(flags.s3bucket, flags.helmbucket)
+		if len(buckets) == 0 {
+			return fmt.Errorf("--lock requires --s3bucket or --helmbucket")
+		}
+		var err error
+		if locks, err = acquireLocks(manifest.Version, buckets); err != nil {
+			return err
+		}
+		defer locks.Release()
+	}
+	// Each step checks the locks are held before and after it runs, so a lost lock stops the publish
+	timed := func(name string, fn func() error) error {
+		if err := locks.Err(); err != nil {
+			return err
+		}
+		if err := metrics.Time(name, "", fn); err != nil {
+			return err
+		}
+		return locks.Err()
+	}
 	if flags.dockerhub != "" {
-		if err := Docker(manifest, flags.dockerhub, flags.dockertags, flags.cosignkey); err != nil {
-			return fmt.Errorf("failed to publish to docker: %v", err)
+		if promoteFlags.from != "" {
+			// A promoted release reuses the images published for the release it was promoted from
+			if err := timed("retag", func() error {
+				return Retag(manifest, flags.dockerhub, promoteFlags.from, flags.dockertags)
+			}); err != nil {
+				return fmt.Errorf("failed to retag docker images: %v", err)
+			}
+		} else {
+			if err := timed("docker", func() error {
+				return Docker(manifest, flags.dockerhub, flags.dockertags, flags.cosignkey)
+			}); err != nil {
+				return fmt.Errorf("failed to publish to docker: %v", err)
+			}
+			if len(manifest.WasmExtensions) > 0 {
+				if err := timed("wasm", func() error {
+					return Wasm(manifest, flags.dockerhub, flags.dockertags)
+				}); err != nil {
+					return fmt.Errorf("failed to publish wasm extensions: %v", err)
+				}
+			}
+		}
+		if len(flags.verifycaches) > 0 {
+			if err := timed("verify-caches", func() error {
+				return VerifyCaches(manifest, flags.dockerhub, flags.dockertags, flags.verifycaches)
+			}); err != nil {
+				return fmt.Errorf("failed to verify images through caches: %v", err)
+			}
 		}
 	}
 	if flags.s3bucket != "" {
-		if err := S3Archive(manifest, flags.s3bucket, flags.s3alias); err != nil {
+		if err := timed("s3", func() error {
+			return S3Archive(manifest, s3Destinations(flags.s3bucket, flags.s3mirrors), flags.s3alias, flags.s3redirect, flags.s3latest, flags.s3)
+		}); err != nil {
 			return fmt.Errorf("failed to publish to S3: %v", err)
 		}
+		if manifest.InstallScript != nil {
+			if err := timed("install-script", func() error {
+				return InstallScript(manifest, flags.s3bucket, flags.s3)
+			}); err != nil {
+				return fmt.Errorf("failed to publish install script: %v", err)
+			}
+		}
 	}
 	if flags.helmbucket != "" || flags.helmhub != "" {
-		if err := Helm(manifest, flags.helmbucket, flags.helmhub); err != nil {
+		if err := timed("helm", func() error { return Helm(manifest, flags.helmbucket, flags.helmhub) }); err != nil {
 			return fmt.Errorf("failed to publish to helm charts: %v", err)
 		}
 	}
+	if manifest.Publish != nil && manifest.Publish.Artifactory != nil {
+		if err := timed("artifactory", func() error { return Artifactory(manifest, *manifest.Publish.Artifactory) }); err != nil {
+			return fmt.Errorf("failed to publish to artifactory: %v", err)
+		}
+	}
+	if manifest.Publish != nil && manifest.Publish.Nexus != nil {
+		if err := timed("nexus", func() error { return Nexus(manifest, *manifest.Publish.Nexus) }); err != nil {
+			return fmt.Errorf("failed to publish to nexus: %v", err)
+		}
+	}
+	if manifest.Publish != nil && manifest.Publish.CDN != nil {
+		aliases, latest := flags.s3alias, flags.s3latest
+		if !updatesAliases(manifest) {
+			aliases, latest = nil, ""
+		}
+		paths := changedPaths(flags.s3bucket, aliases, latest, flags.helmbucket)
+		if manifest.InstallScript != nil && flags.s3bucket != "" && !util.IsPrerelease(manifest.Version) {
+			_, prefix := splitBucket(flags.s3bucket)
+			paths = append(paths, "/"+path.Join(prefix, manifest.InstallScript.Name))
+		}
+		if err := timed("cdn", func() error { return InvalidateCDN(*manifest.Publish.CDN, paths) }); err != nil {
+			return fmt.Errorf("failed to invalidate cdn: %v", err)
+		}
+	}
 	if flags.github != "" {
 		token, err := util.GetGithubToken(flags.githubtoken)
 		if err != nil {
 			return err
 		}
-		if err := Github(manifest, flags.github, token); err != nil {
+		if err := timed("github", func() error { return Github(manifest, flags.github, token) }); err != nil {
 			return fmt.Errorf("failed to publish to github: %v", err)
 		}
 	}
-	if flags.grafanatoken != "" {
-		token, err := getGrafanaToken(flags.grafanatoken)
+	// Only an explicit --grafanatoken or publish.grafanaTokenEnv uploads dashboards; an ambient GRAFANA_TOKEN does not.
+	if flags.grafanatoken != "" || (manifest.Publish != nil && manifest.Publish.GrafanaTokenEnv != "" && os.Getenv(manifest.Publish.GrafanaTokenEnv) != "") {
+		token, err := getGrafanaToken(flags.grafanatoken, manifest.Publish)
 		if err != nil {
 			return err
 		}
 
-		if err := Grafana(manifest, token); err != nil {
-			return fmt.Errorf("failed to publish to github: %v", err)
+		if err := timed("grafana", func() error { return Grafana(manifest, token) }); err != nil {
+			return fmt.Errorf("failed to publish to grafana: %v", err)
+		}
+	}
+	if flags.downloadurl != "" && (manifest.BrewRepo != nil || manifest.KrewRepo != nil) {
+		token, err := util.GetGithubToken(flags.githubtoken)
+		if err != nil {
+			return err
+		}
+		if manifest.BrewRepo != nil {
+			if err := timed("brew", func() error { return Brew(manifest, flags.downloadurl, token) }); err != nil {
+				return fmt.Errorf("failed to update homebrew tap: %v", err)
+			}
+		}
+		if manifest.KrewRepo != nil {
+			if err := timed("krew", func() error { return Krew(manifest, flags.downloadurl, token) }); err != nil {
+				return fmt.Errorf("failed to update krew index: %v", err)
+			}
+		}
+	}
+	if manifest.WindowsPackages != nil && (manifest.WindowsPackages.ScoopRepo != nil || manifest.WindowsPackages.ChocolateyRepo != nil) {
+		token, err := util.GetGithubToken(flags.githubtoken)
+		if err != nil {
+			return err
+		}
+		if err := timed("windows-packages", func() error { return WindowsPackages(manifest, token) }); err != nil {
+			return fmt.Errorf("failed to publish windows packages: %v", err)
+		}
+	}
+	for _, p := range manifest.Plugins {
+		if p.Phase != plugin.Publish {
+			continue
+		}
+		if err := timed(plugin.StepName(p), func() error {
+			return plugin.Run(p, manifest, manifest.Directory, manifest.Directory)
+		}); err != nil {
+			return err
+		}
+	}
+	// Downstream repos are only updated once the release is fully published
+	if len(manifest.Downstream) > 0 {
+		token, err := util.GetGithubToken(flags.githubtoken)
+		if err != nil {
+			return err
+		}
+		if err := timed("downstream", func() error { return Downstream(manifest, token) }); err != nil {
+			return fmt.Errorf("failed to update downstream repos: %v", err)
 		}
 	}
 	return nil
 }
 
-func getGrafanaToken(file string) (string, error) {
+func getGrafanaToken(file string, p *model.PublishConfig) (string, error) {
 	if file != "" {
 		b, err := os.ReadFile(file)
 		if err != nil {
-			return "", fmt.Errorf("failed to read grafana token: %v", file)
+			return "", fmt.Errorf("failed to read grafana token %v: %v", file, err)
 		}
 		return strings.TrimSpace(string(b)), nil
 	}
-	return os.Getenv("GRAFANA_TOKEN"), nil
+	return os.Getenv(p.GrafanaTokenEnv), nil
 }
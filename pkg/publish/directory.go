@@ -0,0 +1,348 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/build"
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// Directory writes everything the configured targets would be sent into dir, rather than publishing to them.
+// Buckets are written to dir/s3/<bucket>, and images and charts to dir/registry/<hub>, with images as OCI image
+// layouts. Publishing several releases to the same directory updates the aliases, latest mirror, and helm index
+// as publishing to the buckets would.
+func Directory(manifest model.Manifest, dir string) error {
+	if flags.s3bucket != "" {
+		if err := directoryArchive(manifest, filepath.Join(dir, "s3", flags.s3bucket), flags.s3alias, flags.s3latest); err != nil {
+			return fmt.Errorf("failed to write s3 archive: %v", err)
+		}
+	}
+	if flags.helmbucket != "" || flags.helmhub != "" {
+		if err := directoryHelm(manifest, dir); err != nil {
+			return fmt.Errorf("failed to write helm charts: %v", err)
+		}
+	}
+	if flags.dockerhub != "" {
+		tags := flags.dockertags
+		if len(tags) == 0 {
+			tags = []string{manifest.Version}
+		}
+		if err := directoryImages(manifest, filepath.Join(dir, "registry"), flags.dockerhub, tags); err != nil {
+			return fmt.Errorf("failed to write images: %v", err)
+		}
+	}
+	return nil
+}
+
+// directoryArchive writes the release files, aliases, latest mirror, and install script as S3Archive and
+// InstallScript would upload them to the bucket.
+func directoryArchive(manifest model.Manifest, root string, aliases []string, latest string) error {
+	dest := filepath.Join(root, manifest.Version)
+	err := filepath.WalkDir(manifest.Directory, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(manifest.Directory, p)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if rel == manifest.ArtifactDir("docker", "") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return copyRecorded(p, filepath.Join(dest, rel))
+	})
+	if err != nil {
+		return err
+	}
+
+	// Aliases and the latest mirror only point to pre-releases for the daily and weekly channels, and the unversioned
+	// install script never does
+	if !updatesAliases(manifest) {
+		log.Infof("Not updating aliases or latest for pre-release %v", manifest.Version)
+		return nil
+	}
+	for _, alias := range aliases {
+		if err := os.WriteFile(filepath.Join(root, alias), []byte(manifest.Version), 0o644); err != nil {
+			return fmt.Errorf("failed to write alias %v: %v", alias, err)
+		}
+		recordObject("file://" + filepath.Join(root, alias))
+	}
+	current, err := semver.NewVersion(manifest.Version)
+	if err != nil {
+		log.Warnf("Not mirroring %v to latest: not a valid semver", manifest.Version)
+		return nil
+	}
+	newer, err := localNewerVersion(root, current)
+	if err != nil {
+		return err
+	}
+	if newer != "" {
+		log.Infof("Not mirroring %v to latest: %v is newer", manifest.Version, newer)
+		return nil
+	}
+	if manifest.InstallScript != nil && !util.IsPrerelease(manifest.Version) {
+		name := manifest.InstallScript.Name
+		if err := copyRecorded(filepath.Join(dest, name), filepath.Join(root, name)); err != nil {
+			return err
+		}
+	}
+	if latest != "" {
+		// Remove anything left over from the previous latest release
+		if err := os.RemoveAll(filepath.Join(root, latest)); err != nil {
+			return err
+		}
+		return filepath.WalkDir(dest, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(dest, p)
+			if err != nil {
+				return err
+			}
+			return copyRecorded(p, filepath.Join(root, latest, strings.ReplaceAll(rel, "-"+manifest.Version, "")))
+		})
+	}
+	return nil
+}
+
+// localNewerVersion returns a stable version in root newer than current, or an empty string if there is none.
+func localNewerVersion(root string, current *semver.Version) (string, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		v, err := semver.NewVersion(e.Name())
+		if !e.IsDir() || err != nil || v.Prerelease() != "" {
+			continue
+		}
+		if v.GreaterThan(current) {
+			return v.Original(), nil
+		}
+	}
+	return "", nil
+}
+
+// directoryHelm writes the charts of each channel as Helm would publish them.
+func directoryHelm(manifest model.Manifest, dir string) error {
+	targets, cleanup, err := helmTargets(manifest, flags.helmbucket, flags.helmhub)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+	for _, t := range targets {
+		if t.bucket != "" {
+			metadata, err := artifactHubMetadata(manifest, t.artifactHubID)
+			if err != nil {
+				return err
+			}
+			if err := directoryHelmIndex(t.root, filepath.Join(dir, "s3", t.bucket), t.bucket, metadata); err != nil {
+				return err
+			}
+		}
+		if t.hub != "" {
+			// Only the packaged charts helm would push are written
+			if err := copyCharts(t.root, filepath.Join(dir, "registry", t.hub)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// directoryHelmIndex writes the charts, and merges them into the helm index, as publishHelmIndex would.
+func directoryHelmIndex(helmPublishRoot string, root string, bucket string, metadata []byte) error {
+	if err := copyCharts(helmPublishRoot, root); err != nil {
+		return err
+	}
+	if metadata != nil {
+		if err := os.WriteFile(filepath.Join(root, artifactHubRepoFile), metadata, 0o644); err != nil {
+			return err
+		}
+		recordObject("file://" + filepath.Join(root, artifactHubRepoFile))
+	}
+	bucketName, objectPrefix := splitBucket(bucket)
+	args := []string{"repo", "index", ".", "--url", fmt.Sprintf("https://%s.storage.googleapis.com/%s", bucketName, objectPrefix)}
+	if util.FileExists(filepath.Join(root, "index.yaml")) {
+		args = append(args, "--merge", "index.yaml")
+	}
+	idxCmd := util.VerboseCommand("helm", args...)
+	idxCmd.Dir = root
+	if err := idxCmd.Run(); err != nil {
+		return fmt.Errorf("index repo: %v", err)
+	}
+	recordObject("file://" + filepath.Join(root, "index.yaml"))
+	return nil
+}
+
+// copyCharts copies the packaged charts, and those in chart subtype directories, to root.
+func copyCharts(src, root string) error {
+	for _, dir := range append([]string{""}, chartSubtypeDir...) {
+		entries, err := os.ReadDir(filepath.Join(src, dir))
+		if err != nil {
+			return err
+		}
+		for _, f := range entries {
+			if filepath.Ext(f.Name()) != ".tgz" {
+				continue
+			}
+			dest := filepath.Join(root, dir, f.Name())
+			if err := util.CopyFile(filepath.Join(src, dir, f.Name()), dest); err != nil {
+				return err
+			}
+			chart, version := model.ChartNameVersion(f.Name())
+			recordChart(chart, version, "file://"+dest)
+		}
+	}
+	return nil
+}
+
+// directoryImages writes each image, and wasm extension, to an OCI image layout at root/<hub>/<image>, with
+// each tag as a reference name. Multi-arch images are written as a single index, as they would be pushed.
+func directoryImages(manifest model.Manifest, root string, hub string, tags []string) error {
+	dockerDir := filepath.Join(manifest.Directory, manifest.ArtifactDir("docker", ""))
+	dockerArchives, err := os.ReadDir(dockerDir)
+	if err != nil {
+		return fmt.Errorf("failed to read docker output of release: %v", err)
+	}
+	for img, archs := range imageIndex(manifest, dockerArchives, hub, tags) {
+		images := []v1.Image{}
+		for _, arch := range archs {
+			archive := img.Image + img.VariantSuffix() + toSuffix(arch) + ".tar.gz"
+			i, err := tarball.Image(func() (io.ReadCloser, error) { return gzipFile(filepath.Join(dockerDir, archive)) }, nil)
+			if err != nil {
+				return fmt.Errorf("failed to read %v: %v", archive, err)
+			}
+			images = append(images, i)
+		}
+		ref, err := name.NewTag(publishedReference(img, archs))
+		if err != nil {
+			return err
+		}
+		dir := filepath.Join(root, hub, img.Image)
+		lp, err := openLayout(dir)
+		if err != nil {
+			return err
+		}
+		annotations := layout.WithAnnotations(map[string]string{"org.opencontainers.image.ref.name": ref.TagStr()})
+		var digest v1.Hash
+		if len(images) == 1 {
+			err = lp.AppendImage(images[0], annotations)
+			digest, _ = images[0].Digest()
+		} else {
+			var index v1.ImageIndex
+			if index, err = build.PlatformIndex(images); err == nil {
+				err = lp.AppendIndex(index, annotations)
+				digest, _ = index.Digest()
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write %v: %v", ref, err)
+		}
+		recordImage(ref.String(), digest.String())
+		log.Infof("Wrote %v to %v", ref, dir)
+	}
+
+	for _, w := range manifest.WasmExtensions {
+		idx, err := layout.ImageIndexFromPath(filepath.Join(manifest.Directory, manifest.ArtifactDir("wasm", ""), "oci", w.Name))
+		if err != nil {
+			return fmt.Errorf("failed to read wasm extension %v: %v", w.Name, err)
+		}
+		im, err := idx.IndexManifest()
+		if err != nil {
+			return err
+		}
+		if len(im.Manifests) != 1 {
+			return fmt.Errorf("expected a single wasm artifact for %v, found %d", w.Name, len(im.Manifests))
+		}
+		img, err := idx.Image(im.Manifests[0].Digest)
+		if err != nil {
+			return err
+		}
+		lp, err := openLayout(filepath.Join(root, hub, w.Name))
+		if err != nil {
+			return err
+		}
+		for _, tag := range tags {
+			if err := lp.AppendImage(img, layout.WithAnnotations(map[string]string{"org.opencontainers.image.ref.name": tag})); err != nil {
+				return fmt.Errorf("failed to write wasm extension %v: %v", w.Name, err)
+			}
+			recordImage(fmt.Sprintf("%s/%s:%s", hub, w.Name, tag), im.Manifests[0].Digest.String())
+		}
+	}
+	return nil
+}
+
+// openLayout opens the OCI image layout at dir, creating it if needed.
+func openLayout(dir string) (layout.Path, error) {
+	if lp, err := layout.FromPath(dir); err == nil {
+		return lp, nil
+	}
+	return layout.Write(dir, empty.Index)
+}
+
+// copyRecorded copies a file into the directory, recording it as published.
+func copyRecorded(src, dest string) error {
+	if err := util.CopyFile(src, dest); err != nil {
+		return err
+	}
+	recordObject("file://" + dest)
+	return nil
+}
+
+// gzipFile opens a gzipped file for reading.
+func gzipFile(file string) (io.ReadCloser, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return gzipReadCloser{gz, f}, nil
+}
+
+// gzipReadCloser closes both the gzip reader and the underlying file.
+type gzipReadCloser struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g gzipReadCloser) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
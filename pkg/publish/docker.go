@@ -18,19 +18,16 @@ import (
 	"fmt"
 	"os"
 	"path"
-	"path/filepath"
 	"strings"
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/daemon"
-	"github.com/google/go-containerregistry/pkg/v1/empty"
-	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
-	"github.com/google/go-containerregistry/pkg/v1/types"
 	"istio.io/istio/pkg/log"
 
+	"github.com/alauda-mesh/release-builder/pkg/build"
 	"github.com/alauda-mesh/release-builder/pkg/model"
 	"github.com/alauda-mesh/release-builder/pkg/util"
 )
@@ -82,7 +79,7 @@ func Docker(manifest model.Manifest, hub string, tags []string, cosignkey string
 	if len(tags) == 0 {
 		tags = []string{manifest.Version}
 	}
-	dockerArchives, err := os.ReadDir(path.Join(manifest.Directory, "docker"))
+	dockerArchives, err := os.ReadDir(path.Join(manifest.Directory, manifest.ArtifactDir("docker", "")))
 	if err != nil {
 		return fmt.Errorf("failed to read docker output of release: %v", err)
 	}
@@ -103,24 +100,28 @@ func Docker(manifest model.Manifest, hub string, tags []string, cosignkey string
 	// This becomes more complex because for multi-arch images, we want to push a single manifest but we have multiple tar files (one per arch).
 
 	// first, we will load all our images into the local docker daemon, and setup an index of Image -> architectures.
-	// Each entry will result in one upstream tag created.
-	images := map[Image][]string{}
 	for _, f := range dockerArchives {
+		// OCI layout directories are exported alongside the archives for offline distribution, and are not published
+		if f.IsDir() {
+			continue
+		}
 		if !strings.HasSuffix(f.Name(), "tar.gz") {
 			return fmt.Errorf("invalid image found in docker folder: %v", f.Name())
 		}
-		if err := util.VerboseCommand("docker", "load", "-i", path.Join(manifest.Directory, "docker", f.Name())).Run(); err != nil {
+		if err := util.VerboseCommand("docker", "load", "-i", path.Join(manifest.Directory, manifest.ArtifactDir("docker", ""), f.Name())).Run(); err != nil {
 			return fmt.Errorf("failed to load docker image %v: %v", f.Name(), err)
 		}
-		imageName, variant, arch := getImageNameVariant(f.Name())
-		for _, tag := range tags {
-			img := Image{
-				OriginalTag: fmt.Sprintf("%s/%s:%s", manifest.Docker, imageName, manifest.Version),
-				NewTag:      fmt.Sprintf("%s/%s:%s", hub, imageName, tag),
-				Variant:     variant,
-				Image:       imageName,
-			}
-			images[img] = append(images[img], arch)
+	}
+	images := imageIndex(manifest, dockerArchives, hub, tags)
+
+	// The docker CLI pushes single arch images, so needs to be logged in as well as the keychain
+	keychain := util.Keychain(manifest.Registries)
+	if err := util.DockerLogin(manifest.Registries, hub); err != nil {
+		return err
+	}
+	if !flags.force {
+		if err := checkImageOverwrites(manifest, images, keychain); err != nil {
+			return err
 		}
 	}
 
@@ -135,25 +136,29 @@ func Docker(manifest model.Manifest, hub string, tags []string, cosignkey string
 				return fmt.Errorf("failed to tag docker image %v->%v: %v", img.OriginalReference(arch), img.NewReference(arch), err)
 			}
 
-			if err := util.VerboseCommand("docker", "push", img.NewReference(arch)).Run(); err != nil {
+			if manifest.PinnedCharts {
+				// Pinned charts reference the digest computed from the archive, which docker push would change
+				if err := pushArchiveDigest(img.OriginalReference(arch), img.NewReference(arch), keychain); err != nil {
+					return err
+				}
+			} else if err := util.VerboseCommand("docker", "push", img.NewReference(arch)).Run(); err != nil {
 				return fmt.Errorf("failed to push docker image %v: %v", img.NewReference(arch), err)
 			}
 
+			imgRef, err := name.ParseReference(img.NewReference(arch))
+			if err != nil {
+				return fmt.Errorf("failed to parse image reference %v: %v", img.NewReference(arch), err)
+			}
+			desc, err := remote.Head(imgRef, remote.WithAuthFromKeychain(keychain))
+			if err != nil {
+				return fmt.Errorf("failed to get digest for %v: %v", imgRef, err)
+			}
+			digest := desc.Digest
+			recordImage(imgRef.String(), digest.String())
+
 			// Sign images *after* push -- cosign only works against real
 			// repositories (not valid against tarballs)
 			if cosignEnabled {
-				imgRef, err := name.ParseReference(img.NewReference(arch))
-				if err != nil {
-					return fmt.Errorf("failed to parse image reference %v: %v", img.NewReference(arch), err)
-				}
-				newImg, err := remote.Image(imgRef, remote.WithAuthFromKeychain(authn.DefaultKeychain))
-				if err != nil {
-					return fmt.Errorf("failed to load %v: %v", imgRef, err)
-				}
-				digest, err := newImg.Digest()
-				if err != nil {
-					return fmt.Errorf("failed to get digest for %v: %v", imgRef, err)
-				}
 				// We need to return the digest of the manifest, not the image. This is because the manifest is what is signed.
 				// This should return something like `gcr.io/istio-testing/pilot@sha256:1234`
 				if err := util.VerboseCommand("cosign", "sign", "--key", cosignkey, imgRef.Context().String()+"@"+digest.String(), "-y", "--recursive").Run(); err != nil {
@@ -161,10 +166,12 @@ func Docker(manifest model.Manifest, hub string, tags []string, cosignkey string
 				}
 			}
 		} else {
-			digest, err := publishManifest(img, archs)
+			digest, err := publishManifest(img, archs, keychain)
 			if err != nil {
 				return err
 			}
+			_, d, _ := strings.Cut(digest, "@")
+			recordImage(img.NewReference(""), d)
 			if cosignEnabled {
 				if err := util.VerboseCommand("cosign", "sign", "--key", cosignkey, digest, "-y", "--recursive").Run(); err != nil {
 					return fmt.Errorf("failed to sign image %v with key %v: %v", digest, cosignkey, err)
@@ -175,9 +182,125 @@ func Docker(manifest model.Manifest, hub string, tags []string, cosignkey string
 	return nil
 }
 
+// pushArchiveDigest pushes a loaded image as is, so it keeps the digest it has in the release archive.
+func pushArchiveDigest(src string, dst string, keychain authn.Keychain) error {
+	srcRef, err := name.ParseReference(src)
+	if err != nil {
+		return fmt.Errorf("failed to parse %v: %v", src, err)
+	}
+	dstRef, err := name.ParseReference(dst)
+	if err != nil {
+		return fmt.Errorf("failed to parse %v: %v", dst, err)
+	}
+	img, err := daemon.Image(srcRef)
+	if err != nil {
+		return fmt.Errorf("failed to load %v: %v", src, err)
+	}
+	if err := remote.Write(dstRef, img, remote.WithAuthFromKeychain(keychain)); err != nil {
+		return fmt.Errorf("failed to push docker image %v: %v", dst, err)
+	}
+	return nil
+}
+
+// Retag tags the images, and Wasm extensions, published to the hub for the from version with each of the tags,
+// defaulting to the version of the manifest. Tags are created from the published digests, so nothing is rebuilt or
+// pushed again, and existing signatures remain valid. As with Docker, only tags of the version are checked for
+// overwrites; other tags, such as latest, are expected to move.
+func Retag(manifest model.Manifest, hub string, from string, tags []string) error {
+	if len(tags) == 0 {
+		tags = []string{manifest.Version}
+	}
+	dockerArchives, err := os.ReadDir(path.Join(manifest.Directory, manifest.ArtifactDir("docker", "")))
+	if err != nil {
+		return fmt.Errorf("failed to read docker output of release: %v", err)
+	}
+	keychain := util.Keychain(manifest.Registries)
+	for img, archs := range imageIndex(manifest, dockerArchives, hub, []string{from}) {
+		for _, tag := range tags {
+			promoted := img
+			promoted.NewTag = fmt.Sprintf("%s/%s:%s", hub, img.Image, tag)
+			src, dst := publishedReference(img, archs), publishedReference(promoted, archs)
+			if err := retagImage(src, dst, tag == manifest.Version, keychain); err != nil {
+				return err
+			}
+		}
+	}
+	for _, w := range manifest.WasmExtensions {
+		for _, tag := range tags {
+			src := fmt.Sprintf("%s/%s:%s", hub, w.Name, from)
+			dst := fmt.Sprintf("%s/%s:%s", hub, w.Name, tag)
+			if err := retagImage(src, dst, tag == manifest.Version, keychain); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// retagImage tags the digest src currently points to as dst. Unless --force is passed, dst is first checked not to
+// point to another digest if check is set.
+func retagImage(src string, dst string, check bool, keychain authn.Keychain) error {
+	srcRef, err := name.ParseReference(src)
+	if err != nil {
+		return fmt.Errorf("failed to parse %v: %v", src, err)
+	}
+	dstRef, err := name.NewTag(dst)
+	if err != nil {
+		return fmt.Errorf("failed to parse %v: %v", dst, err)
+	}
+	desc, err := remote.Get(srcRef, remote.WithAuthFromKeychain(keychain))
+	if err != nil {
+		return fmt.Errorf("failed to get %v: %v", src, err)
+	}
+	if check && !flags.force {
+		if err := checkTagOverwrite(dstRef, desc.Digest, keychain); err != nil {
+			return err
+		}
+	}
+	if err := remote.Tag(dstRef, desc, remote.WithAuthFromKeychain(keychain)); err != nil {
+		return fmt.Errorf("failed to tag %v as %v: %v", src, dst, err)
+	}
+	recordImage(dstRef.String(), desc.Digest.String())
+	log.Infof("Tagged %v@%v as %v", srcRef.Context(), desc.Digest, dst)
+	return nil
+}
+
+// imageIndex builds the index of Image -> architectures for the docker archives in a release, skipping directories.
+// Each entry will result in one upstream tag created.
+func imageIndex(manifest model.Manifest, dockerArchives []os.DirEntry, hub string, tags []string) map[Image][]string {
+	images := map[Image][]string{}
+	for _, f := range dockerArchives {
+		// OCI layout directories are exported alongside the archives, and are not images themselves
+		if f.IsDir() {
+			continue
+		}
+		imageName, variant, arch := GetImageNameVariant(f.Name(), manifest.ImageVariants)
+		for _, tag := range tags {
+			img := Image{
+				OriginalTag: fmt.Sprintf("%s/%s:%s", manifest.Docker, imageName, manifest.Version),
+				NewTag:      fmt.Sprintf("%s/%s:%s", hub, imageName, tag),
+				Variant:     variant,
+				Image:       imageName,
+			}
+			images[img] = append(images[img], arch)
+		}
+	}
+	return images
+}
+
+// publishedReference returns the reference users will pull for an image. Multi-arch images are
+// published as a single manifest, while single arch images keep their arch suffix.
+func publishedReference(img Image, archs []string) string {
+	if len(archs) == 1 {
+		return img.NewReference(archs[0])
+	}
+	return img.NewReference("")
+}
+
 // publishManifest packages a single manifest for a multi-architecture image.
-func publishManifest(img Image, architectures []string) (string, error) {
-	log.Infof("creating manifest %v for architectures %v", img, architectures)
+func publishManifest(img Image, architectures []string, keychain authn.Keychain) (string, error) {
+	logger := log.WithLabels("artifact", img.NewReference(""))
+	logger.Infof("creating manifest %v for architectures %v", img, architectures)
 	// Typically we could just use `docker manifest create manifest images...`. However, we need to actually
 	// push source images first. We want to push these without a tag, so users never use them. Docker cannot
 	// push directly by tag, so here we are...
@@ -193,7 +316,7 @@ func publishManifest(img Image, architectures []string) (string, error) {
 		if err != nil {
 			return "", fmt.Errorf("failed to parse %v: %v", newImage, err)
 		}
-		log.Infof("starting push of %v for manifest (without tag)", origTagRef)
+		logger.Infof("starting push of %v for manifest (without tag)", origTagRef)
 		// We will load from OriginalReference, push to NewReference
 		img, err := daemon.Image(origTagRef)
 		if err != nil {
@@ -208,51 +331,27 @@ func publishManifest(img Image, architectures []string) (string, error) {
 		if err != nil {
 			return "", fmt.Errorf("failed to build digest reference for %v: %v", newImage, err)
 		}
-		if err := remote.Write(digestRef, img, remote.WithAuthFromKeychain(authn.DefaultKeychain)); err != nil {
+		writeOpts := []remote.Option{remote.WithAuthFromKeychain(keychain)}
+		var progress *util.Progress
+		if util.ProgressEnabled() {
+			progress = util.NewProgress(digestRef.String(), 0)
+			writeOpts = append(writeOpts, remote.WithProgress(progress.Updates()))
+		}
+		if err := remote.Write(digestRef, img, writeOpts...); err != nil {
 			return "", fmt.Errorf("failed to push %v: %v", newImage, err)
 		}
+		if progress != nil {
+			progress.Done(0)
+		}
 		craneImages = append(craneImages, img)
-		log.Infof("pushed %v for manifest", digestRef)
+		logger.Infof("pushed %v for manifest", digestRef)
 	}
 	// Now all the images are in the registry, build the manifest. We can't just utilize `docker manifest create`,
 	// since that would be too easy - docker requires the images are in the local daemon, and loading them changes the digest.
 	// Instead, we do it ourselves again.
-	var index v1.ImageIndex = empty.Index
-	index = mutate.IndexMediaType(index, types.DockerManifestList)
-	for _, img := range craneImages {
-		mt, err := img.MediaType()
-		if err != nil {
-			return "", fmt.Errorf("failed to get mediatype: %w", err)
-		}
-
-		h, err := img.Digest()
-		if err != nil {
-			return "", fmt.Errorf("failed to compute digest: %w", err)
-		}
-
-		size, err := img.Size()
-		if err != nil {
-			return "", fmt.Errorf("failed to compute size: %w", err)
-		}
-		cfg, err := img.ConfigFile()
-		if err != nil {
-			return "", fmt.Errorf("failed to get config file: %w", err)
-		}
-		index = mutate.AppendManifests(index, mutate.IndexAddendum{
-			Add: img,
-			Descriptor: v1.Descriptor{
-				MediaType: mt,
-				Size:      size,
-				Digest:    h,
-				Platform: &v1.Platform{
-					Architecture: cfg.Architecture,
-					OS:           cfg.OS,
-					OSVersion:    cfg.OSVersion,
-					Variant:      cfg.Variant,
-					Features:     nil,
-				},
-			},
-		})
+	index, err := build.PlatformIndex(craneImages)
+	if err != nil {
+		return "", err
 	}
 	// Get target name without arch suffix
 	manifest := img.NewReference("")
@@ -260,7 +359,7 @@ func publishManifest(img Image, architectures []string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to parse %v: %v", manifestRef, err)
 	}
-	if err := remote.MultiWrite(map[name.Reference]remote.Taggable{manifestRef: index}, remote.WithAuthFromKeychain(authn.DefaultKeychain)); err != nil {
+	if err := remote.MultiWrite(map[name.Reference]remote.Taggable{manifestRef: index}, remote.WithAuthFromKeychain(keychain)); err != nil {
 		return "", fmt.Errorf("failed to push %v: %v", manifestRef, err)
 	}
 	digest, err := index.Digest()
@@ -272,22 +371,8 @@ func publishManifest(img Image, architectures []string) (string, error) {
 	return manifestRef.Context().String() + "@" + digest.String(), nil
 }
 
-// getImageNameVariant determines the name of the image (eg, pilot) and variant (eg, distroless).
-// This is derived from the file name.
-func getImageNameVariant(fname string) (name string, variant string, arch string) {
-	imageName := strings.Split(fname, ".")[0]
-	if match, _ := filepath.Match("*-arm64", imageName); match {
-		arch = "arm64"
-		imageName = strings.TrimSuffix(imageName, "-arm64")
-	}
-	if match, _ := filepath.Match("*-distroless", imageName); match {
-		variant = "distroless"
-		imageName = strings.TrimSuffix(imageName, "-distroless")
-	}
-	if match, _ := filepath.Match("*-debug", imageName); match {
-		variant = "debug"
-		imageName = strings.TrimSuffix(imageName, "-debug")
-	}
-	name = imageName
-	return
+// GetImageNameVariant determines the name of the image (eg, pilot) and variant (eg, distroless).
+// This is derived from the file name, and the image variants built.
+func GetImageNameVariant(fname string, variants []string) (name string, variant string, arch string) {
+	return model.ImageNameVariant(fname, variants)
 }
@@ -0,0 +1,84 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"text/template"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// Downstream opens PRs against the downstream repos, updating their references to the release.
+// token is used for repos without their own credentials.
+func Downstream(manifest model.Manifest, token string) error {
+	for _, d := range manifest.Downstream {
+		repoToken, err := downstreamToken(d, token)
+		if err != nil {
+			return err
+		}
+		msg := fmt.Sprintf("Update Istio to %s", manifest.Version)
+		if err := updateRepo(manifest, d.Name, d.Repo, fmt.Sprintf("istio-%s", manifest.Version), msg, repoToken, func(dir string) error {
+			for _, e := range d.Edits {
+				if err := applyEdit(manifest, filepath.Join(dir, e.File), e); err != nil {
+					return fmt.Errorf("failed to edit %v: %v", e.File, err)
+				}
+			}
+			return nil
+		}); err != nil {
+			return fmt.Errorf("failed to update %v: %v", d.Name, err)
+		}
+	}
+	return nil
+}
+
+func downstreamToken(d model.DownstreamRepo, token string) (string, error) {
+	if d.GithubApp != nil {
+		return util.GetGithubAppToken(*d.GithubApp)
+	}
+	if d.TokenEnv != "" {
+		return os.Getenv(d.TokenEnv), nil
+	}
+	return token, nil
+}
+
+// applyEdit replaces all matches of the edit in the file with its rendered replacement.
+func applyEdit(manifest model.Manifest, file string, e model.FileEdit) error {
+	tmpl, err := template.New(e.File).Parse(e.Replace)
+	if err != nil {
+		return err
+	}
+	replacement := &bytes.Buffer{}
+	if err := tmpl.Execute(replacement, manifest); err != nil {
+		return err
+	}
+	by, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	match := regexp.MustCompile(e.Match)
+	if !match.Match(by) {
+		log.Warnf("No matches of %q in %v", e.Match, file)
+		return nil
+	}
+	return os.WriteFile(file, match.ReplaceAll(by, replacement.Bytes()), 0o644)
+}
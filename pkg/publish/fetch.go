@@ -0,0 +1,152 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg"
+	"github.com/alauda-mesh/release-builder/pkg/build"
+)
+
+// FetchRelease downloads a previously built release, such as s3://istio-staging/1.25.0, into a temporary
+// directory, so publishing can happen on a different machine than the build. The caller must remove the
+// returned directory.
+func FetchRelease(release string) (string, error) {
+	bucketName, prefix := splitBucket(strings.TrimPrefix(release, "s3://"))
+	prefix = strings.TrimSuffix(prefix, "/")
+	ctx := context.Background()
+	client, err := NewS3Client(ctx)
+	if err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp("", "release-publish")
+	if err != nil {
+		return "", err
+	}
+	fetched := 0
+	for obj := range client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: prefix + "/", Recursive: true}) {
+		if obj.Err != nil {
+			return dir, fmt.Errorf("failed to list objects: %v", obj.Err)
+		}
+		// Keys are untrusted, so must not write outside of the release, such as with ..
+		rel := strings.TrimPrefix(obj.Key, prefix+"/")
+		if !filepath.IsLocal(filepath.FromSlash(rel)) {
+			return dir, fmt.Errorf("invalid object %v: escapes the release", obj.Key)
+		}
+		dest := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := client.FGetObject(ctx, bucketName, obj.Key, dest, minio.GetObjectOptions{}); err != nil {
+			return dir, fmt.Errorf("failed to fetch %v: %v", obj.Key, err)
+		}
+		fetched++
+	}
+	if fetched == 0 {
+		return dir, fmt.Errorf("no release found at %v", release)
+	}
+	log.Infof("Fetched %d files from %v to %v", fetched, release, dir)
+	return dir, nil
+}
+
+// VerifyRelease checks a fetched release has a valid manifest, and every file in it is listed in its checksums, with
+// a matching sha256, so nothing was added or altered since it was built.
+func VerifyRelease(dir string) error {
+	manifest, err := pkg.ReadManifest(filepath.Join(dir, "manifest.yaml"))
+	if err != nil {
+		return err
+	}
+	if manifest.Version == "" {
+		return fmt.Errorf("release manifest has no version")
+	}
+	sums, err := readChecksums(filepath.Join(dir, build.ChecksumsFile))
+	if err != nil {
+		return err
+	}
+	var errs []error
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		// The published destinations are written by publish, so are not part of the build
+		if rel == build.ChecksumsFile || rel == PublishedFile {
+			return nil
+		}
+		want, f := sums[rel]
+		if !f {
+			errs = append(errs, fmt.Errorf("%v is not listed in %v", rel, build.ChecksumsFile))
+			return nil
+		}
+		delete(sums, rel)
+		got, err := fileSha(p)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v cannot be read: %v", rel, err))
+			return nil
+		}
+		if got != want {
+			errs = append(errs, fmt.Errorf("%v has sha256 %v, expected %v", rel, got, want))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to walk release: %v", err)
+	}
+	docker := manifest.ArtifactDir("docker", "") + "/"
+	for rel := range sums {
+		if strings.HasPrefix(rel, docker) {
+			errs = append(errs, fmt.Errorf("%v is listed in %v, but missing; was the release staged without --s3docker?", rel, build.ChecksumsFile))
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%v is listed in %v, but missing", rel, build.ChecksumsFile))
+	}
+	return errors.Join(errs...)
+}
+
+// readChecksums reads a checksums file, in the format of sha256sum, mapping each file to its sha256.
+func readChecksums(file string) (map[string]string, error) {
+	by, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("release has no checksums: %v", err)
+	}
+	return build.ParseChecksums(by)
+}
+
+func fileSha(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,172 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alauda-mesh/release-builder/pkg/build"
+)
+
+func TestVerifyRelease(t *testing.T) {
+	cases := []struct {
+		name  string
+		alter func(dir string) error
+		err   string
+	}{
+		{
+			name:  "unaltered",
+			alter: func(string) error { return nil },
+		},
+		{
+			name: "published destinations",
+			alter: func(dir string) error {
+				return os.WriteFile(filepath.Join(dir, PublishedFile), []byte("s3: []\n"), 0o644)
+			},
+		},
+		{
+			name: "reports added after build",
+			alter: func(dir string) error {
+				if err := os.WriteFile(filepath.Join(dir, "security", "istio-1.0.0.trivy.json"), []byte("{}"), 0o644); err != nil {
+					return err
+				}
+				return build.AddChecksums(dir, []string{"security/istio-1.0.0.trivy.json"})
+			},
+		},
+		{
+			name: "tampered",
+			alter: func(dir string) error {
+				return os.WriteFile(filepath.Join(dir, "rpm", "istio-sidecar.rpm"), []byte("tampered"), 0o644)
+			},
+			err: "rpm/istio-sidecar.rpm has sha256",
+		},
+		{
+			name: "extra",
+			alter: func(dir string) error {
+				return os.WriteFile(filepath.Join(dir, "rpm", "extra.rpm"), []byte("extra"), 0o644)
+			},
+			err: "rpm/extra.rpm is not listed",
+		},
+		{
+			name: "missing",
+			alter: func(dir string) error {
+				return os.Remove(filepath.Join(dir, "rpm", "istio-sidecar.rpm"))
+			},
+			err: "rpm/istio-sidecar.rpm is listed in SHA256SUMS, but missing",
+		},
+		{
+			name: "missing docker",
+			alter: func(dir string) error {
+				return os.RemoveAll(filepath.Join(dir, "docker"))
+			},
+			err: "staged without --s3docker",
+		},
+		{
+			name: "no checksums",
+			alter: func(dir string) error {
+				return os.Remove(filepath.Join(dir, build.ChecksumsFile))
+			},
+			err: "release has no checksums",
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for f, content := range map[string]string{
+				"manifest.yaml":             "version: 1.0.0\n",
+				"rpm/istio-sidecar.rpm":     "sidecar",
+				"docker/pilot-debug.tar.gz": "pilot",
+				"security/pilot.trivy.json": "{}",
+			} {
+				if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, f)), 0o750); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(dir, f), []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := build.WriteChecksums(dir); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.alter(dir); err != nil {
+				t.Fatal(err)
+			}
+			err := VerifyRelease(dir)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("expected release to verify, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestReadChecksums(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    map[string]string
+		err     bool
+	}{
+		{
+			name:    "valid",
+			content: "abc  manifest.yaml\ndef  rpm/istio-sidecar.rpm\n",
+			want:    map[string]string{"manifest.yaml": "abc", "rpm/istio-sidecar.rpm": "def"},
+		},
+		{
+			name:    "file names with spaces",
+			content: "abc  my file.txt\n",
+			want:    map[string]string{"my file.txt": "abc"},
+		},
+		{
+			name:    "single space",
+			content: "abc manifest.yaml\n",
+			err:     true,
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), build.ChecksumsFile)
+			if err := os.WriteFile(file, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readChecksums(file)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("%v: got %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
@@ -51,6 +51,9 @@ func Github(manifest model.Manifest, githubOrg string, githubToken string) error
 			log.Warnf("skipping missing dependency %v", repo)
 			continue
 		}
+		if dep.Dirty {
+			return fmt.Errorf("refusing to tag repo %v: it was built with uncommitted changes", repo)
+		}
 		// Do not use dep.Org, as the source org is not necessarily the same as the publishing org
 		if err := GithubTag(client, githubOrg, repo, manifest.Version, dep.GoVersionEnabled, dep.Sha); err != nil {
 			return fmt.Errorf("failed to tag repo %v: %v", repo, err)
@@ -78,13 +81,14 @@ func GithubRelease(manifest model.Manifest, client *github.Client, githuborg str
 		TagName:    &manifest.Version,
 		Body:       &body,
 		Draft:      &ptrue,
-		Prerelease: &ptrue,
+		Prerelease: github.Bool(util.IsPrerelease(manifest.Version)),
 		Name:       &relName,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to publish github release: %v", err)
 	}
 	util.YamlLog("Release", rel)
+	recordGithubRelease(rel.GetHTMLURL())
 
 	if err := GithubUploadReleaseAssets(ctx, manifest, client, githuborg, rel); err != nil {
 		return fmt.Errorf("failed to publish github release assets: %v", err)
@@ -100,22 +104,44 @@ func GithubUploadReleaseAssets(ctx context.Context, manifest model.Manifest, cli
 	for _, file := range files {
 		fname := file.Name()
 		if githubArtifiactsPattern.MatchString(fname) {
-			log.Infof("github: uploading file %v", fname)
-			f, err := os.Open(path.Join(manifest.Directory, fname))
-			if err != nil {
-				return fmt.Errorf("failed to read file %v: %v", fname, err)
-			}
-			asset, _, err := client.Repositories.UploadReleaseAsset(ctx, githuborg, "istio", *rel.ID, &github.UploadOptions{
-				Name: fname,
-			}, f)
-			if err != nil {
-				return fmt.Errorf("failed to upload asset %v: %v", fname, err)
+			if err := githubUploadReleaseAsset(ctx, client, githuborg, rel, path.Join(manifest.Directory, fname)); err != nil {
+				return err
 			}
-			util.YamlLog("Release asset", asset)
 		} else {
 			log.Infof("github: skipping upload of file %v", fname)
 		}
 	}
+	// The ztunnel binaries are released alongside istio
+	if manifest.Ztunnel != nil {
+		dir := path.Join(manifest.Directory, manifest.ArtifactDir("ztunnel", ""))
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := githubUploadReleaseAsset(ctx, client, githuborg, rel, path.Join(dir, file.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func githubUploadReleaseAsset(ctx context.Context, client *github.Client, githuborg string, rel *github.RepositoryRelease, file string) error {
+	fname := path.Base(file)
+	log.Infof("github: uploading file %v", fname)
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("failed to read file %v: %v", fname, err)
+	}
+	defer f.Close()
+	asset, _, err := client.Repositories.UploadReleaseAsset(ctx, githuborg, "istio", *rel.ID, &github.UploadOptions{
+		Name: fname,
+	}, f)
+	if err != nil {
+		return fmt.Errorf("failed to upload asset %v: %v", fname, err)
+	}
+	util.YamlLog("Release asset", asset)
 	return nil
 }
 
@@ -28,11 +28,12 @@ import (
 	"github.com/alauda-mesh/release-builder/pkg/model"
 )
 
-// Grafana publishes the grafana dashboards to grafana.com
+// Grafana publishes the grafana dashboards to grafana.com, as new revisions of the existing dashboards.
+// The dashboards are extracted and stamped with the version by the build.
 func Grafana(manifest model.Manifest, token string) error {
 	for db, id := range manifest.GrafanaDashboards {
 		url := fmt.Sprintf("https://grafana.com/api/dashboards/%d/revisions", id)
-		dashboard := filepath.Join(manifest.Directory, "grafana", db+".json")
+		dashboard := filepath.Join(manifest.Directory, manifest.ArtifactDir("grafana", ""), db+".json")
 		req, err := fileUploadRequest(url, "json", dashboard)
 		if err != nil {
 			return fmt.Errorf("failed to create request for %v: %v", db, err)
@@ -43,6 +44,10 @@ func Grafana(manifest model.Manifest, token string) error {
 			return fmt.Errorf("request to update %v failed: %v", db, err)
 		}
 		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("failed to upload %v: %v %v", db, resp.StatusCode, string(body))
+		}
 		log.Infof("Dashboard %v uploaded with code: %v. Body: %v", db, resp.StatusCode, string(body))
 	}
 
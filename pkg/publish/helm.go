@@ -20,8 +20,10 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/minio/minio-go/v7"
 	"istio.io/istio/pkg/log"
 	"sigs.k8s.io/yaml"
@@ -35,24 +37,138 @@ var chartSubtypeDir = []string{
 	"samples",
 }
 
-// Helm publishes charts to the given GCS bucket
+// Helm publishes charts to the given GCS bucket and oci hub. Charts in a channel other than stable are published
+// to the repositories of their channel instead, each with its own index.yaml.
 func Helm(manifest model.Manifest, bucket string, hub string) error {
-	if bucket != "" {
-		if err := publishHelmIndex(manifest, bucket); err != nil {
+	targets, cleanup, err := helmTargets(manifest, bucket, hub)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+	for _, t := range targets {
+		metadata, err := artifactHubMetadata(manifest, t.artifactHubID)
+		if err != nil {
 			return err
 		}
-	}
+		if t.bucket != "" {
+			if err := publishHelmIndex(manifest, t.bucket, t.root, metadata); err != nil {
+				return err
+			}
+		}
 
-	if hub != "" {
-		if err := publishHelmOCI(manifest, hub); err != nil {
-			return err
+		if t.hub != "" {
+			if err := publishHelmOCI(manifest, t.root, t.hub, metadata); err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
-func publishHelmIndex(manifest model.Manifest, bucket string) error {
+// helmTarget is the packaged charts of a channel, and where they are published.
+type helmTarget struct {
+	channel       string
+	root          string
+	bucket        string
+	hub           string
+	artifactHubID string
+}
+
+// helmTargets splits the packaged charts by channel. Stable charts are published to bucket and hub, and the others
+// to publish.helmChannels. Without chart channels, the release helm directory is used as is. Otherwise, each
+// channel is copied to a temporary directory, keeping chart subtype directories, which cleanup removes.
+func helmTargets(manifest model.Manifest, bucket string, hub string) ([]helmTarget, func(), error) {
+	helmPublishRoot := filepath.Join(manifest.Directory, manifest.ArtifactDir("helm", ""))
+	if len(manifest.ChartChannels) == 0 {
+		return []helmTarget{{
+			channel:       model.StableChannel,
+			root:          helmPublishRoot,
+			bucket:        bucket,
+			hub:           hub,
+			artifactHubID: stableArtifactHubID(manifest),
+		}}, func() {}, nil
+	}
+	tmpDir, err := os.MkdirTemp("", "helm-channels")
+	if err != nil {
+		return nil, func() {}, err
+	}
+	cleanup := func() { os.RemoveAll(tmpDir) }
+
+	targets := map[string]*helmTarget{}
+	for _, dir := range append([]string{""}, chartSubtypeDir...) {
+		entries, err := os.ReadDir(filepath.Join(helmPublishRoot, dir))
+		if err != nil {
+			return nil, cleanup, err
+		}
+		for _, f := range entries {
+			if filepath.Ext(f.Name()) != ".tgz" {
+				continue
+			}
+			chart, _ := model.ChartNameVersion(f.Name())
+			channel := manifest.ChartChannel(chart)
+			t, found := targets[channel]
+			if !found {
+				if t, err = newHelmTarget(manifest, channel, filepath.Join(tmpDir, channel), bucket, hub); err != nil {
+					return nil, cleanup, fmt.Errorf("chart %v: %v", chart, err)
+				}
+				targets[channel] = t
+			}
+			if err := util.CopyFile(filepath.Join(helmPublishRoot, dir, f.Name()), filepath.Join(t.root, dir, f.Name())); err != nil {
+				return nil, cleanup, err
+			}
+			log.Infof("Publishing chart %v to the %v channel", f.Name(), channel)
+		}
+	}
+	channels := make([]string, 0, len(targets))
+	for c := range targets {
+		channels = append(channels, c)
+	}
+	sort.Strings(channels)
+	res := []helmTarget{}
+	for _, c := range channels {
+		res = append(res, *targets[c])
+	}
+	return res, cleanup, nil
+}
+
+// newHelmTarget creates the charts directory of a channel, with every chart subtype directory publishing expects.
+func newHelmTarget(manifest model.Manifest, channel string, root string, bucket string, hub string) (*helmTarget, error) {
+	t := &helmTarget{channel: channel, root: root, bucket: bucket, hub: hub, artifactHubID: stableArtifactHubID(manifest)}
+	if channel != model.StableChannel {
+		var dest model.HelmChannel
+		found := false
+		if manifest.Publish != nil {
+			dest, found = manifest.Publish.HelmChannels[channel]
+		}
+		if !found {
+			return nil, fmt.Errorf("channel %v has no publish.helmChannels repository", channel)
+		}
+		t.bucket, t.hub, t.artifactHubID = dest.Bucket, dest.Hub, dest.ArtifactHubRepositoryID
+	}
+	for _, sub := range append([]string{""}, chartSubtypeDir...) {
+		if err := os.MkdirAll(filepath.Join(root, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// stableArtifactHubID returns the ArtifactHub repository ID of the stable chart repository.
+func stableArtifactHubID(manifest model.Manifest) string {
+	if manifest.Publish == nil || manifest.Publish.ArtifactHub == nil {
+		return ""
+	}
+	return manifest.Publish.ArtifactHub.RepositoryID
+}
+
+// publishHelmIndex merges the charts into the index.yaml of the bucket, and uploads them. The ArtifactHub repository
+// metadata is uploaded alongside index.yaml, if set.
+func publishHelmIndex(manifest model.Manifest, bucket string, helmPublishRoot string, metadata []byte) error {
+	objectTags, err := s3Tags(manifest, flags.s3.Tags)
+	if err != nil {
+		return err
+	}
 	ctx := context.Background()
 	client, err := NewS3Client(ctx)
 	if err != nil {
@@ -68,8 +184,6 @@ func publishHelmIndex(manifest model.Manifest, bucket string) error {
 		objectPrefix = splitbucket[1]
 	}
 
-	helmPublishRoot := filepath.Join(manifest.Directory, "helm")
-
 	// Pull down the index, update it, and push it back up.
 	// MutateObject ensures there are no races.
 	//
@@ -101,14 +215,27 @@ func publishHelmIndex(manifest model.Manifest, bucket string) error {
 		dumpIndex(liveObject, "live")
 	}
 
+	if metadata != nil {
+		file := filepath.Join(helmPublishRoot, artifactHubRepoFile)
+		if err := os.WriteFile(file, metadata, 0o644); err != nil {
+			return err
+		}
+		objName := path.Join(objectPrefix, artifactHubRepoFile)
+		if err := putVerified(ctx, client, bucketName, objName, file, minio.PutObjectOptions{ContentType: contentType(objName), UserTags: objectTags}); err != nil {
+			return fmt.Errorf("failed writing %v: %v", artifactHubRepoFile, err)
+		}
+		log.Infof("Wrote %v to s3://%s/%s", artifactHubRepoFile, bucketName, objName)
+		recordObject(fmt.Sprintf("https://%s.storage.googleapis.com/%s", bucketName, objName))
+	}
+
 	// Now push all the packaged charts in the helm root directory up
-	if err := publishHelmBucket(ctx, helmPublishRoot, objectPrefix, bucketName, client); err != nil {
+	if err := publishHelmBucket(ctx, helmPublishRoot, objectPrefix, bucketName, client, objectTags); err != nil {
 		return err
 	}
 
 	// For any packaged charts in "chart subtype" subdirectories ("samples" etc), push those up
 	for _, chartType := range chartSubtypeDir {
-		if err := publishHelmBucket(ctx, filepath.Join(helmPublishRoot, chartType), path.Join(objectPrefix, chartType), bucketName, client); err != nil {
+		if err := publishHelmBucket(ctx, filepath.Join(helmPublishRoot, chartType), path.Join(objectPrefix, chartType), bucketName, client, objectTags); err != nil {
 			return err
 		}
 	}
@@ -116,7 +243,7 @@ func publishHelmIndex(manifest model.Manifest, bucket string) error {
 	return nil
 }
 
-func publishHelmBucket(ctx context.Context, packagedChartOutputDir, publishPrefix, bName string, client *minio.Client) error {
+func publishHelmBucket(ctx context.Context, packagedChartOutputDir, publishPrefix, bName string, client *minio.Client, objectTags map[string]string) error {
 	dirInfo, err := os.ReadDir(packagedChartOutputDir)
 	if err != nil {
 		return err
@@ -129,12 +256,13 @@ func publishHelmBucket(ctx context.Context, packagedChartOutputDir, publishPrefi
 		objName := path.Join(publishPrefix, f.Name())
 
 		fileName := filepath.Join(packagedChartOutputDir, f.Name())
-		_, err = client.FPutObject(ctx, bName, objName, fileName, minio.PutObjectOptions{})
-		if err != nil {
+		if err := putVerified(ctx, client, bName, objName, fileName, minio.PutObjectOptions{ContentType: contentType(objName), UserTags: objectTags}); err != nil {
 			return fmt.Errorf("failed writing %v: %v", f.Name(), err)
 		}
 
 		log.Infof("Wrote %v to s3://%s/%s", f.Name(), bName, objName)
+		chart, version := model.ChartNameVersion(f.Name())
+		recordChart(chart, version, fmt.Sprintf("https://%s.storage.googleapis.com/%s", bName, objName))
 	}
 
 	return nil
@@ -173,17 +301,18 @@ func dumpIndex(data []byte, context string) {
 	log.Infof("index.yaml contents %v: %v", context, versions)
 }
 
-func publishHelmOCI(manifest model.Manifest, hub string) error {
-	helmPublishRoot := filepath.Join(manifest.Directory, "helm")
-
+// publishHelmOCI pushes the charts to the hub. The ArtifactHub repository metadata is pushed to each chart
+// repository, if set.
+func publishHelmOCI(manifest model.Manifest, helmPublishRoot string, hub string, metadata []byte) error {
+	keychain := util.Keychain(manifest.Registries)
 	// Now push all the packaged charts in the helm root directory up
-	if err := pushChartsInDirOCI(helmPublishRoot, hub); err != nil {
+	if err := pushChartsInDirOCI(helmPublishRoot, hub, metadata, keychain); err != nil {
 		return err
 	}
 
 	// For any packaged charts in "chart subtype" subdirectories ("samples" etc), push those up
 	for _, chartType := range chartSubtypeDir {
-		if err := pushChartsInDirOCI(filepath.Join(helmPublishRoot, chartType), path.Join(hub, chartType)); err != nil {
+		if err := pushChartsInDirOCI(filepath.Join(helmPublishRoot, chartType), path.Join(hub, chartType), metadata, keychain); err != nil {
 			return err
 		}
 	}
@@ -191,7 +320,7 @@ func publishHelmOCI(manifest model.Manifest, hub string) error {
 	return nil
 }
 
-func pushChartsInDirOCI(packagedChartOutputDir, hub string) error {
+func pushChartsInDirOCI(packagedChartOutputDir, hub string, metadata []byte, keychain authn.Keychain) error {
 	dirInfo, err := os.ReadDir(packagedChartOutputDir)
 	if err != nil {
 		return err
@@ -202,9 +331,21 @@ func pushChartsInDirOCI(packagedChartOutputDir, hub string) error {
 			continue
 		}
 		name := filepath.Join(packagedChartOutputDir, f.Name())
-		if err := util.VerboseCommand("helm", "push", name, "oci://"+hub).Run(); err != nil {
+		args := []string{"push", name, "oci://" + hub}
+		// Local registries, such as in tests, do not serve TLS
+		if strings.HasPrefix(hub, "localhost") || strings.HasPrefix(hub, "127.0.0.1") {
+			args = append(args, "--plain-http")
+		}
+		if err := util.VerboseCommand("helm", args...).Run(); err != nil {
 			return fmt.Errorf("failed to load docker image %v: %v", f.Name(), err)
 		}
+		chart, version := model.ChartNameVersion(f.Name())
+		recordChart(chart, version, fmt.Sprintf("oci://%s/%s:%s", hub, chart, version))
+		if metadata != nil {
+			if err := pushArtifactHubMetadata(path.Join(hub, chart), metadata, keychain); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
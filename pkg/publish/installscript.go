@@ -0,0 +1,81 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"github.com/Masterminds/semver/v3"
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// InstallScript publishes the install script to the version in the bucket, and, if this is the newest
+// release, to the unversioned script users download the latest release with.
+func InstallScript(manifest model.Manifest, bucket string, opts model.S3Options) error {
+	sse, err := s3Encryption(opts)
+	if err != nil {
+		return err
+	}
+	if opts.Tags, err = s3Tags(manifest, opts.Tags); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	client, err := NewS3Client(ctx)
+	if err != nil {
+		return err
+	}
+	bucketName, objectPrefix := splitBucket(bucket)
+	name := manifest.InstallScript.Name
+	file := filepath.Join(manifest.Directory, name)
+	put := func(objName string) error {
+		putOpts := s3PutOptions(opts, sse, objName)
+		putOpts.ContentType = "text/plain; charset=utf-8"
+		if err := putVerified(ctx, client, bucketName, objName, file, putOpts); err != nil {
+			return err
+		}
+		log.Infof("Wrote %v to s3://%s/%s", name, bucketName, objName)
+		return nil
+	}
+
+	if err := put(path.Join(objectPrefix, manifest.Version, name)); err != nil {
+		return err
+	}
+	current, err := semver.NewVersion(manifest.Version)
+	if err != nil {
+		log.Warnf("Not updating the latest %v: %v is not a valid semver", name, manifest.Version)
+		return nil
+	}
+	if current.Prerelease() != "" {
+		log.Infof("Not updating the latest %v: %v is a pre-release", name, manifest.Version)
+		return nil
+	}
+	newer, err := newerVersion(ctx, client, bucketName, objectPrefix, current)
+	if err != nil {
+		return err
+	}
+	if newer != "" {
+		log.Infof("Not updating the latest %v: %v is newer", name, newer)
+		return nil
+	}
+	if err := put(path.Join(objectPrefix, name)); err != nil {
+		return fmt.Errorf("failed to update latest %v: %v", name, err)
+	}
+	return nil
+}
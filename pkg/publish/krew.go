@@ -0,0 +1,66 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// Krew opens a PR updating the istioctl plugin manifest in the krew index.
+func Krew(manifest model.Manifest, downloadURL string, token string) error {
+	plugin, err := krewPluginFor(manifest, downloadURL)
+	if err != nil {
+		return err
+	}
+	return updatePackageRepo(manifest, "krew-index", manifest.KrewRepo, map[string][]byte{
+		"plugins/istioctl.yaml": plugin,
+	}, token)
+}
+
+func krewPluginFor(manifest model.Manifest, downloadURL string) ([]byte, error) {
+	artifacts, err := istioctlArtifacts(manifest, downloadURL)
+	if err != nil {
+		return nil, err
+	}
+	platforms := []map[string]any{}
+	for _, a := range artifacts {
+		bin := "istioctl"
+		if a.OS == "windows" {
+			bin = "istioctl.exe"
+		}
+		platforms = append(platforms, map[string]any{
+			"selector": map[string]any{
+				"matchLabels": map[string]string{"os": a.OS, "arch": a.Arch},
+			},
+			"uri":    a.URL,
+			"sha256": a.Sha,
+			"bin":    bin,
+		})
+	}
+	return yaml.Marshal(map[string]any{
+		"apiVersion": "krew.googlecontainertools.github.com/v1alpha2",
+		"kind":       "Plugin",
+		"metadata":   map[string]any{"name": "istioctl"},
+		"spec": map[string]any{
+			"version":          "v" + manifest.Version,
+			"homepage":         "https://istio.io/",
+			"shortDescription": "Istio configuration command-line utility",
+			"description":      "istioctl is a command-line utility to configure, debug, and diagnose Istio service meshes.",
+			"platforms":        platforms,
+		},
+	})
+}
@@ -0,0 +1,301 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/spf13/pflag"
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	lockObject = ".release-builder.lock"
+	// lockLease is how long a lock is held without a heartbeat before it is considered stale
+	lockLease = 2 * time.Minute
+)
+
+// lockInfo is the content of the lock object.
+type lockInfo struct {
+	Owner   string    `json:"owner"`
+	Version string    `json:"version"`
+	Expires time.Time `json:"expires"`
+	// Released is set once the holder is done, so the lock is free to take.
+	Released bool `json:"released,omitempty"`
+}
+
+// Lock is a lease on a bucket prefix, held while publishing so concurrent publishes do not race on index.yaml
+// and alias objects. The lease is renewed by a heartbeat until released. If the lease cannot be renewed, the lock is
+// lost, and Err reports it so publishing can stop before writing without it.
+type Lock struct {
+	client *minio.Client
+	bucket string
+	key    string
+	info   lockInfo
+
+	mu      sync.Mutex
+	etag    string
+	renewed time.Time
+	lost    error
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// AcquireLock takes the publish lock for the bucket, waiting up to timeout for another publish to release it.
+// Locks not renewed within their lease are taken over.
+func AcquireLock(bucket, owner, version string, timeout time.Duration) (*Lock, error) {
+	ctx := context.Background()
+	client, err := NewS3Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bucketName, prefix := splitBucket(bucket)
+	l := &Lock{
+		client: client,
+		bucket: bucketName,
+		key:    path.Join(prefix, lockObject),
+		info:   lockInfo{Owner: owner, Version: version},
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		held, err := l.tryAcquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if held == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock s3://%s/%s held by %v publishing %v", l.bucket, l.key, held.Owner, held.Version)
+		}
+		log.Infof("Waiting for lock s3://%s/%s held by %v publishing %v", l.bucket, l.key, held.Owner, held.Version)
+		time.Sleep(10 * time.Second)
+	}
+	log.Infof("Acquired lock s3://%s/%s", l.bucket, l.key)
+
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	go l.heartbeat()
+	return l, nil
+}
+
+// tryAcquire attempts to take the lock once, returning the current holder if it is held by another publish.
+func (l *Lock) tryAcquire(ctx context.Context) (*lockInfo, error) {
+	// Create the lock only if it does not exist
+	err := l.write(ctx, "")
+	if err == nil {
+		return nil, nil
+	}
+	if minio.ToErrorResponse(err).Code != "PreconditionFailed" {
+		return nil, fmt.Errorf("failed to create lock: %v", err)
+	}
+
+	obj, err := l.client.GetObject(ctx, l.bucket, l.key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock: %v", err)
+	}
+	defer obj.Close()
+	stat, err := obj.Stat()
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			// Released in the meantime
+			return l.tryAcquire(ctx)
+		}
+		return nil, fmt.Errorf("failed to read lock: %v", err)
+	}
+	by, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock: %v", err)
+	}
+	held := &lockInfo{}
+	if err := json.Unmarshal(by, held); err != nil {
+		return nil, fmt.Errorf("failed to parse lock: %v", err)
+	}
+	if !held.Released && time.Now().Before(held.Expires) {
+		return held, nil
+	}
+
+	// The holder released the lock, or stopped renewing its lease. Take it over, unless another publish did first.
+	if !held.Released {
+		log.Warnf("Taking over stale lock held by %v publishing %v, which expired at %v", held.Owner, held.Version, held.Expires)
+	}
+	if err := l.write(ctx, stat.ETag); err != nil {
+		if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+			return held, nil
+		}
+		return nil, fmt.Errorf("failed to take over lock: %v", err)
+	}
+	return nil, nil
+}
+
+// write writes the lock object with a new lease. If etag is set, the current lock must match it, otherwise the
+// lock must not exist.
+func (l *Lock) write(ctx context.Context, etag string) error {
+	now := time.Now()
+	info := l.info
+	info.Expires = now.Add(lockLease)
+	by, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	opts := minio.PutObjectOptions{ContentType: "application/json"}
+	if etag == "" {
+		opts.SetMatchETagExcept("*")
+	} else {
+		opts.SetMatchETag(etag)
+	}
+	res, err := l.client.PutObject(ctx, l.bucket, l.key, bytes.NewReader(by), int64(len(by)), opts)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.etag = res.ETag
+	l.renewed = now
+	l.mu.Unlock()
+	return nil
+}
+
+// heartbeat renews the lease until the lock is released, or lost. The lock is lost if another publish took it over,
+// or if it was not renewed within its lease.
+func (l *Lock) heartbeat() {
+	defer close(l.done)
+	ticker := time.NewTicker(lockLease / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			etag, renewed := l.etag, l.renewed
+			l.mu.Unlock()
+			err := l.write(context.Background(), etag)
+			if err == nil {
+				continue
+			}
+			if minio.ToErrorResponse(err).Code == "PreconditionFailed" || time.Since(renewed) >= lockLease {
+				log.Errorf("Lost lock s3://%s/%s: %v", l.bucket, l.key, err)
+				l.mu.Lock()
+				l.lost = fmt.Errorf("lost lock s3://%s/%s: %v", l.bucket, l.key, err)
+				l.mu.Unlock()
+				return
+			}
+			log.Warnf("Failed to renew lock s3://%s/%s, retrying: %v", l.bucket, l.key, err)
+		}
+	}
+}
+
+// Err returns an error if the lock was lost, in which case publishing must stop.
+func (l *Lock) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+// Release stops the heartbeat and marks the lock released. The release is conditional on the lock still being ours,
+// so a publish that took over the lock keeps it.
+func (l *Lock) Release() error {
+	close(l.stop)
+	<-l.done
+	if err := l.Err(); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	etag := l.etag
+	l.mu.Unlock()
+	info := l.info
+	info.Released = true
+	by, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	opts := minio.PutObjectOptions{ContentType: "application/json"}
+	opts.SetMatchETag(etag)
+	if _, err := l.client.PutObject(context.Background(), l.bucket, l.key, bytes.NewReader(by), int64(len(by)), opts); err != nil {
+		return fmt.Errorf("failed to release lock: %v", err)
+	}
+	log.Infof("Released lock s3://%s/%s", l.bucket, l.key)
+	return nil
+}
+
+// lockBuckets returns the distinct buckets written by a publish, which must each be locked.
+func lockBuckets(buckets ...string) []string {
+	locked := []string{}
+	for _, b := range buckets {
+		if b != "" && !slices.Contains(locked, b) {
+			locked = append(locked, b)
+		}
+	}
+	// Locks are always taken in the same order, so concurrent publishes to the same buckets do not deadlock
+	sort.Strings(locked)
+	return locked
+}
+
+// heldLocks are the locks of every bucket a command writes to.
+type heldLocks []*Lock
+
+// acquireLocks takes the lock of each of the buckets, as returned by lockBuckets, for version. If any cannot be taken,
+// those already taken are released.
+func acquireLocks(version string, buckets []string) (heldLocks, error) {
+	hostname, _ := os.Hostname()
+	locks := heldLocks{}
+	for _, bucket := range buckets {
+		lock, err := AcquireLock(bucket, flags.publisher+"@"+hostname, version, flags.locktimeout)
+		if err != nil {
+			locks.Release()
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+	return locks, nil
+}
+
+// Err returns the error of the first lock lost, if any.
+func (h heldLocks) Err() error {
+	for _, lock := range h {
+		if err := lock.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Release releases each lock, logging those that could not be.
+func (h heldLocks) Release() {
+	for _, lock := range h {
+		if err := lock.Release(); err != nil {
+			log.Warnf("%v", err)
+		}
+	}
+}
+
+// addLockFlags adds the flags configuring how locks are held to a command that takes them.
+func addLockFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&flags.publisher, "publisher", flags.publisher,
+		"The name recorded as holding publish locks. Defaults to $USER.")
+	fs.DurationVar(&flags.locktimeout, "locktimeout", flags.locktimeout,
+		"How long to wait for another publish to release the lock.")
+}
@@ -0,0 +1,163 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// nexus uploads components to a Nexus instance.
+type nexus struct {
+	url      string
+	username string
+	password string
+}
+
+// Nexus uploads the release files to the raw repository, under the version, the rpm packages to the yum
+// repository, under the version, and the deb packages to the apt repository. Nexus generates the yum and apt
+// metadata itself.
+func Nexus(manifest model.Manifest, config model.NexusConfig) error {
+	n, err := newNexus(config)
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(manifest.Directory, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(manifest.Directory, p)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if rel == manifest.ArtifactDir("docker", "") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		dir := path.Join("/", manifest.Version, path.Dir(filepath.ToSlash(rel)))
+		if config.RawRepo != "" {
+			if err := n.upload(config.RawRepo, map[string]string{
+				"raw.directory":       dir,
+				"raw.asset1.filename": d.Name(),
+			}, "raw.asset1", p); err != nil {
+				return err
+			}
+			recordObject(n.assetURL(config.RawRepo, path.Join(dir, d.Name())))
+		}
+		switch {
+		case config.YumRepo != "" && filepath.Ext(p) == ".rpm":
+			if err := n.upload(config.YumRepo, map[string]string{
+				"yum.directory":      manifest.Version,
+				"yum.asset.filename": d.Name(),
+			}, "yum.asset", p); err != nil {
+				return err
+			}
+			recordObject(n.assetURL(config.YumRepo, path.Join(manifest.Version, d.Name())))
+		case config.AptRepo != "" && filepath.Ext(p) == ".deb":
+			// The apt repository places the package in its pool by the package name and version
+			if err := n.upload(config.AptRepo, nil, "apt.asset", p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func newNexus(config model.NexusConfig) (*nexus, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("nexus url must be set")
+	}
+	username := config.Username
+	if username == "" && config.UsernameEnv != "" {
+		username = os.Getenv(config.UsernameEnv)
+	}
+	if username == "" {
+		return nil, fmt.Errorf("nexus username must be set")
+	}
+	password := os.Getenv(config.PasswordEnv)
+	if password == "" {
+		return nil, fmt.Errorf("nexus credentials not found in $%v", config.PasswordEnv)
+	}
+	return &nexus{url: strings.TrimSuffix(config.URL, "/"), username: username, password: password}, nil
+}
+
+// assetURL returns the URL an asset is served from.
+func (n *nexus) assetURL(repo string, assetPath string) string {
+	return n.url + "/repository/" + repo + "/" + strings.TrimPrefix(assetPath, "/")
+}
+
+// upload creates a component in the repository from the file, sent as the asset field of a multipart form with the
+// other fields. The file is streamed, rather than read into memory, as release archives can be large.
+func (n *nexus) upload(repo string, fields map[string]string, asset string, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		for k, v := range fields {
+			if err := mw.WriteField(k, v); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		part, err := mw.CreateFormFile(asset, filepath.Base(file))
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(part, f); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(mw.Close())
+	}()
+
+	u := n.url + "/service/rest/v1/components?repository=" + url.QueryEscape(repo)
+	req, err := http.NewRequest(http.MethodPost, u, pr)
+	if err != nil {
+		pr.Close()
+		return err
+	}
+	req.SetBasicAuth(n.username, n.password)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload %v to %v: %v", file, repo, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to upload %v to %v: %v %v", file, repo, resp.Status, string(msg))
+	}
+	log.Infof("Uploaded %v to %v", file, repo)
+	return nil
+}
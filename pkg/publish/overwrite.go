@@ -0,0 +1,146 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/daemon"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// checkImageOverwrites refuses to push images over tags of the version that already exist in the registry as other
+// images, so an already published release is never clobbered. Images are compared by their config digests, as
+// pushing the same image again may compress its layers differently. Other tags, such as latest, are expected to move.
+func checkImageOverwrites(manifest model.Manifest, images map[Image][]string, keychain authn.Keychain) error {
+	for img, archs := range images {
+		if img.NewTag[strings.LastIndex(img.NewTag, ":")+1:] != manifest.Version {
+			continue
+		}
+		ref := publishedReference(img, archs)
+		want := []string{}
+		for _, arch := range archs {
+			r, err := name.ParseReference(img.OriginalReference(arch))
+			if err != nil {
+				return err
+			}
+			local, err := daemon.Image(r)
+			if err != nil {
+				return fmt.Errorf("failed to load %v: %v", r, err)
+			}
+			config, err := local.ConfigName()
+			if err != nil {
+				return err
+			}
+			want = append(want, config.String())
+		}
+		existing, digest, err := remoteConfigs(ref, keychain)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			continue
+		}
+		slices.Sort(want)
+		slices.Sort(existing)
+		if !slices.Equal(want, existing) {
+			return fmt.Errorf("%v already exists as %v, a different image; refusing to overwrite it without --force", ref, digest)
+		}
+	}
+	return nil
+}
+
+// remoteConfigs returns the config digests of the image, or of each image of the index, ref points to in the
+// registry, along with its digest. The configs are nil if ref does not exist.
+func remoteConfigs(ref string, keychain authn.Keychain) ([]string, v1.Hash, error) {
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return nil, v1.Hash{}, err
+	}
+	desc, err := remote.Get(r, remote.WithAuthFromKeychain(keychain))
+	if isNotFound(err) {
+		return nil, v1.Hash{}, nil
+	}
+	if err != nil {
+		return nil, v1.Hash{}, fmt.Errorf("failed to get %v: %v", ref, err)
+	}
+	images := []v1.Image{}
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return nil, v1.Hash{}, err
+		}
+		im, err := index.IndexManifest()
+		if err != nil {
+			return nil, v1.Hash{}, err
+		}
+		for _, m := range im.Manifests {
+			if !m.MediaType.IsImage() {
+				continue
+			}
+			img, err := index.Image(m.Digest)
+			if err != nil {
+				return nil, v1.Hash{}, err
+			}
+			images = append(images, img)
+		}
+	} else {
+		img, err := desc.Image()
+		if err != nil {
+			return nil, v1.Hash{}, err
+		}
+		images = append(images, img)
+	}
+	configs := []string{}
+	for _, img := range images {
+		config, err := img.ConfigName()
+		if err != nil {
+			return nil, v1.Hash{}, fmt.Errorf("failed to read config of %v: %v", ref, err)
+		}
+		configs = append(configs, config.String())
+	}
+	return configs, desc.Digest, nil
+}
+
+// checkTagOverwrite refuses to move dst if it already exists in the registry with a digest other than digest.
+func checkTagOverwrite(dst name.Tag, digest v1.Hash, keychain authn.Keychain) error {
+	desc, err := remote.Head(dst, remote.WithAuthFromKeychain(keychain))
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get %v: %v", dst, err)
+	}
+	if desc.Digest != digest {
+		return fmt.Errorf("%v already exists as %v, not %v; refusing to overwrite it without --force", dst, desc.Digest, digest)
+	}
+	return nil
+}
+
+// isNotFound returns whether err is a registry response for a missing manifest.
+func isNotFound(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound
+}
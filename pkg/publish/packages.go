@@ -0,0 +1,117 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// istioctlArtifact is a stand alone istioctl archive for a single platform, as referenced by package managers.
+type istioctlArtifact struct {
+	OS   string
+	Arch string
+	URL  string
+	Sha  string
+}
+
+// istioctlPlatforms maps the platform suffix of istioctl archives to the package manager os and arch.
+var istioctlPlatforms = []struct {
+	suffix string
+	os     string
+	arch   string
+}{
+	{"linux-amd64.tar.gz", "linux", "amd64"},
+	{"linux-arm64.tar.gz", "linux", "arm64"},
+	{"linux-armv7.tar.gz", "linux", "arm"},
+	{"osx-amd64.tar.gz", "darwin", "amd64"},
+	{"osx-arm64.tar.gz", "darwin", "arm64"},
+	{"win-amd64.zip", "windows", "amd64"},
+}
+
+// istioctlArtifacts reads the stand alone istioctl archives, and their checksums, from the release.
+// downloadURL is the base URL the archives are published under, with the version appended.
+func istioctlArtifacts(manifest model.Manifest, downloadURL string) ([]istioctlArtifact, error) {
+	artifacts := []istioctlArtifact{}
+	for _, p := range istioctlPlatforms {
+		archive := fmt.Sprintf("istioctl-%s-%s", manifest.Version, p.suffix)
+		sha, err := os.ReadFile(filepath.Join(manifest.Directory, archive+".sha256"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read checksum of %v: %v", archive, err)
+		}
+		artifacts = append(artifacts, istioctlArtifact{
+			OS:   p.os,
+			Arch: p.arch,
+			URL:  fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(downloadURL, "/"), manifest.Version, archive),
+			Sha:  strings.Fields(string(sha))[0],
+		})
+	}
+	return artifacts, nil
+}
+
+// findArtifact returns the artifact for the os and arch.
+func findArtifact(artifacts []istioctlArtifact, goos, arch string) (istioctlArtifact, error) {
+	for _, a := range artifacts {
+		if a.OS == goos && a.Arch == arch {
+			return a, nil
+		}
+	}
+	return istioctlArtifact{}, fmt.Errorf("no istioctl artifact for %v/%v", goos, arch)
+}
+
+// updatePackageRepo clones the package repo, writes the files, and opens a PR with the changes.
+// name identifies the repo in the working directory and branch name.
+func updatePackageRepo(manifest model.Manifest, name string, repo *model.Dependency, files map[string][]byte, token string) error {
+	msg := fmt.Sprintf("Update istioctl to %s", manifest.Version)
+	return updateRepo(manifest, name, *repo, fmt.Sprintf("istioctl-%s", manifest.Version), msg, token, func(dir string) error {
+		for file, contents := range files {
+			dest := filepath.Join(dir, file)
+			if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(dest, contents, 0o644); err != nil {
+				return fmt.Errorf("failed to write %v: %v", file, err)
+			}
+			log.Infof("Wrote %v", dest)
+		}
+		return nil
+	})
+}
+
+// updateRepo clones the repo, applies edit to its directory, and opens a PR with the changes, if any.
+func updateRepo(manifest model.Manifest, name string, repo model.Dependency, branch, msg, token string, edit func(dir string) error) error {
+	tmpDir, err := os.MkdirTemp("", "release-"+name)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	// Work in a temporary directory, so the repo is not published along with the release
+	m := manifest
+	m.Directory = tmpDir
+	if err := util.Clone(name, repo, m.RepoDir(name), util.CloneOptions{}); err != nil {
+		return fmt.Errorf("failed to clone %v: %v", repo.Git, err)
+	}
+	if err := edit(m.RepoDir(name)); err != nil {
+		return err
+	}
+	return util.CreatePR(m, name, branch, msg, msg, false, token, repo.Git, repo.Branch, nil)
+}
@@ -0,0 +1,364 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"istio.io/istio/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg"
+	"github.com/alauda-mesh/release-builder/pkg/build"
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+var (
+	promoteFlags = struct {
+		from   string
+		to     string
+		output string
+	}{}
+	promoteCmd = &cobra.Command{
+		Use:          "promote-version",
+		Short:        "Promotes a release candidate to a final release, without rebuilding it, and publishes it",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(0),
+		RunE: func(c *cobra.Command, _ []string) error {
+			if flags.release == "" || promoteFlags.from == "" || promoteFlags.to == "" || promoteFlags.output == "" {
+				return fmt.Errorf("invalid flags: --release, --from, --to, and --output required")
+			}
+			manifest, err := pkg.ReadManifest(path.Join(flags.release, "manifest.yaml"))
+			if err != nil {
+				return fmt.Errorf("failed to read manifest from release: %v", err)
+			}
+			manifest.Directory = path.Clean(flags.release)
+			if manifest.Version != promoteFlags.from {
+				return fmt.Errorf("release %v is version %v, not %v", flags.release, manifest.Version, promoteFlags.from)
+			}
+
+			// Promoting publishes the promoted version, so needs the same approval as publishing it
+			if err := checkApproval(requiresApproval(manifest.Publish), "publish", promoteFlags.to); err != nil {
+				return err
+			}
+			promoted, err := Promote(manifest, promoteFlags.to, promoteFlags.output)
+			if err != nil {
+				return fmt.Errorf("failed to promote release: %v", err)
+			}
+			log.Infof("Promoted release %v to %v in %v", promoteFlags.from, promoteFlags.to, promoteFlags.output)
+			applyManifestDefaults(promoted)
+			return Publish(promoted)
+		},
+	}
+)
+
+func init() {
+	promoteCmd.Flags().StringVar(&promoteFlags.from, "from", promoteFlags.from,
+		"The version of the release being promoted. Example: 1.24.0-rc.2")
+	promoteCmd.Flags().StringVar(&promoteFlags.to, "to", promoteFlags.to,
+		"The version to promote the release to. Example: 1.24.0")
+	promoteCmd.Flags().StringVar(&promoteFlags.output, "output", promoteFlags.output,
+		"The directory to write the promoted release to, before publishing it.")
+}
+
+func GetPromoteCommand() *cobra.Command {
+	// The promoted release is published with the same flags as publish. Images are retagged in --dockerhub.
+	promoteCmd.Flags().AddFlagSet(publishCmd.PersistentFlags())
+	return promoteCmd
+}
+
+// Promote copies the release in manifest.Directory to output as version to. Charts are restamped and repackaged,
+// files and directories named for the version are renamed, release archives extract to a directory named for the
+// version, and the image list, install script, checksums and release metadata are regenerated. The binaries, images
+// and other archive contents are not rebuilt, so still report the version they were built as.
+func Promote(manifest model.Manifest, to string, output string) (model.Manifest, error) {
+	if util.FileExists(output) {
+		return model.Manifest{}, fmt.Errorf("output %v already exists", output)
+	}
+	if err := util.CopyDir(manifest.Directory, output); err != nil {
+		return model.Manifest{}, err
+	}
+	from := manifest
+	from.Directory = output
+	promoted := manifest
+	promoted.Version = to
+	promoted.Directory = output
+
+	if err := promoteCharts(from, promoted); err != nil {
+		return model.Manifest{}, fmt.Errorf("failed to promote charts: %v", err)
+	}
+	if err := renameVersioned(output, from.Version, to); err != nil {
+		return model.Manifest{}, err
+	}
+	by, err := yaml.Marshal(promoted)
+	if err != nil {
+		return model.Manifest{}, fmt.Errorf("failed to marshal manifest: %v", err)
+	}
+	if err := os.WriteFile(path.Join(output, "manifest.yaml"), by, 0o640); err != nil {
+		return model.Manifest{}, fmt.Errorf("failed to write manifest: %v", err)
+	}
+	if err := promoteImageList(output, from.Version, to); err != nil {
+		return model.Manifest{}, fmt.Errorf("failed to promote image list: %v", err)
+	}
+	if err := promoteArchives(output, from.Version, to); err != nil {
+		return model.Manifest{}, fmt.Errorf("failed to promote archives: %v", err)
+	}
+	if promoted.InstallScript != nil && util.FileExists(path.Join(output, promoted.InstallScript.Name)) {
+		if err := build.WriteInstallScript(promoted, output); err != nil {
+			return model.Manifest{}, err
+		}
+	}
+	if err := build.WriteReleaseMetadata(promoted, output); err != nil {
+		return model.Manifest{}, err
+	}
+	if err := build.WriteChecksums(output); err != nil {
+		return model.Manifest{}, err
+	}
+	return promoted, Audit(to, "promote", map[string]string{"from": from.Version})
+}
+
+// promoteImageList rewrites the image list of the release in dir with the tags the images are promoted to.
+func promoteImageList(dir, from, to string) error {
+	p := path.Join(dir, build.ImagesFile)
+	if !util.FileExists(p) {
+		return nil
+	}
+	by, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	list := build.ImageList{}
+	if err := yaml.Unmarshal(by, &list); err != nil {
+		return fmt.Errorf("failed to read %v: %v", p, err)
+	}
+	list.Version = to
+	for i, img := range list.Images {
+		// Tags are the version, followed by the variant and arch suffixes
+		if img.Tag == from || strings.HasPrefix(img.Tag, from+"-") {
+			list.Images[i].Tag = to + strings.TrimPrefix(img.Tag, from)
+		}
+	}
+	by, err = yaml.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, by, 0o644)
+}
+
+// promoteArchives recreates each release archive in dir, already renamed for the to version, so it extracts to
+// istio-<to>, as install scripts expect, with the promoted manifest and image list.
+func promoteArchives(dir, from, to string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp("", "promote-archives")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	for _, e := range entries {
+		zipped := strings.HasSuffix(e.Name(), ".zip")
+		if e.IsDir() || !strings.HasPrefix(e.Name(), "istio-"+to+"-") || !(zipped || strings.HasSuffix(e.Name(), ".tar.gz")) {
+			continue
+		}
+		// The archive is created from within the work directory, so is referred to by its absolute path
+		p, err := filepath.Abs(path.Join(dir, e.Name()))
+		if err != nil {
+			return err
+		}
+		work, err := os.MkdirTemp(tmpDir, "archive")
+		if err != nil {
+			return err
+		}
+		if zipped {
+			err = unzip(p, work)
+		} else {
+			err = util.VerboseCommand("tar", "-xzf", p, "-C", work).Run()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to extract %v: %v", e.Name(), err)
+		}
+		src, dst := path.Join(work, "istio-"+from), path.Join(work, "istio-"+to)
+		if !util.FileExists(src) {
+			return fmt.Errorf("archive %v does not contain istio-%v", e.Name(), from)
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return err
+		}
+		for _, f := range []string{"manifest.yaml", build.ImagesFile} {
+			if util.FileExists(path.Join(dst, f)) && util.FileExists(path.Join(dir, f)) {
+				if err := util.CopyFile(path.Join(dir, f), path.Join(dst, f)); err != nil {
+					return err
+				}
+			}
+		}
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		if zipped {
+			err = util.ZipFolder(dst, p)
+		} else {
+			err = util.TarGz(work, p, "istio-"+to)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create %v: %v", e.Name(), err)
+		}
+		if err := util.CreateSha(p); err != nil {
+			return err
+		}
+		log.Infof("Promoted archive %v", e.Name())
+	}
+	return nil
+}
+
+// unzip extracts the zip archive src into dir.
+func unzip(src, dir string) error {
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		dst := filepath.Join(dir, f.Name)
+		if !strings.HasPrefix(dst, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path %v in %v", f.Name, src)
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(dst, 0o755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if err := unzipFile(f, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unzipFile(f *zip.File, dst string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, f.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// promoteCharts repackages each chart of the from release with the versions of the to release.
+func promoteCharts(from, to model.Manifest) error {
+	helmDir := path.Join(from.Directory, from.ArtifactDir("helm", ""))
+	if !util.FileExists(helmDir) {
+		return nil
+	}
+	tmpDir, err := os.MkdirTemp("", "promote-charts")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	return filepath.WalkDir(helmDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(p, ".tgz") {
+			return err
+		}
+		chart, version := model.ChartNameVersion(d.Name())
+		if version != from.ChartVersion(chart) {
+			log.Warnf("Chart %v is not version %v, leaving it", d.Name(), from.ChartVersion(chart))
+			return nil
+		}
+		dir, err := os.MkdirTemp(tmpDir, chart)
+		if err != nil {
+			return err
+		}
+		if err := util.VerboseCommand("tar", "-xzf", p, "-C", dir).Run(); err != nil {
+			return fmt.Errorf("failed to extract %v: %v", d.Name(), err)
+		}
+		if err := build.RestampChart(from, to, path.Join(dir, chart)); err != nil {
+			return fmt.Errorf("failed to restamp %v: %v", d.Name(), err)
+		}
+		if err := os.Remove(p); err != nil {
+			return err
+		}
+		c := util.VerboseCommand("helm", "package", path.Join(dir, chart))
+		c.Dir = filepath.Dir(p)
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("package %v: %v", chart, err)
+		}
+		return nil
+	})
+}
+
+// renameVersioned renames the files and directories under dir named for the from version, and regenerates the
+// checksums of renamed files.
+func renameVersioned(dir, from, to string) error {
+	paths := []string{}
+	if err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == dir || !strings.Contains(d.Name(), from) {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, rel)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	shas := []string{}
+	// Rename the deepest paths first, so the parent of each path is not yet renamed
+	for i := len(paths) - 1; i >= 0; i-- {
+		p := filepath.Join(dir, paths[i])
+		if strings.HasSuffix(p, ".sha256") {
+			if err := os.Remove(p); err != nil {
+				return err
+			}
+			shas = append(shas, strings.ReplaceAll(strings.TrimSuffix(paths[i], ".sha256"), from, to))
+			continue
+		}
+		dst := filepath.Join(filepath.Dir(p), strings.ReplaceAll(filepath.Base(p), from, to))
+		log.Infof("Renaming %v -> %v", p, dst)
+		if err := os.Rename(p, dst); err != nil {
+			return fmt.Errorf("failed to rename %v: %v", p, err)
+		}
+	}
+	for _, f := range shas {
+		if err := util.CreateSha(filepath.Join(dir, f)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/build"
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+func TestPromote(t *testing.T) {
+	const from, to = "1.24.0-rc.1", "1.24.0"
+	release := t.TempDir()
+	manifest := model.Manifest{
+		Version:       from,
+		Directory:     release,
+		InstallScript: &model.InstallScriptConfig{DownloadURL: "https://example.com/releases", Name: "downloadIstio"},
+	}
+
+	// Each release archive extracts to istio-<version>, with the image list of the release
+	images := build.ImageList{Version: from, Images: []build.ImageInfo{
+		{Name: "pilot", Tag: from},
+		{Name: "proxyv2", Variant: "distroless", Tag: from + "-distroless"},
+	}}
+	writeYaml(t, filepath.Join(release, build.ImagesFile), images)
+	work := t.TempDir()
+	content := filepath.Join(work, "istio-"+from)
+	if err := os.MkdirAll(filepath.Join(content, "bin"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeYaml(t, filepath.Join(content, build.ImagesFile), images)
+	for _, arch := range []string{"linux-amd64", "linux-armv7", "linux-arm64", "osx-amd64", "osx-arm64"} {
+		archive := filepath.Join(release, fmt.Sprintf("istio-%s-%s.tar.gz", from, arch))
+		if err := util.TarGz(work, archive, "istio-"+from); err != nil {
+			t.Fatal(err)
+		}
+		if err := util.CreateSha(archive); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := util.ZipFolder(content, filepath.Join(release, fmt.Sprintf("istio-%s-win-amd64.zip", from))); err != nil {
+		t.Fatal(err)
+	}
+	if err := build.WriteInstallScript(manifest, release); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(t.TempDir(), "promoted")
+	if _, err := Promote(manifest, to, out); err != nil {
+		t.Fatal(err)
+	}
+
+	listing, err := exec.Command("tar", "-tzf", filepath.Join(out, "istio-"+to+"-linux-amd64.tar.gz")).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range strings.Fields(string(listing)) {
+		if !strings.HasPrefix(f, "istio-"+to+"/") {
+			t.Errorf("expected archive to extract to istio-%v, found %v", to, f)
+		}
+	}
+	z, err := zip.OpenReader(filepath.Join(out, "istio-"+to+"-win-amd64.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	for _, f := range z.File {
+		if !strings.HasPrefix(f.Name, "istio-"+to+"/") {
+			t.Errorf("expected zip to extract to istio-%v, found %v", to, f.Name)
+		}
+	}
+
+	got := build.ImageList{}
+	by, err := os.ReadFile(filepath.Join(out, build.ImagesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal(by, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != to || got.Images[0].Tag != to || got.Images[1].Tag != to+"-distroless" {
+		t.Errorf("expected images to be promoted to %v, got %+v", to, got)
+	}
+
+	script, err := os.ReadFile(filepath.Join(out, "downloadIstio"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha, err := os.ReadFile(filepath.Join(out, "istio-"+to+"-linux-amd64.tar.gz.sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(script), `ISTIO_VERSION="`+to+`"`) || strings.Contains(string(script), from) {
+		t.Errorf("expected install script for %v, got:\n%s", to, script)
+	}
+	if !strings.Contains(string(script), strings.Fields(string(sha))[0]) {
+		t.Errorf("expected install script to pin the promoted archive checksum")
+	}
+}
+
+func writeYaml(t *testing.T, file string, v any) {
+	t.Helper()
+	by, err := yaml.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, by, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
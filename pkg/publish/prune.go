@@ -0,0 +1,241 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/minio/minio-go/v7"
+	"github.com/spf13/cobra"
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg"
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+var (
+	pruneFlags = struct {
+		manifest string
+	}{}
+	pruneCmd = &cobra.Command{
+		Use:          "prune",
+		Short:        "Removes expired dev builds and pre-releases from the release bucket",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(0),
+		RunE: func(c *cobra.Command, _ []string) error {
+			if pruneFlags.manifest == "" {
+				return fmt.Errorf("--manifest must be passed")
+			}
+			in, err := pkg.ReadInManifest(pruneFlags.manifest, "", nil)
+			if err != nil {
+				return fmt.Errorf("failed to read manifest: %v", err)
+			}
+			if in.Publish == nil || in.Publish.Retention == nil {
+				return fmt.Errorf("manifest has no publish.retention rules")
+			}
+			if unpublishFlags.s3bucket == "" {
+				unpublishFlags.s3bucket = in.Publish.S3Bucket
+			}
+			if unpublishFlags.dockerhub == "" {
+				unpublishFlags.dockerhub = in.Publish.DockerHub
+			}
+			if unpublishFlags.s3bucket == "" {
+				return fmt.Errorf("--s3bucket must be passed, or publish.s3bucket set in the manifest")
+			}
+			applyS3ClientDefaults(in.Publish.S3Client)
+			// Dry runs change nothing, so need no approval
+			if !unpublishFlags.dryrun {
+				if err := checkApproval(in.Publish.RequireApproval, "prune", ""); err != nil {
+					return err
+				}
+			}
+			return Prune(*in.Publish.Retention, unpublishFlags.s3bucket, unpublishFlags.dockerhub)
+		},
+	}
+)
+
+func init() {
+	pruneCmd.PersistentFlags().StringVar(&pruneFlags.manifest, "manifest", pruneFlags.manifest,
+		"The manifest to read the publish.retention rules, and default bucket and hub, from.")
+	pruneCmd.PersistentFlags().StringVar(&unpublishFlags.s3bucket, "s3bucket", unpublishFlags.s3bucket,
+		"The S3 bucket to prune. Example: istio-build/dev.")
+	pruneCmd.PersistentFlags().StringVar(&unpublishFlags.dockerhub, "dockerhub", unpublishFlags.dockerhub,
+		"The docker hub to delete image tags of pruned versions from. Example: gcr.io/istio-testing.")
+	pruneCmd.PersistentFlags().StringSliceVar(&unpublishFlags.images, "images", unpublishFlags.images,
+		"The images to delete tags of.")
+	pruneCmd.PersistentFlags().StringSliceVar(&unpublishFlags.variants, "variants", unpublishFlags.variants,
+		"The image variants to delete tags of.")
+	pruneCmd.PersistentFlags().BoolVar(&unpublishFlags.dryrun, "dryrun", unpublishFlags.dryrun,
+		"Only report what would be removed.")
+	pruneCmd.PersistentFlags().StringVar(&flags.auditlog, "auditlog", flags.auditlog,
+		"The file to append audit log entries to.")
+	addApprovalFlags(pruneCmd.PersistentFlags())
+	addLockFlags(pruneCmd.PersistentFlags())
+}
+
+func GetPruneCommand() *cobra.Command {
+	return pruneCmd
+}
+
+// publishedVersion is a version in the bucket, and when it was last published to.
+type publishedVersion struct {
+	version  *semver.Version
+	modified time.Time
+}
+
+// Prune removes the versions in the bucket that the retention rules no longer keep, along with their image tags
+// in hub, if set. A report of each version, and whether it is kept, is written to stdout.
+func Prune(retention model.RetentionConfig, bucket string, hub string) error {
+	if (retention.KeepDailies != nil && *retention.KeepDailies < 0) ||
+		(retention.KeepPrereleases != nil && *retention.KeepPrereleases < 0) {
+		return fmt.Errorf("retention counts must not be negative")
+	}
+	ctx := context.Background()
+	client, err := NewS3Client(ctx)
+	if err != nil {
+		return err
+	}
+	// A concurrent publish may point aliases to a version being removed, so the bucket is locked before it is listed
+	var locks heldLocks
+	if !unpublishFlags.dryrun {
+		if locks, err = acquireLocks("prune", []string{bucket}); err != nil {
+			return err
+		}
+		defer locks.Release()
+	}
+	bucketName, objectPrefix := splitBucket(bucket)
+	versions, err := listVersionTimes(ctx, client, bucketName, objectPrefix)
+	if err != nil {
+		return err
+	}
+
+	reasons := retain(versions, retention)
+	report := &strings.Builder{}
+	fmt.Fprintf(report, "Pruning s3://%s (dryrun: %v)\n\n", bucket, unpublishFlags.dryrun)
+	expired := []string{}
+	for _, v := range versions {
+		version := v.version.Original()
+		reason, keep := reasons[version]
+		if !keep {
+			reason = "remove"
+			expired = append(expired, version)
+		}
+		fmt.Fprintf(report, "  %-40s %-20s %s\n", version, v.modified.Format(time.RFC3339), reason)
+	}
+	fmt.Fprintf(report, "\n%d versions, %d expired\n", len(versions), len(expired))
+	if _, err := os.Stdout.WriteString(report.String()); err != nil {
+		return err
+	}
+
+	for _, version := range expired {
+		if err := locks.Err(); err != nil {
+			return err
+		}
+		prefix := path.Join(objectPrefix, version) + "/"
+		if err := removeObjects(ctx, client, bucketName, prefix, func(string) bool { return true }); err != nil {
+			return fmt.Errorf("failed to prune %v: %v", version, err)
+		}
+		if hub != "" {
+			if err := unpublishDocker(version, hub); err != nil {
+				// Dev builds are not always pushed to the hub, so missing tags do not stop the prune
+				log.Warnf("failed to delete images of %v: %v", version, err)
+			}
+		}
+		if err := Audit(version, "prune", map[string]string{"bucket": bucket, "dryrun": fmt.Sprint(unpublishFlags.dryrun)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retain returns the reason each kept version is kept. Releases are always kept, as are the newest dailies by
+// publish time, and the newest pre-releases by version. Unset counts keep all.
+func retain(versions []publishedVersion, retention model.RetentionConfig) map[string]string {
+	kept := map[string]string{}
+	dailies := []publishedVersion{}
+	prereleases := []publishedVersion{}
+	for _, v := range versions {
+		switch version := v.version.Original(); {
+		case v.version.Prerelease() == "":
+			kept[version] = "release"
+		case util.IsDevBuild(version):
+			dailies = append(dailies, v)
+		default:
+			prereleases = append(prereleases, v)
+		}
+	}
+	sort.Slice(dailies, func(i, j int) bool { return dailies[i].modified.After(dailies[j].modified) })
+	for i, v := range dailies {
+		if retention.KeepDailies == nil {
+			kept[v.version.Original()] = "daily"
+		} else if i < *retention.KeepDailies {
+			kept[v.version.Original()] = fmt.Sprintf("daily %d/%d", i+1, *retention.KeepDailies)
+		}
+	}
+	sort.Slice(prereleases, func(i, j int) bool { return prereleases[i].version.GreaterThan(prereleases[j].version) })
+	for i, v := range prereleases {
+		if retention.KeepPrereleases == nil {
+			kept[v.version.Original()] = "pre-release"
+		} else if i < *retention.KeepPrereleases {
+			kept[v.version.Original()] = fmt.Sprintf("pre-release %d/%d", i+1, *retention.KeepPrereleases)
+		}
+	}
+	return kept
+}
+
+// listVersionTimes returns each version in the bucket with the time its newest object was written, oldest first.
+// Prefixes that are not versions, such as the latest mirror, are ignored.
+func listVersionTimes(ctx context.Context, client *minio.Client, bucketName, objectPrefix string) ([]publishedVersion, error) {
+	prefix := ""
+	if objectPrefix != "" {
+		prefix = objectPrefix + "/"
+	}
+	versions := map[string]*publishedVersion{}
+	for obj := range client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("failed to list versions: %v", obj.Err)
+		}
+		dir, _, found := strings.Cut(strings.TrimPrefix(obj.Key, prefix), "/")
+		if !found {
+			continue
+		}
+		v, err := semver.NewVersion(dir)
+		if err != nil {
+			continue
+		}
+		pv, f := versions[dir]
+		if !f {
+			pv = &publishedVersion{version: v}
+			versions[dir] = pv
+		}
+		if obj.LastModified.After(pv.modified) {
+			pv.modified = obj.LastModified
+		}
+	}
+	res := []publishedVersion{}
+	for _, v := range versions {
+		res = append(res, *v)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].modified.Before(res[j].modified) })
+	return res, nil
+}
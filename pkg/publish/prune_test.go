@@ -0,0 +1,89 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+func TestRetain(t *testing.T) {
+	now := time.Now()
+	published := func(version string, age time.Duration) publishedVersion {
+		return publishedVersion{version: semver.MustParse(version), modified: now.Add(-age)}
+	}
+	versions := []publishedVersion{
+		published("1.24.0", 90*24*time.Hour),
+		published("1.25.0", 30*24*time.Hour),
+		published("1.25.0-rc.0", 40*24*time.Hour),
+		published("1.25.0-rc.1", 35*24*time.Hour),
+		published("1.26.0-beta.0", time.Hour),
+		// Dailies are ordered by publish time, not version
+		published("1.26-alpha.aaaa", 3*time.Hour),
+		published("1.26-alpha.bbbb", 2*time.Hour),
+		published("1.26-alpha.cccc", time.Hour),
+	}
+	intPtr := func(i int) *int { return &i }
+	cases := []struct {
+		name      string
+		retention model.RetentionConfig
+		want      map[string]string
+	}{
+		{
+			name: "unset keeps all",
+			want: map[string]string{
+				"1.24.0":          "release",
+				"1.25.0":          "release",
+				"1.25.0-rc.0":     "pre-release",
+				"1.25.0-rc.1":     "pre-release",
+				"1.26.0-beta.0":   "pre-release",
+				"1.26-alpha.aaaa": "daily",
+				"1.26-alpha.bbbb": "daily",
+				"1.26-alpha.cccc": "daily",
+			},
+		},
+		{
+			name:      "newest",
+			retention: model.RetentionConfig{KeepDailies: intPtr(2), KeepPrereleases: intPtr(1)},
+			want: map[string]string{
+				"1.24.0":          "release",
+				"1.25.0":          "release",
+				"1.26.0-beta.0":   "pre-release 1/1",
+				"1.26-alpha.cccc": "daily 1/2",
+				"1.26-alpha.bbbb": "daily 2/2",
+			},
+		},
+		{
+			name:      "none",
+			retention: model.RetentionConfig{KeepDailies: intPtr(0), KeepPrereleases: intPtr(0)},
+			want: map[string]string{
+				"1.24.0": "release",
+				"1.25.0": "release",
+			},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retain(versions, tt.retention); !maps.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+	"istio.io/istio/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// PublishedFile is the name of the file recording where a release was published to.
+const PublishedFile = "published.yaml"
+
+// Published records every destination a release was published to, for announcement and audit tooling.
+type Published struct {
+	Version string `json:"version"`
+	Channel string `json:"channel,omitempty"`
+	// Objects are the URLs of the uploaded S3 objects
+	Objects []string `json:"objects,omitempty"`
+	// Images are the pushed image references, with their digests
+	Images []PublishedImage `json:"images,omitempty"`
+	Charts []PublishedChart `json:"charts,omitempty"`
+	// GithubRelease is the URL of the GitHub release
+	GithubRelease string `json:"githubRelease,omitempty"`
+	// Aliases are the s3 aliases updated to the release, with the version each pointed to before, so unpublish can
+	// restore them
+	Aliases []PublishedAlias `json:"aliases,omitempty"`
+}
+
+type PublishedAlias struct {
+	Bucket string `json:"bucket"`
+	Name   string `json:"name"`
+	// Previous is the version the alias pointed to before, or empty if it did not exist
+	Previous string `json:"previous,omitempty"`
+}
+
+type PublishedImage struct {
+	Reference string `json:"reference"`
+	Digest    string `json:"digest"`
+}
+
+type PublishedChart struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// URL is the chart archive in a chart repo, or the oci:// reference of the chart
+	URL string `json:"url"`
+}
+
+var (
+	publishedMu sync.Mutex
+	// published collects the destinations of the current publish
+	published = Published{}
+)
+
+func recordObject(url string) {
+	publishedMu.Lock()
+	defer publishedMu.Unlock()
+	published.Objects = append(published.Objects, url)
+}
+
+func recordImage(ref, digest string) {
+	publishedMu.Lock()
+	defer publishedMu.Unlock()
+	published.Images = append(published.Images, PublishedImage{Reference: ref, Digest: digest})
+}
+
+func recordChart(name, version, url string) {
+	publishedMu.Lock()
+	defer publishedMu.Unlock()
+	published.Charts = append(published.Charts, PublishedChart{Name: name, Version: version, URL: url})
+}
+
+func recordAlias(bucket, name, previous string) {
+	publishedMu.Lock()
+	defer publishedMu.Unlock()
+	published.Aliases = append(published.Aliases, PublishedAlias{Bucket: bucket, Name: name, Previous: previous})
+}
+
+func recordGithubRelease(url string) {
+	publishedMu.Lock()
+	defer publishedMu.Unlock()
+	published.GithubRelease = url
+}
+
+// WritePublished writes the recorded destinations to published.yaml in the release directory. If bucket is
+// set, the file is uploaded alongside the release.
+func WritePublished(manifest model.Manifest, bucket string) error {
+	publishedMu.Lock()
+	p := published
+	publishedMu.Unlock()
+	p.Version = manifest.Version
+	p.Channel = string(manifest.Channel)
+
+	by, err := yaml.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to marshal published destinations: %v", err)
+	}
+	file := filepath.Join(manifest.Directory, PublishedFile)
+	if err := os.WriteFile(file, by, 0o644); err != nil {
+		return fmt.Errorf("failed to write %v: %v", file, err)
+	}
+	log.Infof("Wrote published destinations to %v", file)
+	if bucket == "" {
+		return nil
+	}
+
+	objectTags, err := s3Tags(manifest, flags.s3.Tags)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	client, err := NewS3Client(ctx)
+	if err != nil {
+		return err
+	}
+	bucketName, objectPrefix := splitBucket(bucket)
+	objName := path.Join(objectPrefix, manifest.Version, PublishedFile)
+	putOpts := minio.PutObjectOptions{ContentType: contentType(objName), UserTags: objectTags}
+	if _, err := client.FPutObject(ctx, bucketName, objName, file, putOpts); err != nil {
+		return fmt.Errorf("failed to upload %v: %v", objName, err)
+	}
+	log.Infof("Wrote %v to s3://%s/%s", PublishedFile, bucketName, objName)
+	return nil
+}
@@ -0,0 +1,260 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/spf13/cobra"
+	"istio.io/istio/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg"
+	"github.com/alauda-mesh/release-builder/pkg/build"
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+var (
+	rebuildFlags = struct {
+		patch  int
+		bases  map[string]string
+		output string
+	}{}
+	rebuildCmd = &cobra.Command{
+		Use:          "rebuild-images",
+		Short:        "Rebuilds the images of a release on updated base images, and publishes them as a patch version",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(0),
+		RunE: func(c *cobra.Command, _ []string) error {
+			if flags.release == "" || rebuildFlags.patch < 1 || len(rebuildFlags.bases) == 0 || rebuildFlags.output == "" {
+				return fmt.Errorf("invalid flags: --release, --patch, --base, and --output required")
+			}
+			release := flags.release
+			if strings.HasPrefix(release, "s3://") {
+				dir, err := FetchRelease(release)
+				defer os.RemoveAll(dir)
+				if err != nil {
+					return fmt.Errorf("failed to fetch release: %v", err)
+				}
+				if err := VerifyRelease(dir); err != nil {
+					return fmt.Errorf("fetched release failed verification: %v", err)
+				}
+				release = dir
+			}
+			manifest, err := pkg.ReadManifest(path.Join(release, "manifest.yaml"))
+			if err != nil {
+				return fmt.Errorf("failed to read manifest from release: %v", err)
+			}
+			manifest.Directory = path.Clean(release)
+			applyManifestDefaults(manifest)
+			// A fetched release is fetched with the network flags, as its manifest is only read once fetched
+			if err := util.ConfigureNetwork(manifest.Network); err != nil {
+				return fmt.Errorf("failed to configure network: %v", err)
+			}
+			if flags.dockerhub == "" {
+				return fmt.Errorf("--dockerhub must be passed, or publish.dockerhub set in the manifest")
+			}
+
+			version := fmt.Sprintf("%s-%d", manifest.Version, rebuildFlags.patch)
+			if err := checkApproval(requiresApproval(manifest.Publish), "rebuild-images", version); err != nil {
+				return err
+			}
+			rebuilt, err := RebuildImages(manifest, rebuildFlags.patch, rebuildFlags.bases, rebuildFlags.output)
+			if err != nil {
+				return fmt.Errorf("failed to rebuild images: %v", err)
+			}
+			published = Published{}
+			// Only the patch version is tagged, so tags such as latest keep pointing to the original release
+			if err := Docker(rebuilt, flags.dockerhub, nil, flags.cosignkey); err != nil {
+				return fmt.Errorf("failed to publish images: %v", err)
+			}
+			return WritePublished(rebuilt, "")
+		},
+	}
+)
+
+func init() {
+	rebuildCmd.PersistentFlags().StringVar(&flags.release, "release", flags.release,
+		"The directory with the Istio release, or a release previously uploaded to S3. Example: s3://istio-staging/1.24.0")
+	rebuildCmd.PersistentFlags().IntVar(&rebuildFlags.patch, "patch", rebuildFlags.patch,
+		"The image patch number, appended to the version. Example: 1, for 1.24.0-1")
+	rebuildCmd.PersistentFlags().StringToStringVar(&rebuildFlags.bases, "base", rebuildFlags.bases,
+		"The base images to replace, and their updated images. Example: gcr.io/istio-release/base:1.24.0=gcr.io/istio-release/base:1.24.0-patched")
+	rebuildCmd.PersistentFlags().StringVar(&rebuildFlags.output, "output", rebuildFlags.output,
+		"The directory to write the rebuilt images to, before publishing them.")
+	rebuildCmd.PersistentFlags().StringVar(&flags.dockerhub, "dockerhub", flags.dockerhub,
+		"The docker hub to push images to. Example: docker.io/istio.")
+	rebuildCmd.PersistentFlags().StringVar(&flags.cosignkey, "cosignkey", flags.cosignkey,
+		"A key for signing images, as passed to cosign using 'cosign sign --key <x>'")
+	rebuildCmd.PersistentFlags().StringVar(&flags.auditlog, "auditlog", flags.auditlog,
+		"The file to append audit log entries to.")
+	addApprovalFlags(rebuildCmd.PersistentFlags())
+}
+
+func GetRebuildImagesCommand() *cobra.Command {
+	return rebuildCmd
+}
+
+// RebuildImages writes the images of the release in manifest.Directory to output as version <version>-<patch>,
+// with the layers of each base image in bases replaced by those of its updated image. The layers above the base,
+// holding the binaries, are kept as published, so nothing but the base is rebuilt. Images not built on any of the
+// bases are copied unchanged, so every image is available with the patch version.
+func RebuildImages(manifest model.Manifest, patch int, bases map[string]string, output string) (model.Manifest, error) {
+	if util.FileExists(output) {
+		return model.Manifest{}, fmt.Errorf("output %v already exists", output)
+	}
+	rebuilt := manifest
+	rebuilt.Version = fmt.Sprintf("%s-%d", manifest.Version, patch)
+	rebuilt.Directory = output
+
+	srcDir := path.Join(manifest.Directory, manifest.ArtifactDir("docker", ""))
+	dstDir := path.Join(output, rebuilt.ArtifactDir("docker", ""))
+	if err := os.MkdirAll(dstDir, 0o755); err != nil {
+		return model.Manifest{}, err
+	}
+	archives, err := os.ReadDir(srcDir)
+	if err != nil {
+		return model.Manifest{}, fmt.Errorf("failed to read docker output of release: %v", err)
+	}
+	archives = slices.DeleteFunc(archives, func(f os.DirEntry) bool { return f.IsDir() })
+	fetched := &baseImages{keychain: util.Keychain(manifest.Registries), images: map[string]v1.Image{}}
+	for _, a := range archives {
+		archive := path.Join(srcDir, a.Name())
+		img, err := tarball.Image(func() (io.ReadCloser, error) { return gzipFile(archive) }, nil)
+		if err != nil {
+			return model.Manifest{}, fmt.Errorf("failed to read %v: %v", a.Name(), err)
+		}
+		rebased, base, err := rebaseImage(img, bases, fetched)
+		if err != nil {
+			return model.Manifest{}, fmt.Errorf("failed to rebase %v: %v", a.Name(), err)
+		}
+		if base == "" {
+			log.Warnf("%v is not built on any of the bases, copying it unchanged", a.Name())
+		} else {
+			log.Infof("Rebased %v from %v onto %v", a.Name(), base, bases[base])
+		}
+		// Tag the image as publishing expects to find it once loaded
+		imageName, variant, arch := GetImageNameVariant(a.Name(), manifest.ImageVariants)
+		tag, err := name.NewTag(Image{
+			OriginalTag: fmt.Sprintf("%s/%s:%s", rebuilt.Docker, imageName, rebuilt.Version),
+			Variant:     variant,
+		}.OriginalReference(arch))
+		if err != nil {
+			return model.Manifest{}, err
+		}
+		if err := build.WriteImageArchive(path.Join(dstDir, a.Name()), tag, rebased); err != nil {
+			return model.Manifest{}, fmt.Errorf("failed to write %v: %v", a.Name(), err)
+		}
+	}
+
+	by, err := yaml.Marshal(rebuilt)
+	if err != nil {
+		return model.Manifest{}, fmt.Errorf("failed to marshal manifest: %v", err)
+	}
+	if err := os.WriteFile(path.Join(output, "manifest.yaml"), by, 0o640); err != nil {
+		return model.Manifest{}, fmt.Errorf("failed to write manifest: %v", err)
+	}
+	details := map[string]string{"from": manifest.Version}
+	for old, updated := range bases {
+		details["base "+old] = updated
+	}
+	return rebuilt, Audit(rebuilt.Version, "rebuild-images", details)
+}
+
+// rebaseImage replaces the layers of the base in bases img is built on with those of its updated image, returning
+// the base replaced. If img is built on none of the bases, it is returned as is. Bases are matched by the diff IDs
+// of their layers, as the layers of a saved image are not compressed as they are in a registry.
+func rebaseImage(img v1.Image, bases map[string]string, fetched *baseImages) (v1.Image, string, error) {
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, "", err
+	}
+	platform := v1.Platform{OS: cfg.OS, Architecture: cfg.Architecture, Variant: cfg.Variant}
+	olds := make([]string, 0, len(bases))
+	for old := range bases {
+		olds = append(olds, old)
+	}
+	sort.Strings(olds)
+	for _, old := range olds {
+		oldBase, err := fetched.get(old, platform)
+		if err != nil {
+			return nil, "", err
+		}
+		oldCfg, err := oldBase.ConfigFile()
+		if err != nil {
+			return nil, "", err
+		}
+		baseLayers := oldCfg.RootFS.DiffIDs
+		if len(baseLayers) == 0 || len(baseLayers) > len(cfg.RootFS.DiffIDs) ||
+			!slices.Equal(baseLayers, cfg.RootFS.DiffIDs[:len(baseLayers)]) {
+			continue
+		}
+		newBase, err := fetched.get(bases[old], platform)
+		if err != nil {
+			return nil, "", err
+		}
+		layers, err := img.Layers()
+		if err != nil {
+			return nil, "", err
+		}
+		rebased, err := mutate.AppendLayers(newBase, layers[len(baseLayers):]...)
+		if err != nil {
+			return nil, "", err
+		}
+		// The image config, such as the entrypoint and env, is kept, rather than taken from the new base
+		rebased, err = mutate.Config(rebased, *cfg.Config.DeepCopy())
+		if err != nil {
+			return nil, "", err
+		}
+		return rebased, old, nil
+	}
+	return img, "", nil
+}
+
+// baseImages fetches base images for each platform once.
+type baseImages struct {
+	keychain authn.Keychain
+	images   map[string]v1.Image
+}
+
+func (b *baseImages) get(ref string, platform v1.Platform) (v1.Image, error) {
+	key := ref + "@" + platform.String()
+	if img, f := b.images[key]; f {
+		return img, nil
+	}
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %v", ref, err)
+	}
+	img, err := remote.Image(r, remote.WithPlatform(platform), remote.WithAuthFromKeychain(b.keychain))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch base image %v for %v: %v", ref, platform.String(), err)
+	}
+	b.images[key] = img
+	return img, nil
+}
@@ -16,28 +16,50 @@ package publish
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"mime"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"text/template"
+	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"github.com/minio/minio-go/v7/pkg/tags"
 	"istio.io/istio/pkg/log"
 
 	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
 )
 
+// S3ClientConfig configures the connection made by NewS3Client. It is set from flags or the manifest.
+var S3ClientConfig = model.S3ClientConfig{}
+
 func NewS3Client(ctx context.Context) (*minio.Client, error) {
+	return newS3Client(S3ClientConfig, true)
+}
+
+// newS3Client connects to the storage configured by cfg. If env is set, the endpoint and credentials not configured
+// are read from $S3_ENDPOINT and the AWS environment variables. Otherwise, the storage defaults to AWS and is
+// accessed anonymously without a credentials file.
+func newS3Client(cfg model.S3ClientConfig, env bool) (*minio.Client, error) {
 	endpoint := "https://s3.amazonaws.com"
-	if ep := os.Getenv("S3_ENDPOINT"); ep != "" {
+	if ep := os.Getenv("S3_ENDPOINT"); env && ep != "" {
 		endpoint = ep
 	}
+	if cfg.Endpoint != "" {
+		endpoint = cfg.Endpoint
+	}
 
 	u, err := url.Parse(endpoint)
 	if err != nil {
@@ -45,10 +67,30 @@ func NewS3Client(ctx context.Context) (*minio.Client, error) {
 	}
 
 	useSSL := u.Scheme == "https"
-	minioClient, err := minio.New(u.Host, &minio.Options{
-		Creds:  credentials.NewEnvAWS(),
+	opts := &minio.Options{
+		Creds:  credentials.NewStatic("", "", "", credentials.SignatureAnonymous),
 		Secure: useSSL,
-	})
+		Region: cfg.Region,
+	}
+	if cfg.CredentialsFile != "" {
+		opts.Creds = credentials.NewFileAWSCredentials(cfg.CredentialsFile, cfg.Profile)
+	} else if env {
+		opts.Creds = credentials.NewEnvAWS()
+	}
+	if cfg.PathStyle {
+		opts.BucketLookup = minio.BucketLookupPath
+	}
+	transport, err := minio.DefaultTransport(useSSL)
+	if err != nil {
+		return nil, err
+	}
+	// Follow the network config, which may change once the manifest of a fetched release is read
+	transport.Proxy = util.Proxy
+	if cfg.InsecureSkipVerify {
+		transport.TLSClientConfig.InsecureSkipVerify = true
+	}
+	opts.Transport = transport
+	minioClient, err := minio.New(u.Host, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -56,12 +98,47 @@ func NewS3Client(ctx context.Context) (*minio.Client, error) {
 	return minioClient, nil
 }
 
-// S3Archive publishes the final release archive to the given GCS bucket
-func S3Archive(manifest model.Manifest, bucket string, aliases []string) error {
+// S3Archive publishes the final release archive to each of the destination buckets. If aliasRedirect is set, alias
+// objects also redirect to the version when the bucket is served as a website. If latest is set, the release files
+// are mirrored under that prefix, without the version in their names, when this is the newest release.
+// Objects are written with the encryption, ACL, storage class, and cache headers in opts.
+// A failed destination does not stop the others; the result of each is logged, and all failures returned.
+func S3Archive(manifest model.Manifest, destinations []model.S3Destination, aliases []string, aliasRedirect bool, latest string, opts model.S3Options) error {
+	sse, err := s3Encryption(opts)
+	if err != nil {
+		return err
+	}
+	if opts.Tags, err = s3Tags(manifest, opts.Tags); err != nil {
+		return err
+	}
+	errs := []error{}
+	for i, d := range destinations {
+		// Only the --s3bucket falls back to the environment; mirrors are connected only as configured
+		if err := s3ArchiveDestination(manifest, d, i == 0, aliases, aliasRedirect, latest, opts, sse); err != nil {
+			log.Errorf("Failed to publish to s3://%s: %v", d.Bucket, err)
+			errs = append(errs, fmt.Errorf("s3://%s: %v", d.Bucket, err))
+			continue
+		}
+		log.Infof("Published to s3://%s", d.Bucket)
+	}
+	if len(errs) > 0 {
+		log.Errorf("Published to %d of %d s3 destinations", len(destinations)-len(errs), len(destinations))
+	}
+	return errors.Join(errs...)
+}
+
+// s3Destinations returns the --s3bucket, using the configured client, followed by the mirrors.
+func s3Destinations(bucket string, mirrors []model.S3Destination) []model.S3Destination {
+	return append([]model.S3Destination{{Bucket: bucket, Client: S3ClientConfig}}, mirrors...)
+}
+
+func s3ArchiveDestination(manifest model.Manifest, dest model.S3Destination, env bool, aliases []string, aliasRedirect bool, latest string,
+	opts model.S3Options, sse encrypt.ServerSide,
+) error {
+	bucket := dest.Bucket
 	ctx := context.Background()
-	client, err := NewS3Client(ctx)
+	client, err := newS3Client(dest.Client, env)
 	if err != nil {
-		// TODO: Handle error.
 		return err
 	}
 
@@ -73,6 +150,19 @@ func S3Archive(manifest model.Manifest, bucket string, aliases []string) error {
 	if len(splitbucket) > 1 {
 		objectPrefix = splitbucket[1]
 	}
+	if !flags.overwrite {
+		if err := checkObjectOverwrites(ctx, client, bucketName, path.Join(objectPrefix, manifest.Version), manifest); err != nil {
+			return err
+		}
+	}
+	retention, retainUntil, err := s3Retention(opts.ObjectLock, manifest.Version)
+	if err != nil {
+		return err
+	}
+	if !updatesAliases(manifest) && (len(aliases) > 0 || latest != "") {
+		log.Infof("Not updating aliases %v or %v for pre-release %v", aliases, latest, manifest.Version)
+		aliases, latest = nil, ""
+	}
 	if err := filepath.WalkDir(manifest.Directory, func(p string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -84,21 +174,23 @@ func S3Archive(manifest model.Manifest, bucket string, aliases []string) error {
 				return err
 			}
 
-			// Exclude "docker" directory under manifest directory
-			if rel == "docker" {
+			// Exclude "docker" directory under manifest directory, unless the release is being staged with its images
+			if rel == manifest.ArtifactDir("docker", "") && !opts.Docker {
 				return filepath.SkipDir
 			}
 
 			return nil
 		}
 		objName := path.Join(objectPrefix, manifest.Version, strings.TrimPrefix(p, manifest.Directory))
+		logger := log.WithLabels("artifact", objName)
 
-		_, err = client.FPutObject(ctx, bucketName, objName, p, minio.PutObjectOptions{})
-		if err != nil {
-			return fmt.Errorf("failed to put object: %v", err)
+		putOpts := s3PutOptions(opts, sse, objName)
+		putOpts.Mode, putOpts.RetainUntilDate = retention, retainUntil
+		if err := putVerified(ctx, client, bucketName, objName, p, putOpts); err != nil {
+			return err
 		}
 
-		log.Infof("Wrote %v to s3://%s/%s", p, bucketName, objName)
+		logger.Infof("Wrote %v to s3://%s/%s", p, bucketName, objName)
 		return nil
 	}); err != nil {
 		return fmt.Errorf("failed to walk directory: %v", err)
@@ -107,19 +199,335 @@ func S3Archive(manifest model.Manifest, bucket string, aliases []string) error {
 	// Add alias objects. These are basically symlinks/tags for GCS, pointing to the latest version
 	for _, alias := range aliases {
 		objName := path.Join(objectPrefix, alias)
+		// The version replaced is recorded, so unpublish can restore it. Publishing the same version again keeps
+		// nothing to restore.
+		previous, err := FetchObject(client, bucketName, objectPrefix, alias)
+		if err != nil && minio.ToErrorResponse(err).Code != "NoSuchKey" {
+			return fmt.Errorf("failed to read alias %v: %v", alias, err)
+		}
+		if strings.TrimSpace(string(previous)) != manifest.Version {
+			recordAlias(bucket, alias, strings.TrimSpace(string(previous)))
+		}
 		_, err = client.PutObject(ctx, bucketName, objName,
 			strings.NewReader(manifest.Version), int64(len(manifest.Version)),
-			minio.PutObjectOptions{})
+			aliasPutOptions(opts, sse, objectPrefix, alias, manifest.Version, aliasRedirect))
 		if err != nil {
 			return fmt.Errorf("failed to write alias %v: %v", alias, err)
 		}
+		recordObject(client.EndpointURL().JoinPath(bucketName, objName).String())
 
 		log.Infof("Wrote %v to s3://%s/%s", alias, bucketName, path.Join(objectPrefix, alias))
 	}
 
+	if latest != "" {
+		if err := mirrorLatest(ctx, client, manifest.Version, bucketName, objectPrefix, latest, sse); err != nil {
+			return fmt.Errorf("failed to mirror %v: %v", latest, err)
+		}
+	}
+
+	return nil
+}
+
+// checkObjectOverwrites refuses to upload the release over objects already under the version prefix with different
+// checksums, so an already published release is never clobbered. Objects are compared by the sha256 recorded in their
+// metadata at upload, so publishing the same release again succeeds.
+func checkObjectOverwrites(ctx context.Context, client *minio.Client, bucketName, prefix string, manifest model.Manifest) error {
+	differing := []string{}
+	for obj := range client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: prefix + "/", Recursive: true}) {
+		if obj.Err != nil {
+			return fmt.Errorf("failed to list objects: %v", obj.Err)
+		}
+		rel := strings.TrimPrefix(obj.Key, prefix+"/")
+		// The published destinations are rewritten by every publish
+		file := filepath.Join(manifest.Directory, rel)
+		if rel == PublishedFile || !util.FileExists(file) {
+			continue
+		}
+		sha, err := fileSha(file)
+		if err != nil {
+			return fmt.Errorf("failed to checksum %v: %v", file, err)
+		}
+		info, err := client.StatObject(ctx, bucketName, obj.Key, minio.StatObjectOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to stat %v: %v", obj.Key, err)
+		}
+		// Objects uploaded without a recorded checksum cannot be compared, so are treated as differing
+		if info.UserMetadata["Sha256"] != sha {
+			differing = append(differing, obj.Key)
+		}
+	}
+	if len(differing) > 0 {
+		return fmt.Errorf("objects already exist in s3://%s with different checksums: %v; refusing to overwrite them without --overwrite",
+			bucketName, differing)
+	}
 	return nil
 }
 
+// s3Retention returns the object lock retention of the objects of the version, or none for pre-releases or without an
+// object lock.
+func s3Retention(lock *model.S3ObjectLock, version string) (minio.RetentionMode, time.Time, error) {
+	if lock == nil {
+		return "", time.Time{}, nil
+	}
+	mode := minio.RetentionMode(lock.Mode)
+	if !mode.IsValid() {
+		return "", time.Time{}, fmt.Errorf("unknown object lock mode %q, expected GOVERNANCE or COMPLIANCE", lock.Mode)
+	}
+	if lock.Days <= 0 {
+		return "", time.Time{}, fmt.Errorf("object lock days must be positive")
+	}
+	if util.IsPrerelease(version) {
+		log.Infof("Not locking objects of pre-release %v", version)
+		return "", time.Time{}, nil
+	}
+	return mode, time.Now().UTC().AddDate(0, 0, lock.Days), nil
+}
+
+// s3Encryption returns the server-side encryption requested by opts, or nil for the bucket default.
+func s3Encryption(opts model.S3Options) (encrypt.ServerSide, error) {
+	switch opts.SSE {
+	case "":
+		return nil, nil
+	case "s3":
+		return encrypt.NewSSE(), nil
+	case "kms":
+		if opts.KMSKeyID == "" {
+			return nil, fmt.Errorf("kms encryption requires a key ID")
+		}
+		return encrypt.NewSSEKMS(opts.KMSKeyID, nil)
+	default:
+		return nil, fmt.Errorf("unknown s3 encryption %q", opts.SSE)
+	}
+}
+
+// s3Tags returns the object tags, with each value executed as a template with the manifest.
+func s3Tags(manifest model.Manifest, templates map[string]string) (map[string]string, error) {
+	if len(templates) == 0 {
+		return nil, nil
+	}
+	res, err := executeValues(manifest, templates)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 tags: %v", err)
+	}
+	// Tags are checked against the S3 limits up front, rather than failing part way through the upload
+	if _, err := tags.NewTags(res, true); err != nil {
+		return nil, fmt.Errorf("invalid s3 tags: %v", err)
+	}
+	return res, nil
+}
+
+// executeValues executes each value of the map as a template with the manifest.
+func executeValues(manifest model.Manifest, templates map[string]string) (map[string]string, error) {
+	res := map[string]string{}
+	for k, v := range templates {
+		t, err := template.New(k).Option("missingkey=error").Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", k, err)
+		}
+		b := &strings.Builder{}
+		if err := t.Execute(b, manifest); err != nil {
+			return nil, fmt.Errorf("%v: %v", k, err)
+		}
+		res[k] = b.String()
+	}
+	return res, nil
+}
+
+// s3PutOptions returns the options to write the object with.
+func s3PutOptions(opts model.S3Options, sse encrypt.ServerSide, objName string) minio.PutObjectOptions {
+	putOpts := minio.PutObjectOptions{
+		ContentType:          contentType(objName),
+		ServerSideEncryption: sse,
+		StorageClass:         opts.StorageClass,
+		UserTags:             opts.Tags,
+	}
+	if opts.ACL != "" {
+		// minio sends x-amz-acl as a header, rather than as user metadata
+		putOpts.UserMetadata = map[string]string{"x-amz-acl": opts.ACL}
+	}
+	longest := ""
+	for suffix, cc := range opts.CacheControl {
+		if strings.HasSuffix(objName, suffix) && len(suffix) > len(longest) {
+			longest = suffix
+			putOpts.CacheControl = cc
+		}
+	}
+	return putOpts
+}
+
+// aliasPutOptions returns the options the alias object is written with, pointing to the version. With redirect set,
+// the alias also redirects to the version when the bucket is served as a website.
+func aliasPutOptions(opts model.S3Options, sse encrypt.ServerSide, objectPrefix, alias, version string, redirect bool) minio.PutObjectOptions {
+	putOpts := s3PutOptions(opts, sse, path.Join(objectPrefix, alias))
+	// Aliases hold the version they point to
+	putOpts.ContentType = "text/plain; charset=utf-8"
+	if redirect {
+		putOpts.WebsiteRedirectLocation = "/" + path.Join(objectPrefix, version) + "/"
+	}
+	return putOpts
+}
+
+// putVerified uploads the file, recording its sha256 in the object metadata and having the server verify a sha256
+// checksum of the upload. The checksum returned by the server is compared against the local file, so corrupted
+// uploads fail rather than being published.
+func putVerified(ctx context.Context, client *minio.Client, bucket, objName, file string, opts minio.PutObjectOptions) error {
+	sha, err := fileSha(file)
+	if err != nil {
+		return fmt.Errorf("failed to checksum %v: %v", file, err)
+	}
+	stat, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if opts.UserMetadata == nil {
+		opts.UserMetadata = map[string]string{}
+	}
+	opts.UserMetadata["sha256"] = sha
+	opts.AutoChecksum = minio.ChecksumSHA256
+	progress := util.NewProgress(fmt.Sprintf("s3://%s/%s", bucket, objName), stat.Size())
+	opts.Progress = progress
+	info, err := client.FPutObject(ctx, bucket, objName, file, opts)
+	if err != nil {
+		return fmt.Errorf("failed to put object %v: %v", objName, err)
+	}
+	progress.Done(0)
+	if info.Size != stat.Size() {
+		return fmt.Errorf("uploaded %v is %d bytes, expected %d", objName, info.Size, stat.Size())
+	}
+	recordObject(client.EndpointURL().JoinPath(bucket, objName).String())
+	// Multipart uploads return a checksum of the part checksums, which the server has verified
+	if info.ChecksumSHA256 == "" || strings.Contains(info.ChecksumSHA256, "-") {
+		log.Debugf("No full object checksum returned for %v", objName)
+		return nil
+	}
+	raw, err := hex.DecodeString(sha)
+	if err != nil {
+		return err
+	}
+	if want := base64.StdEncoding.EncodeToString(raw); info.ChecksumSHA256 != want {
+		return fmt.Errorf("uploaded %v has checksum %v, expected %v", objName, info.ChecksumSHA256, want)
+	}
+	return nil
+}
+
+// contentTypes maps file name suffixes of release files to their content type. Compressed archives are
+// served as application/gzip without a Content-Encoding, so clients save them as is rather than decompressing.
+var contentTypes = []struct {
+	suffix      string
+	contentType string
+}{
+	{".tar.gz", "application/gzip"},
+	{".tgz", "application/gzip"},
+	{".zip", "application/zip"},
+	{".yaml", "application/yaml"},
+	{".yml", "application/yaml"},
+	{".json", "application/json"},
+	{".sha256", "text/plain; charset=utf-8"},
+	{".sig", "text/plain; charset=utf-8"},
+	{".html", "text/html; charset=utf-8"},
+	{".txt", "text/plain; charset=utf-8"},
+	{".deb", "application/vnd.debian.binary-package"},
+	{".rpm", "application/x-rpm"},
+}
+
+// contentType detects the content type of the object from its name.
+func contentType(objName string) string {
+	for _, t := range contentTypes {
+		if strings.HasSuffix(objName, t.suffix) {
+			return t.contentType
+		}
+	}
+	if t := mime.TypeByExtension(path.Ext(objName)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// mirrorLatest copies the release files under the latest prefix, removing the version from their names so
+// download URLs such as .../latest/istioctl-linux-amd64.tar.gz are stable. Nothing is done if a newer release
+// has been published.
+func mirrorLatest(ctx context.Context, client *minio.Client, version, bucketName, objectPrefix, latest string, sse encrypt.ServerSide) error {
+	current, err := semver.NewVersion(version)
+	if err != nil {
+		log.Warnf("Not mirroring %v to %v: not a valid semver", version, latest)
+		return nil
+	}
+	newer, err := newerVersion(ctx, client, bucketName, objectPrefix, current)
+	if err != nil {
+		return err
+	}
+	if newer != "" {
+		log.Infof("Not mirroring %v to %v: %v is newer", version, latest, newer)
+		return nil
+	}
+
+	src := path.Join(objectPrefix, version) + "/"
+	dst := path.Join(objectPrefix, latest) + "/"
+	mirrored := map[string]struct{}{}
+	for obj := range client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: src, Recursive: true}) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		rel := strings.TrimPrefix(obj.Key, src)
+		objName := dst + strings.ReplaceAll(rel, "-"+version, "")
+		if _, err := client.CopyObject(ctx,
+			minio.CopyDestOptions{Bucket: bucketName, Object: objName, Encryption: sse},
+			minio.CopySrcOptions{Bucket: bucketName, Object: obj.Key}); err != nil {
+			return fmt.Errorf("failed to copy %v: %v", obj.Key, err)
+		}
+		mirrored[objName] = struct{}{}
+		log.Infof("Mirrored %v to s3://%s/%s", obj.Key, bucketName, objName)
+	}
+	// Remove anything left over from the previous latest release
+	for obj := range client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: dst, Recursive: true}) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		if _, f := mirrored[obj.Key]; f {
+			continue
+		}
+		if err := client.RemoveObject(ctx, bucketName, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+			return fmt.Errorf("failed to remove stale %v: %v", obj.Key, err)
+		}
+	}
+	return nil
+}
+
+// newerVersion returns a stable version published under the prefix newer than current, if any.
+func newerVersion(ctx context.Context, client *minio.Client, bucketName, objectPrefix string, current *semver.Version) (string, error) {
+	versions, err := publishedVersions(ctx, client, bucketName, objectPrefix)
+	if err != nil {
+		return "", err
+	}
+	for _, v := range versions {
+		if v.Prerelease() == "" && v.GreaterThan(current) {
+			return v.Original(), nil
+		}
+	}
+	return "", nil
+}
+
+// publishedVersions lists the versions published under the prefix.
+func publishedVersions(ctx context.Context, client *minio.Client, bucketName, objectPrefix string) ([]*semver.Version, error) {
+	prefix := ""
+	if objectPrefix != "" {
+		prefix = objectPrefix + "/"
+	}
+	versions := []*semver.Version{}
+	for obj := range client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: prefix}) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("failed to list versions: %v", obj.Err)
+		}
+		// Non-recursive listing returns each version "directory" as a prefix
+		v, err := semver.NewVersion(strings.Trim(strings.TrimPrefix(obj.Key, prefix), "/"))
+		if err != nil {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
 func FetchObject(client *minio.Client, bucket string, objectPrefix string, filename string) ([]byte, error) {
 	objName := filepath.Join(objectPrefix, filename)
 	getObjectResult, err := client.GetObject(context.Background(), bucket, objName, minio.GetObjectOptions{})
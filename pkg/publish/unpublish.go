@@ -0,0 +1,556 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/minio/minio-go/v7"
+	"github.com/spf13/cobra"
+	"istio.io/istio/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg"
+	"github.com/alauda-mesh/release-builder/pkg/build"
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+var (
+	unpublishFlags = struct {
+		s3bucket     string
+		s3alias      []string
+		s3redirect   bool
+		s3           model.S3Options
+		helmbucket   string
+		helmchannels []string
+		chartversion string
+		dockerhub    string
+		registries   []model.RegistryAuth
+		images       []string
+		variants     []string
+		dryrun       bool
+		deleteShared bool
+		manifest     string
+	}{
+		images:   []string{"pilot", "proxyv2", "install-cni", "ztunnel"},
+		variants: []string{"debug", "distroless"},
+		dryrun:   true,
+	}
+	unpublishCmd = &cobra.Command{
+		Use:          "unpublish <version>",
+		Short:        "Removes a published release of Istio",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			if unpublishFlags.manifest != "" {
+				in, err := pkg.ReadInManifest(unpublishFlags.manifest, "", nil)
+				if err != nil {
+					return fmt.Errorf("failed to read manifest: %v", err)
+				}
+				// Images are deleted with the credentials they were published with
+				unpublishFlags.registries = in.Registries
+				if p := in.Publish; p != nil {
+					if unpublishFlags.s3bucket == "" {
+						unpublishFlags.s3bucket = p.S3Bucket
+					}
+					if unpublishFlags.helmbucket == "" {
+						unpublishFlags.helmbucket = p.HelmBucket
+					}
+					// Charts of other channels are published to the publish.helmChannels repositories
+					for _, channel := range p.HelmChannels {
+						if channel.Bucket != "" {
+							unpublishFlags.helmchannels = append(unpublishFlags.helmchannels, channel.Bucket)
+						}
+					}
+					sort.Strings(unpublishFlags.helmchannels)
+					if unpublishFlags.dockerhub == "" {
+						unpublishFlags.dockerhub = p.DockerHub
+					}
+					// Reverted aliases are written like publish writes them
+					if s3 := p.S3; s3 != nil {
+						if unpublishFlags.s3.SSE == "" {
+							unpublishFlags.s3.SSE, unpublishFlags.s3.KMSKeyID = s3.SSE, s3.KMSKeyID
+						}
+						if unpublishFlags.s3.ACL == "" {
+							unpublishFlags.s3.ACL = s3.ACL
+						}
+						if unpublishFlags.s3.StorageClass == "" {
+							unpublishFlags.s3.StorageClass = s3.StorageClass
+						}
+						if len(unpublishFlags.s3.CacheControl) == 0 {
+							unpublishFlags.s3.CacheControl = s3.CacheControl
+						}
+					}
+					applyS3ClientDefaults(p.S3Client)
+					flags.requireapproval = flags.requireapproval || p.RequireApproval
+				}
+			}
+			return Unpublish(args[0])
+		},
+	}
+)
+
+func init() {
+	unpublishCmd.PersistentFlags().StringVar(&unpublishFlags.s3bucket, "s3bucket", unpublishFlags.s3bucket,
+		"The S3 bucket to remove binaries from. Example: istio-release/releases.")
+	unpublishCmd.PersistentFlags().StringSliceVar(&unpublishFlags.s3alias, "s3aliases", unpublishFlags.s3alias,
+		"Aliases to revert to the previous version, if they point to this version. Example: latest")
+	unpublishCmd.PersistentFlags().BoolVar(&unpublishFlags.s3redirect, "s3aliasredirect", unpublishFlags.s3redirect,
+		"Also set website redirect metadata on reverted alias objects, pointing to the previous version.")
+	unpublishCmd.PersistentFlags().StringVar(&unpublishFlags.s3.SSE, "s3sse", unpublishFlags.s3.SSE,
+		"Server-side encryption for reverted alias objects, either s3 or kms.")
+	unpublishCmd.PersistentFlags().StringVar(&unpublishFlags.s3.KMSKeyID, "s3kmskey", unpublishFlags.s3.KMSKeyID,
+		"KMS key ID for kms s3 encryption.")
+	unpublishCmd.PersistentFlags().StringVar(&unpublishFlags.s3.ACL, "s3acl", unpublishFlags.s3.ACL,
+		"Canned ACL for reverted alias objects, such as public-read.")
+	unpublishCmd.PersistentFlags().StringVar(&unpublishFlags.helmbucket, "helmbucket", unpublishFlags.helmbucket,
+		"The S3 bucket to remove helm charts from. Example: istio-release/charts.")
+	unpublishCmd.PersistentFlags().StringVar(&unpublishFlags.chartversion, "chartversion", unpublishFlags.chartversion,
+		"The version of the charts to remove, if released with a chartVersionSuffix. Defaults to the version.")
+	unpublishCmd.PersistentFlags().StringVar(&unpublishFlags.dockerhub, "dockerhub", unpublishFlags.dockerhub,
+		"The docker hub to delete image tags from. Example: docker.io/istio.")
+	unpublishCmd.PersistentFlags().StringSliceVar(&unpublishFlags.images, "images", unpublishFlags.images,
+		"The images to delete tags of.")
+	unpublishCmd.PersistentFlags().StringSliceVar(&unpublishFlags.variants, "variants", unpublishFlags.variants,
+		"The image variants to delete tags of.")
+	unpublishCmd.PersistentFlags().BoolVar(&unpublishFlags.dryrun, "dryrun", unpublishFlags.dryrun,
+		"Only log what would be removed.")
+	unpublishCmd.PersistentFlags().BoolVar(&unpublishFlags.deleteShared, "delete-shared", unpublishFlags.deleteShared,
+		"Delete images by digest even if other tags, such as latest or a promoted release candidate, refer to them.")
+	unpublishCmd.PersistentFlags().StringVar(&unpublishFlags.manifest, "manifest", unpublishFlags.manifest,
+		"The manifest to read the default bucket and hub, and whether approval is required, from.")
+	unpublishCmd.PersistentFlags().StringVar(&flags.auditlog, "auditlog", flags.auditlog,
+		"The file to append audit log entries to.")
+	addApprovalFlags(unpublishCmd.PersistentFlags())
+	addLockFlags(unpublishCmd.PersistentFlags())
+}
+
+func GetUnpublishCommand() *cobra.Command {
+	return unpublishCmd
+}
+
+// Unpublish removes a release from each configured target, to recover from a botched release.
+func Unpublish(version string) error {
+	// The version names the prefix removed from the bucket, so anything but a single version would remove other releases
+	if strings.ContainsAny(version, "/\\") || version == "." || version == ".." {
+		return fmt.Errorf("invalid version %q: must be a single path segment", version)
+	}
+	if _, err := semver.NewVersion(version); err != nil {
+		return fmt.Errorf("invalid version %q: %v", version, err)
+	}
+	// Dry runs change nothing, so need no approval or locks
+	var locks heldLocks
+	if !unpublishFlags.dryrun {
+		required, err := publishedRequiresApproval(version)
+		if err != nil {
+			return err
+		}
+		if err := checkApproval(required, "unpublish", version); err != nil {
+			return err
+		}
+		// Aliases and index.yaml are rewritten, so the buckets are locked against concurrent publishes
+		buckets := append([]string{unpublishFlags.s3bucket, unpublishFlags.helmbucket}, unpublishFlags.helmchannels...)
+		if locks, err = acquireLocks(version, lockBuckets(buckets...)); err != nil {
+			return err
+		}
+		defer locks.Release()
+	}
+	if unpublishFlags.s3bucket != "" {
+		if err := unpublishS3(version, unpublishFlags.s3bucket, unpublishFlags.s3alias, unpublishFlags.s3redirect, unpublishFlags.s3); err != nil {
+			return fmt.Errorf("failed to unpublish from s3: %v", err)
+		}
+	}
+	if err := locks.Err(); err != nil {
+		return err
+	}
+	for _, bucket := range lockBuckets(append([]string{unpublishFlags.helmbucket}, unpublishFlags.helmchannels...)...) {
+		if err := unpublishHelm(version, bucket); err != nil {
+			return fmt.Errorf("failed to unpublish helm charts from %v: %v", bucket, err)
+		}
+		if err := locks.Err(); err != nil {
+			return err
+		}
+	}
+	if unpublishFlags.dockerhub != "" {
+		if err := unpublishDocker(version, unpublishFlags.dockerhub); err != nil {
+			return fmt.Errorf("failed to unpublish docker images: %v", err)
+		}
+	}
+	return nil
+}
+
+// publishedRequiresApproval returns whether the manifest published with the version in the s3 bucket requires
+// approval, so the requirement of a release is kept even if unpublish is not passed its --manifest.
+func publishedRequiresApproval(version string) (bool, error) {
+	if unpublishFlags.s3bucket == "" {
+		return false, nil
+	}
+	client, err := NewS3Client(context.Background())
+	if err != nil {
+		return false, err
+	}
+	bucketName, objectPrefix := splitBucket(unpublishFlags.s3bucket)
+	by, err := FetchObject(client, bucketName, path.Join(objectPrefix, version), "manifest.yaml")
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to read published manifest: %v", err)
+	}
+	manifest := model.Manifest{}
+	if err := yaml.Unmarshal(by, &manifest); err != nil {
+		return false, fmt.Errorf("failed to read published manifest: %v", err)
+	}
+	return requiresApproval(manifest.Publish), nil
+}
+
+func splitBucket(bucket string) (string, string) {
+	bucketName, objectPrefix, _ := strings.Cut(bucket, "/")
+	return bucketName, objectPrefix
+}
+
+// removeObjects deletes all objects under the prefix that match the filter.
+func removeObjects(ctx context.Context, client *minio.Client, bucketName, prefix string, match func(key string) bool) error {
+	for obj := range client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return fmt.Errorf("failed to list objects: %v", obj.Err)
+		}
+		if !match(obj.Key) {
+			continue
+		}
+		if unpublishFlags.dryrun {
+			log.Infof("Would remove s3://%s/%s", bucketName, obj.Key)
+			continue
+		}
+		if err := client.RemoveObject(ctx, bucketName, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+			return fmt.Errorf("failed to remove %v: %v", obj.Key, err)
+		}
+		log.Infof("Removed s3://%s/%s", bucketName, obj.Key)
+	}
+	return nil
+}
+
+func unpublishS3(version string, bucket string, aliases []string, aliasRedirect bool, opts model.S3Options) error {
+	sse, err := s3Encryption(opts)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	client, err := NewS3Client(ctx)
+	if err != nil {
+		return err
+	}
+	bucketName, objectPrefix := splitBucket(bucket)
+	// The versions the aliases pointed to before are recorded in the published.yaml of the version, and its channel in
+	// its manifest, so both are read before the version is removed
+	recorded := recordedAliases(client, bucket, version)
+	channel, _ := publishedChannel(client, bucketName, objectPrefix, version)
+	prefix := path.Join(objectPrefix, version) + "/"
+	if err := removeObjects(ctx, client, bucketName, prefix, func(string) bool { return true }); err != nil {
+		return err
+	}
+
+	for _, alias := range aliases {
+		objName := path.Join(objectPrefix, alias)
+		current, err := FetchObject(client, bucketName, objectPrefix, alias)
+		if err != nil || strings.TrimSpace(string(current)) != version {
+			log.Infof("Alias %v does not point to %v, leaving it", alias, version)
+			continue
+		}
+		previous, f := recorded[alias]
+		// The recorded version may since have been unpublished itself
+		if f && previous != "" {
+			_, f = publishedChannel(client, bucketName, objectPrefix, previous)
+		}
+		if !f {
+			versions, err := listVersionTimes(ctx, client, bucketName, objectPrefix)
+			if err != nil {
+				return err
+			}
+			previous, err = previousVersion(versions, version, channel, alias, func(v string) (model.Channel, bool) {
+				return publishedChannel(client, bucketName, objectPrefix, v)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if unpublishFlags.dryrun {
+			log.Infof("Would revert alias %v to %q", alias, previous)
+			continue
+		}
+		if previous == "" {
+			if err := client.RemoveObject(ctx, bucketName, objName, minio.RemoveObjectOptions{}); err != nil {
+				return fmt.Errorf("failed to remove alias %v: %v", alias, err)
+			}
+		} else if _, err := client.PutObject(ctx, bucketName, objName, strings.NewReader(previous), int64(len(previous)),
+			aliasPutOptions(opts, sse, objectPrefix, alias, previous, aliasRedirect)); err != nil {
+			return fmt.Errorf("failed to revert alias %v: %v", alias, err)
+		}
+		log.Infof("Reverted alias %v to %q", alias, previous)
+	}
+	return Audit(version, "unpublish-s3", map[string]string{"bucket": bucket, "dryrun": fmt.Sprint(unpublishFlags.dryrun)})
+}
+
+// recordedAliases returns the version each alias in bucket pointed to before version was published, as recorded in its
+// published.yaml. Releases published without --uploadpublished have no record.
+func recordedAliases(client *minio.Client, bucket, version string) map[string]string {
+	bucketName, objectPrefix := splitBucket(bucket)
+	recorded := map[string]string{}
+	by, err := FetchObject(client, bucketName, path.Join(objectPrefix, version), PublishedFile)
+	if err != nil {
+		return recorded
+	}
+	p := Published{}
+	if err := yaml.Unmarshal(by, &p); err != nil {
+		log.Warnf("Ignoring invalid %v of %v: %v", PublishedFile, version, err)
+		return recorded
+	}
+	for _, a := range p.Aliases {
+		if a.Bucket == bucket {
+			recorded[a.Name] = a.Previous
+		}
+	}
+	return recorded
+}
+
+// publishedChannel returns the channel of a version in the bucket, from its manifest, and whether the manifest is
+// published at all.
+func publishedChannel(client *minio.Client, bucketName, objectPrefix, version string) (model.Channel, bool) {
+	by, err := FetchObject(client, bucketName, path.Join(objectPrefix, version), "manifest.yaml")
+	if err != nil {
+		return "", false
+	}
+	manifest := model.Manifest{}
+	if err := yaml.Unmarshal(by, &manifest); err != nil {
+		return "", false
+	}
+	return manifest.Channel, true
+}
+
+// previousVersion finds the version an alias pointing to the removed version reverts to, when the version it replaced
+// was not recorded: the newest other version of the same channel, and of the same minor if the alias names it, such as
+// 1.25-dev. Aliases of the daily and weekly channels revert to the most recently published build, as dev build
+// versions do not order. Other aliases never point to pre-releases, so revert to the newest older release.
+func previousVersion(versions []publishedVersion, version string, channel model.Channel, alias string,
+	channelOf func(version string) (model.Channel, bool),
+) (string, error) {
+	removed, err := semver.NewVersion(version)
+	if err != nil {
+		return "", fmt.Errorf("cannot determine previous version of non-semver %v: %v", version, err)
+	}
+	dev := channel == model.ChannelDaily || channel == model.ChannelWeekly
+	minor := fmt.Sprintf("%d.%d", removed.Major(), removed.Minor())
+	scoped := regexp.MustCompile(`(^|[^0-9.])` + regexp.QuoteMeta(minor) + `([^0-9.]|$)`).MatchString(alias)
+	candidates := []publishedVersion{}
+	for _, v := range versions {
+		switch {
+		case v.version.Original() == version:
+			continue
+		case scoped && (v.version.Major() != removed.Major() || v.version.Minor() != removed.Minor()):
+			continue
+		case !dev && (v.version.Prerelease() != "" || v.version.GreaterThan(removed)):
+			continue
+		}
+		candidates = append(candidates, v)
+	}
+	if dev {
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].modified.After(candidates[j].modified) })
+	} else {
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].version.GreaterThan(candidates[j].version) })
+	}
+	// Channels are only recorded in the manifest of each version, so are read for the candidates, newest first
+	for _, v := range candidates {
+		if c, ok := channelOf(v.version.Original()); ok && c == channel {
+			return v.version.Original(), nil
+		}
+	}
+	return "", nil
+}
+
+// unpublishHelm removes the charts of the version, and their digest pinned variants, from the index.yaml and bucket.
+func unpublishHelm(version string, bucket string) error {
+	chartVersion := version
+	if unpublishFlags.chartversion != "" {
+		chartVersion = unpublishFlags.chartversion
+	}
+	chartVersions := []string{chartVersion, chartVersion + build.PinnedChartSuffix}
+	ctx := context.Background()
+	client, err := NewS3Client(ctx)
+	if err != nil {
+		return err
+	}
+	bucketName, objectPrefix := splitBucket(bucket)
+
+	tmpDir, err := os.MkdirTemp("", "unpublish-helm")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	if unpublishFlags.dryrun {
+		log.Infof("Would remove versions %v from s3://%s/%s", chartVersions, bucketName, path.Join(objectPrefix, "index.yaml"))
+	} else {
+		err = MutateObject(tmpDir, client, bucketName, objectPrefix, "index.yaml", func() error {
+			return removeIndexVersion(filepath.Join(tmpDir, "index.yaml"), chartVersions...)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	prefix := ""
+	if objectPrefix != "" {
+		prefix = objectPrefix + "/"
+	}
+	if err := removeObjects(ctx, client, bucketName, prefix, func(key string) bool {
+		for _, v := range chartVersions {
+			if strings.HasSuffix(key, "-"+v+".tgz") {
+				return true
+			}
+		}
+		return false
+	}); err != nil {
+		return err
+	}
+	return Audit(version, "unpublish-helm", map[string]string{"bucket": bucket, "dryrun": fmt.Sprint(unpublishFlags.dryrun)})
+}
+
+// removeIndexVersion removes all chart entries of the versions from a helm index.yaml.
+func removeIndexVersion(file string, versions ...string) error {
+	by, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	idx := map[string]any{}
+	if err := yaml.Unmarshal(by, &idx); err != nil {
+		return fmt.Errorf("failed to unmarshal index: %v", err)
+	}
+	entries, _ := idx["entries"].(map[string]any)
+	for chart, chartVersions := range entries {
+		list, _ := chartVersions.([]any)
+		kept := []any{}
+		for _, v := range list {
+			if cv, ok := v.(map[string]any); ok {
+				if version, _ := cv["version"].(string); slices.Contains(versions, version) {
+					log.Infof("Removing %v %v from index", chart, version)
+					continue
+				}
+			}
+			kept = append(kept, v)
+		}
+		if len(kept) == 0 {
+			delete(entries, chart)
+		} else {
+			entries[chart] = kept
+		}
+	}
+	out, err := yaml.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, out, 0o644)
+}
+
+// unpublishDocker deletes the image tags of the version. Registries that refuse to delete tags are deleted from by
+// digest, which deletes any other tags of the same image too, so this is refused if other tags, such as latest or a
+// promoted release candidate, refer to the image, unless --delete-shared is set. Images that could not be deleted,
+// such as on registries that refuse deletes, are reported together.
+func unpublishDocker(version string, hub string) error {
+	opts := []remote.Option{remote.WithAuthFromKeychain(util.Keychain(unpublishFlags.registries))}
+	var errs []error
+	for _, img := range unpublishFlags.images {
+		for _, variant := range unpublishFlags.variants {
+			// The default variant is saved, and published, as the debug variant
+			if variant == "default" {
+				variant = "debug"
+			}
+			if !model.ImageVariantBuilt(img, variant) {
+				continue
+			}
+			published := Image{NewTag: fmt.Sprintf("%s/%s:%s", hub, img, version), Variant: variant}.NewReference("")
+			ref, err := name.ParseReference(published)
+			if err != nil {
+				return fmt.Errorf("failed to parse %v: %v", published, err)
+			}
+			if unpublishFlags.dryrun {
+				log.Infof("Would delete %v", published)
+				continue
+			}
+			desc, err := remote.Head(ref, opts...)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to resolve %v: %v", published, err))
+				continue
+			}
+			if err := remote.Delete(ref, opts...); err == nil {
+				log.Infof("Deleted %v", published)
+				continue
+			}
+			if !unpublishFlags.deleteShared {
+				shared, err := sharedTags(ref, desc.Digest.String(), opts)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("failed to find other tags of %v: %v", published, err))
+					continue
+				}
+				if len(shared) > 0 {
+					errs = append(errs, fmt.Errorf("not deleting %v@%v, which is also tagged %v; set --delete-shared to delete it",
+						published, desc.Digest, shared))
+					continue
+				}
+			}
+			if err := remote.Delete(ref.Context().Digest(desc.Digest.String()), opts...); err != nil {
+				errs = append(errs, fmt.Errorf("%v refused to delete %v@%v: %v", ref.Context().RegistryStr(), published, desc.Digest, err))
+				continue
+			}
+			log.Infof("Deleted %v@%v", published, desc.Digest)
+		}
+	}
+	if err := Audit(version, "unpublish-docker", map[string]string{"hub": hub, "dryrun": fmt.Sprint(unpublishFlags.dryrun)}); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// sharedTags returns the tags of the repository, other than that of ref, which refer to the digest.
+func sharedTags(ref name.Reference, digest string, opts []remote.Option) ([]string, error) {
+	tags, err := remote.List(ref.Context(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	var shared []string
+	for _, tag := range tags {
+		if tag == ref.Identifier() {
+			continue
+		}
+		desc, err := remote.Head(ref.Context().Tag(tag), opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %v: %v", tag, err)
+		}
+		if desc.Digest.String() == digest {
+			shared = append(shared, tag)
+		}
+	}
+	return shared, nil
+}
@@ -0,0 +1,150 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+func TestPreviousVersion(t *testing.T) {
+	now := time.Now()
+	channels := map[string]model.Channel{
+		"1.24.3":          model.ChannelStable,
+		"1.25.0":          "",
+		"1.25.1":          "",
+		"1.26.0":          "",
+		"1.26.0-rc.0":     "",
+		"1.26-alpha.bbbb": model.ChannelDaily,
+		"1.26-alpha.aaaa": model.ChannelDaily,
+		"1.26-alpha.cccc": model.ChannelDaily,
+		"1.26-alpha.dddd": model.ChannelWeekly,
+		"1.25-alpha.eeee": model.ChannelDaily,
+	}
+	versions := []publishedVersion{}
+	age := 0
+	// Dev builds are published in the order listed, which is not their version order
+	for _, v := range []string{
+		"1.24.3", "1.25.0", "1.25.1", "1.26.0-rc.0", "1.26.0",
+		"1.25-alpha.eeee", "1.26-alpha.bbbb", "1.26-alpha.dddd", "1.26-alpha.aaaa", "1.26-alpha.cccc",
+	} {
+		age++
+		versions = append(versions, publishedVersion{version: semver.MustParse(v), modified: now.Add(time.Duration(age) * time.Hour)})
+	}
+	channelOf := func(v string) (model.Channel, bool) {
+		c, f := channels[v]
+		return c, f
+	}
+	cases := []struct {
+		name    string
+		version string
+		channel model.Channel
+		alias   string
+		want    string
+	}{
+		{name: "latest", version: "1.26.0", alias: "latest", want: "1.25.1"},
+		{name: "minor alias", version: "1.25.1", alias: "1.25-latest", want: "1.25.0"},
+		{name: "minor alias of first release", version: "1.26.0", alias: "1.26-latest", want: ""},
+		{name: "daily", version: "1.26-alpha.cccc", channel: model.ChannelDaily, alias: "latest-daily", want: "1.26-alpha.aaaa"},
+		{name: "daily minor alias", version: "1.25-alpha.eeee", channel: model.ChannelDaily, alias: "1.25-dev-daily", want: ""},
+		{name: "weekly", version: "1.26-alpha.dddd", channel: model.ChannelWeekly, alias: "latest-weekly", want: ""},
+		{name: "stable channel", version: "1.26.0", channel: model.ChannelStable, alias: "latest-stable", want: "1.24.3"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := previousVersion(versions, tt.version, tt.channel, tt.alias, channelOf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoveIndexVersion(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "index.yaml")
+	index := `apiVersion: v1
+entries:
+  base:
+  - name: base
+    version: 1.24.1
+  - name: base
+    version: 1.24.1-pinned
+  - name: base
+    version: 1.24.0
+  gateway:
+  - name: gateway
+    version: 1.24.1
+`
+	if err := os.WriteFile(file, []byte(index), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeIndexVersion(file, "1.24.1", "1.24.1-pinned"); err != nil {
+		t.Fatal(err)
+	}
+	by, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := struct {
+		Entries map[string][]struct {
+			Version string `json:"version"`
+		} `json:"entries"`
+	}{}
+	if err := yaml.Unmarshal(by, &got); err != nil {
+		t.Fatal(err)
+	}
+	versions := map[string][]string{}
+	for chart, entries := range got.Entries {
+		for _, e := range entries {
+			versions[chart] = append(versions[chart], e.Version)
+		}
+	}
+	if want := map[string][]string{"base": {"1.24.0"}}; !reflect.DeepEqual(versions, want) {
+		t.Fatalf("got %v, want %v", versions, want)
+	}
+}
+
+func TestAliasPutOptions(t *testing.T) {
+	opts := model.S3Options{SSE: "s3", ACL: "public-read", CacheControl: map[string]string{".tar.gz": "max-age=31536000"}}
+	sse, err := s3Encryption(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reverting an alias writes it as publish did, pointing to the previous version
+	got := aliasPutOptions(opts, sse, "releases", "latest", "1.24.2", true)
+	if got.ContentType != "text/plain; charset=utf-8" {
+		t.Errorf("expected a text alias, got %v", got.ContentType)
+	}
+	if got.ServerSideEncryption == nil || got.UserMetadata["x-amz-acl"] != "public-read" {
+		t.Errorf("expected the alias to be encrypted and public, got %+v", got)
+	}
+	if got.WebsiteRedirectLocation != "/releases/1.24.2/" {
+		t.Errorf("expected a redirect to the previous version, got %v", got.WebsiteRedirectLocation)
+	}
+	if got := aliasPutOptions(opts, sse, "releases", "latest", "1.24.2", false); got.WebsiteRedirectLocation != "" {
+		t.Errorf("expected no redirect, got %v", got.WebsiteRedirectLocation)
+	}
+}
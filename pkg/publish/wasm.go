@@ -0,0 +1,67 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// Wasm pushes the Wasm extension OCI artifacts to <hub>/<name>, with each of the tags.
+func Wasm(manifest model.Manifest, hub string, tags []string) error {
+	if len(tags) == 0 {
+		tags = []string{manifest.Version}
+	}
+	keychain := util.Keychain(manifest.Registries)
+	for _, w := range manifest.WasmExtensions {
+		dir := filepath.Join(manifest.Directory, manifest.ArtifactDir("wasm", ""), "oci", w.Name)
+		idx, err := layout.ImageIndexFromPath(dir)
+		if err != nil {
+			return fmt.Errorf("failed to read wasm extension %v: %v", w.Name, err)
+		}
+		im, err := idx.IndexManifest()
+		if err != nil {
+			return err
+		}
+		if len(im.Manifests) != 1 {
+			return fmt.Errorf("expected a single wasm artifact in %v, found %d", dir, len(im.Manifests))
+		}
+		digest := im.Manifests[0].Digest
+		img, err := idx.Image(digest)
+		if err != nil {
+			return err
+		}
+		for _, tag := range tags {
+			ref, err := name.ParseReference(fmt.Sprintf("%s/%s:%s", hub, w.Name, tag))
+			if err != nil {
+				return err
+			}
+			if err := remote.Write(ref, img, remote.WithAuthFromKeychain(keychain)); err != nil {
+				return fmt.Errorf("failed to push %v: %v", ref, err)
+			}
+			recordImage(ref.String(), digest.String())
+			log.Infof("Pushed wasm extension %v to %v", w.Name, ref)
+		}
+	}
+	return nil
+}
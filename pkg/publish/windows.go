@@ -0,0 +1,73 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package publish
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// WindowsPackages opens PRs updating the Scoop bucket and Chocolatey package sources with the generated
+// istioctl packages.
+func WindowsPackages(manifest model.Manifest, token string) error {
+	dir := filepath.Join(manifest.Directory, manifest.ArtifactDir("windows", ""))
+	cfg := manifest.WindowsPackages
+	if cfg.ScoopRepo != nil {
+		files, err := readPackageFiles(filepath.Join(dir, "scoop"), "bucket")
+		if err != nil {
+			return err
+		}
+		if err := updatePackageRepo(manifest, "scoop-bucket", cfg.ScoopRepo, files, token); err != nil {
+			return fmt.Errorf("failed to update scoop bucket: %v", err)
+		}
+	}
+	if cfg.ChocolateyRepo != nil {
+		files, err := readPackageFiles(filepath.Join(dir, "chocolatey"), "istioctl")
+		if err != nil {
+			return err
+		}
+		if err := updatePackageRepo(manifest, "chocolatey-packages", cfg.ChocolateyRepo, files, token); err != nil {
+			return fmt.Errorf("failed to update chocolatey packages: %v", err)
+		}
+	}
+	return nil
+}
+
+// readPackageFiles reads all files under dir, keyed by their path under prefix in the package repo.
+func readPackageFiles(dir string, prefix string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		contents, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[filepath.Join(prefix, rel)] = contents
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read packages from %v: %v", dir, err)
+	}
+	return files, nil
+}
@@ -16,6 +16,7 @@ package pkg
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -30,9 +31,10 @@ import (
 
 // Sources will copy all dependencies require, pulling from Github if required, and set up the working tree.
 // This includes locally tagging all git repos with the version being built, so that the right version is present in binaries.
-func Sources(manifest model.Manifest) error {
+func Sources(manifest model.Manifest, opts util.CloneOptions) error {
+	opts.Git = manifest.Git
 	// Clone istio first, as it is needed to determine which other dependencies to use
-	if err := cloneRepo(manifest, "istio", manifest.Dependencies.Istio); err != nil {
+	if err := cloneRepo(manifest, "istio", manifest.Dependencies.Istio, opts); err != nil {
 		return err
 	}
 
@@ -44,14 +46,20 @@ func Sources(manifest model.Manifest) error {
 			log.Warnf("skipping clone of missing dependency: %v", repo)
 			continue
 		}
-		if err := cloneRepo(manifest, repo, dependency); err != nil {
+		if err := cloneRepo(manifest, repo, dependency, opts); err != nil {
 			return err
 		}
 	}
 
 	// Clone envoy at the end. It needs proxy repo to determine its SHA.
 	if manifest.Dependencies.Envoy != nil {
-		if err := cloneRepo(manifest, "envoy", manifest.Dependencies.Envoy); err != nil {
+		if err := cloneRepo(manifest, "envoy", manifest.Dependencies.Envoy, opts); err != nil {
+			return err
+		}
+	}
+
+	for _, w := range manifest.WasmExtensions {
+		if err := cloneRepo(manifest, w.RepoName(), &w.Repo, opts); err != nil {
 			return err
 		}
 	}
@@ -59,13 +67,14 @@ func Sources(manifest model.Manifest) error {
 	return nil
 }
 
-func cloneRepo(manifest model.Manifest, repo string, dependency *model.Dependency) error {
+func cloneRepo(manifest model.Manifest, repo string, dependency *model.Dependency, opts util.CloneOptions) error {
+	logger := log.WithLabels("repo", repo)
 	src := path.Join(manifest.SourceDir(), repo)
 	// Fetch the dependency
-	if err := util.Clone(repo, *dependency, src); err != nil {
+	if err := util.Clone(repo, *dependency, src, opts); err != nil {
 		return fmt.Errorf("failed to resolve %+v: %v", dependency, err)
 	}
-	log.Infof("Resolved %v", repo)
+	logger.Infof("Resolved %v", repo)
 	// Also copy it to the working directory
 	if err := util.CopyDir(src, manifest.RepoDir(repo)); err != nil {
 		return fmt.Errorf("failed to copy dependency %v to working directory: %v", repo, err)
@@ -127,7 +136,10 @@ func GetSha(repo string, ref string) (string, error) {
 
 // StandardizeManifest will convert a manifest to a fixed SHA, rather than a branch
 // This allows outputting the exact version used after the build is complete
+// If any sources have uncommitted changes, the version is marked with a -dirty suffix, so everything it is
+// stamped into records that the build cannot be reproduced from the SHAs.
 func StandardizeManifest(manifest *model.Manifest) error {
+	anyDirty := false
 	for repo, dep := range manifest.Dependencies.Get() {
 		if dep == nil {
 			continue
@@ -136,11 +148,99 @@ func StandardizeManifest(manifest *model.Manifest) error {
 		if err != nil {
 			return fmt.Errorf("failed to get SHA for %v: %v", repo, err)
 		}
+		dirty := false
+		if dep.LocalPath != "" {
+			dirty, err = IsDirty(path.Join(manifest.SourceDir(), repo))
+			if err != nil {
+				return fmt.Errorf("failed to get status for %v: %v", repo, err)
+			}
+			if dirty {
+				log.Warnf("%v has uncommitted changes from %v; marking as dirty", repo, dep.LocalPath)
+			}
+		}
 		newDep := model.Dependency{
 			Sha:              strings.TrimSpace(sha),
 			GoVersionEnabled: dep.GoVersionEnabled,
+			Dirty:            dirty,
 		}
 		manifest.Dependencies.Set(repo, newDep)
+		anyDirty = anyDirty || dirty
+	}
+	if anyDirty && !strings.HasSuffix(manifest.Version, DirtySuffix) {
+		tagged := manifest.Version
+		manifest.Version += DirtySuffix
+		log.Warnf("Building version %v from uncommitted changes", manifest.Version)
+		if err := retagRepos(*manifest, tagged); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retagRepos moves the tag Sources placed on each repo to the current version, so the binaries built from
+// the repos report the same version as the rest of the release.
+func retagRepos(manifest model.Manifest, tagged string) error {
+	repos := []string{}
+	for repo, dep := range manifest.Dependencies.Get() {
+		if dep != nil {
+			repos = append(repos, repo)
+		}
+	}
+	for _, w := range manifest.WasmExtensions {
+		repos = append(repos, w.RepoName())
+	}
+	for _, repo := range repos {
+		dir := manifest.RepoDir(repo)
+		if _, err := GetSha(dir, "refs/tags/"+tagged); err == nil {
+			cmd := util.VerboseCommand("git", "tag", "--delete", tagged)
+			cmd.Dir = dir
+			if err := cmd.Run(); err != nil {
+				return fmt.Errorf("failed to remove tag %v from %v: %v", tagged, repo, err)
+			}
+		}
+		if err := TagRepo(manifest, dir); err != nil {
+			return fmt.Errorf("failed to tag repo %v: %v", repo, err)
+		}
 	}
 	return nil
 }
+
+// DirtySuffix marks the version of a release built from sources with uncommitted changes.
+const DirtySuffix = "-dirty"
+
+// VerifyDependencyChecks checks that the required checks of each dependency have passed on the SHA it was
+// fetched at, so releases are not built from red commits. It must run before StandardizeManifest, which drops
+// the checks from the manifest.
+func VerifyDependencyChecks(manifest model.Manifest) error {
+	errs := []error{}
+	for repo, dep := range manifest.Dependencies.Get() {
+		if dep == nil || len(dep.RequiredChecks) == 0 {
+			continue
+		}
+		sha, err := GetSha(manifest.RepoDir(repo), "HEAD")
+		if err != nil {
+			return fmt.Errorf("failed to get SHA for %v: %v", repo, err)
+		}
+		sha = strings.TrimSpace(sha)
+		failing, err := util.FailingChecks(dep.Git, sha, dep.RequiredChecks)
+		if err != nil {
+			return fmt.Errorf("failed to check %v: %v", repo, err)
+		}
+		if len(failing) > 0 {
+			errs = append(errs, fmt.Errorf("%v at %v has failing checks: %v", repo, sha, strings.Join(failing, ", ")))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// IsDirty returns true if the git repo has uncommitted changes
+func IsDirty(repo string) (bool, error) {
+	buf := bytes.Buffer{}
+	cmd := exec.Command("git", "status", "--porcelain")
+	cmd.Stdout = &buf
+	cmd.Dir = repo
+	if err := cmd.Run(); err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(buf.String()) != "", nil
+}
@@ -0,0 +1,70 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+func TestStandardizeManifestRetagsDirty(t *testing.T) {
+	manifest := model.Manifest{
+		Version:   "1.24.0",
+		Directory: t.TempDir(),
+		Dependencies: model.IstioDependencies{
+			Istio: &model.Dependency{LocalPath: "/local/istio"},
+		},
+	}
+	for _, dir := range []string{filepath.Join(manifest.SourceDir(), "istio"), manifest.RepoDir("istio")} {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			t.Fatal(err)
+		}
+		gitCmd(t, dir, "init", "-q")
+		gitCmd(t, dir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "init")
+		if err := os.WriteFile(filepath.Join(dir, "uncommitted"), []byte("change"), 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Sources tags the repo before dirtiness is known
+	if err := TagRepo(manifest, manifest.RepoDir("istio")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := StandardizeManifest(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Version != "1.24.0-dirty" {
+		t.Fatalf("expected version 1.24.0-dirty, got %v", manifest.Version)
+	}
+	if got := gitCmd(t, manifest.RepoDir("istio"), "tag", "--list"); got != "1.24.0-dirty" {
+		t.Fatalf("expected the repo to be tagged 1.24.0-dirty, got %q", got)
+	}
+}
+
+func gitCmd(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
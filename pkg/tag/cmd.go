@@ -0,0 +1,88 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tag
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/spf13/cobra"
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+var (
+	flags = struct {
+		release         string
+		branch          string
+		sign            bool
+		signkey         string
+		dryrun          bool
+		githubTokenFile string
+	}{
+		dryrun: true,
+	}
+	tagCmd = &cobra.Command{
+		Use:          "tag",
+		Short:        "Tags the source repos of a release at the commits it was built from",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(0),
+		RunE: func(c *cobra.Command, _ []string) error {
+			if flags.release == "" {
+				return fmt.Errorf("invalid flags: --release required")
+			}
+			manifest, err := pkg.ReadManifest(path.Join(flags.release, "manifest.yaml"))
+			if err != nil {
+				return fmt.Errorf("failed to read manifest from release: %v", err)
+			}
+			token, err := util.GetGithubToken(flags.githubTokenFile)
+			if err != nil {
+				return err
+			}
+			if err := Tag(manifest, Options{
+				Branch:  flags.branch,
+				Sign:    flags.sign,
+				SignKey: flags.signkey,
+				DryRun:  flags.dryrun,
+				Token:   token,
+			}); err != nil {
+				return fmt.Errorf("failed to tag: %v", err)
+			}
+			log.Infof("Tagged release %v", manifest.Version)
+			return nil
+		},
+	}
+)
+
+func init() {
+	tagCmd.PersistentFlags().StringVar(&flags.release, "release", flags.release,
+		"The directory with the Istio release binary.")
+	tagCmd.PersistentFlags().StringVar(&flags.branch, "branch", flags.branch,
+		"If set, also create this release branch at the tagged commits. Example: release-1.24")
+	tagCmd.PersistentFlags().BoolVar(&flags.sign, "sign", flags.sign,
+		"GPG sign the tags.")
+	tagCmd.PersistentFlags().StringVar(&flags.signkey, "signkey", flags.signkey,
+		"The GPG key to sign tags with. Defaults to git's user.signingkey.")
+	tagCmd.PersistentFlags().BoolVar(&flags.dryrun, "dryrun", flags.dryrun,
+		"Create the tags locally, but do not push them.")
+	tagCmd.PersistentFlags().StringVar(&flags.githubTokenFile, "githubtoken", flags.githubTokenFile,
+		"The file containing a github token.")
+}
+
+func GetTagCommand() *cobra.Command {
+	return tagCmd
+}
@@ -0,0 +1,144 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tag
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// Options configures how the repos are tagged.
+type Options struct {
+	// Branch, if set, is created at the tagged commit as well.
+	Branch string
+	// Sign GPG signs the tags, with SignKey or git's default signing key.
+	Sign    bool
+	SignKey string
+	// DryRun creates the tags locally without pushing them.
+	DryRun bool
+	// Token is sent to https repos without auth of their own, such as a GitHub token.
+	Token string
+}
+
+// Tag creates annotated tags of the version in each source repo, at the commits the release was built from.
+func Tag(manifest model.Manifest, opts Options) error {
+	deps := manifest.Dependencies.Get()
+	repos := make([]string, 0, len(deps))
+	for repo := range deps {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	for _, repo := range repos {
+		dep := deps[repo]
+		if dep == nil {
+			log.Warnf("skipping missing dependency %v", repo)
+			continue
+		}
+		if dep.Dirty {
+			return fmt.Errorf("refusing to tag repo %v: it was built with uncommitted changes", repo)
+		}
+		if dep.Sha == "" || dep.Git == "" {
+			return fmt.Errorf("repo %v has no resolved commit to tag", repo)
+		}
+		if err := tagRepo(repo, *dep, manifest.Version, opts); err != nil {
+			return fmt.Errorf("failed to tag %v: %v", repo, err)
+		}
+	}
+	return nil
+}
+
+func tagRepo(repo string, dep model.Dependency, version string, opts Options) error {
+	dir, err := os.MkdirTemp("", "release-tag-"+repo)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	tags := []string{version}
+	// Go modules require a v prefixed tag. Keep the standard version as well for backwards compat
+	if dep.GoVersionEnabled && !strings.HasPrefix(version, "v") {
+		if _, err := semver.StrictNewVersion(version); err != nil {
+			return fmt.Errorf("cannot tag with invalid semantic version %v: %v", version, err)
+		}
+		tags = append(tags, "v"+version)
+	}
+
+	env, err := gitEnv(dep, opts.Token)
+	if err != nil {
+		return err
+	}
+	if err := git(dir, env, "init", "--quiet"); err != nil {
+		return err
+	}
+	// Only the tagged commit is needed
+	if err := git(dir, env, "fetch", "--quiet", "--depth=1", dep.Git, dep.Sha); err != nil {
+		return err
+	}
+	refs := []string{}
+	for _, tag := range tags {
+		args := []string{"tag", "--annotate", "--message", fmt.Sprintf("Istio %s", version)}
+		if opts.Sign {
+			args = append(args, "--sign")
+			if opts.SignKey != "" {
+				args = append(args, "--local-user", opts.SignKey)
+			}
+		}
+		args = append(args, tag, dep.Sha)
+		if err := git(dir, env, args...); err != nil {
+			return err
+		}
+		refs = append(refs, "refs/tags/"+tag)
+	}
+	if opts.Branch != "" {
+		refs = append(refs, fmt.Sprintf("%s:refs/heads/%s", dep.Sha, opts.Branch))
+	}
+	if opts.DryRun {
+		log.Infof("Would push %v to %v", refs, dep.Git)
+		return nil
+	}
+	if err := git(dir, env, append([]string{"push", "--quiet", "--atomic", dep.Git}, refs...)...); err != nil {
+		return err
+	}
+	log.Infof("Pushed %v to %v", refs, dep.Git)
+	return nil
+}
+
+// gitEnv returns the environment git pushes the tags of the dependency with. Dependencies without their own auth are
+// pushed with the token, if the repo is fetched over https.
+func gitEnv(dep model.Dependency, token string) ([]string, error) {
+	if dep.Auth == nil && token != "" && strings.HasPrefix(dep.Git, "https://") {
+		return util.GitTokenEnv(dep.Git, "x-access-token", token)
+	}
+	return util.GitEnv(dep.Git, dep.Auth)
+}
+
+// git runs a git command in dir. Credentials are passed through the environment, so they do not appear in logs.
+func git(dir string, env []string, args ...string) error {
+	cmd := util.VerboseCommand("git", args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git %v: %v", args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tag
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+func TestGitEnv(t *testing.T) {
+	// Config passed by CI is kept, with the token added after it
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "safe.directory")
+	t.Setenv("GIT_CONFIG_VALUE_0", "*")
+	env, err := gitEnv(model.Dependency{Git: "https://github.example.com/istio/istio"}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"GIT_CONFIG_COUNT=2", "GIT_CONFIG_KEY_1=http.https://github.example.com/.extraHeader"} {
+		if !slices.Contains(env, want) {
+			t.Errorf("expected %q in the environment", want)
+		}
+	}
+
+	// The auth of the dependency is used over the token
+	env, err = gitEnv(model.Dependency{
+		Git:  "git@github.example.com:istio/istio.git",
+		Auth: &model.GitAuth{SSHKeyFile: "/keys/deploy"},
+	}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "GIT_SSH_COMMAND=ssh -i '/keys/deploy' -o IdentitiesOnly=yes"; !slices.Contains(env, want) {
+		t.Errorf("expected %q in the environment", want)
+	}
+	if slices.ContainsFunc(env, func(e string) bool { return strings.HasPrefix(e, "GIT_CONFIG_KEY_1=") }) {
+		t.Errorf("expected no token to be added for a dependency with auth")
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// actionPattern matches a template action.
+var actionPattern = regexp.MustCompile(`{{.*?}}`)
+
+// manifestFuncs are the functions available to manifest templates when the manifest is loaded.
+var manifestFuncs = template.FuncMap{
+	"env": os.Getenv,
+}
+
+// renderManifest resolves template expressions in the manifest. Manifests may use {{ env "NAME" }} to read
+// environment variables and {{ .Date }} for the current date, in the form 20060102.
+// Other expressions, such as {{.Version}} in output layouts or {{.Dependencies.Istio.Sha}} in image labels, are left
+// as is, as they are executed later with the manifest.
+func renderManifest(by []byte) ([]byte, error) {
+	data := map[string]string{"Date": time.Now().UTC().Format("20060102")}
+	var errs []error
+	out := actionPattern.ReplaceAllFunc(by, func(action []byte) []byte {
+		t, err := template.New("manifest").Funcs(manifestFuncs).Parse(string(action))
+		if err != nil || !loadTimeAction(t.Tree) {
+			return action
+		}
+		buf := &bytes.Buffer{}
+		if err := t.Execute(buf, data); err != nil {
+			errs = append(errs, err)
+			return action
+		}
+		return buf.Bytes()
+	})
+	return out, errors.Join(errs...)
+}
+
+// loadTimeAction returns true if the template is a single action using only env and .Date.
+func loadTimeAction(tree *parse.Tree) bool {
+	if tree == nil || len(tree.Root.Nodes) != 1 {
+		return false
+	}
+	a, ok := tree.Root.Nodes[0].(*parse.ActionNode)
+	return ok && loadTimePipe(a.Pipe)
+}
+
+func loadTimePipe(pipe *parse.PipeNode) bool {
+	if pipe == nil || len(pipe.Decl) > 0 {
+		return false
+	}
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			switch n := arg.(type) {
+			case *parse.IdentifierNode:
+				if n.Ident != "env" {
+					return false
+				}
+			case *parse.FieldNode:
+				if len(n.Ident) != 1 || n.Ident[0] != "Date" {
+					return false
+				}
+			case *parse.PipeNode:
+				if !loadTimePipe(n) {
+					return false
+				}
+			case *parse.StringNode:
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// applyOverrides sets each key=value override in the manifest. Keys are dot separated paths, such as
+// dependencies.istio.branch, and values are parsed as yaml so booleans and lists may be set. Values of string
+// fields are kept as is, so versions such as 1.20 and SHAs such as 12e4567 are not read as numbers.
+func applyOverrides(by []byte, overrides []string) ([]byte, error) {
+	if len(overrides) == 0 {
+		return by, nil
+	}
+	manifest := map[string]any{}
+	if err := yaml.Unmarshal(by, &manifest); err != nil {
+		return nil, err
+	}
+	for _, o := range overrides {
+		key, value, ok := strings.Cut(o, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid override %q, expected key=value", o)
+		}
+		path := strings.Split(key, ".")
+		var v any = value
+		if overrideKind(reflect.TypeOf(model.InputManifest{}), path) != reflect.String {
+			if err := yaml.Unmarshal([]byte(value), &v); err != nil {
+				v = value
+			}
+		}
+		if err := setPath(manifest, path, v); err != nil {
+			return nil, fmt.Errorf("invalid override %q: %v", o, err)
+		}
+	}
+	return yaml.Marshal(manifest)
+}
+
+// overrideKind returns the kind of the manifest field at path, following json names, or reflect.Invalid if the path
+// is not a field of the manifest.
+func overrideKind(t reflect.Type, path []string) reflect.Kind {
+	for _, p := range path {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		switch t.Kind() {
+		case reflect.Struct:
+			f, found := jsonField(t, p)
+			if !found {
+				return reflect.Invalid
+			}
+			t = f.Type
+		case reflect.Map:
+			t = t.Elem()
+		default:
+			return reflect.Invalid
+		}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Kind()
+}
+
+// jsonField returns the field of the struct named name in json, which matches case insensitively, as encoding/json does.
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if tag == "-" || !f.IsExported() {
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		if strings.EqualFold(tag, name) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// applyProfile merges the named profile over the manifest. The profiles are removed from the result.
+func applyProfile(by []byte, profile string) ([]byte, error) {
+	manifest := map[string]any{}
+	if err := yaml.Unmarshal(by, &manifest); err != nil {
+		return nil, err
+	}
+	profiles, _ := manifest["profiles"].(map[string]any)
+	delete(manifest, "profiles")
+	if profile != "" {
+		p, f := profiles[profile]
+		if !f {
+			return nil, fmt.Errorf("unknown profile")
+		}
+		pm, ok := p.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("profile is not a map")
+		}
+		mergeMaps(manifest, pm)
+	}
+	return yaml.Marshal(manifest)
+}
+
+// mergeMaps recursively merges src into dst. Values other than maps are replaced.
+func mergeMaps(dst, src map[string]any) {
+	for k, v := range src {
+		sm, sok := v.(map[string]any)
+		dm, dok := dst[k].(map[string]any)
+		if sok && dok {
+			mergeMaps(dm, sm)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// setPath sets the value at path, creating intermediate maps as needed.
+func setPath(m map[string]any, path []string, value any) error {
+	for _, p := range path[:len(path)-1] {
+		next, f := m[p]
+		if !f || next == nil {
+			next = map[string]any{}
+			m[p] = next
+		}
+		nm, ok := next.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%v is not a map", p)
+		}
+		m = nm
+	}
+	m[path[len(path)-1]] = value
+	return nil
+}
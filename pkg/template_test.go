@@ -0,0 +1,77 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+func TestRenderManifest(t *testing.T) {
+	t.Setenv("RELEASE_BRANCH", "release-1.24")
+	date := time.Now().UTC().Format("20060102")
+	cases := map[string]string{
+		`branch: {{ env "RELEASE_BRANCH" }}`:                "branch: release-1.24",
+		`version: 1.24-alpha.{{ .Date }}`:                   "version: 1.24-alpha." + date,
+		`deb: packages/{{.Version}}/{{.Arch}}`:              `deb: packages/{{.Version}}/{{.Arch}}`,
+		`sha: "{{.Dependencies.Istio.Sha}}"`:                `sha: "{{.Dependencies.Istio.Sha}}"`,
+		`content: "{{ if .Channel }}{{.Channel}}{{ end }}"`: `content: "{{ if .Channel }}{{.Channel}}{{ end }}"`,
+		`tag: {{ .Date }}-{{ .Dependencies.Istio.Sha }}`:    "tag: " + date + "-{{ .Dependencies.Istio.Sha }}",
+	}
+	for in, want := range cases {
+		got, err := renderManifest([]byte(in))
+		if err != nil {
+			t.Fatalf("%v: %v", in, err)
+		}
+		if string(got) != want {
+			t.Errorf("%v: got %v, want %v", in, string(got), want)
+		}
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	base := []byte("version: 1.24.0\ndependencies:\n  istio:\n    git: https://github.com/istio/istio\n    branch: master\n")
+	by, err := applyOverrides(base, []string{
+		"version=1.20",
+		"dependencies.istio.sha=12e4567",
+		"dependencies.proxy.sha=1234567",
+		"dependencies.istio.goversionenabled=true",
+		"imageVariants=[debug, distroless]",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := model.InputManifest{}
+	if err := yaml.Unmarshal(by, &got); err != nil {
+		t.Fatalf("failed to unmarshal %s: %v", by, err)
+	}
+	if got.Version != "1.20" {
+		t.Errorf("expected version 1.20, got %v", got.Version)
+	}
+	if got.Dependencies.Istio.Sha != "12e4567" || got.Dependencies.Proxy.Sha != "1234567" {
+		t.Errorf("expected SHAs to be kept as is, got %v and %v", got.Dependencies.Istio.Sha, got.Dependencies.Proxy.Sha)
+	}
+	if !got.Dependencies.Istio.GoVersionEnabled {
+		t.Errorf("expected goversionenabled to be parsed as a boolean")
+	}
+	if !reflect.DeepEqual(got.ImageVariants, []string{"debug", "distroless"}) {
+		t.Errorf("expected image variants to be parsed as a list, got %v", got.ImageVariants)
+	}
+}
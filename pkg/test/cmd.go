@@ -0,0 +1,64 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"istio.io/istio/pkg/log"
+)
+
+var (
+	flags = struct {
+		release   string
+		cluster   string
+		nodeImage string
+		keep      bool
+	}{
+		cluster: "release-builder",
+	}
+	testCmd = &cobra.Command{
+		Use:          "test",
+		Short:        "Smoke tests a release of Istio in a kind cluster",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(0),
+		RunE: func(c *cobra.Command, _ []string) error {
+			if flags.release == "" {
+				return fmt.Errorf("--release must be passed")
+			}
+			if err := Test(flags.release, flags.cluster, flags.nodeImage, flags.keep); err != nil {
+				return fmt.Errorf("release smoke test FAILED: %v", err)
+			}
+			log.Infof("Release smoke test PASSED")
+			return nil
+		},
+	}
+)
+
+func init() {
+	testCmd.PersistentFlags().StringVar(&flags.release, "release", flags.release,
+		"The release to test.")
+	testCmd.PersistentFlags().StringVar(&flags.cluster, "cluster", flags.cluster,
+		"The name of the kind cluster to create.")
+	testCmd.PersistentFlags().StringVar(&flags.nodeImage, "node-image", flags.nodeImage,
+		"If set, the kind node image to use. Example: kindest/node:v1.32.0")
+	testCmd.PersistentFlags().BoolVar(&flags.keep, "keep", flags.keep,
+		"Keep the kind cluster after testing, for debugging.")
+}
+
+func GetTestCommand() *cobra.Command {
+	return testCmd
+}
@@ -0,0 +1,206 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg"
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// chart is a helm chart installed by the smoke test.
+type chart struct {
+	release   string
+	name      string
+	namespace string
+	args      []string
+}
+
+// charts are installed in order, in ambient mode so cni and ztunnel are exercised.
+var charts = []chart{
+	{release: "istio-base", name: "base", namespace: "istio-system"},
+	{release: "istiod", name: "istiod", namespace: "istio-system", args: []string{"--set", "profile=ambient"}},
+	{release: "istio-cni", name: "cni", namespace: "istio-system", args: []string{"--set", "profile=ambient"}},
+	{release: "ztunnel", name: "ztunnel", namespace: "istio-system"},
+	{release: "istio-ingress", name: "gateway", namespace: "istio-ingress"},
+}
+
+// workloads is a server and client in the mesh, used to check traffic.
+const workloads = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: smoke
+  labels:
+    istio.io/dataplane-mode: ambient
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: httpbin
+  namespace: smoke
+spec:
+  selector:
+    matchLabels:
+      app: httpbin
+  template:
+    metadata:
+      labels:
+        app: httpbin
+    spec:
+      containers:
+      - name: httpbin
+        image: docker.io/mccutchen/go-httpbin:v2.15.0
+        ports:
+        - containerPort: 8080
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: httpbin
+  namespace: smoke
+spec:
+  selector:
+    app: httpbin
+  ports:
+  - name: http
+    port: 8000
+    targetPort: 8080
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: curl
+  namespace: smoke
+spec:
+  selector:
+    matchLabels:
+      app: curl
+  template:
+    metadata:
+      labels:
+        app: curl
+    spec:
+      containers:
+      - name: curl
+        image: docker.io/curlimages/curl:8.11.1
+        command: ["sleep", "infinity"]
+`
+
+// Test creates a kind cluster, installs the release images and charts, and checks traffic flows through the mesh.
+func Test(release, cluster, nodeImage string, keep bool) error {
+	manifest, err := pkg.ReadManifest(filepath.Join(release, "manifest.yaml"))
+	if err != nil {
+		return err
+	}
+	args := []string{"create", "cluster", "--name", cluster}
+	if nodeImage != "" {
+		args = append(args, "--image", nodeImage)
+	}
+	if err := util.VerboseCommand("kind", args...).Run(); err != nil {
+		return fmt.Errorf("failed to create kind cluster: %v", err)
+	}
+	if keep {
+		log.Infof("Keeping kind cluster %v", cluster)
+	} else {
+		defer func() {
+			if err := util.VerboseCommand("kind", "delete", "cluster", "--name", cluster).Run(); err != nil {
+				log.Warnf("failed to delete kind cluster %v: %v", cluster, err)
+			}
+		}()
+	}
+
+	if err := loadImages(release, manifest, cluster); err != nil {
+		return err
+	}
+	if err := installCharts(release, manifest, cluster); err != nil {
+		return err
+	}
+	return checkTraffic(cluster)
+}
+
+// loadImages loads the release docker images into the kind cluster.
+func loadImages(release string, manifest model.Manifest, cluster string) error {
+	dir := filepath.Join(release, manifest.ArtifactDir("docker", ""))
+	images, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read docker images: %v", err)
+	}
+	for _, image := range images {
+		if !strings.HasSuffix(image.Name(), ".tar.gz") {
+			continue
+		}
+		out, err := util.RunWithOutput("docker", "load", "-i", filepath.Join(dir, image.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to load %v: %v", image.Name(), err)
+		}
+		for _, line := range strings.Split(out, "\n") {
+			tag, f := strings.CutPrefix(line, "Loaded image: ")
+			if !f {
+				continue
+			}
+			if err := util.VerboseCommand("kind", "load", "docker-image", "--name", cluster, strings.TrimSpace(tag)).Run(); err != nil {
+				return fmt.Errorf("failed to load %v into kind: %v", tag, err)
+			}
+		}
+	}
+	return nil
+}
+
+// installCharts installs the release helm charts, waiting for each to be ready.
+func installCharts(release string, manifest model.Manifest, cluster string) error {
+	dir := filepath.Join(release, manifest.ArtifactDir("helm", ""))
+	for _, c := range charts {
+		archive := filepath.Join(dir, manifest.ChartFile(c.name))
+		args := append([]string{
+			"install", c.release, archive,
+			"--kube-context", "kind-" + cluster,
+			"--namespace", c.namespace, "--create-namespace",
+			"--wait", "--timeout", "5m",
+		}, c.args...)
+		if err := util.VerboseCommand("helm", args...).Run(); err != nil {
+			return fmt.Errorf("failed to install %v: %v", c.name, err)
+		}
+	}
+	return nil
+}
+
+// checkTraffic deploys a client and server in the mesh, and checks the client can reach the server.
+func checkTraffic(cluster string) error {
+	kubectl := func(args ...string) error {
+		return util.VerboseCommand("kubectl", append([]string{"--context", "kind-" + cluster}, args...)...).Run()
+	}
+	apply := util.VerboseCommand("kubectl", "--context", "kind-"+cluster, "apply", "-f", "-")
+	apply.Stdin = strings.NewReader(workloads)
+	if err := apply.Run(); err != nil {
+		return fmt.Errorf("failed to deploy workloads: %v", err)
+	}
+	for _, d := range []string{"httpbin", "curl"} {
+		if err := kubectl("rollout", "status", "deployment/"+d, "-n", "smoke", "--timeout", "5m"); err != nil {
+			return fmt.Errorf("workload %v not ready: %v", d, err)
+		}
+	}
+	if err := kubectl("exec", "-n", "smoke", "deploy/curl", "--",
+		"curl", "-sS", "--fail", "--retry", "10", "--retry-all-errors", "http://httpbin:8000/get"); err != nil {
+		return fmt.Errorf("traffic check failed: %v", err)
+	}
+	return nil
+}
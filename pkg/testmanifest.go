@@ -0,0 +1,82 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// fakeIstio is a tiny stand in for istio/istio, with just enough charts and make targets for the helm, rpm, and docker
+// outputs.
+//
+//go:embed testdata/fake-istio
+var fakeIstio embed.FS
+
+// TestManifest writes the fake istio repo to dir, and returns a manifest building it in dir/build. This exercises the
+// build and publish pipeline in seconds, without the real sources or toolchain.
+func TestManifest(dir string) (model.InputManifest, error) {
+	repo := filepath.Join(dir, "istio")
+	root := "testdata/fake-istio"
+	err := fs.WalkDir(fakeIstio, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(repo, rel), 0o750)
+		}
+		by, err := fakeIstio.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(repo, rel), by, 0o644)
+	})
+	if err != nil {
+		return model.InputManifest{}, fmt.Errorf("failed to write fake istio repo: %v", err)
+	}
+	// Local dependencies must be git repos
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=release-builder", "-c", "user.email=release-builder@istio.io", "commit", "-q", "-m", "fake istio"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return model.InputManifest{}, fmt.Errorf("failed to create fake istio repo: %v: %s", err, out)
+		}
+	}
+
+	return model.InputManifest{
+		Dependencies: model.IstioDependencies{
+			Istio: &model.Dependency{LocalPath: repo},
+		},
+		Version:                     "1.0.0",
+		Docker:                      "localhost/istio",
+		Directory:                   filepath.Join(dir, "build"),
+		BuildOutputs:                model.Outputs{Components: []string{"helm", "rpm", "docker"}},
+		SkipGenerateBillOfMaterials: true,
+	}, nil
+}
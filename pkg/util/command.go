@@ -39,6 +39,24 @@ func StandardEnv(manifest model.Manifest) []string {
 	if manifest.Docker != "" {
 		env = append(env, "HUB="+manifest.Docker)
 	}
+	if url := manifest.ProxyURL(); url != "" {
+		// Images and packages embed the envoy of the manifest proxy
+		env = append(env, "ISTIO_ENVOY_BASE_URL="+url)
+	}
+	if url := manifest.ZtunnelURL(); url != "" {
+		env = append(env, "ISTIO_ZTUNNEL_BASE_URL="+url)
+	}
+	if m := manifest.GoModules; m != nil {
+		if m.Proxy != "" {
+			env = append(env, "GOPROXY="+m.Proxy)
+		}
+		if m.SumDB != "" {
+			env = append(env, "GOSUMDB="+m.SumDB)
+		}
+		if m.Vendor {
+			env = append(env, "GOFLAGS="+strings.TrimSpace(os.Getenv("GOFLAGS")+" -mod=vendor"))
+		}
+	}
 	return env
 }
 
@@ -66,8 +84,7 @@ func RunMake(manifest model.Manifest, repo string, env []string, c ...string) er
 	cmd.Env = removeEnvKey(cmd.Env, "TARGET_ARCH")
 	cmd.Env = removeEnvKey(cmd.Env, "FOR_BUILD_CONTAINER")
 	cmd.Env = append(cmd.Env, env...)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
+	cmd.Stdout, cmd.Stderr = CommandOutput(log.WithLabels("repo", repo))
 	cmd.Dir = manifest.RepoDir(repo)
 	log.Infof("Running make %v with env=%v wd=%v", strings.Join(c, " "), strings.Join(env, " "), cmd.Dir)
 	return cmd.Run()
@@ -28,7 +28,9 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/mod/modfile"
 	"istio.io/istio/pkg/log"
@@ -40,8 +42,7 @@ import (
 func VerboseCommand(name string, arg ...string) *exec.Cmd {
 	log.Infof("Running command: %v %v", name, strings.Join(arg, " "))
 	cmd := exec.Command(name, arg...)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
+	cmd.Stdout, cmd.Stderr = CommandOutput(log.WithLabels("command", name))
 	return cmd
 }
 
@@ -50,8 +51,9 @@ func RunWithOutput(name string, arg ...string) (string, error) {
 	var outBuffer bytes.Buffer
 	var errBuffer bytes.Buffer
 	cmd := VerboseCommand(name, arg...)
-	cmd.Stdout = io.MultiWriter(os.Stdout, &outBuffer)
-	cmd.Stderr = io.MultiWriter(os.Stderr, &errBuffer)
+	stdout, stderr := CommandOutput(log.WithLabels("command", name))
+	cmd.Stdout = io.MultiWriter(stdout, &outBuffer)
+	cmd.Stderr = io.MultiWriter(stderr, &errBuffer)
 	if err := cmd.Run(); err != nil {
 		log.Infof("Running command %s %s failed: %s: %s",
 			name, strings.Join(arg, " "), err.Error(), errBuffer.String())
@@ -157,7 +159,19 @@ func CopyFile(src, dst string) error {
 	return nil
 }
 
-func Clone(repo string, dep model.Dependency, dest string) error {
+// CloneOptions tune how git dependencies are fetched.
+type CloneOptions struct {
+	// Depth, if set, shallow clones to the given depth. Branches are always shallow cloned, with a default depth of 1.
+	Depth int
+	// Reference is a directory containing local mirrors of the repos, named <repo> or <repo>.git, to borrow objects from.
+	Reference string
+	// CacheDir is a directory of mirrors, named <repo>.git, which is kept up to date and reused across runs.
+	CacheDir string
+	// Git configures the mirrors and retries of fetches.
+	Git *model.GitConfig
+}
+
+func Clone(repo string, dep model.Dependency, dest string, opts CloneOptions) error {
 	if dep.LocalPath != "" {
 		return CopyDir(dep.LocalPath, dest)
 	}
@@ -166,23 +180,84 @@ func Clone(repo string, dep model.Dependency, dest string) error {
 		if err := FetchAuto(repo, &dep, dest); err != nil {
 			return err
 		}
+		// The resolved SHA is not necessarily the head of the branch, so it cannot be shallow cloned by branch
+		dep.Branch = ""
+	}
+	remote := remoteURL(dep.Git, opts.Git)
+	args := []string{"clone", remote, dest}
+	// As an optimization, if we are cloning a branch or tag just shallow clone
+	if branch := dep.Ref(); dep.Sha == "" && branch != "" {
+		depth := opts.Depth
+		if depth == 0 {
+			depth = 1
+		}
+		args = append(args, "-b", branch, fmt.Sprintf("--depth=%d", depth))
+	} else if opts.Depth > 0 {
+		// The SHA may not be reachable from the default branch, so it is fetched explicitly below
+		args = append(args, "--no-checkout", fmt.Sprintf("--depth=%d", opts.Depth))
+	}
+	reference, err := cloneReference(repo, dep, opts)
+	if err != nil {
+		return err
 	}
-	args := []string{"clone", dep.Git, dest}
-	// As an optimization, if we are cloning a branch just shallow clone
-	if dep.Branch != "" {
-		args = append(args, "-b", dep.Branch, "--depth=1")
+	if reference != "" {
+		// Dissociate so the sources are self-contained, as they are copied and bundled later
+		args = append(args, "--reference-if-able", reference, "--dissociate")
 	}
+	if progressEnabled {
+		// git only reports progress on a terminal unless asked to
+		args = append(args, "--progress")
+	}
+	progress := NewProgress(repo, 0)
 	// We must be fetching from git
-	err := VerboseCommand("git", args...).Run()
-	if err != nil {
+	if err := runGit(remote, dep, opts, "", args...); err != nil {
 		return err
 	}
+	progress.Done(dirSize(filepath.Join(dest, ".git")))
+
+	if dep.Sha != "" && opts.Depth > 0 {
+		if err := runGit(remote, dep, opts, dest, "fetch", fmt.Sprintf("--depth=%d", opts.Depth), "origin", dep.Ref()); err != nil {
+			return fmt.Errorf("failed to fetch %v: %v", dep.Ref(), err)
+		}
+	}
 
 	cmd := VerboseCommand("git", "checkout", dep.Ref())
 	cmd.Dir = dest
 	return cmd.Run()
 }
 
+// cloneReference returns the local mirror to borrow objects from when cloning, if any. Mirrors in the cache
+// directory are created or updated as needed.
+func cloneReference(repo string, dep model.Dependency, opts CloneOptions) (string, error) {
+	if opts.Reference != "" {
+		for _, name := range []string{repo, repo + ".git"} {
+			p := filepath.Join(opts.Reference, name)
+			if _, err := os.Stat(p); err == nil {
+				return p, nil
+			}
+		}
+		log.Warnf("no reference repo for %v found in %v", repo, opts.Reference)
+	}
+	if opts.CacheDir == "" {
+		return "", nil
+	}
+	remote := remoteURL(dep.Git, opts.Git)
+	mirror := filepath.Join(opts.CacheDir, repo+".git")
+	if _, err := os.Stat(mirror); err == nil {
+		if err := runGit(remote, dep, opts, mirror, "remote", "update", "--prune"); err != nil {
+			return "", fmt.Errorf("failed to update cached repo %v: %v", mirror, err)
+		}
+		return mirror, nil
+	}
+	if err := os.MkdirAll(opts.CacheDir, 0o750); err != nil {
+		return "", fmt.Errorf("failed to create clone cache: %v", err)
+	}
+	if err := runGit(remote, dep, opts, "", "clone", "--mirror", remote, mirror); err != nil {
+		return "", fmt.Errorf("failed to populate cached repo %v: %v", mirror, err)
+	}
+	return mirror, nil
+}
+
 // FetchAuto looks up the SHA to use for the dependency from istio/istio
 func FetchAuto(repo string, dep *model.Dependency, dest string) error {
 	if dep.Auto == model.Deps {
@@ -191,6 +266,8 @@ func FetchAuto(repo string, dep *model.Dependency, dest string) error {
 		return fetchAutoModules(repo, dep, dest)
 	} else if dep.Auto == model.ProxyWorkspace {
 		return fetchAutoProxyWorkspace(dep, dest)
+	} else if dep.Auto == model.Green {
+		return fetchAutoGreen(repo, dep)
 	}
 	return fmt.Errorf("unknown auto dependency: %v", dep.Auto)
 }
@@ -260,6 +337,77 @@ func fetchAutoProxyWorkspace(dep *model.Dependency, dest string) error {
 	return nil
 }
 
+// SourceDateEpoch returns the commit time of HEAD in the repo, in seconds since the epoch, for use as SOURCE_DATE_EPOCH.
+func SourceDateEpoch(repo string) (string, error) {
+	buf := bytes.Buffer{}
+	cmd := exec.Command("git", "log", "-1", "--format=%ct")
+	cmd.Stdout = &buf
+	cmd.Dir = repo
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to get commit time of %v: %v", repo, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// TarGz creates a gzipped tar archive of files, relative to dir. If SOURCE_DATE_EPOCH is set,
+// entries are sorted and have fixed timestamps and ownership, so the archive is reproducible.
+func TarGz(dir string, archive string, files ...string) error {
+	args := []string{"-czf", archive}
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		args = []string{
+			"--sort=name", "--mtime=@" + epoch, "--owner=0", "--group=0", "--numeric-owner",
+			"--pax-option=exthdr.name=%d/PaxHeaders/%f,delete=atime,delete=ctime",
+			// gzip would otherwise record the current time in its header
+			"--use-compress-program=gzip -n", "-cf", archive,
+		}
+	}
+	cmd := VerboseCommand("tar", append(args, files...)...)
+	cmd.Dir = dir
+	return cmd.Run()
+}
+
+// ZipFiles creates a zip archive of files, relative to dir. The archive is created in dir.
+func ZipFiles(dir string, archive string, files ...string) error {
+	zipfile, err := os.Create(filepath.Join(dir, archive))
+	if err != nil {
+		return err
+	}
+	defer zipfile.Close()
+
+	w := zip.NewWriter(zipfile)
+	for _, f := range files {
+		info, err := os.Stat(filepath.Join(dir, f))
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = f
+		header.Method = zip.Deflate
+		if e := os.Getenv("SOURCE_DATE_EPOCH"); e != "" {
+			sec, err := strconv.ParseInt(e, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %v", e, err)
+			}
+			header.Modified = time.Unix(sec, 0).UTC()
+		}
+		writer, err := w.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		in, err := os.ReadFile(filepath.Join(dir, f))
+		if err != nil {
+			return err
+		}
+		if _, err := writer.Write(in); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
 func ZipFolder(source, target string) error {
 	zipfile, err := os.Create(target)
 	if err != nil {
@@ -280,6 +428,17 @@ func ZipFolder(source, target string) error {
 		baseDir = filepath.Base(source)
 	}
 
+	// Use a fixed modification time when building reproducibly
+	var epoch *time.Time
+	if e := os.Getenv("SOURCE_DATE_EPOCH"); e != "" {
+		sec, err := strconv.ParseInt(e, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %v", e, err)
+		}
+		t := time.Unix(sec, 0).UTC()
+		epoch = &t
+	}
+
 	return filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -293,6 +452,9 @@ func ZipFolder(source, target string) error {
 		if baseDir != "" {
 			header.Name = filepath.Join(baseDir, strings.TrimPrefix(path, source))
 		}
+		if epoch != nil {
+			header.Modified = *epoch
+		}
 
 		if info.IsDir() {
 			header.Name += "/"
@@ -209,3 +209,125 @@ func GetGithubToken(file string) (string, error) {
 	}
 	return os.Getenv("GITHUB_TOKEN"), nil
 }
+
+// fetchAutoGreen resolves the dependency to the most recent commit on its branch passing the configured status check.
+// Both commit statuses and check runs are considered.
+func fetchAutoGreen(repo string, dep *model.Dependency) error {
+	orgString, repoString, err := githubRepo(dep.Git)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	client, err := githubClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	commits, _, err := client.Repositories.ListCommits(ctx, orgString, repoString, &github.CommitsListOptions{
+		SHA:         dep.Branch,
+		ListOptions: github.ListOptions{PerPage: 50},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list commits for %v: %v", repo, err)
+	}
+	for _, c := range commits {
+		sha := c.GetSHA()
+		status, _, err := client.Repositories.GetCombinedStatus(ctx, orgString, repoString, sha, nil)
+		if err != nil {
+			return fmt.Errorf("failed to get status of %v: %v", sha, err)
+		}
+		for _, s := range status.Statuses {
+			if s.GetContext() == dep.StatusCheck && s.GetState() == "success" {
+				log.Infof("Resolved %v to %v, passing %v", repo, sha, dep.StatusCheck)
+				dep.Sha = sha
+				return nil
+			}
+		}
+		checks, _, err := client.Checks.ListCheckRunsForRef(ctx, orgString, repoString, sha, &github.ListCheckRunsOptions{
+			CheckName: github.String(dep.StatusCheck),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get check runs of %v: %v", sha, err)
+		}
+		for _, r := range checks.CheckRuns {
+			if r.GetConclusion() == "success" {
+				log.Infof("Resolved %v to %v, passing %v", repo, sha, dep.StatusCheck)
+				dep.Sha = sha
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("failed to find a commit on %v passing %v for %v", dep.Branch, dep.StatusCheck, repo)
+}
+
+// FailingChecks returns the required checks, each a GitHub status or check run such as a prow job, that have not
+// passed on the commit, with their state. Checks that have not reported are failing.
+func FailingChecks(git string, sha string, required []string) ([]string, error) {
+	orgString, repoString, err := githubRepo(git)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	client, err := githubClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status, _, err := client.Repositories.GetCombinedStatus(ctx, orgString, repoString, sha, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status of %v: %v", sha, err)
+	}
+	failing := []string{}
+	for _, check := range required {
+		state := ""
+		for _, s := range status.Statuses {
+			if s.GetContext() == check {
+				state = s.GetState()
+			}
+		}
+		if state == "" {
+			checks, _, err := client.Checks.ListCheckRunsForRef(ctx, orgString, repoString, sha, &github.ListCheckRunsOptions{
+				CheckName: github.String(check),
+				Filter:    github.String("latest"),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get check runs of %v: %v", sha, err)
+			}
+			for _, r := range checks.CheckRuns {
+				state = r.GetConclusion()
+				if state == "" {
+					state = r.GetStatus()
+				}
+			}
+		}
+		switch state {
+		case "success":
+			log.Infof("%v passed %v", sha, check)
+		case "":
+			failing = append(failing, check+": not reported")
+		default:
+			failing = append(failing, check+": "+state)
+		}
+	}
+	return failing, nil
+}
+
+// githubRepo returns the GitHub org and repo of a git URL.
+func githubRepo(git string) (string, string, error) {
+	repoStrings := strings.Split(strings.TrimSuffix(git, ".git"), "/")
+	if len(repoStrings) < 2 {
+		return "", "", fmt.Errorf("failed to determine GitHub repo from %v", git)
+	}
+	return repoStrings[len(repoStrings)-2], repoStrings[len(repoStrings)-1], nil
+}
+
+// githubClient returns a GitHub client, authenticated if a token is set in the environment.
+func githubClient(ctx context.Context) (*github.Client, error) {
+	token, err := GetGithubToken("")
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return github.NewClient(nil), nil
+	}
+	return github.NewClient(oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))), nil
+}
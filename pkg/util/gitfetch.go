@@ -0,0 +1,196 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// gitRetries is how many times a failed git fetch is retried, unless the manifest configures it.
+const gitRetries = 3
+
+// gitBackoff is the delay before the first retry of a git fetch, doubled for each further retry.
+var gitBackoff = 2 * time.Second
+
+// remoteURL returns the URL the dependency is fetched from, rewritten to the longest matching mirror, if any.
+func remoteURL(git string, cfg *model.GitConfig) string {
+	if cfg == nil {
+		return git
+	}
+	prefix := ""
+	for from := range cfg.Mirrors {
+		if strings.HasPrefix(git, from) && len(from) > len(prefix) {
+			prefix = from
+		}
+	}
+	if prefix == "" {
+		return git
+	}
+	return cfg.Mirrors[prefix] + strings.TrimPrefix(git, prefix)
+}
+
+// runGit runs a git command fetching from the remote, retrying failures with exponential backoff. The credentials
+// of the dependency are passed through the environment, so they do not show up in the command line or logs.
+func runGit(remote string, dep model.Dependency, opts CloneOptions, dir string, args ...string) error {
+	env, err := GitEnv(remote, dep.Auth)
+	if err != nil {
+		return err
+	}
+	retries := gitRetries
+	if opts.Git != nil && opts.Git.Retries > 0 {
+		retries = opts.Git.Retries
+	}
+	backoff := gitBackoff
+	for attempt := 0; ; attempt++ {
+		cmd := VerboseCommand("git", args...)
+		cmd.Dir = dir
+		cmd.Env = env
+		err := cmd.Run()
+		if err == nil || attempt == retries {
+			return err
+		}
+		log.Warnf("git %v failed, retrying in %v: %v", args[0], backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// GitEnv returns the environment git runs against the remote with. Credentials are set as git config through
+// GIT_CONFIG_COUNT, scoped to the remote host.
+func GitEnv(remote string, auth *model.GitAuth) ([]string, error) {
+	// Fail rather than hang on a credential prompt
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if auth == nil {
+		return env, nil
+	}
+	if auth.SSHKeyFile != "" {
+		// GIT_SSH_COMMAND is run by the shell, so the path is quoted in case it contains spaces
+		return append(env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes", shellQuote(auth.SSHKeyFile))), nil
+	}
+	u, err := httpRemote(remote)
+	if err != nil {
+		return nil, err
+	}
+	var user, password string
+	switch {
+	case auth.TokenEnv != "":
+		user, password = auth.Username, os.Getenv(auth.TokenEnv)
+		if password == "" {
+			return nil, fmt.Errorf("token environment variable %v is not set", auth.TokenEnv)
+		}
+		if user == "" {
+			user = "oauth2"
+		}
+	case auth.Netrc != "":
+		user, password, err = netrcLogin(auth.Netrc, u.Hostname())
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return env, nil
+	}
+	return basicAuthEnv(env, u, user, password), nil
+}
+
+// GitTokenEnv returns the environment git runs against the https remote with, sending the token as the password of
+// the user.
+func GitTokenEnv(remote string, user string, token string) ([]string, error) {
+	u, err := httpRemote(remote)
+	if err != nil {
+		return nil, err
+	}
+	return basicAuthEnv(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), u, user, token), nil
+}
+
+// httpRemote parses the remote, which must be http or https for credentials to be sent as a header.
+func httpRemote(remote string) (*url.URL, error) {
+	u, err := url.Parse(remote)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, fmt.Errorf("token and netrc credentials require an https url, not %v", remote)
+	}
+	return u, nil
+}
+
+// basicAuthEnv adds a basic auth header for requests to the host of u to the environment.
+func basicAuthEnv(env []string, u *url.URL, user string, password string) []string {
+	header := "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	// Config already passed through the environment, such as by CI, is kept, with the header added after it
+	count := 0
+	if c, err := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT")); err == nil && c > 0 {
+		count = c
+	}
+	env = slices.DeleteFunc(env, func(e string) bool { return strings.HasPrefix(e, "GIT_CONFIG_COUNT=") })
+	return append(env,
+		fmt.Sprintf("GIT_CONFIG_COUNT=%d", count+1),
+		fmt.Sprintf("GIT_CONFIG_KEY_%d=http.%s://%s/.extraHeader", count, u.Scheme, u.Host),
+		fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", count, header),
+	)
+}
+
+// shellQuote quotes s as a single shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// netrcLogin returns the login and password of the host in the netrc file, or of its default entry.
+func netrcLogin(file string, host string) (string, string, error) {
+	by, err := os.ReadFile(file)
+	if err != nil {
+		return "", "", err
+	}
+	type entry struct{ login, password string }
+	entries := map[string]*entry{}
+	var current *entry
+	fields := strings.Fields(string(by))
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "machine":
+			if i+1 < len(fields) {
+				i++
+				current = &entry{}
+				entries[fields[i]] = current
+			}
+		case "default":
+			current = &entry{}
+			entries[""] = current
+		case "login", "password":
+			if current != nil && i+1 < len(fields) {
+				if fields[i] == "login" {
+					current.login = fields[i+1]
+				} else {
+					current.password = fields[i+1]
+				}
+				i++
+			}
+		}
+	}
+	for _, name := range []string{host, ""} {
+		if e, f := entries[name]; f {
+			return e.login, e.password, nil
+		}
+	}
+	return "", "", fmt.Errorf("netrc %v has no login for %v", file, host)
+}
@@ -0,0 +1,102 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"golang.org/x/oauth2"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// GetGithubAppToken exchanges the GitHub App private key for an installation token.
+func GetGithubAppToken(app model.GithubApp) (string, error) {
+	key, err := readRSAKey(app.PrivateKeyFile)
+	if err != nil {
+		return "", err
+	}
+	jwt, err := githubAppJWT(app.AppID, key)
+	if err != nil {
+		return "", err
+	}
+	ctx := context.Background()
+	client := github.NewClient(oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: jwt})))
+	token, _, err := client.Apps.CreateInstallationToken(ctx, app.InstallationID, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create installation token for app %v: %v", app.AppID, err)
+	}
+	return token.GetToken(), nil
+}
+
+func readRSAKey(file string) (*rsa.PrivateKey, error) {
+	by, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read github app key: %v", err)
+	}
+	block, _ := pem.Decode(by)
+	if block == nil {
+		return nil, fmt.Errorf("github app key %v is not PEM encoded", file)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse github app key: %v", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("github app key %v is not an RSA key", file)
+	}
+	return rsaKey, nil
+}
+
+// githubAppJWT signs the short lived JWT GitHub Apps authenticate as to create installation tokens.
+func githubAppJWT(appID int64, key *rsa.PrivateKey) (string, error) {
+	now := time.Now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		// Backdated to allow for clock drift
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": appID,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign github app jwt: %v", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
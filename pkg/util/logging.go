@@ -0,0 +1,130 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"istio.io/istio/pkg/log"
+)
+
+var (
+	logStepMu sync.RWMutex
+	// logStep is the build or publish step running, attached to every log line in json mode. Steps run one at a
+	// time, so it is process wide, while fields of work that may run concurrently, such as the repo or artifact,
+	// are carried by loggers derived with log.WithLabels.
+	logStep string
+
+	// jsonLogs is set in json mode, where the output of subprocesses is logged, rather than written as is.
+	jsonLogs atomic.Bool
+)
+
+// ConfigureLogging sets up logging in the given format, either "text" or "json". In json mode, every line carries
+// the current step, and any labels of the logger, such as the repo or artifact, so CI systems can index and filter
+// logs per step.
+func ConfigureLogging(format string) error {
+	return configureLogging(format, log.DefaultOptions())
+}
+
+func configureLogging(format string, opts *log.Options) error {
+	switch format {
+	case "", "text":
+		return nil
+	case "json":
+		jsonLogs.Store(true)
+		opts.JSONEncoding = true
+		opts.WithExtension(func(c zapcore.Core) (zapcore.Core, func() error, error) {
+			return contextCore{c}, func() error { return nil }, nil
+		})
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	return log.Configure(opts)
+}
+
+// WithLogStep sets the step attached to log lines. The returned function restores the previous step, so callers
+// can `defer util.WithLogStep(step)()`.
+func WithLogStep(step string) func() {
+	logStepMu.Lock()
+	defer logStepMu.Unlock()
+	prev := logStep
+	logStep = step
+	return func() {
+		logStepMu.Lock()
+		defer logStepMu.Unlock()
+		logStep = prev
+	}
+}
+
+func logStepFields() []zapcore.Field {
+	logStepMu.RLock()
+	defer logStepMu.RUnlock()
+	if logStep == "" {
+		return nil
+	}
+	return []zapcore.Field{zap.String("step", logStep)}
+}
+
+// contextCore adds the step to every entry written.
+type contextCore struct {
+	zapcore.Core
+}
+
+func (c contextCore) With(fields []zapcore.Field) zapcore.Core {
+	return contextCore{c.Core.With(fields)}
+}
+
+func (c contextCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c contextCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(e, append(fields, logStepFields()...))
+}
+
+// CommandOutput returns the stdout and stderr for a subprocess. In text mode, they are those of the builder. In json
+// mode, each line is logged by logger, with the step and its labels, such as the repo, so the output stays json.
+func CommandOutput(logger *log.Scope) (io.Writer, io.Writer) {
+	if !jsonLogs.Load() {
+		return os.Stdout, os.Stderr
+	}
+	return lineWriter{logger.WithLabels("stream", "stdout")}, lineWriter{logger.WithLabels("stream", "stderr")}
+}
+
+// lineWriter logs each line written to it. Output is logged as soon as it is written, so the end of the output is
+// never held back, and a line split across writes, which is rare as subprocesses mostly write whole lines, is logged
+// in parts.
+type lineWriter struct {
+	logger *log.Scope
+}
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(string(p), "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			w.logger.Info(line)
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,73 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"istio.io/istio/pkg/log"
+)
+
+func TestCommandOutputJSON(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "log.json")
+	opts := log.DefaultOptions()
+	opts.OutputPaths = []string{out}
+	if err := configureLogging("json", opts); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		jsonLogs.Store(false)
+		_ = log.Configure(log.DefaultOptions())
+	})
+
+	restore := WithLogStep("docker")
+	cmd := VerboseCommand("sh", "-c", "echo built pilot; printf 'warning\\r\\n' >&2; printf 'no newline'")
+	cmd.Stdout, cmd.Stderr = CommandOutput(log.WithLabels("repo", "istio"))
+	err := cmd.Run()
+	restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = log.Sync()
+
+	by, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(string(by)), "\n") {
+		entry := map[string]any{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid json log line %q: %v", line, err)
+		}
+		got[entry["msg"].(string)] = entry
+	}
+	for msg, stream := range map[string]string{"built pilot": "stdout", "warning": "stderr", "no newline": "stdout"} {
+		entry, f := got[msg]
+		if !f {
+			t.Errorf("%q not logged, got %v", msg, got)
+			continue
+		}
+		for k, want := range map[string]string{"step": "docker", "repo": "istio", "stream": stream} {
+			if entry[k] != want {
+				t.Errorf("%q: %v is %v, want %v", msg, k, entry[k], want)
+			}
+		}
+	}
+}
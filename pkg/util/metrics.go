@@ -0,0 +1,143 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"istio.io/istio/pkg/log"
+)
+
+// StepMetrics records the resources used by a single build or publish step.
+type StepMetrics struct {
+	Name string `json:"name"`
+	// WallSeconds is the elapsed time of the step
+	WallSeconds float64 `json:"wallSeconds"`
+	// CPUSeconds is the user and system CPU time of this process and all child processes, such as make
+	CPUSeconds float64 `json:"cpuSeconds"`
+	// OutputBytes is how much the output directory grew during the step
+	OutputBytes int64  `json:"outputBytes"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Metrics collects StepMetrics for a run.
+type Metrics struct {
+	Version string        `json:"version"`
+	Steps   []StepMetrics `json:"steps"`
+}
+
+func NewMetrics(version string) *Metrics {
+	return &Metrics{Version: version}
+}
+
+// Time runs the step f, recording its metrics. outDir is measured before and after the step to
+// determine the output size; it may be empty if the step does not produce local output.
+// Logs emitted during the step carry the step name in json mode.
+func (m *Metrics) Time(name string, outDir string, f func() error) error {
+	defer WithLogStep(name)()
+	sizeBefore := dirSize(outDir)
+	cpuBefore := cpuTime()
+	start := time.Now()
+
+	err := f()
+
+	step := StepMetrics{
+		Name:        name,
+		WallSeconds: time.Since(start).Seconds(),
+		CPUSeconds:  (cpuTime() - cpuBefore).Seconds(),
+		OutputBytes: dirSize(outDir) - sizeBefore,
+	}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	log.Infof("Step %v completed in %.1fs (cpu %.1fs, output %d bytes)", name, step.WallSeconds, step.CPUSeconds, step.OutputBytes)
+	m.Steps = append(m.Steps, step)
+	return err
+}
+
+// Write outputs the metrics as json to the given file.
+func (m *Metrics) Write(file string) error {
+	by, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics: %v", err)
+	}
+	if err := os.WriteFile(file, by, 0o644); err != nil {
+		return fmt.Errorf("failed to write metrics: %v", err)
+	}
+	return nil
+}
+
+// Push sends the metrics to a Prometheus pushgateway, replacing any metrics for the job.
+func (m *Metrics) Push(gateway, job string) error {
+	buf := &bytes.Buffer{}
+	write := func(metric, help string, value func(StepMetrics) string) {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s gauge\n", metric, help, metric)
+		for _, s := range m.Steps {
+			fmt.Fprintf(buf, "%s{step=%q,version=%q} %s\n", metric, s.Name, m.Version, value(s))
+		}
+	}
+	write("release_builder_step_wall_seconds", "Wall time of the step.", func(s StepMetrics) string {
+		return fmt.Sprintf("%f", s.WallSeconds)
+	})
+	write("release_builder_step_cpu_seconds", "CPU time of the step, including child processes.", func(s StepMetrics) string {
+		return fmt.Sprintf("%f", s.CPUSeconds)
+	})
+	write("release_builder_step_output_bytes", "Size of the output produced by the step.", func(s StepMetrics) string {
+		return fmt.Sprintf("%d", s.OutputBytes)
+	})
+
+	url := fmt.Sprintf("%s/metrics/job/%s", strings.TrimSuffix(gateway, "/"), job)
+	req, err := http.NewRequest(http.MethodPut, url, buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics to %v: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to push metrics to %v: %v %v", url, resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+func dirSize(dir string) int64 {
+	if dir == "" {
+		return 0
+	}
+	var size int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
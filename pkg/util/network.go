@@ -0,0 +1,162 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"golang.org/x/net/http/httpproxy"
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// NetworkConfig is the proxy and CA bundle applied by ConfigureNetwork. It is set from flags, or the manifest.
+var NetworkConfig = model.NetworkConfig{}
+
+// trustedBundle is the CA bundle combined with the system CAs, and configuredBundle the CA bundle it was combined
+// from, as ConfigureNetwork may be called again.
+var trustedBundle, configuredBundle string
+
+// systemBundles are the system CA bundles of common distributions. The first found is extended with the CA bundle.
+var systemBundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// ConfigureNetwork applies NetworkConfig, with unset fields defaulted from the manifest network config, if any.
+// Everything is exported to the environment, which the git, helm, docker, and curl commands run by the build read.
+// Go reads the proxy environment, and the system CAs, only once, so the default HTTP and registry transports are
+// updated to follow it; this may be called again, such as once the manifest of a fetched release is read.
+func ConfigureNetwork(manifest *model.NetworkConfig) error {
+	if manifest != nil {
+		setDefault := func(flag *string, value string) {
+			if *flag == "" {
+				*flag = value
+			}
+		}
+		setDefault(&NetworkConfig.HTTPProxy, manifest.HTTPProxy)
+		setDefault(&NetworkConfig.HTTPSProxy, manifest.HTTPSProxy)
+		setDefault(&NetworkConfig.NoProxy, manifest.NoProxy)
+		setDefault(&NetworkConfig.CABundle, manifest.CABundle)
+	}
+	for _, p := range []struct {
+		value string
+		envs  []string
+	}{
+		{NetworkConfig.HTTPProxy, []string{"HTTP_PROXY", "http_proxy"}},
+		{NetworkConfig.HTTPSProxy, []string{"HTTPS_PROXY", "https_proxy"}},
+		{NetworkConfig.NoProxy, []string{"NO_PROXY", "no_proxy"}},
+	} {
+		if p.value == "" {
+			continue
+		}
+		for _, env := range p.envs {
+			if err := os.Setenv(env, p.value); err != nil {
+				return err
+			}
+		}
+	}
+	if NetworkConfig.CABundle == "" || NetworkConfig.CABundle == configuredBundle {
+		return configureTransports()
+	}
+	bundle, err := caBundle(NetworkConfig.CABundle)
+	if err != nil {
+		return err
+	}
+	// SSL_CERT_FILE replaces, rather than extends, the trusted CAs, so the combined bundle is used
+	for _, env := range []string{"SSL_CERT_FILE", "GIT_SSL_CAINFO", "CURL_CA_BUNDLE"} {
+		if err := os.Setenv(env, bundle); err != nil {
+			return err
+		}
+	}
+	trustedBundle, configuredBundle = bundle, NetworkConfig.CABundle
+	log.Infof("Trusting CA bundle %v", NetworkConfig.CABundle)
+	return configureTransports()
+}
+
+// Proxy returns the proxy for the request from the current environment. Unlike http.ProxyFromEnvironment, which
+// reads the environment once, it follows changes made by ConfigureNetwork.
+func Proxy(req *http.Request) (*url.URL, error) {
+	return httpproxy.FromEnvironment().ProxyFunc()(req.URL)
+}
+
+// configureTransports applies the proxy, and the trusted CA bundle, to the default HTTP and registry transports,
+// which the GitHub and registry clients use.
+func configureTransports() error {
+	var roots *x509.CertPool
+	if trustedBundle != "" {
+		by, err := os.ReadFile(trustedBundle)
+		if err != nil {
+			return fmt.Errorf("failed to read CA bundle: %v", err)
+		}
+		// Start from the system CAs, as the combined bundle may lack some, such as on platforms without a bundle file
+		roots, err = x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		roots.AppendCertsFromPEM(by)
+	}
+	for _, rt := range []http.RoundTripper{http.DefaultTransport, remote.DefaultTransport} {
+		t, ok := rt.(*http.Transport)
+		if !ok {
+			continue
+		}
+		t.Proxy = Proxy
+		if roots != nil {
+			if t.TLSClientConfig == nil {
+				t.TLSClientConfig = &tls.Config{}
+			}
+			t.TLSClientConfig.RootCAs = roots
+		}
+	}
+	return nil
+}
+
+// caBundle writes the system CAs, and those in file, to a combined bundle, returning its path.
+func caBundle(file string) (string, error) {
+	extra, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read CA bundle: %v", err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(extra) {
+		return "", fmt.Errorf("CA bundle %v has no PEM certificates", file)
+	}
+	combined := []byte{}
+	for _, s := range systemBundles {
+		if by, err := os.ReadFile(s); err == nil {
+			combined = append(by, '\n')
+			break
+		}
+	}
+	combined = append(combined, extra...)
+	f, err := os.CreateTemp("", "ca-bundle-*.pem")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(combined); err != nil {
+		return "", fmt.Errorf("failed to write CA bundle: %v", err)
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,203 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"istio.io/istio/pkg/log"
+)
+
+var (
+	progressEnabled bool
+
+	transfersMu sync.Mutex
+	// transfers records the completed transfers for the summary
+	transfers []transfer
+)
+
+type transfer struct {
+	name     string
+	bytes    int64
+	duration time.Duration
+}
+
+// EnableProgress turns on progress reporting of uploads, clones, and pushes. Progress is drawn as bars on a
+// terminal, or logged periodically otherwise, such as in CI.
+func EnableProgress(enabled bool) {
+	progressEnabled = enabled
+}
+
+// ProgressEnabled returns true if progress reporting is on.
+func ProgressEnabled() bool {
+	return progressEnabled
+}
+
+// Progress tracks a single transfer. It implements io.Reader, counting the bytes read, so it can be passed
+// as the progress reader of S3 uploads.
+type Progress struct {
+	name        string
+	start       time.Time
+	interactive bool
+
+	mu       sync.Mutex
+	total    int64
+	complete int64
+	lastDraw time.Time
+}
+
+// NewProgress starts tracking a transfer of total bytes, which may be 0 if unknown.
+func NewProgress(name string, total int64) *Progress {
+	return &Progress{name: name, total: total, start: time.Now(), interactive: isTerminal()}
+}
+
+// Read counts len(b) bytes as transferred.
+func (p *Progress) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	p.complete += int64(len(b))
+	p.mu.Unlock()
+	p.report(false)
+	return len(b), nil
+}
+
+// Updates returns a channel for registry writes to report progress on. The channel is closed by the writer.
+func (p *Progress) Updates() chan v1.Update {
+	updates := make(chan v1.Update, 16)
+	go func() {
+		for u := range updates {
+			p.mu.Lock()
+			p.complete, p.total = u.Complete, u.Total
+			p.mu.Unlock()
+			p.report(false)
+		}
+	}()
+	return updates
+}
+
+// Done completes the transfer, recording it for the summary. If bytes is non-zero, it replaces the count
+// of transferred bytes, for transfers measured after the fact such as clones.
+func (p *Progress) Done(bytes int64) {
+	p.mu.Lock()
+	if bytes > 0 {
+		p.complete = bytes
+	}
+	p.mu.Unlock()
+	p.report(true)
+	transfersMu.Lock()
+	defer transfersMu.Unlock()
+	transfers = append(transfers, transfer{name: p.name, bytes: p.complete, duration: time.Since(p.start)})
+}
+
+// report draws the progress, throttled to avoid flooding the terminal or logs.
+func (p *Progress) report(final bool) {
+	if !progressEnabled {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	interval := 10 * time.Second
+	if p.interactive {
+		interval = 100 * time.Millisecond
+	}
+	if !final && time.Since(p.lastDraw) < interval {
+		return
+	}
+	p.lastDraw = time.Now()
+
+	elapsed := time.Since(p.start).Seconds()
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(p.complete) / elapsed
+	}
+	eta := "?"
+	if p.total > 0 && rate > 0 {
+		eta = (time.Duration(float64(max(p.total-p.complete, 0))/rate) * time.Second).Round(time.Second).String()
+	}
+	if !p.interactive {
+		log.Infof("%v: %v of %v (%v/s, ETA %v)", p.name, formatBytes(p.complete), formatBytes(p.total), formatBytes(int64(rate)), eta)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\r%-40.40s %s%9s %9s/s ETA %-8s", p.name, progressBar(p.complete, p.total), formatBytes(p.complete), formatBytes(int64(rate)), eta)
+	if final {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// progressBar draws a bar of the completed fraction, or nothing if the total is unknown. Writers may report
+// more bytes than the total, such as when retrying, so the bar is clamped to be full.
+func progressBar(complete, total int64) string {
+	if total <= 0 {
+		return ""
+	}
+	const width = 30
+	filled := int(width * complete / total)
+	filled = max(0, min(filled, width))
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", width-filled) + "] "
+}
+
+// LogTransferSummary logs a table of the completed transfers, if progress reporting is on.
+func LogTransferSummary() {
+	transfersMu.Lock()
+	defer transfersMu.Unlock()
+	if !progressEnabled || len(transfers) == 0 {
+		return
+	}
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%-60s %12s %10s %12s\n", "TRANSFER", "SIZE", "TIME", "RATE")
+	var totalBytes int64
+	var totalTime time.Duration
+	for _, t := range transfers {
+		fmt.Fprintf(b, "%-60s %12s %10s %10s/s\n", t.name, formatBytes(t.bytes), t.duration.Round(time.Second), formatBytes(rate(t.bytes, t.duration)))
+		totalBytes += t.bytes
+		totalTime += t.duration
+	}
+	fmt.Fprintf(b, "%-60s %12s %10s %10s/s", fmt.Sprintf("TOTAL (%d)", len(transfers)), formatBytes(totalBytes), totalTime.Round(time.Second), formatBytes(rate(totalBytes, totalTime)))
+	log.Infof("Transfer summary:\n%v", b.String())
+	transfers = nil
+}
+
+func rate(bytes int64, d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(float64(bytes) / d.Seconds())
+}
+
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+// isTerminal returns true if progress can be drawn interactively.
+func isTerminal() bool {
+	if os.Getenv("CI") != "" {
+		return false
+	}
+	fi, err := os.Stderr.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
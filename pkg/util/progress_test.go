@@ -0,0 +1,58 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProgressBar(t *testing.T) {
+	cases := []struct {
+		complete, total int64
+		filled          int
+	}{
+		{0, 100, 0},
+		{50, 100, 15},
+		{100, 100, 30},
+		// Writers may report more than the total, such as when retrying a layer
+		{250, 100, 30},
+		{-1, 100, 0},
+	}
+	for _, c := range cases {
+		bar := progressBar(c.complete, c.total)
+		if len(bar) != 33 || strings.Count(bar, "=") != c.filled {
+			t.Errorf("progressBar(%v, %v) = %q, expected %v of 30 filled", c.complete, c.total, bar, c.filled)
+		}
+	}
+	if bar := progressBar(10, 0); bar != "" {
+		t.Errorf("expected no bar for an unknown total, got %q", bar)
+	}
+}
+
+func TestProgressOvershoot(t *testing.T) {
+	EnableProgress(true)
+	defer EnableProgress(false)
+	p := NewProgress("overshoot", 100)
+	p.interactive = true
+	// Drawing a transfer past its total must not panic
+	p.Done(250)
+	transfersMu.Lock()
+	defer transfersMu.Unlock()
+	if len(transfers) != 1 || transfers[0].bytes != 250 {
+		t.Errorf("expected the transfer of 250 bytes to be recorded, got %+v", transfers)
+	}
+	transfers = nil
+}
@@ -0,0 +1,172 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// Keychain returns a keychain resolving credentials for the configured registries, falling back to the
+// ambient docker config for other registries.
+func Keychain(registries []model.RegistryAuth) authn.Keychain {
+	if len(registries) == 0 {
+		return authn.DefaultKeychain
+	}
+	return authn.NewMultiKeychain(&registryKeychain{registries: registries, cache: map[string]authn.AuthConfig{}}, authn.DefaultKeychain)
+}
+
+type registryKeychain struct {
+	registries []model.RegistryAuth
+	mu         sync.Mutex
+	// cache holds resolved credentials, as token exchanges are slow
+	cache map[string]authn.AuthConfig
+}
+
+func (k *registryKeychain) Resolve(r authn.Resource) (authn.Authenticator, error) {
+	auth, f := matchRegistry(k.registries, r.String())
+	if !f {
+		return authn.Anonymous, nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if cfg, f := k.cache[auth.Hub]; f {
+		return authn.FromConfig(cfg), nil
+	}
+	cfg, err := resolveRegistryAuth(auth)
+	if err != nil {
+		return nil, err
+	}
+	k.cache[auth.Hub] = cfg
+	return authn.FromConfig(cfg), nil
+}
+
+// matchRegistry returns the registry config with the longest hub prefix matching the repository. Repositories are
+// named as go-containerregistry names them, such as index.docker.io/istio/pilot, so hubs are normalized the same way.
+func matchRegistry(registries []model.RegistryAuth, repository string) (model.RegistryAuth, bool) {
+	best, found := model.RegistryAuth{}, false
+	for _, r := range registries {
+		hub := normalizeHub(r.Hub)
+		if (repository == hub || strings.HasPrefix(repository, hub+"/")) && len(r.Hub) > len(best.Hub) {
+			best, found = r, true
+		}
+	}
+	return best, found
+}
+
+// normalizeHub names the registry of a hub as go-containerregistry does, such as docker.io as index.docker.io.
+func normalizeHub(hub string) string {
+	host, repo, _ := strings.Cut(strings.TrimSuffix(hub, "/"), "/")
+	if reg, err := name.NewRegistry(host); err == nil {
+		host = reg.Name()
+	}
+	if repo == "" {
+		return host
+	}
+	return host + "/" + repo
+}
+
+// resolveRegistryAuth reads the credentials for a registry, exchanging cloud credentials for a registry token
+// where needed.
+func resolveRegistryAuth(auth model.RegistryAuth) (authn.AuthConfig, error) {
+	host, _, _ := strings.Cut(auth.Hub, "/")
+	switch auth.Type {
+	case "", "basic":
+		// Harbor and Quay robot accounts are plain username and password credentials
+		username := auth.Username
+		if auth.UsernameEnv != "" {
+			username = os.Getenv(auth.UsernameEnv)
+		}
+		password, err := registryPassword(auth)
+		if err != nil {
+			return authn.AuthConfig{}, err
+		}
+		return authn.AuthConfig{Username: username, Password: password}, nil
+	case "quay-token":
+		token, err := registryPassword(auth)
+		if err != nil {
+			return authn.AuthConfig{}, err
+		}
+		return authn.AuthConfig{Username: "$oauthtoken", Password: token}, nil
+	case "ecr":
+		// Hosts are in the form <account>.dkr.ecr.<region>.amazonaws.com
+		parts := strings.Split(host, ".")
+		if len(parts) < 4 {
+			return authn.AuthConfig{}, fmt.Errorf("invalid ecr registry %v", host)
+		}
+		token, err := RunWithOutput("aws", "ecr", "get-login-password", "--region", parts[3])
+		if err != nil {
+			return authn.AuthConfig{}, fmt.Errorf("failed to get ecr token: %v", err)
+		}
+		return authn.AuthConfig{Username: "AWS", Password: strings.TrimSpace(token)}, nil
+	case "gcr":
+		token, err := RunWithOutput("gcloud", "auth", "print-access-token")
+		if err != nil {
+			return authn.AuthConfig{}, fmt.Errorf("failed to get gcloud token: %v", err)
+		}
+		return authn.AuthConfig{Username: "oauth2accesstoken", Password: strings.TrimSpace(token)}, nil
+	case "acr":
+		name, _, _ := strings.Cut(host, ".")
+		token, err := RunWithOutput("az", "acr", "login", "--name", name, "--expose-token", "--output", "tsv", "--query", "accessToken")
+		if err != nil {
+			return authn.AuthConfig{}, fmt.Errorf("failed to get acr token: %v", err)
+		}
+		return authn.AuthConfig{Username: "00000000-0000-0000-0000-000000000000", Password: strings.TrimSpace(token)}, nil
+	default:
+		return authn.AuthConfig{}, fmt.Errorf("unknown registry auth type %q", auth.Type)
+	}
+}
+
+func registryPassword(auth model.RegistryAuth) (string, error) {
+	if auth.PasswordFile != "" {
+		by, err := os.ReadFile(auth.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read registry password: %v", err)
+		}
+		return strings.TrimSpace(string(by)), nil
+	}
+	if auth.PasswordEnv != "" {
+		return os.Getenv(auth.PasswordEnv), nil
+	}
+	return "", fmt.Errorf("no password configured for %v", auth.Hub)
+}
+
+// DockerLogin logs the docker CLI into the hub, if credentials are configured for it. Otherwise, the
+// ambient docker login is used.
+func DockerLogin(registries []model.RegistryAuth, hub string) error {
+	// Hubs are given as configured, such as docker.io/istio, but matched as go-containerregistry names them
+	auth, f := matchRegistry(registries, normalizeHub(hub))
+	if !f {
+		return nil
+	}
+	cfg, err := resolveRegistryAuth(auth)
+	if err != nil {
+		return err
+	}
+	host, _, _ := strings.Cut(hub, "/")
+	cmd := VerboseCommand("docker", "login", host, "--username", cfg.Username, "--password-stdin")
+	cmd.Stdin = strings.NewReader(cfg.Password)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to login to %v: %v", host, err)
+	}
+	return nil
+}
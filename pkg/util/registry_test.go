@@ -0,0 +1,78 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+func TestMatchRegistry(t *testing.T) {
+	registries := []model.RegistryAuth{
+		{Hub: "docker.io/istio"},
+		{Hub: "harbor.example.com"},
+		{Hub: "harbor.example.com/istio/"},
+	}
+	cases := map[string]string{
+		"docker.io/istio/pilot":               "docker.io/istio",
+		"istio/pilot":                         "docker.io/istio",
+		"harbor.example.com/istio/pilot":      "harbor.example.com/istio/",
+		"harbor.example.com/other/pilot":      "harbor.example.com",
+		"docker.io/other/pilot":               "",
+		"harbor.example.com.evil.com/istio/x": "",
+	}
+	for repo, want := range cases {
+		// Match as the keychain is called, with the repository named by go-containerregistry
+		r, err := name.NewRepository(repo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, f := matchRegistry(registries, r.String())
+		if !f {
+			got.Hub = ""
+		}
+		if got.Hub != want {
+			t.Errorf("%v: got %q, want %q", repo, got.Hub, want)
+		}
+	}
+	// Raw hubs, as passed to DockerLogin, match once normalized
+	for hub, want := range map[string]string{
+		"docker.io/istio":    "docker.io/istio",
+		"harbor.example.com": "harbor.example.com",
+		"quay.io/istio":      "",
+	} {
+		got, f := matchRegistry(registries, normalizeHub(hub))
+		if !f {
+			got.Hub = ""
+		}
+		if got.Hub != want {
+			t.Errorf("%v: got %q, want %q", hub, got.Hub, want)
+		}
+	}
+}
+
+func TestDockerLogin(t *testing.T) {
+	// No password is configured, so resolving the matched credentials fails before docker is run
+	registries := []model.RegistryAuth{{Hub: "docker.io/istio"}}
+	if err := DockerLogin(registries, "docker.io/istio"); err == nil {
+		t.Fatal("expected docker.io/istio to match the configured registry")
+	}
+	if err := DockerLogin(registries, "quay.io/istio"); err != nil {
+		t.Fatalf("expected quay.io/istio to use the ambient login, got %v", err)
+	}
+}
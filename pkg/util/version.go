@@ -0,0 +1,107 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// PrereleaseKinds are the supported pre-release identifiers, in release order. Pre-releases are
+// versioned as <kind>.<n>, such as 1.25.0-rc.1, starting from 0.
+var PrereleaseKinds = []string{"alpha", "beta", "rc"}
+
+// IsPrerelease returns true if the version is a semver pre-release, such as 1.25.0-beta.1.
+func IsPrerelease(version string) bool {
+	v, err := semver.NewVersion(version)
+	return err == nil && v.Prerelease() != ""
+}
+
+// IsDevBuild returns true if the version is a dev build, such as the daily 1.25-alpha.<sha>, rather than a release or a
+// numbered pre-release.
+func IsDevBuild(version string) bool {
+	v, err := semver.NewVersion(version)
+	if err != nil || v.Prerelease() == "" {
+		return false
+	}
+	_, _, err = parsePrerelease(v.Prerelease())
+	return err != nil
+}
+
+// NextVersion returns the version after current. bump is one of major, minor, or patch, which may be combined with a
+// pre-release kind to start the first pre-release of the new version; prerelease, which increments the pre-release
+// number, or moves to the first pre-release of the kind; or release, which drops the pre-release.
+func NextVersion(current string, bump string, kind string) (string, error) {
+	v, err := semver.NewVersion(current)
+	if err != nil {
+		return "", fmt.Errorf("invalid version %v: %v", current, err)
+	}
+	if kind != "" && !slices.Contains(PrereleaseKinds, kind) {
+		return "", fmt.Errorf("unknown pre-release kind %q, expected one of %v", kind, PrereleaseKinds)
+	}
+	var next semver.Version
+	switch bump {
+	case "major":
+		next = v.IncMajor()
+	case "minor":
+		next = v.IncMinor()
+	case "patch":
+		// IncPatch of a pre-release just drops the pre-release, which is the release bump
+		next = *semver.New(v.Major(), v.Minor(), v.Patch()+1, "", "")
+	case "prerelease":
+		currentKind, n, err := parsePrerelease(v.Prerelease())
+		if err != nil {
+			return "", fmt.Errorf("cannot bump pre-release of %v: %v", current, err)
+		}
+		if kind == "" || kind == currentKind {
+			next, _ = v.SetPrerelease(fmt.Sprintf("%s.%d", currentKind, n+1))
+			return next.String(), nil
+		}
+		if slices.Index(PrereleaseKinds, kind) < slices.Index(PrereleaseKinds, currentKind) {
+			return "", fmt.Errorf("cannot move from %v back to %v", currentKind, kind)
+		}
+		next, _ = v.SetPrerelease(kind + ".0")
+		return next.String(), nil
+	case "release":
+		if v.Prerelease() == "" {
+			return "", fmt.Errorf("%v is not a pre-release", current)
+		}
+		next, _ = v.SetPrerelease("")
+		return next.String(), nil
+	default:
+		return "", fmt.Errorf("unknown bump %q, expected one of major, minor, patch, prerelease, release", bump)
+	}
+	if kind != "" {
+		next, _ = next.SetPrerelease(kind + ".0")
+	}
+	return next.String(), nil
+}
+
+// parsePrerelease splits a pre-release such as rc.3 into its kind and number.
+func parsePrerelease(pre string) (string, int, error) {
+	kind, num, _ := strings.Cut(pre, ".")
+	if !slices.Contains(PrereleaseKinds, kind) {
+		return "", 0, fmt.Errorf("pre-release %q is not one of %v", pre, PrereleaseKinds)
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil {
+		return "", 0, fmt.Errorf("pre-release %q is not numbered", pre)
+	}
+	return kind, n, nil
+}
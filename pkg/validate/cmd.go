@@ -23,7 +23,13 @@ import (
 
 var (
 	flags = struct {
-		release string
+		release  string
+		scanner  string
+		severity string
+		previous string
+		helmrepo string
+		layout   string
+		packages bool
 	}{}
 
 	validateCmd = &cobra.Command{
@@ -52,6 +58,18 @@ var (
 func init() {
 	validateCmd.PersistentFlags().StringVar(&flags.release, "release", flags.release,
 		"The release to validate.")
+	validateCmd.PersistentFlags().StringVar(&flags.scanner, "scanner", flags.scanner,
+		"If set, scan images and archives for vulnerabilities with this scanner, one of trivy or grype. Reports are written to security/ in the release, and added to its checksums.")
+	validateCmd.PersistentFlags().Var(severityFlag{&flags.severity}, "severity",
+		fmt.Sprintf("If set, fail validation on vulnerabilities at or above this severity, one of %v. Example: HIGH", severities))
+	validateCmd.PersistentFlags().StringVar(&flags.previous, "previous", flags.previous,
+		"If set, check the CRDs are upgrade compatible with this previous release. Defaults to crdCompatibility.previousVersion in the manifest.")
+	validateCmd.PersistentFlags().StringVar(&flags.helmrepo, "helmrepo", flags.helmrepo,
+		"The helm repo the previous release is published to. Defaults to crdCompatibility.helmRepo in the manifest.")
+	validateCmd.PersistentFlags().BoolVar(&flags.packages, "packages", flags.packages,
+		"If set, install the deb and rpm packages in containers of their distros, checking their files and systemd units. Requires docker.")
+	validateCmd.PersistentFlags().StringVar(&flags.layout, "layout", flags.layout,
+		"If set, check the release archive matches the expected layout in this file for the release version. Example: example/archive-layout.yaml")
 }
 
 func GetValidateCommand() *cobra.Command {
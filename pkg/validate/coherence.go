@@ -0,0 +1,160 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg"
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// TestVersionCoherence checks the versions stamped into every artifact agree with the manifest: the versions reported
+// by the istioctl and pilot-discovery binaries, the chart versions, the image tags, and the archive manifest. Every
+// disagreement is reported, rather than only the first.
+func TestVersionCoherence(r ReleaseInfo) error {
+	want := r.manifest.Version
+	errs := []error{}
+	check := func(source, got, want string) {
+		if got != want {
+			errs = append(errs, fmt.Errorf("%v has version %q, expected %q", source, got, want))
+		}
+	}
+
+	archived, err := pkg.ReadManifest(filepath.Join(r.archive, "manifest.yaml"))
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to read archive manifest: %v", err))
+	} else {
+		check("archive manifest.yaml", archived.Version, want)
+	}
+
+	if v, err := clientVersion(filepath.Join(r.archive, "bin", "istioctl"), "version", "--remote=false", "-ojson"); err != nil {
+		errs = append(errs, fmt.Errorf("istioctl version: %v", err))
+	} else {
+		check("istioctl", v, want)
+	}
+
+	if util.IsValidSemver(want) {
+		charts, err := filepath.Glob(filepath.Join(r.release, r.manifest.ArtifactDir("helm", ""), "*.tgz"))
+		if err != nil {
+			return err
+		}
+		for _, c := range charts {
+			name, _ := model.ChartNameVersion(filepath.Base(c))
+			out, err := util.RunWithOutput("helm", "show", "chart", c)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("helm show chart %v: %v", name, err))
+				continue
+			}
+			meta := struct {
+				Version    string `json:"version"`
+				AppVersion string `json:"appVersion"`
+			}{}
+			if err := yaml.Unmarshal([]byte(out), &meta); err != nil {
+				errs = append(errs, fmt.Errorf("failed to unmarshal chart %v: %v", name, err))
+				continue
+			}
+			check("chart "+name, meta.Version, r.manifest.ChartVersion(name))
+			check("chart "+name+" appVersion", meta.AppVersion, r.manifest.ChartAppVersion(name))
+		}
+	}
+
+	if _, f := r.manifest.BuildOutputs[model.Docker]; f {
+		images, err := filepath.Glob(filepath.Join(r.release, r.manifest.ArtifactDir("docker", ""), "*.tar.gz"))
+		if err != nil {
+			return err
+		}
+		// The binary version is read from one amd64 pilot image, as the other archives are checked to be amd64
+		pilotChecked := false
+		for _, image := range images {
+			tags, err := imageTags(image)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to read %v: %v", filepath.Base(image), err))
+				continue
+			}
+			for _, tag := range tags {
+				check("image "+tag, imageTagVersion(r.manifest, tag), want)
+			}
+			if name, _, arch := model.ImageNameVariant(filepath.Base(image), r.manifest.ImageVariants); name == "pilot" && arch == "" && len(tags) > 0 && !pilotChecked {
+				pilotChecked = true
+				if err := util.VerboseCommand("docker", "load", "-i", image).Run(); err != nil {
+					errs = append(errs, fmt.Errorf("failed to load %v: %v", filepath.Base(image), err))
+					continue
+				}
+				if v, err := clientVersion("docker", "run", "--rm", tags[0], "version", "-ojson"); err != nil {
+					errs = append(errs, fmt.Errorf("pilot-discovery version: %v", err))
+				} else {
+					check("pilot-discovery in "+tags[0], v, want)
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// clientVersion runs the version command of an istio binary, returning the version it reports.
+func clientVersion(command string, args ...string) (string, error) {
+	out, err := util.RunWithOutput(command, args...)
+	if err != nil {
+		return "", err
+	}
+	var v Version
+	if err := json.Unmarshal([]byte(out), &v); err != nil {
+		return "", fmt.Errorf("failed to unmarshal version information: %v", err)
+	}
+	if v.ClientVersion == nil {
+		return "", fmt.Errorf("no client version found in version information")
+	}
+	return v.ClientVersion.Version, nil
+}
+
+// imageTags returns the tags of the image archive.
+func imageTags(image string) ([]string, error) {
+	m, err := tarball.LoadManifest(func() (io.ReadCloser, error) { return gzipFile(image) })
+	if err != nil {
+		return nil, err
+	}
+	tags := []string{}
+	for _, desc := range m {
+		tags = append(tags, desc.RepoTags...)
+	}
+	return tags, nil
+}
+
+// imageTagVersion returns the version of the image tag, which is <hub>/<image>:<version>[-<variant>], or the whole
+// tag if it is not of the manifest hub.
+func imageTagVersion(manifest model.Manifest, tag string) string {
+	i := strings.LastIndex(tag, ":")
+	if i < 0 || !strings.HasPrefix(tag, manifest.Docker+"/") {
+		return tag
+	}
+	version := tag[i+1:]
+	for _, variant := range []string{"debug", "distroless"} {
+		version = strings.TrimSuffix(version, "-"+variant)
+	}
+	for _, variant := range manifest.ImageVariants {
+		version = strings.TrimSuffix(version, "-"+variant)
+	}
+	return version
+}
@@ -0,0 +1,90 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+func TestImageTagVersion(t *testing.T) {
+	manifest := model.Manifest{Docker: "docker.io/istio", ImageVariants: []string{"fips"}}
+	cases := map[string]string{
+		"docker.io/istio/pilot:1.24.1":            "1.24.1",
+		"docker.io/istio/pilot:1.24.1-distroless": "1.24.1",
+		"docker.io/istio/proxyv2:1.24.1-fips":     "1.24.1",
+		"docker.io/istio/pilot:1.24.0-debug":      "1.24.0",
+		"gcr.io/istio-testing/pilot:1.24.1":       "gcr.io/istio-testing/pilot:1.24.1",
+	}
+	for tag, want := range cases {
+		if got := imageTagVersion(manifest, tag); got != want {
+			t.Errorf("%v: got %v, want %v", tag, got, want)
+		}
+	}
+}
+
+func TestVersionCoherencePilotDiscovery(t *testing.T) {
+	release := t.TempDir()
+	r := ReleaseInfo{
+		manifest: model.Manifest{
+			Version:      "1.24.0",
+			Docker:       "docker.io/istio",
+			BuildOutputs: map[model.BuildOutput]struct{}{model.Docker: {}},
+		},
+		archive: filepath.Join(release, "istio-1.24.0"),
+		release: release,
+	}
+	writeFiles(t, r.archive, "manifest.yaml", "bin/istioctl")
+	if err := os.WriteFile(filepath.Join(r.archive, "manifest.yaml"), []byte("version: 1.24.0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(r.archive, "bin", "istioctl"),
+		[]byte("#!/bin/sh\necho '{\"clientVersion\":{\"version\":\"1.24.0\"}}'\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	images := map[string]string{
+		"pilot.tar.gz":            "docker.io/istio/pilot:1.24.0",
+		"pilot-distroless.tar.gz": "docker.io/istio/pilot:1.24.0-distroless",
+		"proxyv2.tar.gz":          "docker.io/istio/proxyv2:1.24.0",
+	}
+	for file, tag := range images {
+		writeImage(t, filepath.Join(release, "docker", file), tag, 1, nil)
+	}
+
+	// pilot-discovery reports the version it was built with, which was not the release
+	runs := filepath.Join(t.TempDir(), "runs")
+	fakeCommand(t, "docker", `if [ "$1" = run ]; then echo "$@" >> `+runs+`; echo '{"clientVersion":{"version":"1.23.0"}}'; fi`)
+	err := TestVersionCoherence(r)
+	want := `pilot-discovery in docker.io/istio/pilot:1.24.0-distroless has version "1.23.0", expected "1.24.0"`
+	if err == nil || err.Error() != want {
+		t.Fatalf("expected %q, got %v", want, err)
+	}
+	by, err := os.ReadFile(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(by)); got != "run --rm docker.io/istio/pilot:1.24.0-distroless version -ojson" {
+		t.Fatalf("expected only one pilot image to be run, got %q", got)
+	}
+
+	fakeCommand(t, "docker", `if [ "$1" = run ]; then echo '{"clientVersion":{"version":"1.24.0"}}'; fi`)
+	if err := TestVersionCoherence(r); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+type crd struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Versions []crdVersion `json:"versions"`
+	} `json:"spec"`
+}
+
+type crdVersion struct {
+	Name   string `json:"name"`
+	Served bool   `json:"served"`
+	Schema struct {
+		OpenAPIV3Schema *schema `json:"openAPIV3Schema"`
+	} `json:"schema"`
+}
+
+type schema struct {
+	Type                 string             `json:"type"`
+	Properties           map[string]*schema `json:"properties"`
+	Items                *schema            `json:"items"`
+	AdditionalProperties *schema            `json:"additionalProperties"`
+}
+
+// TestCRDCompatibility compares the CRDs of the base chart against the previous release, failing on removed
+// CRDs, dropped versions, removed fields, or changed field types, any of which would break `helm upgrade`.
+func TestCRDCompatibility(r ReleaseInfo) error {
+	previous, helmRepo := flags.previous, flags.helmrepo
+	if v := r.manifest.CRDCompatibility; v != nil {
+		if previous == "" {
+			previous = v.PreviousVersion
+		}
+		if helmRepo == "" {
+			helmRepo = v.HelmRepo
+		}
+	}
+	if previous == "" || helmRepo == "" {
+		return fmt.Errorf("the previous version and helm repo must be set")
+	}
+	chart := filepath.Join(r.release, r.manifest.ArtifactDir("helm", ""), r.manifest.ChartFile("base"))
+	current, err := renderCRDs(chart)
+	if err != nil {
+		return fmt.Errorf("failed to render CRDs: %v", err)
+	}
+	old, err := renderCRDs("base", "--repo", helmRepo, "--version", previous)
+	if err != nil {
+		return fmt.Errorf("failed to render CRDs of %v: %v", previous, err)
+	}
+
+	var errs []error
+	for name, o := range old {
+		n, f := current[name]
+		if !f {
+			errs = append(errs, fmt.Errorf("CRD %v was removed", name))
+			continue
+		}
+		errs = append(errs, compareCRD(name, o, n)...)
+	}
+	return errors.Join(errs...)
+}
+
+// renderCRDs renders the chart, returning the CRDs by name.
+func renderCRDs(chart string, args ...string) (map[string]crd, error) {
+	out, err := util.RunWithOutput("helm", append([]string{"template", "release-test", chart, "--include-crds"}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	crds := map[string]crd{}
+	for _, doc := range strings.Split(out, "\n---") {
+		c := crd{}
+		if err := yaml.Unmarshal([]byte(doc), &c); err != nil {
+			return nil, fmt.Errorf("rendered invalid yaml: %v", err)
+		}
+		if c.Kind == "CustomResourceDefinition" {
+			crds[c.Metadata.Name] = c
+		}
+	}
+	return crds, nil
+}
+
+func compareCRD(name string, old, current crd) []error {
+	versions := map[string]crdVersion{}
+	for _, v := range current.Spec.Versions {
+		versions[v.Name] = v
+	}
+	var errs []error
+	for _, o := range old.Spec.Versions {
+		if !o.Served {
+			continue
+		}
+		n, f := versions[o.Name]
+		if !f || !n.Served {
+			errs = append(errs, fmt.Errorf("CRD %v no longer serves version %v", name, o.Name))
+			continue
+		}
+		errs = append(errs, compareSchema(fmt.Sprintf("%v %v", name, o.Name), "", o.Schema.OpenAPIV3Schema, n.Schema.OpenAPIV3Schema)...)
+	}
+	return errs
+}
+
+// compareSchema reports fields removed from, or with a different type in, the current schema.
+func compareSchema(crd, path string, old, current *schema) []error {
+	if old == nil {
+		return nil
+	}
+	if current == nil {
+		return []error{fmt.Errorf("CRD %v removed the schema of %v", crd, fieldPath(path))}
+	}
+	if old.Type != "" && current.Type != old.Type {
+		return []error{fmt.Errorf("CRD %v changed the type of %v from %v to %v", crd, fieldPath(path), old.Type, current.Type)}
+	}
+	var errs []error
+	fields := make([]string, 0, len(old.Properties))
+	for f := range old.Properties {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	for _, f := range fields {
+		p := path + "." + f
+		n, found := current.Properties[f]
+		if !found {
+			errs = append(errs, fmt.Errorf("CRD %v removed field %v", crd, p))
+			continue
+		}
+		errs = append(errs, compareSchema(crd, p, old.Properties[f], n)...)
+	}
+	errs = append(errs, compareSchema(crd, path+"[]", old.Items, current.Items)...)
+	errs = append(errs, compareSchema(crd, path+".*", old.AdditionalProperties, current.AdditionalProperties)...)
+	return errs
+}
+
+func fieldPath(path string) string {
+	if path == "" {
+		return "the root"
+	}
+	return path
+}
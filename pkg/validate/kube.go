@@ -0,0 +1,145 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+var (
+	// defaultKubernetesVersions is used when the manifest does not define kubernetesVersions
+	defaultKubernetesVersions = []string{"1.29.0", "1.30.0", "1.31.0", "1.32.0"}
+
+	// apiCapabilitySets are additional API versions advertised to `helm template`, as some charts
+	// render different objects depending on the APIs available in the cluster.
+	apiCapabilitySets = map[string][]string{
+		"default":     nil,
+		"gateway-api": {"gateway.networking.k8s.io/v1", "gateway.networking.k8s.io/v1beta1"},
+	}
+
+	// removedAPIs maps apiVersion/kind to the Kubernetes version it was removed in.
+	removedAPIs = map[string]string{
+		"extensions/v1beta1/Ingress":                                          "1.22.0",
+		"networking.k8s.io/v1beta1/Ingress":                                   "1.22.0",
+		"admissionregistration.k8s.io/v1beta1/MutatingWebhookConfiguration":   "1.22.0",
+		"admissionregistration.k8s.io/v1beta1/ValidatingWebhookConfiguration": "1.22.0",
+		"apiextensions.k8s.io/v1beta1/CustomResourceDefinition":               "1.22.0",
+		"rbac.authorization.k8s.io/v1beta1/ClusterRole":                       "1.22.0",
+		"rbac.authorization.k8s.io/v1beta1/ClusterRoleBinding":                "1.22.0",
+		"rbac.authorization.k8s.io/v1beta1/Role":                              "1.22.0",
+		"rbac.authorization.k8s.io/v1beta1/RoleBinding":                       "1.22.0",
+		"batch/v1beta1/CronJob":                                               "1.25.0",
+		"policy/v1beta1/PodDisruptionBudget":                                  "1.25.0",
+		"policy/v1beta1/PodSecurityPolicy":                                    "1.25.0",
+		"autoscaling/v2beta1/HorizontalPodAutoscaler":                         "1.25.0",
+		"autoscaling/v2beta2/HorizontalPodAutoscaler":                         "1.26.0",
+		"flowcontrol.apiserver.k8s.io/v1beta1/FlowSchema":                     "1.26.0",
+		"flowcontrol.apiserver.k8s.io/v1beta2/FlowSchema":                     "1.29.0",
+		"flowcontrol.apiserver.k8s.io/v1beta3/FlowSchema":                     "1.32.0",
+	}
+)
+
+type kubeObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+}
+
+// TestHelmKubeVersions renders every packaged chart for each supported Kubernetes version and API
+// capability set, failing if a chart does not render or renders an API removed in that version.
+func TestHelmKubeVersions(r ReleaseInfo) error {
+	if !util.IsValidSemver(r.manifest.Version) {
+		return nil
+	}
+	versions := r.manifest.KubernetesVersions
+	if len(versions) == 0 {
+		versions = defaultKubernetesVersions
+	}
+	charts, err := filepath.Glob(filepath.Join(r.release, r.manifest.ArtifactDir("helm", ""), "*.tgz"))
+	if err != nil {
+		return err
+	}
+	samples, err := filepath.Glob(filepath.Join(r.release, r.manifest.ArtifactDir("helm", ""), "samples", "*.tgz"))
+	if err != nil {
+		return err
+	}
+	charts = append(charts, samples...)
+	if len(charts) == 0 {
+		return fmt.Errorf("no charts found")
+	}
+	for _, chart := range charts {
+		for _, kubeVersion := range versions {
+			for capability, apis := range apiCapabilitySets {
+				rendered, err := renderChart(chart, kubeVersion, apis)
+				if err != nil {
+					return fmt.Errorf("%v failed to render for %v (%v): %v", filepath.Base(chart), kubeVersion, capability, err)
+				}
+				if err := checkRemovedAPIs(rendered, kubeVersion); err != nil {
+					return fmt.Errorf("%v for %v (%v): %v", filepath.Base(chart), kubeVersion, capability, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func renderChart(chart, kubeVersion string, apis []string) ([]byte, error) {
+	args := []string{"template", "release-test", chart, "--kube-version", kubeVersion}
+	for _, api := range apis {
+		args = append(args, "--api-versions", api)
+	}
+	buf := &bytes.Buffer{}
+	c := util.VerboseCommand("helm", args...)
+	c.Stdout = buf
+	if err := c.Run(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// checkRemovedAPIs verifies none of the rendered objects use an API that is not served by kubeVersion.
+func checkRemovedAPIs(rendered []byte, kubeVersion string) error {
+	kv, err := semver.NewVersion(kubeVersion)
+	if err != nil {
+		return fmt.Errorf("invalid kubernetes version %v: %v", kubeVersion, err)
+	}
+	for _, doc := range strings.Split(string(rendered), "\n---") {
+		obj := kubeObject{}
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+			return fmt.Errorf("rendered invalid yaml: %v", err)
+		}
+		if obj.Kind == "" {
+			continue
+		}
+		removed, f := removedAPIs[obj.APIVersion+"/"+obj.Kind]
+		if !f {
+			continue
+		}
+		if !kv.LessThan(semver.MustParse(removed)) {
+			return fmt.Errorf("%v %v uses %v, which was removed in %v", obj.Kind, obj.Metadata.Name, obj.APIVersion, removed)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"testing"
+)
+
+func TestCheckRemovedAPIs(t *testing.T) {
+	pdb := `---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: istiod
+---
+apiVersion: policy/v1beta1
+kind: PodDisruptionBudget
+metadata:
+  name: istiod
+`
+	cases := []struct {
+		name        string
+		rendered    string
+		kubeVersion string
+		wantErr     bool
+	}{
+		{"served", pdb, "1.24.0", false},
+		{"removed", pdb, "1.25.0", true},
+		{"removed later", pdb, "1.30.2", true},
+		{"empty", "", "1.30.0", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkRemovedAPIs([]byte(tc.rendered), tc.kubeVersion)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got err %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"sigs.k8s.io/yaml"
+)
+
+// ArchiveLayoutSpec lists the expected layouts of the release archive, by version.
+type ArchiveLayoutSpec struct {
+	Layouts []ArchiveLayout `json:"layouts"`
+}
+
+// ArchiveLayout is the expected contents of the release archives of the versions matching Versions. Globs are
+// relative to the root of the archive, and match a file, or a directory and everything in it.
+type ArchiveLayout struct {
+	// Versions is a semver constraint of the versions the layout applies to. Example: >= 1.24.0-0
+	Versions string `json:"versions"`
+	// Required are globs that must each match a file in the archive. Example: manifests/charts/*
+	Required []string `json:"required"`
+	// Optional are globs of files that may be in the archive.
+	Optional []string `json:"optional,omitempty"`
+}
+
+// TestArchiveLayout checks the release archive has the files required by the layout for its version, and no files
+// outside the layout, to catch upstream changes to the archive layout.
+func TestArchiveLayout(r ReleaseInfo) error {
+	by, err := os.ReadFile(flags.layout)
+	if err != nil {
+		return err
+	}
+	spec := ArchiveLayoutSpec{}
+	if err := yaml.Unmarshal(by, &spec); err != nil {
+		return fmt.Errorf("failed to unmarshal %v: %v", flags.layout, err)
+	}
+	layout, err := spec.forVersion(r.manifest.Version)
+	if err != nil {
+		return err
+	}
+	missing, unexpected, err := checkLayout(r.archive, layout)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 || len(unexpected) > 0 {
+		return fmt.Errorf("archive does not match the layout for %v: missing %v, unexpected %v",
+			layout.Versions, missing, unexpected)
+	}
+	return nil
+}
+
+// forVersion returns the first layout applying to the version.
+func (s ArchiveLayoutSpec) forVersion(version string) (ArchiveLayout, error) {
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return ArchiveLayout{}, fmt.Errorf("invalid version %v: %v", version, err)
+	}
+	for _, l := range s.Layouts {
+		c, err := semver.NewConstraint(l.Versions)
+		if err != nil {
+			return ArchiveLayout{}, fmt.Errorf("invalid layout versions %q: %v", l.Versions, err)
+		}
+		if c.Check(v) {
+			return l, nil
+		}
+	}
+	return ArchiveLayout{}, fmt.Errorf("no layout for version %v", version)
+}
+
+// checkLayout returns the required globs matching no file in dir, and the files in dir matching no glob.
+func checkLayout(dir string, layout ArchiveLayout) ([]string, []string, error) {
+	files := []string{}
+	if err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	missing := []string{}
+	for _, glob := range layout.Required {
+		found := false
+		for _, f := range files {
+			if matchesLayout(glob, f) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, glob)
+		}
+	}
+	unexpected := []string{}
+	for _, f := range files {
+		expected := false
+		for _, glob := range append(layout.Required, layout.Optional...) {
+			if matchesLayout(glob, f) {
+				expected = true
+				break
+			}
+		}
+		if !expected {
+			unexpected = append(unexpected, f)
+		}
+	}
+	return missing, unexpected, nil
+}
+
+// matchesLayout checks if the glob matches the file, or one of its parent directories.
+func matchesLayout(glob string, file string) bool {
+	for p := file; p != "."; p = path.Dir(p) {
+		if m, _ := path.Match(strings.TrimSuffix(glob, "/"), p); m {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckLayout(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"bin/istioctl", "manifests/charts/base/Chart.yaml", "LICENSE", "tools/new.sh"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(f)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, f), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	missing, unexpected, err := checkLayout(dir, ArchiveLayout{
+		Required: []string{"bin/istioctl", "manifests/charts/*", "samples/*"},
+		Optional: []string{"LICENSE", "NOTICES"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"samples/*"}; !reflect.DeepEqual(missing, want) {
+		t.Errorf("got missing %v, want %v", missing, want)
+	}
+	if want := []string{"tools/new.sh"}; !reflect.DeepEqual(unexpected, want) {
+		t.Errorf("got unexpected %v, want %v", unexpected, want)
+	}
+}
+
+func TestLayoutForVersion(t *testing.T) {
+	spec := ArchiveLayoutSpec{Layouts: []ArchiveLayout{
+		{Versions: ">= 1.25.0-0"},
+		{Versions: ">= 1.22.0-0, < 1.25.0-0"},
+	}}
+	cases := map[string]string{
+		"1.25.1":         ">= 1.25.0-0",
+		"1.25.0-alpha.0": ">= 1.25.0-0",
+		"1.24.3":         ">= 1.22.0-0, < 1.25.0-0",
+		"1.21.0":         "",
+	}
+	for version, want := range cases {
+		l, err := spec.forVersion(version)
+		if (err != nil) != (want == "") {
+			t.Fatalf("%v: got err %v", version, err)
+		}
+		if l.Versions != want {
+			t.Errorf("%v: got layout %q, want %q", version, l.Versions, want)
+		}
+	}
+}
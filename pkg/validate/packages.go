@@ -0,0 +1,106 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// rpmDistroImages are the containers the distro rpm variants are installed in.
+var rpmDistroImages = map[string]string{
+	"el8":        "rockylinux:8",
+	"el9":        "rockylinux:9",
+	"amazon2023": "amazonlinux:2023",
+}
+
+// packageInstall is a package installed in a distro container.
+type packageInstall struct {
+	image string
+	// install is the command installing the package, and systemd, with the package in /pkg.
+	install string
+	// file is the package, relative to the release.
+	file string
+	// unit is the path the systemd unit is installed to.
+	unit string
+}
+
+// TestPackageInstall installs the amd64 deb and rpm packages in containers of the distros they are for, checking
+// the binaries are installed and the systemd unit parses.
+func TestPackageInstall(r ReleaseInfo) error {
+	installs := []packageInstall{}
+	if _, f := r.manifest.BuildOutputs[model.Debian]; f {
+		installs = append(installs, packageInstall{
+			image:   "debian:12",
+			install: "apt-get update -qq && apt-get install -y -qq systemd /pkg/istio-sidecar.deb",
+			file:    filepath.Join(r.manifest.ArtifactDir("deb", "amd64"), "istio-sidecar.deb"),
+			unit:    "/lib/systemd/system/istio.service",
+		})
+	}
+	if _, f := r.manifest.BuildOutputs[model.Rpm]; f {
+		installs = append(installs, packageInstall{
+			image:   "rockylinux:9",
+			install: "dnf install -y -q systemd /pkg/istio-sidecar.rpm",
+			file:    filepath.Join(r.manifest.ArtifactDir("rpm", "amd64"), "istio-sidecar.rpm"),
+			unit:    "/lib/systemd/system/istio.service",
+		})
+		if r.manifest.Rpm != nil {
+			for _, d := range r.manifest.Rpm.Distros {
+				image, f := rpmDistroImages[d.Name]
+				if !f {
+					log.Warnf("Skipping install of %v rpm; no container for the distro", d.Name)
+					continue
+				}
+				name := d.PackageName(r.manifest.Version, "amd64")
+				installs = append(installs, packageInstall{
+					image:   image,
+					install: "dnf install -y -q systemd /pkg/" + name,
+					file:    filepath.Join(r.manifest.ArtifactDir("rpm", "amd64"), name),
+					unit:    path.Join(d.UnitDir, "istio.service"),
+				})
+			}
+		}
+	}
+
+	var errs []error
+	for _, i := range installs {
+		if err := i.run(r.release); err != nil {
+			errs = append(errs, fmt.Errorf("%v on %v: %v", filepath.Base(i.file), i.image, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (i packageInstall) run(release string) error {
+	// docker requires an absolute path to mount
+	pkg, err := filepath.Abs(filepath.Join(release, i.file))
+	if err != nil {
+		return err
+	}
+	if !fileExists(pkg) {
+		return fmt.Errorf("package not found")
+	}
+	check := fmt.Sprintf("%s && test -x /usr/local/bin/pilot-agent && test -x /usr/local/bin/envoy && "+
+		"systemd-analyze verify %s", i.install, i.unit)
+	return util.VerboseCommand("docker", "run", "--rm", "--platform", "linux/amd64",
+		"-v", filepath.Dir(pkg)+":/pkg:ro", i.image, "sh", "-c", check).Run()
+}
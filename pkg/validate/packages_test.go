@@ -0,0 +1,78 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+func TestPackageInstallUnits(t *testing.T) {
+	runs := filepath.Join(t.TempDir(), "runs")
+	fakeCommand(t, "docker", `echo "$@" >> `+runs)
+	r := ReleaseInfo{
+		manifest: model.Manifest{
+			Version:      "1.24.0",
+			BuildOutputs: map[model.BuildOutput]struct{}{model.Debian: {}, model.Rpm: {}},
+			Rpm: &model.RpmConfig{Distros: []model.RpmDistro{
+				model.DefaultRpmDistros["el8"],
+				// No container to install in, so skipped
+				{Name: "sles15", Dist: "sles15", UnitDir: "/usr/lib/systemd/system"},
+			}},
+		},
+		release: t.TempDir(),
+	}
+	writeFiles(t, r.release, "deb/istio-sidecar.deb", "rpm/istio-sidecar.rpm")
+	if err := TestPackageInstall(r); err == nil ||
+		!strings.Contains(err.Error(), "istio-sidecar-1.24.0.el8.x86_64.rpm on rockylinux:8: package not found") {
+		t.Fatalf("expected the missing el8 rpm to fail, got %v", err)
+	}
+
+	writeFiles(t, r.release, "rpm/istio-sidecar-1.24.0.el8.x86_64.rpm")
+	if err := os.Remove(runs); err != nil {
+		t.Fatal(err)
+	}
+	if err := TestPackageInstall(r); err != nil {
+		t.Fatal(err)
+	}
+	by, err := os.ReadFile(runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(strings.TrimSpace(string(by)), "\n")
+	want := []struct{ image, pkg, unit string }{
+		{"debian:12", "/pkg/istio-sidecar.deb", "/lib/systemd/system/istio.service"},
+		{"rockylinux:9", "/pkg/istio-sidecar.rpm", "/lib/systemd/system/istio.service"},
+		{"rockylinux:8", "/pkg/istio-sidecar-1.24.0.el8.x86_64.rpm", "/usr/lib/systemd/system/istio.service"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d installs, got %q", len(want), got)
+	}
+	for i, w := range want {
+		if !strings.Contains(got[i], " "+w.image+" ") || !strings.Contains(got[i], w.pkg) ||
+			!strings.HasSuffix(got[i], "systemd-analyze verify "+w.unit) {
+			t.Errorf("expected %v installed on %v and %v verified, got %v", w.pkg, w.image, w.unit, got[i])
+		}
+	}
+
+	fakeCommand(t, "docker", "exit 1")
+	if err := TestPackageInstall(r); err == nil || !strings.Contains(err.Error(), "istio-sidecar.deb on debian:12") {
+		t.Fatalf("expected the failed install to be reported, got %v", err)
+	}
+}
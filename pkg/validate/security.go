@@ -0,0 +1,205 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/build"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// severities in increasing order
+var severities = []string{"UNKNOWN", "NEGLIGIBLE", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+func severityRank(s string) int {
+	for i, sev := range severities {
+		if strings.EqualFold(s, sev) {
+			return i
+		}
+	}
+	return 0
+}
+
+// severityFlag is a --severity value, rejecting anything but one of the severities, so a typo does not fail
+// validation on every finding.
+type severityFlag struct {
+	value *string
+}
+
+func (f severityFlag) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return *f.value
+}
+
+func (f severityFlag) Set(s string) error {
+	for _, sev := range severities {
+		if strings.EqualFold(s, sev) {
+			*f.value = sev
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown severity %q, expected one of %v", s, severities)
+}
+
+func (f severityFlag) Type() string {
+	return "severity"
+}
+
+// vulnerability is a single finding from a scanner report
+type vulnerability struct {
+	ID       string
+	Severity string
+}
+
+// TestVulnerabilities scans the docker images and release archive with the configured scanner, writing
+// reports to the security/ directory of the release, and adding them to its checksums. If a severity threshold
+// is set, any finding at or above it fails the check.
+func TestVulnerabilities(r ReleaseInfo) (err error) {
+	reports := filepath.Join(r.release, "security")
+	if err := os.MkdirAll(reports, 0o750); err != nil {
+		return err
+	}
+	// The reports are written after the release was built, so must be added to its checksums to publish it
+	written := []string{}
+	defer func() {
+		if cerr := build.AddChecksums(r.release, written); cerr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to add reports to %v: %v", build.ChecksumsFile, cerr))
+		}
+	}()
+
+	targets := map[string]string{}
+	images, _ := filepath.Glob(filepath.Join(r.release, r.manifest.ArtifactDir("docker", ""), "*.tar.gz"))
+	for _, img := range images {
+		targets[strings.TrimSuffix(filepath.Base(img), ".tar.gz")] = img
+	}
+	targets["istio-"+r.manifest.Version] = r.archive
+
+	failures := []string{}
+	for name, target := range targets {
+		report := filepath.Join(reports, name+"."+flags.scanner+".json")
+		vulns, err := scan(target, report)
+		if util.FileExists(report) {
+			written = append(written, path.Join("security", filepath.Base(report)))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to scan %v: %v", name, err)
+		}
+		log.Infof("Found %d vulnerabilities in %v, report at %v", len(vulns), name, report)
+		if flags.severity == "" {
+			continue
+		}
+		for _, v := range vulns {
+			if severityRank(v.Severity) >= severityRank(flags.severity) {
+				failures = append(failures, fmt.Sprintf("%v: %v (%v)", name, v.ID, v.Severity))
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("found vulnerabilities at or above %v:\n%v", flags.severity, strings.Join(failures, "\n"))
+	}
+	return nil
+}
+
+// scan runs the scanner against an image tarball or directory, writing a json report.
+func scan(target string, report string) ([]vulnerability, error) {
+	info, err := os.Stat(target)
+	if err != nil {
+		return nil, err
+	}
+	switch flags.scanner {
+	case "trivy":
+		mode := "image"
+		args := []string{"--input", target}
+		if info.IsDir() {
+			mode = "fs"
+			args = []string{target}
+		}
+		cmd := append([]string{mode, "--quiet", "--format", "json", "--output", report}, args...)
+		if err := util.VerboseCommand("trivy", cmd...).Run(); err != nil {
+			return nil, err
+		}
+		return parseTrivyReport(report)
+	case "grype":
+		source := "docker-archive:" + target
+		if info.IsDir() {
+			source = "dir:" + target
+		}
+		if err := util.VerboseCommand("grype", source, "-q", "-o", "json", "--file", report).Run(); err != nil {
+			return nil, err
+		}
+		return parseGrypeReport(report)
+	default:
+		return nil, fmt.Errorf("unknown scanner %q", flags.scanner)
+	}
+}
+
+func parseTrivyReport(report string) ([]vulnerability, error) {
+	by, err := os.ReadFile(report)
+	if err != nil {
+		return nil, err
+	}
+	out := struct {
+		Results []struct {
+			Vulnerabilities []struct {
+				VulnerabilityID string
+				Severity        string
+			}
+		}
+	}{}
+	if err := json.Unmarshal(by, &out); err != nil {
+		return nil, fmt.Errorf("failed to parse trivy report: %v", err)
+	}
+	vulns := []vulnerability{}
+	for _, res := range out.Results {
+		for _, v := range res.Vulnerabilities {
+			vulns = append(vulns, vulnerability{ID: v.VulnerabilityID, Severity: v.Severity})
+		}
+	}
+	return vulns, nil
+}
+
+func parseGrypeReport(report string) ([]vulnerability, error) {
+	by, err := os.ReadFile(report)
+	if err != nil {
+		return nil, err
+	}
+	out := struct {
+		Matches []struct {
+			Vulnerability struct {
+				ID       string `json:"id"`
+				Severity string `json:"severity"`
+			} `json:"vulnerability"`
+		} `json:"matches"`
+	}{}
+	if err := json.Unmarshal(by, &out); err != nil {
+		return nil, fmt.Errorf("failed to parse grype report: %v", err)
+	}
+	vulns := []vulnerability{}
+	for _, m := range out.Matches {
+		vulns = append(vulns, vulnerability{ID: m.Vulnerability.ID, Severity: m.Vulnerability.Severity})
+	}
+	return vulns, nil
+}
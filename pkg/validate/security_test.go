@@ -0,0 +1,34 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import "testing"
+
+func TestSeverityFlag(t *testing.T) {
+	severity := ""
+	f := severityFlag{&severity}
+	if err := f.Set("high"); err != nil {
+		t.Fatal(err)
+	}
+	if severity != "HIGH" {
+		t.Fatalf("expected HIGH, got %v", severity)
+	}
+	if err := f.Set("hihg"); err == nil {
+		t.Fatal("expected an unknown severity to be rejected")
+	}
+	if severity != "HIGH" {
+		t.Fatalf("expected an unknown severity to leave HIGH, got %v", severity)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"istio.io/istio/pkg/log"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+const mib = 1 << 20
+
+// TestSizeBudgets checks the istioctl binary, release archives, and images are within the size budgets of the
+// manifest. With warn set, exceeded budgets are only logged.
+func TestSizeBudgets(r ReleaseInfo) error {
+	budgets := r.manifest.SizeBudgets
+	var errs []error
+	checkSize := func(file string, budget int64) {
+		if budget == 0 {
+			return
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if info.Size() > budget*mib {
+			errs = append(errs, fmt.Errorf("%v is %.1f MiB, over its budget of %d MiB",
+				filepath.Base(file), float64(info.Size())/mib, budget))
+		}
+	}
+
+	if _, f := r.manifest.BuildOutputs[model.Archive]; f {
+		checkSize(filepath.Join(r.archive, "bin", "istioctl"), budgets.Istioctl)
+		archives, err := filepath.Glob(filepath.Join(r.release, fmt.Sprintf("istio-%s-*", r.manifest.Version)))
+		if err != nil {
+			return err
+		}
+		for _, a := range archives {
+			if filepath.Ext(a) == ".sha256" {
+				continue
+			}
+			checkSize(a, budgets.Archive)
+		}
+	}
+
+	if _, f := r.manifest.BuildOutputs[model.Docker]; f && (len(budgets.Images) > 0 || budgets.ImageLayers > 0) {
+		images, err := filepath.Glob(filepath.Join(r.release, r.manifest.ArtifactDir("docker", ""), "*.tar.gz"))
+		if err != nil {
+			return err
+		}
+		for _, image := range images {
+			name, _, _ := model.ImageNameVariant(filepath.Base(image), r.manifest.ImageVariants)
+			budget, f := budgets.Images[name]
+			if !f {
+				budget = budgets.Images["*"]
+			}
+			checkSize(image, budget)
+			if budgets.ImageLayers == 0 {
+				continue
+			}
+			img, err := tarball.Image(func() (io.ReadCloser, error) { return gzipFile(image) }, nil)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to read %v: %v", filepath.Base(image), err))
+				continue
+			}
+			layers, err := img.Layers()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to read %v: %v", filepath.Base(image), err))
+				continue
+			}
+			if len(layers) > budgets.ImageLayers {
+				errs = append(errs, fmt.Errorf("%v has %d layers, over its budget of %d",
+					filepath.Base(image), len(layers), budgets.ImageLayers))
+			}
+		}
+	}
+
+	if budgets.Warn {
+		for _, err := range errs {
+			log.Warnf("Size budget exceeded: %v", err)
+		}
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// gzipFile opens a gzipped file, closing the file as the reader is closed.
+func gzipFile(file string) (io.ReadCloser, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, f}, nil
+}
@@ -0,0 +1,99 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+func TestSizeBudgetsExceeded(t *testing.T) {
+	release := t.TempDir()
+	r := ReleaseInfo{
+		manifest: model.Manifest{
+			Version:      "1.24.0",
+			BuildOutputs: map[model.BuildOutput]struct{}{model.Archive: {}, model.Docker: {}},
+			SizeBudgets: &model.SizeBudgets{
+				Istioctl: 1,
+				Archive:  3,
+				Images:   map[string]int64{"pilot": 3, "*": 1},
+			},
+		},
+		archive: filepath.Join(release, "istio-1.24.0"),
+		release: release,
+	}
+	sizes := map[string]int64{
+		"istio-1.24.0/bin/istioctl":              2,
+		"istio-1.24.0-linux-amd64.tar.gz":        4,
+		"istio-1.24.0-linux-amd64.tar.gz.sha256": 4,
+		"istio-1.24.0-linux-arm64.tar.gz":        2,
+		"docker/pilot.tar.gz":                    2,
+		"docker/proxyv2.tar.gz":                  2,
+	}
+	for f, size := range sizes {
+		writeFiles(t, release, f)
+		if err := os.Truncate(filepath.Join(release, f), size*mib); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := TestSizeBudgets(r)
+	if err == nil {
+		t.Fatal("expected the budgets to be exceeded")
+	}
+	for _, want := range []string{
+		"istioctl is 2.0 MiB, over its budget of 1 MiB",
+		"istio-1.24.0-linux-amd64.tar.gz is 4.0 MiB, over its budget of 3 MiB",
+		// Images not listed are limited by "*"
+		"proxyv2.tar.gz is 2.0 MiB, over its budget of 1 MiB",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q, got %v", want, err)
+		}
+	}
+	for _, within := range []string{".sha256", "arm64", "pilot"} {
+		if strings.Contains(err.Error(), within) {
+			t.Errorf("expected %v to be within its budget, got %v", within, err)
+		}
+	}
+
+	r.manifest.SizeBudgets.Warn = true
+	if err := TestSizeBudgets(r); err != nil {
+		t.Fatalf("expected exceeded budgets to only warn, got %v", err)
+	}
+}
+
+func TestSizeBudgetsImageLayers(t *testing.T) {
+	r := ReleaseInfo{
+		manifest: model.Manifest{
+			Version:      "1.24.0",
+			BuildOutputs: map[model.BuildOutput]struct{}{model.Docker: {}},
+			SizeBudgets:  &model.SizeBudgets{ImageLayers: 2},
+		},
+		release: t.TempDir(),
+	}
+	writeImage(t, filepath.Join(r.release, "docker", "pilot.tar.gz"), "docker.io/istio/pilot:1.24.0", 2, nil)
+	writeImage(t, filepath.Join(r.release, "docker", "proxyv2.tar.gz"), "docker.io/istio/proxyv2:1.24.0", 3, nil)
+	err := TestSizeBudgets(r)
+	if err == nil || !strings.Contains(err.Error(), "proxyv2.tar.gz has 3 layers, over its budget of 2") {
+		t.Fatalf("expected proxyv2 to exceed its layer budget, got %v", err)
+	}
+	if strings.Contains(err.Error(), "pilot") {
+		t.Fatalf("expected pilot to be within its layer budget, got %v", err)
+	}
+}
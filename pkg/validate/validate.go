@@ -17,6 +17,7 @@ package validate
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
@@ -26,6 +27,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/Masterminds/semver/v3"
 	"istio.io/istio/pkg/log"
 	"sigs.k8s.io/yaml"
 
@@ -78,14 +80,38 @@ func CheckRelease(release string) ([]string, string, []error) {
 		"TestDocker":         TestDocker,
 		"HelmVersionsIstio":  TestHelmVersionsIstio,
 		"HelmChartVersions":  TestHelmChartVersions,
+		"ChartDependencies":  TestChartDependencies,
+		"HelmKubeVersions":   TestHelmKubeVersions,
 		"IstioctlProfiles":   TestIstioctlProfiles,
 		"Manifest":           TestManifest,
 		"Licenses":           TestLicenses,
 		"Grafana":            TestGrafana,
 		"CompletionFiles":    TestCompletionFiles,
 		"ProxyVersion":       TestProxyVersion,
+		"ZtunnelVersion":     TestZtunnelVersion,
+		"VersionCoherence":   TestVersionCoherence,
 		"Debian":             TestDebian,
 		"Rpm":                TestRpm,
+		"Architectures":      TestArchitectures,
+	}
+	if flags.scanner != "" {
+		checks["Vulnerabilities"] = TestVulnerabilities
+	}
+	if flags.layout != "" {
+		checks["ArchiveLayout"] = TestArchiveLayout
+	}
+	if flags.packages {
+		checks["PackageInstall"] = TestPackageInstall
+	}
+	if r.manifest.SizeBudgets != nil {
+		checks["SizeBudgets"] = TestSizeBudgets
+	}
+	if _, f := r.manifest.BuildOutputs[model.Istioctl]; !f {
+		delete(checks, "IstioctlStandalone")
+	}
+	_, helm := r.manifest.BuildOutputs[model.Helm]
+	if (flags.previous != "" || r.manifest.CRDCompatibility != nil) && helm && util.IsValidSemver(r.manifest.Version) {
+		checks["CRDCompatibility"] = TestCRDCompatibility
 	}
 	var errors []error
 	var success []string
@@ -213,17 +239,25 @@ func getValues(values []byte) (map[string]interface{}, error) {
 }
 
 func TestDocker(r ReleaseInfo) error {
-	expected := []string{
-		"pilot-distroless",
-		"pilot-debug",
-		"install-cni-debug",
-		"ztunnel-debug",
-		"ztunnel-distroless",
-		"proxyv2-debug",
-		"proxyv2-distroless",
+	variants := r.manifest.ImageVariants
+	if len(variants) == 0 {
+		variants = []string{"debug", "distroless"}
+	}
+	expected := []string{}
+	for _, img := range []string{"pilot", "install-cni", "ztunnel", "proxyv2"} {
+		for _, v := range variants {
+			// The default variant is saved with a -debug suffix
+			if v == "default" {
+				v = "debug"
+			}
+			if !model.ImageVariantBuilt(img, v) {
+				continue
+			}
+			expected = append(expected, img+"-"+v)
+		}
 	}
 	found := map[string]struct{}{}
-	d, err := os.ReadDir(filepath.Join(r.release, "docker"))
+	d, err := os.ReadDir(filepath.Join(r.release, r.manifest.ArtifactDir("docker", "")))
 	if err != nil {
 		return fmt.Errorf("failed to read docker dir: %v", err)
 	}
@@ -273,13 +307,33 @@ type Version struct {
 }
 
 func TestProxyVersion(r ReleaseInfo) error {
-	archive := filepath.Join(r.release, "docker", "proxyv2-distroless.tar.gz")
+	// Any amd64 variant of proxyv2 will do, as the variants built are configured by the manifest
+	images, err := filepath.Glob(filepath.Join(r.release, r.manifest.ArtifactDir("docker", ""), "*.tar.gz"))
+	if err != nil {
+		return err
+	}
+	archive := ""
+	for _, image := range images {
+		if name, _, arch := model.ImageNameVariant(filepath.Base(image), r.manifest.ImageVariants); name == "proxyv2" && arch == "" {
+			archive = image
+			break
+		}
+	}
+	if archive == "" {
+		return fmt.Errorf("no proxyv2 image found")
+	}
+	tags, err := imageTags(archive)
+	if err != nil {
+		return fmt.Errorf("failed to read %v: %v", filepath.Base(archive), err)
+	}
+	if len(tags) == 0 {
+		return fmt.Errorf("%v has no tags", filepath.Base(archive))
+	}
 	if err := util.VerboseCommand("docker", "load", "-i", archive).Run(); err != nil {
-		return fmt.Errorf("failed to load proxyv2-debug.tar.gz as docker image: %v", err)
+		return fmt.Errorf("failed to load %v as docker image: %v", filepath.Base(archive), err)
 	}
 	buf := bytes.Buffer{}
-	image := fmt.Sprintf("%s/%s:%s", r.manifest.Docker, "proxyv2", r.manifest.Version)
-	cmd := util.VerboseCommand("docker", "run", "--rm", image, "version", "--short", "-ojson")
+	cmd := util.VerboseCommand("docker", "run", "--rm", tags[0], "version", "--short", "-ojson")
 	cmd.Stdout = &buf
 	if err := cmd.Run(); err != nil {
 		return err
@@ -315,7 +369,7 @@ func TestHelmChartVersions(r ReleaseInfo) error {
 	for chart, path := range expected {
 		buf := bytes.Buffer{}
 		c := util.VerboseCommand("helm", "show", "values",
-			filepath.Join(r.release, "helm", fmt.Sprintf("%s-%s.tgz", chart, r.manifest.Version)))
+			filepath.Join(r.release, r.manifest.ArtifactDir("helm", ""), r.manifest.ChartFile(chart)))
 		c.Stdout = &buf
 		if err := c.Run(); err != nil {
 			return fmt.Errorf("helm show: %v", err)
@@ -331,6 +385,49 @@ func TestHelmChartVersions(r ReleaseInfo) error {
 	return nil
 }
 
+// TestChartDependencies checks the packaged charts only depend on exact versions, not floating ranges, so
+// the release is reproducible from its charts.
+func TestChartDependencies(r ReleaseInfo) error {
+	if !util.IsValidSemver(r.manifest.Version) {
+		log.Infof("Skipping TestChartDependencies; not a valid semver")
+		return nil
+	}
+	dir := filepath.Join(r.release, r.manifest.ArtifactDir("helm", ""))
+	charts, err := filepath.Glob(filepath.Join(dir, "*.tgz"))
+	if err != nil {
+		return err
+	}
+	samples, err := filepath.Glob(filepath.Join(dir, "samples", "*.tgz"))
+	if err != nil {
+		return err
+	}
+	floating := []string{}
+	for _, c := range append(charts, samples...) {
+		out, err := util.RunWithOutput("helm", "show", "chart", c)
+		if err != nil {
+			return fmt.Errorf("helm show chart %v: %v", c, err)
+		}
+		meta := struct {
+			Dependencies []struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+			} `json:"dependencies"`
+		}{}
+		if err := yaml.Unmarshal([]byte(out), &meta); err != nil {
+			return fmt.Errorf("failed to unmarshal chart %v: %v", c, err)
+		}
+		for _, d := range meta.Dependencies {
+			if _, err := semver.StrictNewVersion(d.Version); err != nil {
+				floating = append(floating, fmt.Sprintf("%s: %s %q", filepath.Base(c), d.Name, d.Version))
+			}
+		}
+	}
+	if len(floating) > 0 {
+		return fmt.Errorf("charts have floating dependency versions: %v", strings.Join(floating, ", "))
+	}
+	return nil
+}
+
 func TestHelmVersionsIstio(r ReleaseInfo) error {
 	manifestValues := []string{
 		"manifests/charts/gateways/istio-egress/values.yaml",
@@ -441,7 +538,7 @@ func TestManifest(r ReleaseInfo) error {
 
 func TestGrafana(r ReleaseInfo) error {
 	created := map[string]struct{}{}
-	dir, err := os.ReadDir(path.Join(r.release, "grafana"))
+	dir, err := os.ReadDir(path.Join(r.release, r.manifest.ArtifactDir("grafana", "")))
 	if err != nil {
 		return err
 	}
@@ -459,7 +556,7 @@ func TestGrafana(r ReleaseInfo) error {
 }
 
 func TestLicenses(r ReleaseInfo) error {
-	l, err := os.ReadDir(filepath.Join(r.release, "licenses"))
+	l, err := os.ReadDir(filepath.Join(r.release, r.manifest.ArtifactDir("licenses", "")))
 	if err != nil {
 		return err
 	}
@@ -493,19 +590,55 @@ func TestCompletionFiles(r ReleaseInfo) error {
 }
 
 func TestDebian(info ReleaseInfo) error {
-	if !fileExists(filepath.Join(info.release, "deb", "istio-sidecar.deb")) {
+	if !fileExists(filepath.Join(info.release, info.manifest.ArtifactDir("deb", "amd64"), "istio-sidecar.deb")) {
 		return fmt.Errorf("debian package not found")
 	}
 	return nil
 }
 
 func TestRpm(info ReleaseInfo) error {
-	if !fileExists(filepath.Join(info.release, "rpm", "istio-sidecar.rpm")) {
+	if !fileExists(filepath.Join(info.release, info.manifest.ArtifactDir("rpm", "amd64"), "istio-sidecar.rpm")) {
 		return fmt.Errorf("rpm package not found")
 	}
 	return nil
 }
 
+// TestArchitectures verifies every architecture of the manifest produced its archives and packages.
+func TestArchitectures(info ReleaseInfo) error {
+	var errs []error
+	for _, plat := range info.manifest.Architectures {
+		_, arch, _ := strings.Cut(plat, "/")
+		expected := []string{}
+		if _, f := info.manifest.BuildOutputs[model.Archive]; f {
+			expected = append(expected, fmt.Sprintf("istio-%s-linux-%s.tar.gz", info.manifest.Version, arch))
+		}
+		if _, f := info.manifest.BuildOutputs[model.Istioctl]; f {
+			expected = append(expected, fmt.Sprintf("istioctl-%s-linux-%s.tar.gz", info.manifest.Version, arch))
+		}
+		suffix := ""
+		if arch != "amd64" {
+			suffix = "-" + arch
+		}
+		if _, f := info.manifest.BuildOutputs[model.Debian]; f {
+			expected = append(expected, filepath.Join(info.manifest.ArtifactDir("deb", arch), "istio-sidecar"+suffix+".deb"))
+		}
+		if _, f := info.manifest.BuildOutputs[model.Rpm]; f {
+			expected = append(expected, filepath.Join(info.manifest.ArtifactDir("rpm", arch), "istio-sidecar"+suffix+".rpm"))
+			if info.manifest.Rpm != nil {
+				for _, d := range info.manifest.Rpm.Distros {
+					expected = append(expected, filepath.Join(info.manifest.ArtifactDir("rpm", arch), d.PackageName(info.manifest.Version, arch)))
+				}
+			}
+		}
+		for _, file := range expected {
+			if !fileExists(filepath.Join(info.release, file)) {
+				errs = append(errs, fmt.Errorf("%v: %v not found", plat, file))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func fileExists(filename string) bool {
 	info, err := os.Stat(filename)
 	if os.IsNotExist(err) {
@@ -0,0 +1,133 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// writeFiles creates empty files, relative to dir.
+func writeFiles(t *testing.T, dir string, files ...string) {
+	t.Helper()
+	for _, f := range files {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(f)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, f), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// fakeCommand puts a shell script named command first on the PATH, standing in for a tool the checks run.
+func fakeCommand(t *testing.T, command string, script string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, command), []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// writeImage writes a gzipped image archive with the tag, layers, and labels, as saved by the build.
+func writeImage(t *testing.T, file string, tag string, layers int64, labels map[string]string) {
+	t.Helper()
+	img, err := random.Image(64, layers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Config.Labels = labels
+	if img, err = mutate.ConfigFile(img, cfg); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.NewTag(tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := gzip.NewWriter(f)
+	if err := tarball.Write(ref, img, w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchitecturesPerArch(t *testing.T) {
+	r := ReleaseInfo{
+		manifest: model.Manifest{
+			Version:       "1.24.0",
+			Architectures: []string{"linux/amd64", "linux/s390x", "linux/ppc64le"},
+			BuildOutputs: map[model.BuildOutput]struct{}{
+				model.Archive: {}, model.Istioctl: {}, model.Debian: {}, model.Rpm: {},
+			},
+		},
+		release: t.TempDir(),
+	}
+	writeFiles(t, r.release,
+		"istio-1.24.0-linux-amd64.tar.gz", "istioctl-1.24.0-linux-amd64.tar.gz",
+		"deb/istio-sidecar.deb", "rpm/istio-sidecar.rpm")
+	err := TestArchitectures(r)
+	if err == nil {
+		t.Fatal("expected the s390x and ppc64le artifacts to be missing")
+	}
+	for _, arch := range []string{"s390x", "ppc64le"} {
+		for _, want := range []string{
+			"istio-1.24.0-linux-" + arch + ".tar.gz",
+			"istioctl-1.24.0-linux-" + arch + ".tar.gz",
+			"deb/istio-sidecar-" + arch + ".deb",
+			"rpm/istio-sidecar-" + arch + ".rpm",
+		} {
+			if !strings.Contains(err.Error(), "linux/"+arch+": "+want+" not found") {
+				t.Errorf("expected %v to be reported missing, got %v", want, err)
+			}
+		}
+	}
+	if strings.Contains(err.Error(), "linux/amd64") {
+		t.Errorf("expected the amd64 artifacts to be found, got %v", err)
+	}
+
+	for _, arch := range []string{"s390x", "ppc64le"} {
+		writeFiles(t, r.release,
+			"istio-1.24.0-linux-"+arch+".tar.gz", "istioctl-1.24.0-linux-"+arch+".tar.gz",
+			"deb/istio-sidecar-"+arch+".deb", "rpm/istio-sidecar-"+arch+".rpm")
+	}
+	if err := TestArchitectures(r); err != nil {
+		t.Fatal(err)
+	}
+}
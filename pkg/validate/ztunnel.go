@@ -0,0 +1,108 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"istio.io/istio/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// TestZtunnelVersion checks the ztunnel chart, images, and binaries are of the same release as istiod, as ztunnel
+// must match the control plane it connects to.
+func TestZtunnelVersion(r ReleaseInfo) error {
+	if util.IsValidSemver(r.manifest.Version) {
+		// Both charts are versioned by the manifest, so match each other unless the manifest overrides one
+		for _, chart := range []string{"istiod", "ztunnel"} {
+			out, err := util.RunWithOutput("helm", "show", "chart",
+				filepath.Join(r.release, r.manifest.ArtifactDir("helm", ""), r.manifest.ChartFile(chart)))
+			if err != nil {
+				return fmt.Errorf("helm show chart %v: %v", chart, err)
+			}
+			meta := struct {
+				Version    string `json:"version"`
+				AppVersion string `json:"appVersion"`
+			}{}
+			if err := yaml.Unmarshal([]byte(out), &meta); err != nil {
+				return fmt.Errorf("failed to unmarshal chart %v: %v", chart, err)
+			}
+			if want := r.manifest.ChartVersion(chart); meta.Version != want {
+				return fmt.Errorf("%v chart version %v does not match %v", chart, meta.Version, want)
+			}
+			if want := r.manifest.ChartAppVersion(chart); meta.AppVersion != want {
+				return fmt.Errorf("%v chart app version %v does not match %v", chart, meta.AppVersion, want)
+			}
+		}
+	} else {
+		log.Infof("Skipping ztunnel chart versions; not a valid semver")
+	}
+
+	if _, f := r.manifest.BuildOutputs[model.Docker]; f {
+		images, err := filepath.Glob(filepath.Join(r.release, r.manifest.ArtifactDir("docker", ""), "ztunnel-*.tar.gz"))
+		if err != nil {
+			return err
+		}
+		for _, image := range images {
+			if err := checkZtunnelImage(r, image); err != nil {
+				return fmt.Errorf("%v: %v", filepath.Base(image), err)
+			}
+		}
+	}
+
+	if r.manifest.Ztunnel != nil {
+		for _, plat := range r.manifest.Architectures {
+			_, arch, _ := strings.Cut(plat, "/")
+			binary := fmt.Sprintf("ztunnel-%s-linux-%s", r.manifest.Version, arch)
+			if !fileExists(filepath.Join(r.release, r.manifest.ArtifactDir("ztunnel", ""), binary)) {
+				return fmt.Errorf("expected ztunnel binary %v", binary)
+			}
+		}
+	}
+	return nil
+}
+
+// checkZtunnelImage checks the image archive is tagged, and labeled, with the release version.
+func checkZtunnelImage(r ReleaseInfo, image string) error {
+	tags, err := imageTags(image)
+	if err != nil {
+		return err
+	}
+	tag := fmt.Sprintf("%s/ztunnel:%s", r.manifest.Docker, r.manifest.Version)
+	if !slices.ContainsFunc(tags, func(t string) bool { return t == tag || strings.HasPrefix(t, tag+"-") }) {
+		return fmt.Errorf("expected a tag of %v", tag)
+	}
+	img, err := tarball.Image(func() (io.ReadCloser, error) { return gzipFile(image) }, nil)
+	if err != nil {
+		return err
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return err
+	}
+	// Images are only labeled when saved by the build
+	if v, f := cfg.Config.Labels["org.opencontainers.image.version"]; f && v != r.manifest.Version {
+		return fmt.Errorf("labeled with version %v, not %v", v, r.manifest.Version)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package validate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+// writeChart writes a packaged chart as shown by a fake helm, which only needs its Chart.yaml fields.
+func writeChart(t *testing.T, file string, version string, appVersion string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(fmt.Sprintf("version: %s\nappVersion: %s\n", version, appVersion)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestZtunnelVersionImages(t *testing.T) {
+	// Not a semver, so the charts are not checked
+	r := ReleaseInfo{
+		manifest: model.Manifest{
+			Version:      "master",
+			Docker:       "docker.io/istio",
+			BuildOutputs: map[model.BuildOutput]struct{}{model.Docker: {}},
+		},
+		release: t.TempDir(),
+	}
+	image := filepath.Join(r.release, "docker", "ztunnel-distroless.tar.gz")
+	writeImage(t, image, "docker.io/istio/ztunnel:1.23.0-distroless", 1, nil)
+	if err := TestZtunnelVersion(r); err == nil || !strings.Contains(err.Error(), "expected a tag of docker.io/istio/ztunnel:master") {
+		t.Fatalf("expected the image tag to mismatch, got %v", err)
+	}
+
+	writeImage(t, image, "docker.io/istio/ztunnel:master-distroless", 1,
+		map[string]string{"org.opencontainers.image.version": "1.23.0"})
+	if err := TestZtunnelVersion(r); err == nil || !strings.Contains(err.Error(), "labeled with version 1.23.0, not master") {
+		t.Fatalf("expected the image label to mismatch, got %v", err)
+	}
+
+	writeImage(t, image, "docker.io/istio/ztunnel:master-distroless", 1,
+		map[string]string{"org.opencontainers.image.version": "master"})
+	if err := TestZtunnelVersion(r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestZtunnelVersionBinaries(t *testing.T) {
+	r := ReleaseInfo{
+		manifest: model.Manifest{
+			Version:       "master",
+			Architectures: []string{"linux/amd64", "linux/arm64"},
+			Ztunnel:       &model.ZtunnelConfig{},
+		},
+		release: t.TempDir(),
+	}
+	writeFiles(t, r.release, "ztunnel/ztunnel-master-linux-amd64")
+	if err := TestZtunnelVersion(r); err == nil || !strings.Contains(err.Error(), "ztunnel-master-linux-arm64") {
+		t.Fatalf("expected the arm64 binary to be missing, got %v", err)
+	}
+	writeFiles(t, r.release, "ztunnel/ztunnel-master-linux-arm64")
+	if err := TestZtunnelVersion(r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestZtunnelVersionCharts(t *testing.T) {
+	fakeCommand(t, "helm", `cat "$3"`)
+	r := ReleaseInfo{
+		manifest: model.Manifest{
+			Version:            "1.24.0",
+			ChartVersionSuffix: "-alauda",
+			ChartVersions:      map[string]model.ChartVersionConfig{"ztunnel": {AppVersion: "1.24.0-r1"}},
+		},
+		release: t.TempDir(),
+	}
+	// The ztunnel appVersion is overridden, so differs from istiod
+	writeChart(t, filepath.Join(r.release, "helm", "istiod-1.24.0-alauda.tgz"), "1.24.0-alauda", "1.24.0")
+	writeChart(t, filepath.Join(r.release, "helm", "ztunnel-1.24.0-alauda.tgz"), "1.24.0-alauda", "1.24.0-r1")
+	if err := TestZtunnelVersion(r); err != nil {
+		t.Fatal(err)
+	}
+
+	writeChart(t, filepath.Join(r.release, "helm", "ztunnel-1.24.0-alauda.tgz"), "1.24.0-alauda", "1.24.0")
+	err := TestZtunnelVersion(r)
+	if err == nil || err.Error() != "ztunnel chart app version 1.24.0 does not match 1.24.0-r1" {
+		t.Fatalf("expected the ztunnel appVersion override to be checked, got %v", err)
+	}
+
+	writeChart(t, filepath.Join(r.release, "helm", "istiod-1.24.0-alauda.tgz"), "1.24.0", "1.24.0")
+	err = TestZtunnelVersion(r)
+	if err == nil || err.Error() != "istiod chart version 1.24.0 does not match 1.24.0-alauda" {
+		t.Fatalf("expected the istiod chart version suffix to be checked, got %v", err)
+	}
+}
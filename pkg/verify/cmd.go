@@ -0,0 +1,64 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verify
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"istio.io/istio/pkg/log"
+)
+
+var (
+	flags = struct {
+		bucket string
+		sample int
+		hub    string
+		images []string
+	}{
+		images: []string{"pilot", "proxyv2", "install-cni", "ztunnel"},
+	}
+	verifyCmd = &cobra.Command{
+		Use:          "verify <version>",
+		Short:        "Verifies a published release matches its checksums",
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			if flags.bucket == "" {
+				return fmt.Errorf("--bucket must be passed")
+			}
+			if err := Verify(args[0], flags.bucket, flags.sample, flags.hub, flags.images); err != nil {
+				return fmt.Errorf("release verification FAILED:\n%v", err)
+			}
+			log.Infof("Release verification PASSED")
+			return nil
+		},
+	}
+)
+
+func init() {
+	verifyCmd.PersistentFlags().StringVar(&flags.bucket, "bucket", flags.bucket,
+		"The bucket the release was published to. Example: istio-release/releases")
+	verifyCmd.PersistentFlags().IntVar(&flags.sample, "sample", flags.sample,
+		"If set, spot-check only this many randomly selected artifacts, rather than all of them.")
+	verifyCmd.PersistentFlags().StringVar(&flags.hub, "hub", flags.hub,
+		"If set, also verify the release images resolve on this hub. Example: docker.io/istio")
+	verifyCmd.PersistentFlags().StringSliceVar(&flags.images, "images", flags.images,
+		"The images to verify on the hub.")
+}
+
+func GetVerifyCommand() *cobra.Command {
+	return verifyCmd
+}
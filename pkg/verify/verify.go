@@ -0,0 +1,194 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verify
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/minio/minio-go/v7"
+	"istio.io/istio/pkg/log"
+	"sigs.k8s.io/yaml"
+
+	"github.com/alauda-mesh/release-builder/pkg/build"
+	"github.com/alauda-mesh/release-builder/pkg/model"
+	"github.com/alauda-mesh/release-builder/pkg/publish"
+	"github.com/alauda-mesh/release-builder/pkg/util"
+)
+
+// Verify checks the published release against its SHA256SUMS. Every file listed is downloaded and hashed, or just a
+// random sample of them if sample is non-zero, and objects missing from the release or not listed in it are reported.
+// If hub is set, the release images are checked to resolve to the digests recorded when they were published as well.
+// All drift found is returned, rather than just the first.
+func Verify(version string, bucket string, sample int, hub string, images []string) error {
+	ctx := context.Background()
+	client, err := publish.NewS3Client(ctx)
+	if err != nil {
+		return err
+	}
+	// Allow the caller to pass a reference like bucket/folder/subfolder, matching publish
+	bucketName, objectPrefix, _ := strings.Cut(bucket, "/")
+	prefix := path.Join(objectPrefix, version)
+
+	by, err := publish.FetchObject(client, bucketName, prefix, "manifest.yaml")
+	if err != nil {
+		return fmt.Errorf("failed to fetch published manifest: %v", err)
+	}
+	manifest := model.Manifest{}
+	if err := yaml.Unmarshal(by, &manifest); err != nil {
+		return fmt.Errorf("failed to unmarshal published manifest: %v", err)
+	}
+	var errs []error
+	if manifest.Version != version {
+		errs = append(errs, fmt.Errorf("published manifest is for version %v", manifest.Version))
+	}
+
+	by, err = publish.FetchObject(client, bucketName, prefix, build.ChecksumsFile)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %v: %v", build.ChecksumsFile, err)
+	}
+	sums, err := build.ParseChecksums(by)
+	if err != nil {
+		return err
+	}
+
+	docker := manifest.ArtifactDir("docker", "") + "/"
+	dockerPublished := false
+	objects := map[string]struct{}{}
+	for obj := range client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: prefix + "/", Recursive: true}) {
+		if obj.Err != nil {
+			return fmt.Errorf("failed to list objects: %v", obj.Err)
+		}
+		rel := strings.TrimPrefix(obj.Key, prefix+"/")
+		// The published destinations are written by publish, so are not part of the build
+		if rel == build.ChecksumsFile || rel == publish.PublishedFile {
+			continue
+		}
+		objects[rel] = struct{}{}
+		dockerPublished = dockerPublished || strings.HasPrefix(rel, docker)
+		if _, f := sums[rel]; !f {
+			errs = append(errs, fmt.Errorf("%v is not listed in %v", rel, build.ChecksumsFile))
+		}
+	}
+
+	listed := []string{}
+	for _, rel := range slices.Sorted(maps.Keys(sums)) {
+		if _, f := objects[rel]; f {
+			listed = append(listed, rel)
+			continue
+		}
+		// Docker archives are only published to s3 when staging a release
+		if strings.HasPrefix(rel, docker) && !dockerPublished {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%v is listed in %v, but missing", rel, build.ChecksumsFile))
+	}
+	if sample > 0 && sample < len(listed) {
+		rand.Shuffle(len(listed), func(i, j int) { listed[i], listed[j] = listed[j], listed[i] })
+		listed = listed[:sample]
+	}
+
+	for _, rel := range listed {
+		got, err := objectSha(ctx, client, bucketName, path.Join(prefix, rel))
+		if err != nil {
+			return err
+		}
+		if want := sums[rel]; got != want {
+			errs = append(errs, fmt.Errorf("%v has sha256 %v, expected %v", rel, got, want))
+			continue
+		}
+		log.Infof("Verified %v", rel)
+	}
+
+	if hub != "" {
+		recorded := map[string]string{}
+		if by, err := publish.FetchObject(client, bucketName, prefix, publish.PublishedFile); err != nil {
+			log.Warnf("Not comparing image digests; failed to fetch %v: %v", publish.PublishedFile, err)
+		} else {
+			p := publish.Published{}
+			if err := yaml.Unmarshal(by, &p); err != nil {
+				return fmt.Errorf("failed to unmarshal %v: %v", publish.PublishedFile, err)
+			}
+			for _, img := range p.Images {
+				if ref, err := name.ParseReference(img.Reference); err == nil {
+					recorded[ref.Name()] = img.Digest
+				}
+			}
+		}
+		errs = append(errs, verifyImages(manifest, hub, images, recorded)...)
+	}
+	return errors.Join(errs...)
+}
+
+func objectSha(ctx context.Context, client *minio.Client, bucket string, key string) (string, error) {
+	obj, err := client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %v: %v", key, err)
+	}
+	defer obj.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, obj); err != nil {
+		return "", fmt.Errorf("failed to read %v: %v", key, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyImages checks each image, in each variant built, resolves on the hub, to its recorded digest if it has one.
+func verifyImages(manifest model.Manifest, hub string, images []string, recorded map[string]string) []error {
+	var errs []error
+	variants := manifest.ImageVariants
+	if len(variants) == 0 {
+		variants = []string{"debug", "distroless"}
+	}
+	for _, img := range images {
+		for _, v := range variants {
+			// The default variant is saved, and published, as the debug variant
+			if v == "default" {
+				v = "debug"
+			}
+			if !model.ImageVariantBuilt(img, v) {
+				continue
+			}
+			published := publish.Image{NewTag: fmt.Sprintf("%s/%s:%s", hub, img, manifest.Version), Variant: v}.NewReference("")
+			ref, err := name.ParseReference(published)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to parse %v: %v", published, err))
+				continue
+			}
+			desc, err := remote.Head(ref, remote.WithAuthFromKeychain(util.Keychain(manifest.Registries)))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to resolve %v: %v", published, err))
+				continue
+			}
+			if want, f := recorded[ref.Name()]; f && want != desc.Digest.String() {
+				errs = append(errs, fmt.Errorf("%v resolves to %v, but was published as %v", published, desc.Digest, want))
+				continue
+			}
+			log.Infof("Verified %v resolves to %v", published, desc.Digest)
+		}
+	}
+	return errs
+}
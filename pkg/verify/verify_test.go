@@ -0,0 +1,62 @@
+// Copyright Istio Authors. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package verify
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/alauda-mesh/release-builder/pkg/model"
+)
+
+func TestVerifyImages(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	hub := strings.TrimPrefix(server.URL, "http://") + "/istio"
+	// install-cni is only built, and published, as the default variant
+	for _, tag := range []string{"pilot:1.24.0-debug", "pilot:1.24.0-distroless", "install-cni:1.24.0-debug"} {
+		ref, err := name.ParseReference(hub + "/" + tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := random.Image(64, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := remote.Write(ref, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manifest := model.Manifest{Version: "1.24.0"}
+	if errs := verifyImages(manifest, hub, []string{"pilot", "install-cni"}, nil); len(errs) > 0 {
+		t.Fatalf("expected the published images to verify, got %v", errs)
+	}
+	errs := verifyImages(manifest, hub, []string{"pilot", "install-cni", "ztunnel"}, nil)
+	if len(errs) != 2 {
+		t.Fatalf("expected both ztunnel variants to be missing, got %v", errs)
+	}
+	for i, v := range []string{"debug", "distroless"} {
+		if !strings.Contains(errs[i].Error(), "failed to resolve "+hub+"/ztunnel:1.24.0-"+v) {
+			t.Errorf("expected ztunnel %v to be missing, got %v", v, errs[i])
+		}
+	}
+}